@@ -32,12 +32,15 @@ require (
 	github.com/variantdev/chartify v0.9.2
 	github.com/variantdev/dag v1.1.0
 	github.com/variantdev/vals v0.15.0
+	github.com/xeipuuv/gojsonschema v1.2.0
 	go.uber.org/multierr v1.6.0
 	go.uber.org/zap v1.16.0
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	gopkg.in/square/go-jose.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.0-20210107172259-749611fa9fcc
 	gotest.tools v2.2.0+incompatible
 	gotest.tools/v3 v3.0.3
 	k8s.io/apimachinery v0.21.0
+	k8s.io/cli-runtime v0.21.0
 )
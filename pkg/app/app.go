@@ -14,14 +14,19 @@ import (
 	"sync"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/huolunl/helmfile/pkg/argparser"
+	"github.com/huolunl/helmfile/pkg/audit"
 	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/manifest"
 	"github.com/huolunl/helmfile/pkg/plugins"
 	"github.com/huolunl/helmfile/pkg/remote"
 	"github.com/huolunl/helmfile/pkg/state"
+	"github.com/huolunl/helmfile/pkg/timing"
 	"github.com/variantdev/vals"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
 )
 
 type App struct {
@@ -59,16 +64,42 @@ type App struct {
 	helmsMutex sync.Mutex
 	Extra      []string
 	Writer     io.Writer
+
+	validateSchema bool
+
+	// AuditLog is the path to the JSON Lines file the mutating operations are recorded to
+	AuditLog string
+	// AuditSink is the sink the mutating operations are recorded to, in addition to AuditLog
+	AuditSink audit.Sink
+
+	// RenderCache enables caching the prepared charts under the cache directory across runs
+	RenderCache bool
+
+	// Timings records the wall time of the steps of the run. Nothing is recorded when nil
+	Timings *timing.Recorder
+	// PrintTimings and TimingsFile configure ReportTimings to print the timings and to write them in JSON to the file
+	PrintTimings bool
+	TimingsFile  string
 }
 
 type HelmRelease struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Enabled   bool   `json:"enabled"`
-	Installed bool   `json:"installed"`
-	Labels    string `json:"labels"`
-	Chart     string `json:"chart"`
-	Version   string `json:"version"`
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Enabled   bool   `json:"enabled" yaml:"enabled"`
+	Installed bool   `json:"installed" yaml:"installed"`
+	Labels    string `json:"labels" yaml:"labels"`
+	Chart     string `json:"chart" yaml:"chart"`
+	Version   string `json:"version" yaml:"version"`
+	// ChartDigest is the digest of the chart pulled from the OCI registry
+	ChartDigest string `json:"chartDigest,omitempty" yaml:"chartDigest,omitempty"`
+
+	// The fields below are set by `list --with-status` from the release deployed in the cluster
+	Revision        int    `json:"revision,omitempty" yaml:"revision,omitempty"`
+	Status          string `json:"status,omitempty" yaml:"status,omitempty"`
+	DeployedVersion string `json:"deployedVersion,omitempty" yaml:"deployedVersion,omitempty"`
+	AppVersion      string `json:"appVersion,omitempty" yaml:"appVersion,omitempty"`
+	// Orphan is true for the release deployed in a managed namespace but not declared in any helmfile
+	Orphan bool `json:"orphan,omitempty" yaml:"orphan,omitempty"`
 }
 
 func New(conf ConfigProvider) *App {
@@ -84,6 +115,11 @@ func New(conf ConfigProvider) *App {
 		FileOrDir:           conf.FileOrDir(),
 		ValuesFiles:         conf.StateValuesFiles(),
 		Set:                 conf.StateValuesSet(),
+		AuditLog:            conf.AuditLog(),
+		RenderCache:         conf.RenderCache(),
+		Timings:             newTimings(conf),
+		PrintTimings:        conf.Timings(),
+		TimingsFile:         conf.TimingsFile(),
 		//helmExecer: helmexec.New(conf.HelmBinary(), conf.Logger(), conf.KubeContext(), &helmexec.ShellRunner{
 		//	Logger: conf.Logger(),
 		//}),
@@ -102,6 +138,11 @@ func NewWithHelmExtra(conf ConfigProvider, writer io.Writer, Description string,
 		FileOrDir:           conf.FileOrDir(),
 		ValuesFiles:         conf.StateValuesFiles(),
 		Set:                 conf.StateValuesSet(),
+		AuditLog:            conf.AuditLog(),
+		RenderCache:         conf.RenderCache(),
+		Timings:             newTimings(conf),
+		PrintTimings:        conf.Timings(),
+		TimingsFile:         conf.TimingsFile(),
 		//helmExecer: helmexec.New(conf.HelmBinary(), conf.Logger(), conf.KubeContext(), &helmexec.ShellRunner{
 		//	Logger: conf.Logger(),
 		//}),
@@ -131,6 +172,32 @@ func Init(app *App) *App {
 	return app
 }
 
+func newTimings(conf ConfigProvider) *timing.Recorder {
+	if conf.Timings() || conf.TimingsFile() != "" {
+		return timing.NewRecorder()
+	}
+
+	return nil
+}
+
+// ReportTimings prints the timings of the run to stderr with --timings, and writes them to the file with --timings-file.
+// It is called after the command even if it failed, to tell where the time was spent until the failure.
+func (a *App) ReportTimings() {
+	if a.Timings == nil {
+		return
+	}
+
+	if a.PrintTimings {
+		a.Timings.Print(os.Stderr)
+	}
+
+	if a.TimingsFile != "" {
+		if err := a.Timings.WriteFile(a.TimingsFile); err != nil {
+			a.Logger.Warnf("failed to write timings to %s: %v", a.TimingsFile, err)
+		}
+	}
+}
+
 func (a *App) Deps(c DepsConfigProvider) error {
 	return a.ForEachState(func(run *Run) (_ bool, errs []error) {
 		prepErr := run.withPreparedCharts("deps", state.ChartPrepareOptions{
@@ -178,7 +245,11 @@ func (a *App) DeprecatedSyncCharts(c DeprecatedChartsConfigProvider) error {
 	}, c.IncludeTransitiveNeeds(), SetFilter(true))
 }
 
-func (a *App) Diff(c DiffConfigProvider) error {
+func (a *App) Diff(c DiffCommandConfigProvider) error {
+	if c.Against() != "" {
+		return a.diffAgainst(c)
+	}
+
 	var allDiffDetectedErrs []error
 
 	var affectedAny bool
@@ -252,6 +323,16 @@ func (a *App) Diff(c DiffConfigProvider) error {
 }
 
 func (a *App) Template(c TemplateConfigProvider) error {
+	output, err := newTemplateOutput(c, a.Writer)
+	if err != nil {
+		return err
+	}
+
+	var onRendered func(*state.ReleaseSpec, []manifest.Resource) error
+	if output != nil {
+		onRendered = output.write
+	}
+
 	return a.ForEachState(func(run *Run) (ok bool, errs []error) {
 		includeCRDs := c.IncludeCRDs()
 
@@ -265,7 +346,7 @@ func (a *App) Template(c TemplateConfigProvider) error {
 			SkipCleanup:   c.SkipCleanup(),
 			Validate:      c.Validate(),
 		}, func() {
-			ok, errs = a.template(run, c)
+			ok, errs = a.template(run, c, onRendered)
 		})
 
 		if prepErr != nil {
@@ -380,7 +461,16 @@ func (a *App) Fetch(c FetchConfigProvider) error {
 }
 
 func (a *App) Sync(c SyncConfigProvider) error {
-	return a.ForEachState(func(run *Run) (ok bool, errs []error) {
+	pruner, err := newPruner(a, c)
+	if err != nil {
+		return err
+	}
+
+	err = a.ForEachState(func(run *Run) (ok bool, errs []error) {
+		if err := pruner.detect(run); err != nil {
+			return false, []error{err}
+		}
+
 		includeCRDs := !c.SkipCRDs()
 
 		prepErr := run.withPreparedCharts("sync", state.ChartPrepareOptions{
@@ -400,6 +490,14 @@ func (a *App) Sync(c SyncConfigProvider) error {
 
 		return
 	}, c.IncludeTransitiveNeeds())
+
+	if err != nil {
+		return err
+	}
+
+	_, err = a.prune(pruner, "sync", c)
+
+	return err
 }
 
 func (a *App) Apply(c ApplyConfigProvider) error {
@@ -411,7 +509,25 @@ func (a *App) Apply(c ApplyConfigProvider) error {
 
 	opts = append(opts, SetRetainValuesFiles(c.RetainValuesFiles() || c.SkipCleanup()))
 
-	err := a.ForEachState(func(run *Run) (ok bool, errs []error) {
+	var fingerprints *state.FingerprintStore
+	if c.Incremental() {
+		path := c.FingerprintFile()
+		if path == "" {
+			path = state.DefaultFingerprintFile
+		}
+		fingerprints = state.NewFingerprintStore(path)
+	}
+
+	pruner, err := newPruner(a, c)
+	if err != nil {
+		return err
+	}
+
+	err = a.ForEachState(func(run *Run) (ok bool, errs []error) {
+		if err := pruner.detect(run); err != nil {
+			return false, []error{err}
+		}
+
 		includeCRDs := !c.SkipCRDs()
 
 		prepErr := run.withPreparedCharts("apply", state.ChartPrepareOptions{
@@ -423,7 +539,7 @@ func (a *App) Apply(c ApplyConfigProvider) error {
 			SkipCleanup: c.RetainValuesFiles() || c.SkipCleanup(),
 			Validate:    c.Validate(),
 		}, func() {
-			matched, updated, es := a.apply(run, c)
+			matched, updated, es := a.apply(run, c, fingerprints)
 
 			mut.Lock()
 			any = any || updated
@@ -443,7 +559,12 @@ func (a *App) Apply(c ApplyConfigProvider) error {
 		return err
 	}
 
-	if c.DetailedExitcode() && any {
+	pruned, err := a.prune(pruner, "apply", c)
+	if err != nil {
+		return err
+	}
+
+	if c.DetailedExitcode() && (any || pruned) {
 		code := 2
 
 		return &Error{msg: "", Errors: nil, code: &code}
@@ -453,12 +574,20 @@ func (a *App) Apply(c ApplyConfigProvider) error {
 }
 
 func (a *App) Status(c StatusesConfigProvider) error {
-	return a.ForEachState(func(run *Run) (ok bool, errs []error) {
+	switch c.Output() {
+	case "", "json", "yaml", "table":
+	default:
+		return fmt.Errorf("unsupported output format %q: it must be one of json, yaml or table", c.Output())
+	}
+
+	var statuses []state.ReleaseStatus
+
+	err := a.ForEachState(func(run *Run) (ok bool, errs []error) {
 		err := run.withPreparedCharts("status", state.ChartPrepareOptions{
 			SkipRepos: true,
 			SkipDeps:  true,
 		}, func() {
-			ok, errs = a.status(run, c)
+			ok, errs = a.status(run, c, &statuses)
 		})
 
 		if err != nil {
@@ -467,6 +596,28 @@ func (a *App) Status(c StatusesConfigProvider) error {
 
 		return
 	}, false, SetFilter(true))
+
+	if err != nil || c.Output() == "" {
+		return err
+	}
+
+	if err := FormatStatuses(a.Writer, statuses, c.Output()); err != nil {
+		return err
+	}
+
+	var unhealthy []string
+	for _, s := range statuses {
+		if !s.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", s.Name, s.Status))
+		}
+	}
+
+	if len(unhealthy) > 0 {
+		code := 1
+		return &Error{msg: fmt.Sprintf("%d release(s) failed or pending: %s", len(unhealthy), strings.Join(unhealthy, ", ")), code: &code}
+	}
+
+	return nil
 }
 
 func (a *App) Delete(c DeleteConfigProvider) error {
@@ -475,7 +626,7 @@ func (a *App) Delete(c DeleteConfigProvider) error {
 			SkipRepos: c.SkipDeps(),
 			SkipDeps:  c.SkipDeps(),
 		}, func() {
-			ok, errs = a.delete(run, c.Purge(), c)
+			ok, errs = a.delete(run, "delete", c.Purge(), c)
 		})
 
 		if err != nil {
@@ -492,7 +643,7 @@ func (a *App) Destroy(c DestroyConfigProvider) error {
 			SkipRepos: c.SkipDeps(),
 			SkipDeps:  c.SkipDeps(),
 		}, func() {
-			ok, errs = a.delete(run, true, c)
+			ok, errs = a.delete(run, "destroy", true, c)
 		})
 
 		if err != nil {
@@ -577,16 +728,36 @@ func (a *App) PrintState(c StateConfigProvider) error {
 }
 
 func (a *App) ListReleases(c ListConfigProvider) error {
+	switch c.Output() {
+	case "", "json", "yaml", "csv", "table":
+	default:
+		return fmt.Errorf("unsupported output format %q: it must be one of json, yaml, csv or table", c.Output())
+	}
+
 	var releases []*HelmRelease
 
+	// ids are the keys of the releases to join them with the deployed releases on `--with-status`
+	var ids []string
+
+	var deployed []state.DeployedRelease
+
+	// Orphans are reported only when all the releases are listed, as the releases not selected aren't orphans
+	withOrphans := true
+
 	err := a.ForEachState(func(run *Run) (_ bool, errs []error) {
+		// The charts pulled from OCI registries are listed as declared rather than the paths to the pulled charts
+		declared := make([]string, len(run.state.Releases))
+		for i, r := range run.state.Releases {
+			declared[i] = r.Chart
+		}
+
 		err := run.withPreparedCharts("list", state.ChartPrepareOptions{
 			SkipRepos: true,
 			SkipDeps:  true,
 		}, func() {
 
 			//var releases m
-			for _, r := range run.state.Releases {
+			for i, r := range run.state.Releases {
 				labels := ""
 				if r.Labels == nil {
 					r.Labels = map[string]string{}
@@ -612,16 +783,50 @@ func (a *App) ListReleases(c ListConfigProvider) error {
 					panic(err)
 				}
 
+				chart := r.Chart
+				if r.ChartDigest != "" && i < len(declared) {
+					chart = declared[i]
+				}
+
 				installed := r.Installed == nil || *r.Installed
 				releases = append(releases, &HelmRelease{
-					Name:      r.Name,
-					Namespace: r.Namespace,
-					Installed: installed,
-					Enabled:   enabled,
-					Labels:    labels,
-					Chart:     r.Chart,
-					Version:   r.Version,
+					Name:        r.Name,
+					Namespace:   r.Namespace,
+					Installed:   installed,
+					Enabled:     enabled,
+					Labels:      labels,
+					Chart:       chart,
+					Version:     r.Version,
+					ChartDigest: r.ChartDigest,
 				})
+
+			}
+
+			if c.WithStatus() {
+				for _, r := range run.state.Releases {
+					spec := r
+					run.state.ApplyOverrides(&spec)
+
+					id, err := run.state.DeployedReleaseID(run.helm, &spec)
+					if err != nil {
+						errs = append(errs, err)
+						return
+					}
+
+					ids = append(ids, id)
+				}
+
+				if len(run.state.Selectors) > 0 {
+					withOrphans = false
+				}
+
+				ds, err := run.state.ListDeployedReleases(run.helm)
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+
+				deployed = append(deployed, ds...)
 			}
 		})
 
@@ -636,15 +841,273 @@ func (a *App) ListReleases(c ListConfigProvider) error {
 		return err
 	}
 
-	if c.Output() == "json" {
-		err = FormatAsJson(releases)
-	} else {
-		err = FormatAsTable(releases)
+	if c.WithStatus() {
+		releases = joinDeployedReleases(releases, ids, deployed, withOrphans)
+	}
+
+	switch c.Output() {
+	case "json":
+		return FormatAsJson(a.Writer, releases)
+	case "yaml":
+		return FormatAsYaml(a.Writer, releases)
+	case "csv":
+		return FormatAsCsv(a.Writer, releases, c.WithStatus())
+	default:
+		return FormatAsTable(a.Writer, releases, c.WithStatus())
+	}
+}
+
+// joinDeployedReleases sets the deployed revision, status and versions to the desired releases,
+// and appends the deployed releases that are not declared in any helmfile as orphans when withOrphans is true.
+func joinDeployedReleases(releases []*HelmRelease, ids []string, deployed []state.DeployedRelease, withOrphans bool) []*HelmRelease {
+	desired := map[string]bool{}
+	for _, id := range ids {
+		desired[id] = true
+	}
+
+	seen := map[string]bool{}
+
+	var orphans []*HelmRelease
+
+	for _, d := range deployed {
+		id := d.ID()
+		// The same namespace is listed once per helmfile that has releases in it
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if desired[id] {
+			for i := range releases {
+				if ids[i] == id {
+					releases[i].Revision = d.Revision
+					releases[i].Status = d.Status
+					releases[i].DeployedVersion = d.ChartVersion
+					releases[i].AppVersion = d.AppVersion
+				}
+			}
+
+			continue
+		}
+
+		if !withOrphans {
+			continue
+		}
+
+		orphans = append(orphans, &HelmRelease{
+			Name:            d.Name,
+			Namespace:       d.Namespace,
+			Chart:           d.Chart,
+			Revision:        d.Revision,
+			Status:          d.Status,
+			DeployedVersion: d.ChartVersion,
+			AppVersion:      d.AppVersion,
+			Orphan:          true,
+		})
+	}
+
+	return append(releases, orphans...)
+}
+
+// Validate validates every helmfile.yaml and sub-helmfile against the JSON Schema of helmfile.yaml,
+// for the selected environment and then for every other environment defined in them.
+func (a *App) Validate(c ValidateConfigProvider) error {
+	if c.PrintSchema() {
+		schema, err := state.JSONSchema()
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(a.Writer, string(schema))
+
+		return err
+	}
+
+	a.validateSchema = true
+	defer func() {
+		a.validateSchema = false
+	}()
+
+	envs := map[string]bool{}
+
+	validateEnv := func() error {
+		return a.visitStatesWithSelectorsAndRemoteSupport(a.FileOrDir, func(st *state.HelmState) (bool, []error) {
+			for name := range st.Environments {
+				envs[name] = true
+			}
+			return true, nil
+		}, false)
+	}
+
+	if err := validateEnv(); err != nil {
+		return err
 	}
 
+	a.Logger.Infof("validated environment \"%s\"", a.Env)
+
+	selected := a.Env
+	defer func() {
+		a.Env = selected
+	}()
+
+	var names []string
+	for name := range envs {
+		if name != selected {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a.Env = name
+
+		if err := validateEnv(); err != nil {
+			switch err.(type) {
+			case *NoMatchingHelmfileError:
+			default:
+				return appError(fmt.Sprintf("in environment \"%s\"", name), err)
+			}
+		}
+
+		a.Logger.Infof("validated environment \"%s\"", name)
+	}
+
+	return nil
+}
+
+// Explain prints the final values of the selected releases along with the values files, inline values and flags that
+// set or overrode each of them, in the order of precedence.
+// The helmfile-wide values, that is `.Values` in templates, are explained as well as they are often the origin of
+// release values.
+func (a *App) Explain(c ExplainConfigProvider) error {
+	var overrides []interface{}
+	for _, f := range a.ValuesFiles {
+		overrides = append(overrides, f)
+	}
+	if a.Set != nil {
+		overrides = append(overrides, a.Set)
+	}
+
+	err := a.ForEachState(func(run *Run) (bool, []error) {
+		st := run.state
+
+		var releases []state.ReleaseSpec
+		for _, r := range st.GetReleasesWithOverrides() {
+			if c.Release() == "" || r.Name == c.Release() {
+				releases = append(releases, r)
+			}
+		}
+
+		if len(releases) == 0 {
+			return false, nil
+		}
+
+		stateValues, err := st.StateValuesProvenance(run.helm, c.Key(), overrides)
+		if err != nil {
+			return false, []error{err}
+		}
+
+		a.printProvenance(fmt.Sprintf("values of %s", st.FilePath), stateValues)
+
+		for i := range releases {
+			r := releases[i]
+
+			vals, err := st.ReleaseValuesProvenance(run.helm, &r, c.Key())
+			if err != nil {
+				return false, []error{err}
+			}
+
+			a.printProvenance(fmt.Sprintf("values of release \"%s\" in %s", r.Name, st.FilePath), vals)
+		}
+
+		return true, nil
+	}, false, SetFilter(true))
+
 	return err
 }
 
+func (a *App) printProvenance(title string, provenances []*state.ValueProvenance) {
+	fmt.Fprintf(a.Writer, "# %s\n", title)
+
+	if len(provenances) == 0 {
+		fmt.Fprintln(a.Writer, "(no values)")
+	}
+
+	for _, p := range provenances {
+		if !p.Set {
+			fmt.Fprintf(a.Writer, "%s: (not set)\n", p.Key)
+			continue
+		}
+
+		fmt.Fprintf(a.Writer, "%s: %s\n", p.Key, state.FormatValue(p.Value))
+
+		for _, s := range p.Sources {
+			fmt.Fprintf(a.Writer, "  %s: %s\n", s.Location(), state.FormatValue(s.Value))
+		}
+	}
+
+	fmt.Fprintln(a.Writer)
+}
+
+// Environments lists the environments defined in the helmfiles along with the environments they inherit.
+// When ShowValues is true, it prints the values of the selected environment resolved for each helmfile instead.
+func (a *App) Environments(c EnvironmentsConfigProvider) error {
+	envs := map[string]state.EnvironmentSpec{}
+
+	err := a.ForEachState(func(run *Run) (bool, []error) {
+		st := run.state
+
+		if c.ShowValues() {
+			bs, err := yaml.Marshal(st.Values())
+			if err != nil {
+				return false, []error{err}
+			}
+
+			fmt.Fprintf(a.Writer, "# values of environment \"%s\" in %s\n%s\n", a.Env, st.FilePath, string(bs))
+
+			return true, nil
+		}
+
+		for name := range st.Environments {
+			if _, ok := envs[name]; ok {
+				continue
+			}
+
+			spec, _, err := st.ResolveEnvironment(name)
+			if err != nil {
+				return false, []error{err}
+			}
+
+			envs[name] = spec
+		}
+
+		return true, nil
+	}, false)
+
+	if err != nil || c.ShowValues() {
+		return err
+	}
+
+	var names []string
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := new(tabwriter.Writer)
+	w.Init(a.Writer, 0, 1, 1, ' ', 0)
+
+	fmt.Fprintln(w, "NAME\tINHERITS\tKUBECONTEXT")
+
+	for _, name := range names {
+		spec := envs[name]
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.Join(spec.Inherits, ", "), spec.KubeContext)
+	}
+
+	return w.Flush()
+}
+
 func (a *App) within(dir string, do func() error) error {
 	if dir == "." {
 		return do()
@@ -739,6 +1202,8 @@ func (a *App) loadDesiredStateFromYaml(file string, opts ...LoadOpts) (*state.He
 		glob:                a.glob,
 		getHelm:             a.getHelm,
 		valsRuntime:         a.valsRuntime,
+		validateSchema:      a.validateSchema,
+		timings:             a.Timings,
 	}
 
 	return ld.Load(file, op)
@@ -792,7 +1257,9 @@ func (a *App) visitStates(fileOrDir string, defOpts LoadOpts, converge func(*sta
 			opts.CalleePath = f
 		}
 
+		stopTiming := a.Timings.Start(timing.CategoryLoad, f)
 		st, err := a.loadDesiredStateFromYaml(f, opts)
+		stopTiming()
 
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -827,6 +1294,7 @@ func (a *App) visitStates(fileOrDir string, defOpts LoadOpts, converge func(*sta
 			}
 		}
 		st.Selectors = opts.Selectors
+		st.Timings = a.Timings
 
 		visitSubHelmfiles := func() error {
 			if len(st.Helmfiles) > 0 {
@@ -868,7 +1336,9 @@ func (a *App) visitStates(fileOrDir string, defOpts LoadOpts, converge func(*sta
 			}
 		}
 
+		stopTiming = a.Timings.Start(timing.CategoryRender, fmt.Sprintf("release templates in %s", f))
 		templated, tmplErr := st.ExecuteTemplates()
+		stopTiming()
 		if tmplErr != nil {
 			return appError(fmt.Sprintf("failed executing release templates in \"%s\"", f), tmplErr)
 		}
@@ -925,6 +1395,9 @@ func (a *App) ForEachState(do func(*Run) (bool, []error), includeTransitiveNeeds
 		helm := a.getHelm(st)
 
 		run := NewRun(st, helm, ctx)
+		if a.RenderCache {
+			run.RenderCacheDir = RenderCacheDir()
+		}
 		return do(run)
 	}, includeTransitiveNeeds, o...)
 
@@ -962,6 +1435,15 @@ func withDAG(templated *state.HelmState, helm helmexec.Interface, logger *zap.Su
 	return withBatches(templated, batches, helm, logger, converge)
 }
 
+// scheduleReleases processes the releases with withParallelDAG when parallel is true, or withDAG otherwise
+func scheduleReleases(parallel bool, concurrency int, templated *state.HelmState, helm helmexec.Interface, logger *zap.SugaredLogger, opts state.PlanOptions, converge func(*state.HelmState, helmexec.Interface) (bool, []error)) (bool, []error) {
+	if parallel {
+		return withParallelDAG(templated, helm, logger, opts, concurrency, converge)
+	}
+
+	return withDAG(templated, helm, logger, opts, converge)
+}
+
 func withBatches(templated *state.HelmState, batches [][]state.Release, helm helmexec.Interface, logger *zap.SugaredLogger, converge func(*state.HelmState, helmexec.Interface) (bool, []error)) (bool, []error) {
 	numBatches := len(batches)
 
@@ -1250,7 +1732,7 @@ func (a *App) getSelectedReleases(r *Run, includeTransitiveNeeds bool) ([]state.
 	return selected, deduplicated, nil
 }
 
-func (a *App) apply(r *Run, c ApplyConfigProvider) (bool, bool, []error) {
+func (a *App) apply(r *Run, c ApplyConfigProvider, fingerprints *state.FingerprintStore) (bool, bool, []error) {
 	st := r.state
 	helm := r.helm
 
@@ -1295,6 +1777,8 @@ func (a *App) apply(r *Run, c ApplyConfigProvider) (bool, bool, []error) {
 		Set:               c.Set(),
 		SkipCleanup:       c.RetainValuesFiles() || c.SkipCleanup(),
 		SkipDiffOnInstall: c.SkipDiffOnInstall(),
+		Fingerprints:      fingerprints,
+		ForceDiff:         c.ForceDiff(),
 	}
 
 	infoMsg, releasesToBeUpdated, releasesToBeDeleted, errs := r.diff(false, detailedExitCode, c, diffOpts)
@@ -1337,6 +1821,7 @@ func (a *App) apply(r *Run, c ApplyConfigProvider) (bool, bool, []error) {
 			logger.Infof("")
 			logger.Infof(*infoMsg)
 		}
+		a.saveFingerprints(st, fingerprints, releasesWithNoChange, nil)
 		return true, false, nil
 	}
 
@@ -1360,9 +1845,11 @@ Do you really want to apply?
 	if !interactive || interactive && r.askForConfirmation(confMsg) {
 		r.helm.SetExtraArgs(argparser.GetArgs(c.Args(), r.state)...)
 
+		a.notifyStart(st, "apply", &affectedReleases)
+
 		// We deleted releases by traversing the DAG in reverse order
 		if len(releasesToBeDeleted) > 0 {
-			_, deletionErrs := withDAG(st, helm, a.Logger, state.PlanOptions{Reverse: true, SelectedReleases: toDelete, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
+			_, deletionErrs := scheduleReleases(c.DAG(), c.Concurrency(), st, helm, a.Logger, state.PlanOptions{Reverse: true, SelectedReleases: toDelete, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
 				var rs []state.ReleaseSpec
 
 				for _, r := range subst.Releases {
@@ -1374,7 +1861,7 @@ Do you really want to apply?
 
 				subst.Releases = rs
 
-				return subst.DeleteReleasesForSync(&affectedReleases, helm, c.Concurrency())
+				return subst.DeleteReleasesForSync(&affectedReleases, helm, c.Concurrency(), &state.SyncOpts{HelmfileCommand: "apply"})
 			}))
 
 			if len(deletionErrs) > 0 {
@@ -1384,7 +1871,7 @@ Do you really want to apply?
 
 		// We upgrade releases by traversing the DAG
 		if len(releasesToBeUpdated) > 0 {
-			_, updateErrs := withDAG(st, helm, a.Logger, state.PlanOptions{SelectedReleases: toUpdate, Reverse: false, SkipNeeds: true, IncludeTransitiveNeeds: c.IncludeTransitiveNeeds()}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
+			_, updateErrs := scheduleReleases(c.DAG(), c.Concurrency(), st, helm, a.Logger, state.PlanOptions{SelectedReleases: toUpdate, Reverse: false, SkipNeeds: true, IncludeTransitiveNeeds: c.IncludeTransitiveNeeds()}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
 				var rs []state.ReleaseSpec
 
 				for _, r := range subst.Releases {
@@ -1397,11 +1884,12 @@ Do you really want to apply?
 				subst.Releases = rs
 
 				syncOpts := state.SyncOpts{
-					Set:         c.Set(),
-					SkipCleanup: c.RetainValuesFiles() || c.SkipCleanup(),
-					SkipCRDs:    c.SkipCRDs(),
-					Wait:        c.Wait(),
-					WaitForJobs: c.WaitForJobs(),
+					Set:             c.Set(),
+					SkipCleanup:     c.RetainValuesFiles() || c.SkipCleanup(),
+					SkipCRDs:        c.SkipCRDs(),
+					Wait:            c.Wait(),
+					WaitForJobs:     c.WaitForJobs(),
+					HelmfileCommand: "apply",
 				}
 				return subst.SyncReleases(&affectedReleases, helm, c.Values(), c.Concurrency(), &syncOpts)
 			}))
@@ -1410,13 +1898,47 @@ Do you really want to apply?
 				syncErrs = append(syncErrs, updateErrs...)
 			}
 		}
+
+		a.notifyComplete(st, "apply", &affectedReleases, syncErrs)
+		a.auditResult(st, "apply", &affectedReleases, syncErrs)
 	}
 
+	a.saveFingerprints(st, fingerprints, releasesWithNoChange, &affectedReleases)
+
 	affectedReleases.DisplayAffectedReleases(c.Logger())
 	return true, true, syncErrs
 }
 
-func (a *App) delete(r *Run, purge bool, c DestroyConfigProvider) (bool, []error) {
+// saveFingerprints saves the fingerprints of the releases found unchanged or upgraded by `apply --incremental`,
+// so that they are not diffed in the next apply unless changed.
+// A failure to save never fails the command, as it only makes the next apply slower
+func (a *App) saveFingerprints(st *state.HelmState, fingerprints *state.FingerprintStore, unchanged map[string]state.ReleaseSpec, affected *state.AffectedReleases) {
+	if fingerprints == nil {
+		return
+	}
+
+	var applied, deleted []state.ReleaseSpec
+
+	for id := range unchanged {
+		applied = append(applied, unchanged[id])
+	}
+
+	if affected != nil {
+		for _, r := range affected.Upgraded {
+			applied = append(applied, *r)
+		}
+
+		for _, r := range affected.Deleted {
+			deleted = append(deleted, *r)
+		}
+	}
+
+	if err := st.SaveFingerprints(fingerprints, applied, deleted); err != nil {
+		a.Logger.Warnf("warn: saving fingerprints: %v\n", err)
+	}
+}
+
+func (a *App) delete(r *Run, helmfileCommand string, purge bool, c DestroyConfigProvider) (bool, []error) {
 	st := r.state
 	helm := r.helm
 
@@ -1480,8 +2002,10 @@ Do you really want to delete?
 	if !interactive || interactive && r.askForConfirmation(msg) {
 		r.helm.SetExtraArgs(argparser.GetArgs(c.Args(), r.state)...)
 
+		a.notifyStart(st, helmfileCommand, &affectedReleases)
+
 		if len(releasesToDelete) > 0 {
-			_, deletionErrs := withDAG(st, helm, a.Logger, state.PlanOptions{SelectedReleases: toDelete, Reverse: true, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
+			_, deletionErrs := scheduleReleases(c.DAG(), c.Concurrency(), st, helm, a.Logger, state.PlanOptions{SelectedReleases: toDelete, Reverse: true, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
 				return subst.DeleteReleases(&affectedReleases, helm, c.Concurrency(), purge)
 			}))
 
@@ -1489,6 +2013,9 @@ Do you really want to delete?
 				errs = append(errs, deletionErrs...)
 			}
 		}
+
+		a.notifyComplete(st, helmfileCommand, &affectedReleases, errs)
+		a.auditResult(st, helmfileCommand, &affectedReleases, errs)
 	}
 	affectedReleases.DisplayAffectedReleases(c.Logger())
 	return true, errs
@@ -1615,7 +2142,7 @@ func (a *App) lint(r *Run, c LintConfigProvider) (bool, []error, []error) {
 	return true, deferredLintErrs, errs
 }
 
-func (a *App) status(r *Run, c StatusesConfigProvider) (bool, []error) {
+func (a *App) status(r *Run, c StatusesConfigProvider, statuses *[]state.ReleaseStatus) (bool, []error) {
 	st := r.state
 	helm := r.helm
 
@@ -1657,7 +2184,14 @@ func (a *App) status(r *Run, c StatusesConfigProvider) (bool, []error) {
 
 	if len(toStatus) > 0 {
 		_, templateErrs := withDAG(st, helm, a.Logger, state.PlanOptions{SelectedReleases: toStatus, Reverse: false, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
-			return subst.ReleaseStatuses(helm, c.Concurrency())
+			if c.Output() == "" {
+				return subst.ReleaseStatuses(helm, c.Concurrency())
+			}
+
+			s, errs := subst.GetReleaseStatuses(helm, c.Concurrency())
+			*statuses = append(*statuses, s...)
+
+			return errs
 		}))
 
 		if len(templateErrs) > 0 {
@@ -1779,8 +2313,10 @@ func (a *App) sync(r *Run, c SyncConfigProvider) (bool, []error) {
 
 	affectedReleases := state.AffectedReleases{}
 
+	a.notifyStart(st, "sync", &affectedReleases)
+
 	if len(releasesToDelete) > 0 {
-		_, deletionErrs := withDAG(st, helm, a.Logger, state.PlanOptions{Reverse: true, SelectedReleases: toDelete, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
+		_, deletionErrs := scheduleReleases(c.DAG(), c.Concurrency(), st, helm, a.Logger, state.PlanOptions{Reverse: true, SelectedReleases: toDelete, SkipNeeds: true}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
 			var rs []state.ReleaseSpec
 
 			for _, r := range subst.Releases {
@@ -1801,7 +2337,7 @@ func (a *App) sync(r *Run, c SyncConfigProvider) (bool, []error) {
 	}
 
 	if len(releasesToUpdate) > 0 {
-		_, syncErrs := withDAG(st, helm, a.Logger, state.PlanOptions{SelectedReleases: toUpdate, SkipNeeds: true, IncludeTransitiveNeeds: c.IncludeTransitiveNeeds()}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
+		_, syncErrs := scheduleReleases(c.DAG(), c.Concurrency(), st, helm, a.Logger, state.PlanOptions{SelectedReleases: toUpdate, SkipNeeds: true, IncludeTransitiveNeeds: c.IncludeTransitiveNeeds()}, a.WrapWithoutSelector(func(subst *state.HelmState, helm helmexec.Interface) []error {
 			var rs []state.ReleaseSpec
 
 			for _, r := range subst.Releases {
@@ -1826,11 +2362,66 @@ func (a *App) sync(r *Run, c SyncConfigProvider) (bool, []error) {
 			errs = append(errs, syncErrs...)
 		}
 	}
+
+	a.notifyComplete(st, "sync", &affectedReleases, errs)
+	a.auditResult(st, "sync", &affectedReleases, errs)
+
 	affectedReleases.DisplayAffectedReleases(c.Logger())
 	return true, errs
 }
 
-func (a *App) template(r *Run, c TemplateConfigProvider) (bool, []error) {
+// auditResult records the releases affected by the helmfile command to the audit log.
+// A failure to record is logged as a warning without failing the command
+func (a *App) auditResult(st *state.HelmState, helmfileCommand string, affected *state.AffectedReleases, errs []error) {
+	var sinks audit.MultiSink
+	if a.AuditLog != "" {
+		sinks = append(sinks, audit.NewFileSink(a.AuditLog))
+	}
+	if a.AuditSink != nil {
+		sinks = append(sinks, a.AuditSink)
+	}
+
+	if len(sinks) == 0 {
+		return
+	}
+
+	base := audit.Record{
+		Time:            time.Now().UTC(),
+		User:            audit.CurrentUser(),
+		Description:     a.Description,
+		HelmfileCommand: helmfileCommand,
+		Selectors:       a.Selectors,
+	}
+
+	records := st.AuditRecords(base, affected, errs)
+	if len(records) == 0 {
+		return
+	}
+
+	if err := sinks.Write(records); err != nil {
+		a.Logger.Warnf("warn: %v\n", err)
+	}
+}
+
+// notifyStart sends the start notifications configured in the state, and makes the affected releases send the
+// per-release notifications as soon as each release is processed. A failure to notify never fails the command
+func (a *App) notifyStart(st *state.HelmState, helmfileCommand string, affected *state.AffectedReleases) {
+	if err := st.NotifyStart(helmfileCommand, affected); err != nil {
+		a.Logger.Warnf("warn: %v\n", err)
+	}
+}
+
+// notifyComplete sends the completion notifications configured in the state.
+// A failure to notify never fails the command
+func (a *App) notifyComplete(st *state.HelmState, helmfileCommand string, affected *state.AffectedReleases, errs []error) {
+	if err := st.NotifyComplete(helmfileCommand, affected, errs); err != nil {
+		a.Logger.Warnf("warn: %v\n", err)
+	}
+}
+
+// template renders the releases with `helm template`.
+// When onRendered is not nil, it's called with the resources of each release instead of writing the manifests.
+func (a *App) template(r *Run, c TemplateConfigProvider, onRendered func(*state.ReleaseSpec, []manifest.Resource) error) (bool, []error) {
 	st := r.state
 	helm := r.helm
 
@@ -1896,6 +2487,7 @@ func (a *App) template(r *Run, c TemplateConfigProvider) (bool, []error) {
 				OutputDirTemplate: c.OutputDirTemplate(),
 				SkipCleanup:       c.SkipCleanup(),
 				SkipTests:         c.SkipTests(),
+				OnRendered:        onRendered,
 			}
 			return subst.TemplateReleases(helm, c.OutputDir(), c.Values(), args, c.Concurrency(), c.Validate(), opts)
 		}))
@@ -2117,6 +2709,12 @@ func (c context) wrapErrs(errs ...error) error {
 	return nil
 }
 
+// RenderCacheDir returns the directory the prepared charts are cached to with --render-cache,
+// that is under the cache directory so that it is managed by `helmfile cache info` and `helmfile cache cleanup`
+func RenderCacheDir() string {
+	return filepath.Join(remote.CacheDir(), "render")
+}
+
 func (a *App) ShowCacheDir(c ListConfigProvider) error {
 	fmt.Printf("Cache directory: %s\n", remote.CacheDir())
 
@@ -2255,6 +2255,11 @@ type configImpl struct {
 	skipNeeds              bool
 	includeNeeds           bool
 	includeTransitiveNeeds bool
+
+	withStatus bool
+
+	outputDir  string
+	outputMode string
 }
 
 func (a configImpl) Selectors() []string {
@@ -2305,10 +2310,21 @@ func (c configImpl) IncludeTransitiveNeeds() bool {
 	return c.includeTransitiveNeeds
 }
 
+func (c configImpl) DAG() bool {
+	return false
+}
+
 func (c configImpl) OutputDir() string {
+	if c.outputDir != "" {
+		return c.outputDir
+	}
 	return "output/subdir"
 }
 
+func (c configImpl) OutputMode() string {
+	return c.outputMode
+}
+
 func (c configImpl) OutputDirTemplate() string {
 	return ""
 }
@@ -2329,6 +2345,10 @@ func (c configImpl) Output() string {
 	return c.output
 }
 
+func (c configImpl) WithStatus() bool {
+	return c.withStatus
+}
+
 type applyConfig struct {
 	args                   string
 	values                 []string
@@ -2356,6 +2376,12 @@ type applyConfig struct {
 	logger                 *zap.SugaredLogger
 	wait                   bool
 	waitForJobs            bool
+	dag                    bool
+	incremental            bool
+	forceDiff              bool
+	fingerprintFile        string
+	prune                  bool
+	pruneDryRun            bool
 }
 
 func (a applyConfig) Args() string {
@@ -2462,6 +2488,30 @@ func (a applyConfig) SkipDiffOnInstall() bool {
 	return a.skipDiffOnInstall
 }
 
+func (a applyConfig) DAG() bool {
+	return a.dag
+}
+
+func (a applyConfig) Prune() bool {
+	return a.prune
+}
+
+func (a applyConfig) PruneDryRun() bool {
+	return a.pruneDryRun
+}
+
+func (a applyConfig) Incremental() bool {
+	return a.incremental
+}
+
+func (a applyConfig) ForceDiff() bool {
+	return a.forceDiff
+}
+
+func (a applyConfig) FingerprintFile() string {
+	return a.fingerprintFile
+}
+
 type depsConfig struct {
 	skipRepos              bool
 	includeTransitiveNeeds bool
@@ -2493,7 +2543,7 @@ func (mock *mockRunner) Execute(cmd string, args []string, env map[string]string
 }
 
 func MockExecer(logger *zap.SugaredLogger, kubeContext string) helmexec.Interface {
-	execer := helmexec.New("helm", logger, kubeContext, &mockRunner{})
+	execer := helmexec.New("helm", logger, kubeContext, &mockRunner{}, &bytes.Buffer{}, "")
 	return execer
 }
 
@@ -2513,6 +2563,9 @@ type mockRepo struct {
 	Name string
 }
 
+func (helm *mockHelmExec) GetManifests(name, chart string, flags ...string) ([]byte, error) {
+	return nil, nil
+}
 func (helm *mockHelmExec) TemplateRelease(name, chart string, flags ...string) error {
 	helm.templated = append(helm.templated, mockTemplates{name: name, chart: chart, flags: flags})
 	return nil
@@ -2526,6 +2579,10 @@ func (helm *mockHelmExec) ChartExport(chart string, path string, flags ...string
 	return nil
 }
 
+func (helm *mockHelmExec) VerifyChart(path string, flags ...string) error {
+	return nil
+}
+
 func (helm *mockHelmExec) UpdateDeps(chart string) error {
 	return nil
 }
@@ -2557,6 +2614,18 @@ func (helm *mockHelmExec) DiffRelease(context helmexec.HelmContext, name, chart
 func (helm *mockHelmExec) ReleaseStatus(context helmexec.HelmContext, release string, flags ...string) error {
 	return nil
 }
+func (helm *mockHelmExec) GetReleaseStatus(context helmexec.HelmContext, release string, flags ...string) ([]byte, error) {
+	return nil, nil
+}
+func (helm *mockHelmExec) GetReleases(context helmexec.HelmContext, flags ...string) ([]byte, error) {
+	return nil, nil
+}
+func (helm *mockHelmExec) GetValues(context helmexec.HelmContext, name string, flags ...string) ([]byte, error) {
+	return nil, nil
+}
+func (helm *mockHelmExec) SearchRepo(keyword string, flags ...string) ([]byte, error) {
+	return nil, nil
+}
 func (helm *mockHelmExec) DeleteRelease(context helmexec.HelmContext, name string, flags ...string) error {
 	return nil
 }
@@ -4816,10 +4885,7 @@ releases:
     id: myrelease1
 `,
 	}
-	stdout := os.Stdout
-	defer func() { os.Stdout = stdout }()
-
-	var buffer bytes.Buffer
+	var buffer, out bytes.Buffer
 	logger := helmexec.NewLogger(&buffer, "debug")
 
 	app := appWithFs(&App{
@@ -4830,14 +4896,13 @@ releases:
 		Env:                 "default",
 		Logger:              logger,
 		Namespace:           "testNamespace",
+		Writer:              &out,
 	}, files)
 
 	expectNoCallsToHelm(app)
 
-	out := captureStdout(func() {
-		err := app.ListReleases(configImpl{})
-		assert.NilError(t, err)
-	})
+	err := app.ListReleases(configImpl{})
+	assert.NilError(t, err)
 
 	expected := `NAME      	NAMESPACE	ENABLED	INSTALLED	LABELS                    	CHART   	VERSION
 myrelease1	         	true   	false    	common:label,id:myrelease1	mychart1	       
@@ -4846,7 +4911,7 @@ myrelease3	         	true   	true     	                          	mychart1
 myrelease4	         	true   	true     	id:myrelease1             	mychart1	       
 `
 
-	assert.Equal(t, expected, out)
+	assert.Equal(t, expected, out.String())
 }
 
 func TestListWithJsonOutput(t *testing.T) {
@@ -4878,10 +4943,7 @@ releases:
     id: myrelease1
 `,
 	}
-	stdout := os.Stdout
-	defer func() { os.Stdout = stdout }()
-
-	var buffer bytes.Buffer
+	var buffer, out bytes.Buffer
 	logger := helmexec.NewLogger(&buffer, "debug")
 
 	app := appWithFs(&App{
@@ -4892,20 +4954,155 @@ releases:
 		Env:                 "default",
 		Logger:              logger,
 		Namespace:           "testNamespace",
+		Writer:              &out,
 	}, files)
 
 	expectNoCallsToHelm(app)
 
-	out := captureStdout(func() {
-		err := app.ListReleases(configImpl{
-			output: "json",
-		})
-		assert.NilError(t, err)
+	err := app.ListReleases(configImpl{
+		output: "json",
 	})
+	assert.NilError(t, err)
 
 	expected := `[{"name":"myrelease1","namespace":"","enabled":true,"installed":false,"labels":"id:myrelease1","chart":"mychart1","version":""},{"name":"myrelease2","namespace":"","enabled":false,"installed":true,"labels":"","chart":"mychart1","version":""},{"name":"myrelease3","namespace":"","enabled":true,"installed":true,"labels":"","chart":"mychart1","version":""},{"name":"myrelease4","namespace":"","enabled":true,"installed":true,"labels":"id:myrelease1","chart":"mychart1","version":""}]
 `
-	assert.Equal(t, expected, out)
+	assert.Equal(t, expected, out.String())
+}
+
+func TestListWithStatus(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.d/first.yaml": `
+releases:
+- name: myrelease1
+  namespace: apps
+  chart: stable/mychart1
+  version: 1.2.0
+- name: myrelease2
+  namespace: apps
+  chart: stable/mychart2
+`,
+		"/path/to/helmfile.d/second.yaml": `
+releases:
+- name: myrelease3
+  namespace: apps
+  chart: stable/mychart1
+  installed: false
+`,
+	}
+
+	helm := &exectest.Helm{
+		Helm3: true,
+		Deployed: map[string]string{
+			"apps": `[
+{"name":"myrelease1","namespace":"apps","revision":"3","updated":"2021-05-01 10:00:00.0 +0000 UTC","status":"deployed","chart":"mychart1-1.1.0","app_version":"2.0"},
+{"name":"myrelease3","namespace":"apps","revision":"1","updated":"2021-05-01 10:00:00.0 +0000 UTC","status":"failed","chart":"mychart1-1.2.0","app_version":"2.1"},
+{"name":"legacy","namespace":"apps","revision":"12","updated":"2020-01-01 10:00:00.0 +0000 UTC","status":"deployed","chart":"legacy-0.1.0","app_version":""}
+]`,
+		},
+	}
+
+	testcases := []struct {
+		output, selector, expected string
+	}{
+		{
+			output: "table",
+			expected: `NAME      	NAMESPACE	ENABLED	INSTALLED	LABELS	CHART          	VERSION	REVISION	STATUS  	DEPLOYED VERSION	APP VERSION	ORPHAN
+myrelease1	apps     	true   	true     	      	stable/mychart1	1.2.0  	3       	deployed	1.1.0           	2.0        	false 
+myrelease2	apps     	true   	true     	      	stable/mychart2	       	        	        	                	           	false 
+myrelease3	apps     	true   	false    	      	stable/mychart1	       	1       	failed  	1.2.0           	2.1        	false 
+legacy    	apps     	false  	false    	      	legacy         	       	12      	deployed	0.1.0           	           	true  
+`,
+		},
+		{
+			output: "csv",
+			expected: `NAME,NAMESPACE,ENABLED,INSTALLED,LABELS,CHART,VERSION,REVISION,STATUS,DEPLOYED VERSION,APP VERSION,ORPHAN
+myrelease1,apps,true,true,,stable/mychart1,1.2.0,3,deployed,1.1.0,2.0,false
+myrelease2,apps,true,true,,stable/mychart2,,,,,,false
+myrelease3,apps,true,false,,stable/mychart1,,1,failed,1.2.0,2.1,false
+legacy,apps,false,false,,legacy,,12,deployed,0.1.0,,true
+`,
+		},
+		{
+			output:   "yaml",
+			selector: "name=myrelease1",
+			expected: `- name: myrelease1
+  namespace: apps
+  enabled: true
+  installed: true
+  labels: chart:mychart1,name:myrelease1,namespace:apps
+  chart: stable/mychart1
+  version: 1.2.0
+  revision: 3
+  status: deployed
+  deployedVersion: 1.1.0
+  appVersion: "2.0"
+`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.output, func(t *testing.T) {
+			var buffer, out bytes.Buffer
+			logger := helmexec.NewLogger(&buffer, "debug")
+
+			var selectors []string
+			if tc.selector != "" {
+				selectors = []string{tc.selector}
+			}
+
+			app := appWithFs(&App{
+				OverrideHelmBinary:  DefaultHelmBinary,
+				glob:                filepath.Glob,
+				abs:                 filepath.Abs,
+				OverrideKubeContext: "default",
+				Env:                 "default",
+				Logger:              logger,
+				Selectors:           selectors,
+				Writer:              &out,
+				helms: map[helmKey]helmexec.Interface{
+					createHelmKey("helm", "default"): helm,
+				},
+			}, files)
+
+			err := app.ListReleases(configImpl{output: tc.output, withStatus: true})
+			assert.NilError(t, err)
+
+			if d := cmp.Diff(tc.expected, out.String()); d != "" {
+				t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestListWithUnsupportedOutput(t *testing.T) {
+	app := appWithFs(&App{
+		OverrideHelmBinary: DefaultHelmBinary,
+		Env:                "default",
+	}, map[string]string{})
+
+	err := app.ListReleases(configImpl{output: "xml"})
+	assert.ErrorContains(t, err, `unsupported output format "xml"`)
+}
+
+func TestFormatAsCsvWithChartDigests(t *testing.T) {
+	releases := []*HelmRelease{
+		{Name: "foo", Enabled: true, Installed: true, Chart: "oci://registry.example.com/charts/foo", Version: "1.0.0", ChartDigest: "sha256:abc"},
+		{Name: "bar", Enabled: true, Installed: true, Chart: "stable/bar"},
+	}
+
+	var out bytes.Buffer
+	assert.NilError(t, FormatAsCsv(&out, releases, false))
+
+	expected := `NAME,NAMESPACE,ENABLED,INSTALLED,LABELS,CHART,VERSION,DIGEST
+foo,,true,true,,oci://registry.example.com/charts/foo,1.0.0,sha256:abc
+bar,,true,true,,stable/bar,,
+`
+	assert.Equal(t, expected, out.String())
+
+	out.Reset()
+	assert.NilError(t, FormatAsCsv(&out, releases[1:], false))
+
+	assert.Equal(t, "NAME,NAMESPACE,ENABLED,INSTALLED,LABELS,CHART,VERSION\nbar,,true,true,,stable/bar,\n", out.String())
 }
 
 func TestSetValuesTemplate(t *testing.T) {
@@ -14,6 +14,10 @@ type ConfigProvider interface {
 	StateValuesSet() map[string]interface{}
 	StateValuesFiles() []string
 	Env() string
+	AuditLog() string
+	RenderCache() bool
+	Timings() bool
+	TimingsFile() string
 
 	loggingConfig
 }
@@ -37,6 +41,14 @@ type ReposConfigProvider interface {
 	IncludeTransitiveNeeds() bool
 }
 
+type ReposListConfigProvider interface {
+	Output() string
+}
+
+type ReposCheckConfigProvider interface {
+	Output() string
+}
+
 type ApplyConfigProvider interface {
 	Args() string
 
@@ -69,9 +81,13 @@ type ApplyConfigProvider interface {
 	IncludeNeeds() bool
 	IncludeTransitiveNeeds() bool
 
-	concurrencyConfig
-	interactive
-	loggingConfig
+	DAG() bool
+
+	Incremental() bool
+	ForceDiff() bool
+	FingerprintFile() string
+
+	pruneConfig
 }
 
 type SyncConfigProvider interface {
@@ -88,8 +104,9 @@ type SyncConfigProvider interface {
 	IncludeNeeds() bool
 	IncludeTransitiveNeeds() bool
 
-	concurrencyConfig
-	loggingConfig
+	DAG() bool
+
+	pruneConfig
 }
 
 type DiffConfigProvider interface {
@@ -120,11 +137,19 @@ type DiffConfigProvider interface {
 	concurrencyConfig
 }
 
+// DiffCommandConfigProvider is the config of `helmfile diff`, that diffs against either the cluster or another revision of the helmfile
+type DiffCommandConfigProvider interface {
+	DiffConfigProvider
+
+	Against() string
+}
+
 type DeleteConfigProvider interface {
 	Args() string
 
 	Purge() bool
 	SkipDeps() bool
+	DAG() bool
 
 	interactive
 	loggingConfig
@@ -135,6 +160,7 @@ type DestroyConfigProvider interface {
 	Args() string
 
 	SkipDeps() bool
+	DAG() bool
 
 	interactive
 	loggingConfig
@@ -181,6 +207,7 @@ type TemplateConfigProvider interface {
 	SkipCleanup() bool
 	SkipTests() bool
 	OutputDir() string
+	OutputMode() string
 	IncludeCRDs() bool
 	IncludeNeeds() bool
 	IncludeTransitiveNeeds() bool
@@ -197,8 +224,26 @@ type WriteValuesConfigProvider interface {
 	IncludeTransitiveNeeds() bool
 }
 
+type ExportConfigProvider interface {
+	Format() string
+	ResourceNamespace() string
+	ArgoCDProject() string
+	ArgoCDServer() string
+	ValuesAs() string
+	IncludeSecrets() bool
+	OutputDir() string
+}
+
+type ImportConfigProvider interface {
+	KubeContexts() []string
+	Namespaces() []string
+	OutputDir() string
+	SkipRepos() bool
+}
+
 type StatusesConfigProvider interface {
 	Args() string
+	Output() string
 
 	concurrencyConfig
 }
@@ -219,6 +264,31 @@ type interactive interface {
 	Interactive() bool
 }
 
+type pruneConfig interface {
+	// Prune deletes the releases labeled with the helmfile that are no longer declared in any helmfile
+	Prune() bool
+	// PruneDryRun prints the releases to be pruned without deleting them
+	PruneDryRun() bool
+
+	concurrencyConfig
+	interactive
+	loggingConfig
+}
+
 type ListConfigProvider interface {
 	Output() string
+	WithStatus() bool
+}
+
+type ValidateConfigProvider interface {
+	PrintSchema() bool
+}
+
+type ExplainConfigProvider interface {
+	Release() string
+	Key() string
+}
+
+type EnvironmentsConfigProvider interface {
+	ShowValues() bool
 }
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+type dagResult struct {
+	index     int
+	processed bool
+	errs      []error
+	start     time.Time
+	end       time.Time
+}
+
+// withParallelDAG is the alternative to withDAG that starts processing each release as soon as the releases it needs are processed,
+// instead of waiting for all the releases in the previous group.
+// It processes at most `concurrency` releases at once, or all the ready releases when `concurrency` is 0, within the limits of
+// helmDefaults.maxConcurrency, maxConcurrencyPerContext and maxConcurrencyPerNamespace across all the releases.
+//
+// When a release fails, the releases that depend on it are skipped and reported as failed, while the others are processed as usual.
+// The critical path, that is the chain of releases that determined the total time, is logged at the end.
+func withParallelDAG(templated *state.HelmState, helm helmexec.Interface, logger *zap.SugaredLogger, opts state.PlanOptions, concurrency int, converge func(*state.HelmState, helmexec.Interface) (bool, []error)) (bool, []error) {
+	nodes, err := templated.PlanReleaseGraph(opts)
+	if err != nil {
+		return false, []error{err}
+	}
+
+	if len(nodes) == 0 {
+		return false, nil
+	}
+
+	logger.Debugf("processing %d releases as soon as their dependencies are processed:\n%s", len(nodes), printReleaseGraph(nodes))
+
+	dependents := make([][]int, len(nodes))
+	pending := make([]int, len(nodes))
+
+	var ready []int
+
+	for i, n := range nodes {
+		pending[i] = len(n.Dependencies)
+		for _, d := range n.Dependencies {
+			dependents[d] = append(dependents[d], i)
+		}
+
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	limit := templated.ConcurrencyLimit(concurrency, len(nodes))
+
+	releases := make([]*state.ReleaseSpec, len(nodes))
+	for i := range nodes {
+		releases[i] = &nodes[i].ReleaseSpec
+	}
+
+	// Each release is processed by its own run, so the limits per kube context and namespace are enforced across the runs
+	scheduler := templated.NewReleaseScheduler(releases)
+
+	results := make(chan dagResult)
+
+	starts := make([]time.Time, len(nodes))
+	ends := make([]time.Time, len(nodes))
+	skipped := make([]bool, len(nodes))
+
+	begin := time.Now()
+	running := 0
+	processedAny := false
+
+	var errs []error
+
+	for len(ready) > 0 || running > 0 {
+		for running < limit {
+			// Nodes are ordered by the group and then the definition, so that the releases are processed
+			// in the same order as withDAG when the concurrency is 1.
+			// The releases whose kube context or namespace has reached the limit wait for the running ones.
+			p := -1
+			for j, i := range ready {
+				if scheduler.TryStart(i) {
+					p = j
+					break
+				}
+			}
+
+			if p == -1 {
+				break
+			}
+
+			i := ready[p]
+			ready = append(ready[:p], ready[p+1:]...)
+
+			logger.Debugf("processing release %s", state.ReleaseToID(&nodes[i].ReleaseSpec))
+
+			running++
+
+			go func(i int) {
+				st := *templated
+				st.Releases = []state.ReleaseSpec{nodes[i].ReleaseSpec}
+
+				start := time.Now()
+				processed, errs := converge(&st, helm)
+
+				results <- dagResult{index: i, processed: processed, errs: errs, start: start, end: time.Now()}
+			}(i)
+		}
+
+		res := <-results
+		running--
+		scheduler.Done(res.index)
+
+		starts[res.index], ends[res.index] = res.start, res.end
+
+		if len(res.errs) > 0 {
+			errs = append(errs, res.errs...)
+
+			failedID := state.ReleaseToID(&nodes[res.index].ReleaseSpec)
+
+			queue := append([]int{}, dependents[res.index]...)
+			for len(queue) > 0 {
+				d := queue[0]
+				queue = queue[1:]
+
+				if skipped[d] {
+					continue
+				}
+				skipped[d] = true
+
+				skippedID := state.ReleaseToID(&nodes[d].ReleaseSpec)
+
+				logger.Warnf("skipped processing release %s due to the failure of %s", skippedID, failedID)
+
+				errs = append(errs, fmt.Errorf("release %s was skipped due to the failure of %s", skippedID, failedID))
+
+				queue = append(queue, dependents[d]...)
+			}
+
+			continue
+		}
+
+		processedAny = processedAny || res.processed
+
+		for _, d := range dependents[res.index] {
+			pending[d]--
+
+			if pending[d] == 0 && !skipped[d] {
+				ready = append(ready, d)
+				sort.Ints(ready)
+			}
+		}
+	}
+
+	if path := criticalPath(nodes, starts, ends); len(path) > 0 {
+		var steps []string
+		for _, i := range path {
+			steps = append(steps, fmt.Sprintf("%s (%s)", state.ReleaseToID(&nodes[i].ReleaseSpec), ends[i].Sub(starts[i]).Round(time.Millisecond)))
+		}
+
+		last := path[len(path)-1]
+
+		logger.Infof("critical path (%s): %s", ends[last].Sub(begin).Round(time.Millisecond), strings.Join(steps, " -> "))
+	}
+
+	return processedAny, errs
+}
+
+// criticalPath returns the indices of the nodes that determined the total time, from the first one to the last one.
+// It starts from the node that ended last, and follows the dependency that ended last, that is the one the node waited for.
+// Nodes that have not been processed have the zero end time and are ignored.
+func criticalPath(nodes []state.ReleaseNode, starts, ends []time.Time) []int {
+	last := -1
+
+	for i := range nodes {
+		if ends[i].IsZero() {
+			continue
+		}
+
+		if last == -1 || ends[i].After(ends[last]) {
+			last = i
+		}
+	}
+
+	var path []int
+
+	for i := last; i != -1; {
+		path = append([]int{i}, path...)
+
+		next := -1
+		for _, d := range nodes[i].Dependencies {
+			if ends[d].IsZero() {
+				continue
+			}
+
+			if next == -1 || ends[d].After(ends[next]) {
+				next = d
+			}
+		}
+
+		i = next
+	}
+
+	return path
+}
+
+func printReleaseGraph(nodes []state.ReleaseNode) string {
+	buf := &bytes.Buffer{}
+
+	w := new(tabwriter.Writer)
+
+	w.Init(buf, 0, 1, 1, ' ', 0)
+
+	fmt.Fprintln(w, "RELEASE\tAFTER")
+
+	for _, n := range nodes {
+		var deps []string
+		for _, d := range n.Dependencies {
+			deps = append(deps, state.ReleaseToID(&nodes[d].ReleaseSpec))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", state.ReleaseToID(&n.ReleaseSpec), strings.Join(deps, ", "))
+	}
+
+	w.Flush()
+
+	return buf.String()
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+func TestWithParallelDAG(t *testing.T) {
+	releases := []state.ReleaseSpec{
+		{Name: "slow", Namespace: "ns1"},
+		{Name: "after-slow", Namespace: "ns1", Needs: []string{"slow"}},
+		{Name: "fast", Namespace: "ns2"},
+		{Name: "after-fast", Namespace: "ns2", Needs: []string{"fast"}},
+	}
+
+	testcases := []struct {
+		name        string
+		concurrency int
+		reverse     bool
+		fail        string
+		defaults    state.HelmSpec
+
+		wantOrder      []string
+		wantErrs       int
+		wantSkipped    []string
+		wantMaxRunning int
+	}{
+		{
+			name:        "releases start as soon as their needs are processed",
+			concurrency: 2,
+			wantOrder:   []string{"fast", "after-fast", "slow", "after-slow"},
+		},
+		{
+			name:        "the same order as batches with the concurrency of 1",
+			concurrency: 1,
+			wantOrder:   []string{"slow", "fast", "after-slow", "after-fast"},
+		},
+		{
+			name:        "reverse",
+			concurrency: 2,
+			reverse:     true,
+			wantOrder:   []string{"after-fast", "fast", "after-slow", "slow"},
+		},
+		{
+			name:        "dependents of failed releases are skipped",
+			concurrency: 0,
+			fail:        "slow",
+			wantOrder:   []string{"fast", "after-fast", "slow"},
+			wantErrs:    2,
+			wantSkipped: []string{"after-slow"},
+		},
+		{
+			name:           "maxConcurrency",
+			concurrency:    0,
+			defaults:       state.HelmSpec{MaxConcurrency: 1},
+			wantOrder:      []string{"slow", "fast", "after-slow", "after-fast"},
+			wantMaxRunning: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &state.HelmState{
+				ReleaseSetSpec: state.ReleaseSetSpec{Releases: releases, HelmDefaults: tc.defaults},
+				RenderedValues: map[string]interface{}{},
+			}
+
+			var mu sync.Mutex
+			var order []string
+			running, maxRunning := 0, 0
+
+			converge := func(subst *state.HelmState, helm helmexec.Interface) (bool, []error) {
+				if len(subst.Releases) != 1 {
+					t.Errorf("expected a release at a time, got %v", subst.Releases)
+				}
+
+				r := subst.Releases[0]
+
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+
+				d := 10 * time.Millisecond
+				if r.Name == "slow" || (tc.reverse && r.Name == "after-slow") {
+					d = 100 * time.Millisecond
+				}
+				time.Sleep(d)
+
+				mu.Lock()
+				running--
+				order = append(order, r.Name)
+				mu.Unlock()
+
+				if r.Name == tc.fail {
+					return false, []error{errors.New("failed")}
+				}
+
+				return true, nil
+			}
+
+			opts := state.PlanOptions{SelectedReleases: releases, SkipNeeds: true, Reverse: tc.reverse}
+
+			processed, errs := withParallelDAG(st, &exectest.Helm{}, helmexec.NewLogger(os.Stderr, "debug"), opts, tc.concurrency, converge)
+
+			if len(errs) != tc.wantErrs {
+				t.Errorf("unexpected errors: want %d, got %v", tc.wantErrs, errs)
+			}
+
+			if !processed {
+				t.Error("expected releases to be processed")
+			}
+
+			if d := cmp.Diff(tc.wantOrder, order); d != "" {
+				t.Errorf("unexpected order: want (-), got (+):\n%s", d)
+			}
+
+			if tc.concurrency > 0 && maxRunning > tc.concurrency {
+				t.Errorf("concurrency exceeded the limit: want %d, got %d", tc.concurrency, maxRunning)
+			}
+
+			if tc.wantMaxRunning > 0 && maxRunning > tc.wantMaxRunning {
+				t.Errorf("concurrency exceeded the limit of helmDefaults: want %d, got %d", tc.wantMaxRunning, maxRunning)
+			}
+
+			for _, name := range tc.wantSkipped {
+				for _, o := range order {
+					if o == name {
+						t.Errorf("expected %s to be skipped", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWithParallelDAG_MaxConcurrencyPerNamespace(t *testing.T) {
+	st := &state.HelmState{
+		ReleaseSetSpec: state.ReleaseSetSpec{
+			Releases: []state.ReleaseSpec{
+				{Name: "a", Namespace: "ns1"},
+				{Name: "b", Namespace: "ns1"},
+				{Name: "c", Namespace: "ns1"},
+				{Name: "d", Namespace: "ns2"},
+			},
+			HelmDefaults: state.HelmSpec{MaxConcurrencyPerNamespace: 1},
+		},
+		RenderedValues: map[string]interface{}{},
+	}
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+
+	converge := func(subst *state.HelmState, helm helmexec.Interface) (bool, []error) {
+		ns := subst.Releases[0].Namespace
+
+		mu.Lock()
+		running[ns]++
+		if running[ns] > maxRunning[ns] {
+			maxRunning[ns] = running[ns]
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running[ns]--
+		mu.Unlock()
+
+		return true, nil
+	}
+
+	opts := state.PlanOptions{SelectedReleases: st.Releases, SkipNeeds: true}
+
+	processed, errs := withParallelDAG(st, &exectest.Helm{}, helmexec.NewLogger(os.Stderr, "debug"), opts, 0, converge)
+	if !processed || len(errs) > 0 {
+		t.Fatalf("unexpected result: processed=%v, errs=%v", processed, errs)
+	}
+
+	if d := cmp.Diff(map[string]int{"ns1": 1, "ns2": 1}, maxRunning); d != "" {
+		t.Errorf("unexpected max concurrency per namespace: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	nodes := []state.ReleaseNode{
+		{Release: state.Release{ReleaseSpec: state.ReleaseSpec{Name: "a"}}},
+		{Release: state.Release{ReleaseSpec: state.ReleaseSpec{Name: "b"}}},
+		{Release: state.Release{ReleaseSpec: state.ReleaseSpec{Name: "c"}}, Dependencies: []int{0, 1}},
+		{Release: state.Release{ReleaseSpec: state.ReleaseSpec{Name: "d"}}, Dependencies: []int{0}},
+		{Release: state.Release{ReleaseSpec: state.ReleaseSpec{Name: "e"}}, Dependencies: []int{2}},
+	}
+
+	t0 := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time {
+		return t0.Add(time.Duration(s) * time.Second)
+	}
+
+	starts := []time.Time{at(0), at(0), at(20), at(5), {}}
+	// b ended after a, so that c waited for b. e has not been processed due to a failure of c
+	ends := []time.Time{at(5), at(20), at(30), at(25), {}}
+
+	if d := cmp.Diff([]int{1, 2}, criticalPath(nodes, starts, ends)); d != "" {
+		t.Errorf("unexpected critical path: want (-), got (+):\n%s", d)
+	}
+}
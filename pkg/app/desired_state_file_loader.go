@@ -10,6 +10,7 @@ import (
 	"github.com/huolunl/helmfile/pkg/helmexec"
 	"github.com/huolunl/helmfile/pkg/remote"
 	"github.com/huolunl/helmfile/pkg/state"
+	"github.com/huolunl/helmfile/pkg/timing"
 	"github.com/imdario/mergo"
 	"github.com/variantdev/vals"
 	"go.uber.org/zap"
@@ -38,6 +39,11 @@ type desiredStateLoader struct {
 	remote      *remote.Remote
 	logger      *zap.SugaredLogger
 	valsRuntime vals.Evaluator
+
+	// validateSchema instructs the loader to validate every rendered helmfile.yaml part against the JSON Schema
+	validateSchema bool
+
+	timings *timing.Recorder
 }
 
 func (ld *desiredStateLoader) Load(f string, opts LoadOpts) (*state.HelmState, error) {
@@ -138,6 +144,12 @@ func (ld *desiredStateLoader) loadFileWithOverrides(inheritedEnv, overrodeEnv *e
 			evaluateBases,
 		)
 	} else {
+		if ld.validateSchema {
+			if err := ld.validateRenderedPart(f, 1, fileBytes, fileBytes); err != nil {
+				return nil, err
+			}
+		}
+
 		self, err = ld.load(
 			fileBytes,
 			baseDir,
@@ -145,6 +157,7 @@ func (ld *desiredStateLoader) loadFileWithOverrides(inheritedEnv, overrodeEnv *e
 			evaluateBases,
 			inheritedEnv,
 			overrodeEnv,
+			nil,
 		)
 	}
 
@@ -171,13 +184,16 @@ func (a *desiredStateLoader) underlying() *state.StateCreator {
 	return c
 }
 
-func (a *desiredStateLoader) load(yaml []byte, baseDir, file string, evaluateBases bool, env, overrodeEnv *environment.Environment) (*state.HelmState, error) {
+func (a *desiredStateLoader) load(yaml []byte, baseDir, file string, evaluateBases bool, env, overrodeEnv *environment.Environment, templates map[string]state.TemplateSpec) (*state.HelmState, error) {
 	merged, err := env.Merge(overrodeEnv)
 	if err != nil {
 		return nil, err
 	}
 
-	st, err := a.underlying().ParseAndLoad(yaml, baseDir, file, a.env, evaluateBases, merged)
+	c := a.underlying()
+	c.Templates = templates
+
+	st, err := c.ParseAndLoad(yaml, baseDir, file, a.env, evaluateBases, merged)
 	if err != nil {
 		return nil, err
 	}
@@ -198,6 +214,9 @@ func (ld *desiredStateLoader) renderAndLoad(env, overrodeEnv *environment.Enviro
 
 	var finalState *state.HelmState
 
+	// firstLine is the line number in the file the current part starts at
+	firstLine := 1
+
 	for i, part := range parts {
 		var yamlBuf *bytes.Buffer
 		var err error
@@ -216,6 +235,18 @@ func (ld *desiredStateLoader) renderAndLoad(env, overrodeEnv *environment.Enviro
 			}
 		}
 
+		if ld.validateSchema {
+			if err := ld.validateRenderedPart(filename, firstLine, part, yamlBuf.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+		firstLine += bytes.Count(part, []byte("\n")) + 2
+
+		var templates map[string]state.TemplateSpec
+		if finalState != nil {
+			templates = finalState.Templates
+		}
+
 		currentState, err := ld.load(
 			yamlBuf.Bytes(),
 			baseDir,
@@ -223,6 +254,7 @@ func (ld *desiredStateLoader) renderAndLoad(env, overrodeEnv *environment.Enviro
 			evaluateBases,
 			env,
 			overrodeEnv,
+			templates,
 		)
 		if err != nil {
 			return nil, err
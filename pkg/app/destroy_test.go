@@ -55,6 +55,10 @@ func (d destroyConfig) IncludeTransitiveNeeds() bool {
 	return d.includeTransitiveNeeds
 }
 
+func (d destroyConfig) DAG() bool {
+	return false
+}
+
 func TestDestroy(t *testing.T) {
 	type testcase struct {
 		helm3       bool
@@ -0,0 +1,346 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/huolunl/helmfile/pkg/manifest"
+	"github.com/huolunl/helmfile/pkg/manifestdiff"
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+// diffTemplateConfig renders the releases for `helmfile diff --against` with the flags of `helmfile diff`
+type diffTemplateConfig struct {
+	DiffCommandConfigProvider
+}
+
+func (c diffTemplateConfig) OutputDirTemplate() string {
+	return ""
+}
+
+func (c diffTemplateConfig) SkipCleanup() bool {
+	return false
+}
+
+func (c diffTemplateConfig) SkipTests() bool {
+	return !c.IncludeTests()
+}
+
+func (c diffTemplateConfig) OutputDir() string {
+	return ""
+}
+
+func (c diffTemplateConfig) OutputMode() string {
+	return ""
+}
+
+func (c diffTemplateConfig) IncludeCRDs() bool {
+	return !c.SkipCRDs()
+}
+
+func (c diffTemplateConfig) IncludeTransitiveNeeds() bool {
+	return false
+}
+
+// renderedReleases is the resources rendered by `helm template` keyed by the ID of the release
+type renderedReleases map[string][]manifest.Resource
+
+// diffAgainst renders the selected releases in both the working tree and the revision specified by --against,
+// and prints the difference of the resources. It never accesses the cluster unless --validate is given.
+func (a *App) diffAgainst(c DiffCommandConfigProvider) error {
+	dir, cleanup, err := a.checkoutAgainst(c.Against())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	current, err := a.renderReleases(c)
+	if err != nil {
+		return err
+	}
+
+	fileOrDir := a.FileOrDir
+	if filepath.IsAbs(fileOrDir) {
+		wd, err := a.getwd()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(wd, fileOrDir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("--file %s must be in the working directory to diff against %s", fileOrDir, c.Against())
+		}
+
+		fileOrDir = rel
+	}
+
+	var previous renderedReleases
+
+	prevFileOrDir := a.FileOrDir
+	a.FileOrDir = fileOrDir
+	err = a.within(dir, func() error {
+		var err error
+		previous, err = a.renderReleases(c)
+		return err
+	})
+	a.FileOrDir = prevFileOrDir
+	if err != nil {
+		return fmt.Errorf("rendering releases in %s: %v", c.Against(), err)
+	}
+
+	changes, err := diffRenderedReleases(previous, current)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		a.Logger.Infof("No affected releases")
+		return nil
+	}
+
+	// Like helm-diff, the whole resource is shown unless --context is given
+	opts := manifestdiff.FormatOptions{Context: c.Context(), Color: !c.NoColor()}
+	if opts.Context <= 0 {
+		opts.Context = -1
+	}
+
+	var buf bytes.Buffer
+	for _, rc := range changes {
+		if err := rc.write(&buf, c.Against(), opts); err != nil {
+			return err
+		}
+	}
+	a.Writer.Write(buf.Bytes())
+
+	if c.DetailedExitcode() {
+		code := 2
+		return &Error{
+			msg:  "Identified at least one change",
+			code: &code,
+		}
+	}
+
+	return nil
+}
+
+// renderReleases renders the selected releases of the helmfiles in the working directory
+func (a *App) renderReleases(c DiffCommandConfigProvider) (renderedReleases, error) {
+	rendered := renderedReleases{}
+
+	var mu sync.Mutex
+
+	onRendered := func(release *state.ReleaseSpec, resources []manifest.Resource) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		rendered[state.ReleaseToID(release)] = resources
+
+		return nil
+	}
+
+	tc := diffTemplateConfig{c}
+
+	err := a.ForEachState(func(run *Run) (ok bool, errs []error) {
+		includeCRDs := tc.IncludeCRDs()
+
+		prepErr := run.withPreparedCharts("diff", state.ChartPrepareOptions{
+			ForceDownload: !run.helm.IsHelm3(),
+			SkipRepos:     c.SkipDeps(),
+			SkipDeps:      c.SkipDeps(),
+			IncludeCRDs:   &includeCRDs,
+			Validate:      c.Validate(),
+		}, func() {
+			ok, errs = a.template(run, tc, onRendered)
+		})
+
+		if prepErr != nil {
+			errs = append(errs, prepErr)
+		}
+
+		return
+	}, false)
+
+	return rendered, err
+}
+
+// checkoutAgainst returns the directory corresponding to the working directory in the revision given by --against,
+// that is either a directory or a git ref.
+// For a git ref, the revision of the git repository containing the working directory is checked out to a temporary worktree.
+func (a *App) checkoutAgainst(against string) (string, func(), error) {
+	noop := func() {}
+
+	if a.directoryExistsAt(against) {
+		dir, err := a.abs(against)
+		return dir, noop, err
+	}
+
+	wd, err := a.getwd()
+	if err != nil {
+		return "", noop, err
+	}
+
+	topLevel, err := git(wd, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", noop, fmt.Errorf("--against %s is neither a directory nor a git ref: %v", against, err)
+	}
+
+	realWd, err := filepath.EvalSymlinks(wd)
+	if err != nil {
+		return "", noop, err
+	}
+
+	root := strings.TrimSpace(string(topLevel))
+
+	rel, err := filepath.Rel(root, realWd)
+	if err != nil {
+		return "", noop, err
+	}
+
+	// The ref starting with "-" would be taken as an option of git
+	if strings.HasPrefix(against, "-") {
+		return "", noop, fmt.Errorf("--against %s is neither a directory nor a git ref", against)
+	}
+
+	rev, err := git(root, "rev-parse", "--verify", "--quiet", against+"^{commit}")
+	if err != nil {
+		return "", noop, fmt.Errorf("--against %s is neither a directory nor a git ref: %v", against, err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "helmfile-against")
+	if err != nil {
+		return "", noop, err
+	}
+
+	// A worktree has all the files of the revision, unlike `git archive` that omits the ones marked export-ignore
+	worktree := filepath.Join(tempDir, "worktree")
+
+	cleanup := func() {
+		os.RemoveAll(tempDir)
+		// Make git forget the removed worktree
+		_, _ = git(root, "worktree", "prune")
+	}
+
+	if _, err := git(root, "worktree", "add", "--detach", worktree, strings.TrimSpace(string(rev))); err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("checking out %s: %v", against, err)
+	}
+
+	dir := filepath.Join(worktree, rel)
+	if !a.directoryExistsAt(dir) {
+		cleanup()
+		return "", noop, fmt.Errorf("the working directory doesn't exist in %s", against)
+	}
+
+	return dir, cleanup, nil
+}
+
+func git(dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// releaseChanges is the changes of the resources of a release between two revisions
+type releaseChanges struct {
+	release string
+	changes []manifestdiff.Change
+}
+
+func (rc releaseChanges) write(w io.Writer, against string, opts manifestdiff.FormatOptions) error {
+	for _, ch := range rc.changes {
+		switch ch.Type {
+		case manifestdiff.Added:
+			fmt.Fprintf(w, "%s: %s has been added\n", rc.release, ch.ID)
+		case manifestdiff.Removed:
+			fmt.Fprintf(w, "%s: %s has been removed\n", rc.release, ch.ID)
+		default:
+			fmt.Fprintf(w, "%s: %s has changed since %s\n", rc.release, ch.ID, against)
+		}
+
+		unified, err := ch.Unified(opts)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(w, unified); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffRenderedReleases compares the resources of each release with manifestdiff, so that the formatting and
+// the order of the resources and the keys, and the noisy fields like the helm.sh/chart label don't matter
+func diffRenderedReleases(before, after renderedReleases) ([]releaseChanges, error) {
+	ids := map[string]bool{}
+	for id := range before {
+		ids[id] = true
+	}
+	for id := range after {
+		ids[id] = true
+	}
+
+	var releases []string
+	for id := range ids {
+		releases = append(releases, id)
+	}
+	sort.Strings(releases)
+
+	var changes []releaseChanges
+
+	for _, release := range releases {
+		b, err := parseRenderedResources(before[release])
+		if err != nil {
+			return nil, err
+		}
+
+		a, err := parseRenderedResources(after[release])
+		if err != nil {
+			return nil, err
+		}
+
+		cs, err := manifestdiff.Diff(b, a, manifestdiff.Options{IgnoredFields: manifestdiff.DefaultIgnoredFields})
+		if err != nil {
+			return nil, fmt.Errorf("comparing the resources of release %s: %v", release, err)
+		}
+
+		if len(cs) > 0 {
+			changes = append(changes, releaseChanges{release: release, changes: cs})
+		}
+	}
+
+	return changes, nil
+}
+
+func parseRenderedResources(resources []manifest.Resource) ([]manifestdiff.Object, error) {
+	var objects []manifestdiff.Object
+
+	for _, r := range resources {
+		objs, err := manifestdiff.Parse(strings.NewReader(r.Content))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s %s: %v", r.Kind, r.Name, err)
+		}
+
+		objects = append(objects, objs...)
+	}
+
+	return objects, nil
+}
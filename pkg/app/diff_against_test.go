@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/vals"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+func TestDiff_Against(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+releases:
+- name: foo
+  namespace: apps
+  chart: stable/foo-next
+- name: bar
+  namespace: apps
+  chart: stable/bar
+`,
+		"/previous/path/to/helmfile.yaml": `
+releases:
+- name: foo
+  namespace: apps
+  chart: stable/foo
+- name: baz
+  namespace: apps
+  chart: stable/baz
+`,
+	}
+
+	manifests := map[string]string{
+		"foo stable/foo": `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: apps
+data:
+  a: "1"
+  b: "2"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: apps
+spec:
+  ports:
+  - port: 80
+`,
+		// The Service is the same as the previous one except the order and the formatting
+		"foo stable/foo-next": `---
+apiVersion: v1
+kind: Service
+metadata: {namespace: apps, name: foo}
+spec:
+  ports: [{port: 80}]
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: apps
+data:
+  b: "2"
+  a: "3"
+`,
+		"bar": `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bar
+`,
+		"baz": `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: baz
+`,
+	}
+
+	var buffer, out bytes.Buffer
+	logger := helmexec.NewLogger(&buffer, "debug")
+
+	valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+	if err != nil {
+		t.Fatalf("unexpected error creating vals runtime: %v", err)
+	}
+
+	app := appWithFs(&App{
+		OverrideHelmBinary:  DefaultHelmBinary,
+		glob:                filepath.Glob,
+		abs:                 filepath.Abs,
+		OverrideKubeContext: "default",
+		Env:                 "default",
+		Logger:              logger,
+		Writer:              &out,
+		helms: map[helmKey]helmexec.Interface{
+			createHelmKey("helm", "default"): &exectest.Helm{Helm3: true, Manifests: manifests},
+		},
+		valsRuntime: valsRuntime,
+	}, files)
+
+	err = app.Diff(diffConfig{against: "/previous/path/to", detailedExitcode: true, noColor: true, logger: logger})
+	if err == nil || err.Error() != "Identified at least one change" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `default/apps/bar: apps/v1/Deployment bar has been added
++ apiVersion: apps/v1
++ kind: Deployment
++ metadata:
++   name: bar
+default/apps/baz: v1/Secret baz has been removed
+- apiVersion: v1
+- kind: Secret
+- metadata:
+-   name: baz
+default/apps/foo: v1/ConfigMap apps/foo has changed since /previous/path/to
+  apiVersion: v1
+  data:
+-   a: "1"
++   a: "3"
+    b: "2"
+  kind: ConfigMap
+  metadata:
+    name: foo
+    namespace: apps
+`
+
+	if d := cmp.Diff(want, out.String()); d != "" {
+		t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestDiff_AgainstInvalidRevision(t *testing.T) {
+	app := appWithFs(&App{
+		OverrideHelmBinary: DefaultHelmBinary,
+		Env:                "default",
+	}, map[string]string{})
+
+	err := app.Diff(diffConfig{against: "/nonexistent"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCheckoutAgainst_GitRef(t *testing.T) {
+	repo, err := ioutil.TempDir("", "helmfile-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	wd := filepath.Join(repo, "clusters", "prod")
+	if err := os.MkdirAll(wd, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(content string) {
+		if err := ioutil.WriteFile(filepath.Join(wd, "helmfile.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(args ...string) {
+		if _, err := git(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("releases: []\n")
+	// `git archive` would omit the files marked export-ignore
+	if err := ioutil.WriteFile(filepath.Join(repo, ".gitattributes"), []byte("clusters/prod/values.yaml export-ignore\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(wd, "values.yaml"), []byte("replicas: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("init", "-q")
+	run("add", "-A")
+	run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial")
+	write("releases:\n- name: foo\n")
+
+	app := &App{
+		abs:               filepath.Abs,
+		getwd:             func() (string, error) { return wd, nil },
+		directoryExistsAt: directoryExistsAt,
+	}
+
+	dir, cleanup, err := app.checkoutAgainst("HEAD")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cleanup()
+
+	if filepath.Base(dir) != "prod" {
+		t.Errorf("unexpected directory: %s", dir)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "helmfile.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := cmp.Diff("releases: []\n", string(bs)); d != "" {
+		t.Errorf("unexpected helmfile.yaml: want (-), got (+):\n%s", d)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "values.yaml")); err != nil {
+		t.Errorf("expected the file marked export-ignore to be checked out: %v", err)
+	}
+
+	cleanup()
+
+	if directoryExistsAt(dir) {
+		t.Errorf("%s must be removed by cleanup", dir)
+	}
+
+	worktrees, err := git(repo, "worktree", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(strings.Split(strings.TrimSpace(string(worktrees)), "\n")); n != 1 {
+		t.Errorf("expected the worktree to be removed by cleanup:\n%s", worktrees)
+	}
+
+	for _, against := range []string{"--output=/tmp/foo", "nonexistent"} {
+		if _, _, err := app.checkoutAgainst(against); err == nil {
+			t.Errorf("expected error for --against %s, got nil", against)
+		} else if !strings.HasPrefix(err.Error(), "--against "+against+" is neither a directory nor a git ref") {
+			t.Errorf("unexpected error for --against %s: %v", against, err)
+		}
+	}
+}
@@ -38,9 +38,14 @@ type diffConfig struct {
 	detailedExitcode  bool
 	interactive       bool
 	skipDiffOnInstall bool
+	against           string
 	logger            *zap.SugaredLogger
 }
 
+func (a diffConfig) Against() string {
+	return a.against
+}
+
 func (a diffConfig) Args() string {
 	return a.args
 }
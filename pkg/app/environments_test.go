@@ -0,0 +1,118 @@
+package app
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+type environmentsConfig struct {
+	showValues bool
+}
+
+func (c environmentsConfig) ShowValues() bool {
+	return c.showValues
+}
+
+func TestEnvironments(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+environments:
+  base:
+    kubeContext: base
+    values:
+    - replicas: 1
+      image:
+        tag: v1
+  region-eu:
+    helmDefaults:
+      kubeContext: eu
+    values:
+    - region: eu
+  prod-eu:
+    inherits: [base, region-eu]
+    helmDefaults:
+      kubeContext: prod-eu
+    values:
+    - image:
+        tag: v2
+  staging:
+    inherits: [base]
+`,
+	}
+
+	testcases := []struct {
+		name     string
+		env      string
+		config   environmentsConfig
+		files    map[string]string
+		expected string
+	}{
+		{
+			name: "list",
+			env:  "default",
+			files: map[string]string{
+				"/path/to/helmfile.yaml": files["/path/to/helmfile.yaml"] + `
+helmfiles:
+- sub/helmfile.yaml
+`,
+				"/path/to/sub/helmfile.yaml": `
+environments:
+  dev: {}
+`,
+			},
+			expected: `NAME      INHERITS        KUBECONTEXT
+base                      base
+dev                       
+prod-eu   base, region-eu prod-eu
+region-eu                 eu
+staging   base            base
+`,
+		},
+		{
+			name:   "values",
+			env:    "prod-eu",
+			config: environmentsConfig{showValues: true},
+			files:  files,
+			expected: `# values of environment "prod-eu" in helmfile.yaml
+image:
+  tag: v2
+region: eu
+replicas: 1
+
+`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buffer, out bytes.Buffer
+			logger := helmexec.NewLogger(&buffer, "debug")
+
+			app := appWithFs(&App{
+				OverrideHelmBinary: DefaultHelmBinary,
+				glob:               filepath.Glob,
+				abs:                filepath.Abs,
+				Env:                tc.env,
+				Logger:             logger,
+				Writer:             &out,
+				helms: map[helmKey]helmexec.Interface{
+					createHelmKey("helm", ""): &exectest.Helm{Helm3: true},
+				},
+			}, tc.files)
+
+			if err := app.Environments(tc.config); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if d := cmp.Diff(tc.expected, out.String()); d != "" {
+				t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/vals"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+type explainConfig struct {
+	release, key string
+}
+
+func (c explainConfig) Release() string {
+	return c.release
+}
+
+func (c explainConfig) Key() string {
+	return c.key
+}
+
+func TestExplain(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+environments:
+  default:
+    values:
+    - env.yaml
+---
+releases:
+- name: foo
+  chart: stable/foo
+  values:
+  - foo.yaml
+  - foo-{{ .Environment.Name }}.yaml
+- name: bar
+  chart: stable/bar
+`,
+		"/path/to/env.yaml": `
+image:
+  tag: v1
+`,
+		"/path/to/foo.yaml": `
+image:
+  repository: example/foo
+  tag: latest
+replicas: 2
+`,
+		"/path/to/foo-default.yaml": `
+image:
+  tag: v2
+`,
+	}
+
+	testcases := []struct {
+		name     string
+		config   explainConfig
+		expected string
+	}{
+		{
+			name:   "release and key",
+			config: explainConfig{release: "foo", key: "image.tag"},
+			expected: `# values of helmfile.yaml
+image.tag: "v1"
+  env.yaml:3: "v1"
+
+# values of release "foo" in helmfile.yaml
+image.tag: "v2"
+  foo.yaml:4: "latest"
+  foo-default.yaml:3: "v2"
+
+`,
+		},
+		{
+			name:   "key not set",
+			config: explainConfig{release: "bar", key: "replicas"},
+			expected: `# values of helmfile.yaml
+replicas: (not set)
+
+# values of release "bar" in helmfile.yaml
+replicas: (not set)
+
+`,
+		},
+		{
+			name:     "unknown release",
+			config:   explainConfig{release: "baz", key: "replicas"},
+			expected: ``,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buffer, out bytes.Buffer
+			logger := helmexec.NewLogger(&buffer, "debug")
+
+			valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+			if err != nil {
+				t.Fatalf("unexpected error creating vals runtime: %v", err)
+			}
+
+			fs := testhelper.NewTestFs(files)
+
+			app := injectFs(&App{
+				OverrideHelmBinary: DefaultHelmBinary,
+				glob:               filepath.Glob,
+				abs:                filepath.Abs,
+				Env:                "default",
+				Logger:             logger,
+				Writer:             &out,
+				helms: map[helmKey]helmexec.Interface{
+					createHelmKey("helm", ""): &exectest.Helm{Helm3: true},
+				},
+				valsRuntime: valsRuntime,
+			}, fs)
+
+			// The values files of the releases are merged after written to the temporary directory
+			app.readFile = func(path string) ([]byte, error) {
+				if bs, err := fs.ReadFile(path); err == nil {
+					return bs, nil
+				}
+				return ioutil.ReadFile(path)
+			}
+
+			if err := app.Explain(tc.config); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if d := cmp.Diff(tc.expected, out.String()); d != "" {
+				t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/manifest"
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+func exportOpts(c ExportConfigProvider) (state.ExportOpts, error) {
+	opts := state.ExportOpts{
+		Format:         c.Format(),
+		Namespace:      c.ResourceNamespace(),
+		ArgoCDProject:  c.ArgoCDProject(),
+		ArgoCDServer:   c.ArgoCDServer(),
+		ValuesAs:       c.ValuesAs(),
+		IncludeSecrets: c.IncludeSecrets(),
+	}
+
+	switch opts.Format {
+	case state.ExportArgoCD:
+		if opts.Namespace == "" {
+			opts.Namespace = "argocd"
+		}
+	case state.ExportFlux:
+		if opts.Namespace == "" {
+			opts.Namespace = "flux-system"
+		}
+	default:
+		return opts, fmt.Errorf("unsupported format %q: it must be either %s or %s", opts.Format, state.ExportArgoCD, state.ExportFlux)
+	}
+
+	switch opts.ValuesAs {
+	case "":
+		opts.ValuesAs = state.ExportValuesInline
+	case state.ExportValuesInline:
+	case state.ExportValuesConfigMap:
+		if opts.Format != state.ExportFlux {
+			return opts, fmt.Errorf("--values-as %s is supported only with --format %s", opts.ValuesAs, state.ExportFlux)
+		}
+	default:
+		return opts, fmt.Errorf("unsupported --values-as %q: it must be either %s or %s", opts.ValuesAs, state.ExportValuesInline, state.ExportValuesConfigMap)
+	}
+
+	return opts, nil
+}
+
+// Export converts the selected releases across all the helmfiles to the resources of Argo CD or Flux
+func (a *App) Export(c ExportConfigProvider) error {
+	opts, err := exportOpts(c)
+	if err != nil {
+		return err
+	}
+
+	var resources []manifest.Resource
+
+	err = a.ForEachState(func(run *Run) (ok bool, errs []error) {
+		toExport, _, err := a.getSelectedReleases(run, false)
+		if err != nil {
+			return false, []error{err}
+		}
+		if len(toExport) == 0 {
+			return false, nil
+		}
+
+		exported, err := run.state.ExportReleases(run.helm, toExport, opts)
+		if err != nil {
+			return false, []error{err}
+		}
+
+		resources = append(resources, exported...)
+
+		return true, nil
+	}, false, SetFilter(true))
+	if err != nil {
+		return err
+	}
+
+	resources, err = deduplicateExportedResources(resources)
+	if err != nil {
+		return err
+	}
+
+	manifest.Sort(resources)
+
+	if c.OutputDir() == "" {
+		_, err := io.WriteString(a.Writer, manifest.Format(resources))
+		return err
+	}
+
+	return writeExportedResources(c.OutputDir(), resources)
+}
+
+// deduplicateExportedResources removes the resources exported more than once, like the Flux HelmRepository of
+// the repository shared by helmfiles, and fails on the different resources of the same name
+func deduplicateExportedResources(resources []manifest.Resource) ([]manifest.Resource, error) {
+	seen := map[string]manifest.Resource{}
+
+	var rs []manifest.Resource
+
+	for _, r := range resources {
+		key := exportedResourcePath(r)
+
+		if prev, ok := seen[key]; ok {
+			if prev.Content != r.Content {
+				return nil, fmt.Errorf("more than one different %s named %q are exported to namespace %q", r.Kind, r.Name, r.Namespace)
+			}
+
+			continue
+		}
+		seen[key] = r
+
+		rs = append(rs, r)
+	}
+
+	return rs, nil
+}
+
+// exportedResourcePath returns the path of the file the resource is written to, relative to --output-dir
+func exportedResourcePath(r manifest.Resource) string {
+	return filepath.Join(r.Namespace, strings.ToLower(r.Kind), r.Name+".yaml")
+}
+
+func writeExportedResources(outputDir string, resources []manifest.Resource) error {
+	for _, r := range resources {
+		path := filepath.Join(outputDir, exportedResourcePath(r))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path, []byte(r.Content+"\n"), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
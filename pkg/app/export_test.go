@@ -0,0 +1,188 @@
+package app
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/vals"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+type exportConfig struct {
+	format            string
+	resourceNamespace string
+	valuesAs          string
+	outputDir         string
+}
+
+func (c exportConfig) Format() string {
+	return c.format
+}
+
+func (c exportConfig) ResourceNamespace() string {
+	return c.resourceNamespace
+}
+
+func (c exportConfig) ArgoCDProject() string {
+	return "default"
+}
+
+func (c exportConfig) ArgoCDServer() string {
+	return "https://kubernetes.default.svc"
+}
+
+func (c exportConfig) ValuesAs() string {
+	return c.valuesAs
+}
+
+func (c exportConfig) IncludeSecrets() bool {
+	return false
+}
+
+func (c exportConfig) OutputDir() string {
+	return c.outputDir
+}
+
+func TestExport(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+helmfiles:
+- frontend.yaml
+- backend.yaml
+`,
+		"/path/to/frontend.yaml": `
+repositories:
+- name: stable
+  url: https://charts.example.com
+releases:
+- name: web
+  namespace: apps
+  chart: stable/web
+  version: 1.0.0
+`,
+		"/path/to/backend.yaml": `
+repositories:
+- name: stable
+  url: https://charts.example.com
+releases:
+- name: api
+  namespace: apps
+  chart: stable/api
+  createNamespace: false
+`,
+	}
+
+	var buffer, out bytes.Buffer
+	logger := helmexec.NewLogger(&buffer, "debug")
+
+	valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+	if err != nil {
+		t.Fatalf("unexpected error creating vals runtime: %v", err)
+	}
+
+	app := appWithFs(&App{
+		OverrideHelmBinary:  DefaultHelmBinary,
+		glob:                filepath.Glob,
+		abs:                 filepath.Abs,
+		OverrideKubeContext: "default",
+		Env:                 "default",
+		Logger:              logger,
+		Writer:              &out,
+		helms: map[helmKey]helmexec.Interface{
+			createHelmKey("helm", "default"): &exectest.Helm{Helm3: true},
+		},
+		valsRuntime: valsRuntime,
+	}, files)
+
+	if err := app.Export(exportConfig{format: "flux"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The HelmRepository shared by the helmfiles is exported once
+	want := `---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: api
+  namespace: apps
+spec:
+  releaseName: api
+  interval: 10m
+  chart:
+    spec:
+      chart: api
+      version: '*'
+      sourceRef:
+        kind: HelmRepository
+        name: stable
+        namespace: flux-system
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: web
+  namespace: apps
+spec:
+  releaseName: web
+  interval: 10m
+  chart:
+    spec:
+      chart: web
+      version: 1.0.0
+      sourceRef:
+        kind: HelmRepository
+        name: stable
+        namespace: flux-system
+  install:
+    createNamespace: true
+---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: stable
+  namespace: flux-system
+spec:
+  url: https://charts.example.com
+  interval: 10m
+`
+
+	if d := cmp.Diff(want, out.String()); d != "" {
+		t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestExport_InvalidOptions(t *testing.T) {
+	testcases := []struct {
+		c    exportConfig
+		want string
+	}{
+		{
+			c:    exportConfig{format: "helm"},
+			want: `unsupported format "helm": it must be either argocd or flux`,
+		},
+		{
+			c:    exportConfig{format: "argocd", valuesAs: "configmap"},
+			want: `--values-as configmap is supported only with --format flux`,
+		},
+		{
+			c:    exportConfig{format: "flux", valuesAs: "secret"},
+			want: `unsupported --values-as "secret": it must be either inline or configmap`,
+		},
+	}
+
+	for _, tc := range testcases {
+		app := appWithFs(&App{
+			OverrideHelmBinary: DefaultHelmBinary,
+			Env:                "default",
+		}, map[string]string{})
+
+		err := app.Export(tc.c)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("unexpected error: want %q, got %v", tc.want, err)
+		}
+	}
+}
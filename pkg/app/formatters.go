@@ -1,33 +1,225 @@
 package app
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/gosuri/uitable"
+	"gopkg.in/yaml.v2"
+
+	"github.com/huolunl/helmfile/pkg/state"
 )
 
-func FormatAsTable(releases []*HelmRelease) error {
-	table := uitable.New()
-	table.AddRow("NAME", "NAMESPACE", "ENABLED", "INSTALLED", "LABELS", "CHART", "VERSION")
+// releaseRows returns the header and the rows of the releases for the table and the csv outputs.
+// withStatus adds the columns of the deployed releases.
+// The digest column is added only when any of the charts is pulled from an OCI registry.
+func releaseRows(releases []*HelmRelease, withStatus bool) [][]string {
+	var withDigests bool
+	for _, r := range releases {
+		if r.ChartDigest != "" {
+			withDigests = true
+			break
+		}
+	}
+
+	header := []string{"NAME", "NAMESPACE", "ENABLED", "INSTALLED", "LABELS", "CHART", "VERSION"}
+	if withDigests {
+		header = append(header, "DIGEST")
+	}
+	if withStatus {
+		header = append(header, "REVISION", "STATUS", "DEPLOYED VERSION", "APP VERSION", "ORPHAN")
+	}
+
+	rows := [][]string{header}
 
 	for _, r := range releases {
-		table.AddRow(r.Name, r.Namespace, fmt.Sprintf("%t", r.Enabled), fmt.Sprintf("%t", r.Installed), r.Labels, r.Chart, r.Version)
+		row := []string{r.Name, r.Namespace, fmt.Sprintf("%t", r.Enabled), fmt.Sprintf("%t", r.Installed), r.Labels, r.Chart, r.Version}
+
+		if withDigests {
+			row = append(row, r.ChartDigest)
+		}
+
+		if withStatus {
+			revision := ""
+			if r.Revision > 0 {
+				revision = fmt.Sprintf("%d", r.Revision)
+			}
+
+			row = append(row, revision, r.Status, r.DeployedVersion, r.AppVersion, fmt.Sprintf("%t", r.Orphan))
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func FormatAsTable(w io.Writer, releases []*HelmRelease, withStatus bool) error {
+	table := uitable.New()
+
+	for _, row := range releaseRows(releases, withStatus) {
+		cells := make([]interface{}, len(row))
+		for i := range row {
+			cells[i] = row[i]
+		}
+		table.AddRow(cells...)
 	}
 
-	fmt.Println(table.String())
+	fmt.Fprintln(w, table.String())
 
 	return nil
 }
 
-func FormatAsJson(releases []*HelmRelease) error {
+func FormatAsJson(w io.Writer, releases []*HelmRelease) error {
 	output, err := json.Marshal(releases)
 
 	if err != nil {
 		return fmt.Errorf("error generating json: %v", err)
 	}
 
-	fmt.Println(string(output))
+	fmt.Fprintln(w, string(output))
+
+	return nil
+}
+
+func FormatAsYaml(w io.Writer, releases []*HelmRelease) error {
+	if releases == nil {
+		releases = []*HelmRelease{}
+	}
+
+	output, err := yaml.Marshal(releases)
+	if err != nil {
+		return fmt.Errorf("error generating yaml: %v", err)
+	}
+
+	fmt.Fprint(w, string(output))
+
+	return nil
+}
+
+func FormatAsCsv(w io.Writer, releases []*HelmRelease, withStatus bool) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.WriteAll(releaseRows(releases, withStatus)); err != nil {
+		return fmt.Errorf("error generating csv: %v", err)
+	}
+
+	return nil
+}
+
+// FormatStatuses writes the statuses of the releases in the output format, that is one of json, yaml and table
+func FormatStatuses(w io.Writer, statuses []state.ReleaseStatus, output string) error {
+	if statuses == nil {
+		statuses = []state.ReleaseStatus{}
+	}
+
+	switch output {
+	case "json":
+		bs, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error generating json: %v", err)
+		}
+
+		fmt.Fprintln(w, string(bs))
+	case "yaml":
+		bs, err := yaml.Marshal(statuses)
+		if err != nil {
+			return fmt.Errorf("error generating yaml: %v", err)
+		}
+
+		fmt.Fprint(w, string(bs))
+	default:
+		table := uitable.New()
+		table.AddRow("NAME", "NAMESPACE", "INSTALLED", "REVISION", "STATUS", "CHART", "VERSION", "DESIRED VERSION", "APP VERSION", "LAST DEPLOYED", "HEALTHY")
+
+		for _, s := range statuses {
+			revision := ""
+			if s.Revision > 0 {
+				revision = fmt.Sprintf("%d", s.Revision)
+			}
+
+			desired := s.DesiredVersion
+			if s.VersionMismatch {
+				desired += " (mismatch)"
+			}
+
+			table.AddRow(s.Name, s.Namespace, fmt.Sprintf("%t", s.Installed), revision, s.Status, s.Chart, s.ChartVersion, desired, s.AppVersion, s.LastDeployed, fmt.Sprintf("%t", s.Healthy))
+		}
+
+		fmt.Fprintln(w, table.String())
+	}
+
+	return nil
+}
+
+// FormatRepositories writes the repositories in the output format, that is one of json, yaml and table
+func FormatRepositories(w io.Writer, repos []Repository, output string) error {
+	if repos == nil {
+		repos = []Repository{}
+	}
+
+	switch output {
+	case "json":
+		bs, err := json.MarshalIndent(repos, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error generating json: %v", err)
+		}
+
+		fmt.Fprintln(w, string(bs))
+	case "yaml":
+		bs, err := yaml.Marshal(repos)
+		if err != nil {
+			return fmt.Errorf("error generating yaml: %v", err)
+		}
+
+		fmt.Fprint(w, string(bs))
+	default:
+		table := uitable.New()
+		table.AddRow("NAME", "URL", "OCI", "MANAGED", "CREDENTIALS")
+
+		for _, r := range repos {
+			table.AddRow(r.Name, r.URL, fmt.Sprintf("%t", r.OCI), r.Managed, r.Credentials)
+		}
+
+		fmt.Fprintln(w, table.String())
+	}
+
+	return nil
+}
+
+// FormatRepositoryChecks writes the results of checking the repositories in the output format, that is one of json, yaml and table
+func FormatRepositoryChecks(w io.Writer, checks []state.RepositoryCheck, output string) error {
+	if checks == nil {
+		checks = []state.RepositoryCheck{}
+	}
+
+	switch output {
+	case "json":
+		bs, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error generating json: %v", err)
+		}
+
+		fmt.Fprintln(w, string(bs))
+	case "yaml":
+		bs, err := yaml.Marshal(checks)
+		if err != nil {
+			return fmt.Errorf("error generating yaml: %v", err)
+		}
+
+		fmt.Fprint(w, string(bs))
+	default:
+		table := uitable.New()
+		table.AddRow("NAME", "URL", "OCI", "STATUS", "MESSAGE")
+
+		for _, c := range checks {
+			table.AddRow(c.Name, c.URL, fmt.Sprintf("%t", c.OCI), c.Status, c.Message)
+		}
+
+		fmt.Fprintln(w, table.String())
+	}
 
 	return nil
 }
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+// importedHelmfile is the helmfile.yaml written by `helmfile import`
+type importedHelmfile struct {
+	Repositories []state.RepositorySpec `yaml:"repositories,omitempty"`
+	Releases     []state.ReleaseSpec    `yaml:"releases"`
+}
+
+// Import converts the releases installed in the cluster, that are not declared in the helmfiles, to a new helmfile.
+// The charts of the releases are looked up in the repositories of the helmfiles.
+func (a *App) Import(c ImportConfigProvider) error {
+	outputDir := c.OutputDir()
+	if outputDir == "" {
+		return errors.New("--output-dir is required to write the imported helmfile.yaml and values files to")
+	}
+
+	helmfilePath := filepath.Join(outputDir, "helmfile.yaml")
+	if exists, err := fileExists(helmfilePath); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%s already exists", helmfilePath)
+	}
+
+	var (
+		first        *Run
+		repositories []state.RepositorySpec
+	)
+
+	opts := state.ImportOpts{
+		KubeContexts: c.KubeContexts(),
+		Namespaces:   c.Namespaces(),
+		Declared:     map[string]bool{},
+	}
+
+	added := map[string]bool{}
+
+	err := a.ForEachState(func(run *Run) (ok bool, errs []error) {
+		if first == nil {
+			first = run
+		}
+
+		st := run.state
+
+		for _, r := range st.Repositories {
+			if !added[r.Name] {
+				added[r.Name] = true
+				repositories = append(repositories, r)
+			}
+		}
+
+		releases := st.GetReleasesWithOverrides()
+		for i := range releases {
+			id, err := st.DeployedReleaseID(run.helm, &releases[i])
+			if err != nil {
+				return false, []error{err}
+			}
+
+			opts.Declared[id] = true
+		}
+
+		if !c.SkipRepos() {
+			if err := run.ctx.SyncReposOnce(st, run.helm); err != nil {
+				return false, []error{err}
+			}
+		}
+
+		return true, nil
+	}, false, SetFilter(true))
+	if err != nil {
+		return err
+	}
+
+	imported, err := first.state.ImportReleases(first.helm, repositories, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(imported) == 0 {
+		a.Logger.Info("No releases to be imported")
+		return nil
+	}
+
+	sort.SliceStable(imported, func(i, j int) bool {
+		a, b := imported[i], imported[j]
+
+		if a.KubeContext != b.KubeContext {
+			return a.KubeContext < b.KubeContext
+		}
+
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+
+		return a.Name < b.Name
+	})
+
+	var helmfile importedHelmfile
+
+	used := map[string]bool{}
+
+	for _, r := range imported {
+		release := r.ReleaseSpec
+
+		if r.Repository != nil {
+			used[r.Repository.Name] = true
+		}
+
+		if len(r.UserValues) > 0 {
+			valuesFile := filepath.Join("values", release.KubeContext, release.Namespace, release.Name+".yaml")
+
+			if err := writeImportedValues(filepath.Join(outputDir, valuesFile), r.UserValues); err != nil {
+				return err
+			}
+
+			release.Values = []interface{}{filepath.ToSlash(valuesFile)}
+		}
+
+		helmfile.Releases = append(helmfile.Releases, release)
+	}
+
+	for _, r := range repositories {
+		if used[r.Name] {
+			// The credentials may be rendered from environment variables or secrets, that must not be written to the file
+			r.Username, r.Password = "", ""
+			helmfile.Repositories = append(helmfile.Repositories, r)
+		}
+	}
+
+	bs, err := yaml.Marshal(helmfile)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(helmfilePath, bs, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", helmfilePath, err)
+	}
+
+	a.Logger.Infof("Imported %d releases to %s", len(helmfile.Releases), helmfilePath)
+
+	return nil
+}
+
+func writeImportedValues(path string, values map[string]interface{}) error {
+	bs, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
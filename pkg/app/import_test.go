@@ -0,0 +1,136 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/vals"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+type importConfig struct {
+	namespaces []string
+	outputDir  string
+}
+
+func (c importConfig) KubeContexts() []string {
+	return nil
+}
+
+func (c importConfig) Namespaces() []string {
+	return c.namespaces
+}
+
+func (c importConfig) OutputDir() string {
+	return c.outputDir
+}
+
+func (c importConfig) SkipRepos() bool {
+	return true
+}
+
+func TestImport(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+repositories:
+- name: stable
+  url: https://charts.example.com
+  username: admin
+  password: secret
+- name: unused
+  url: https://unused.example.com
+releases:
+- name: db
+  namespace: data
+  chart: stable/postgres
+`,
+	}
+
+	helm := &exectest.Helm{
+		Helm3: true,
+		Deployed: map[string]string{
+			"apps": `[{"name": "web", "namespace": "apps", "revision": "3", "status": "deployed", "chart": "web-1.2.3"}]`,
+			"data": `[{"name": "db", "namespace": "data", "revision": "1", "status": "deployed", "chart": "postgres-12.1.0"}]`,
+		},
+		Values: map[string]string{
+			"web": "replicas: 2\n",
+		},
+		SearchResults: map[string]string{
+			"stable/web": `[{"name": "stable/web", "version": "1.2.3"}]`,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "helmfile-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buffer bytes.Buffer
+	logger := helmexec.NewLogger(&buffer, "debug")
+
+	valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+	if err != nil {
+		t.Fatalf("unexpected error creating vals runtime: %v", err)
+	}
+
+	app := appWithFs(&App{
+		OverrideHelmBinary:  DefaultHelmBinary,
+		glob:                filepath.Glob,
+		abs:                 filepath.Abs,
+		OverrideKubeContext: "default",
+		Env:                 "default",
+		Logger:              logger,
+		helms: map[helmKey]helmexec.Interface{
+			createHelmKey("helm", "default"): helm,
+		},
+		valsRuntime: valsRuntime,
+	}, files)
+
+	c := importConfig{namespaces: []string{"apps", "data"}, outputDir: dir}
+
+	if err := app.Import(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	read := func(path string) string {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	// db is declared in the helmfile already, and the credentials of the repository are never written
+	wantHelmfile := `repositories:
+- name: stable
+  url: https://charts.example.com
+releases:
+- chart: stable/web
+  version: 1.2.3
+  name: web
+  namespace: apps
+  values:
+  - values/apps/web.yaml
+`
+
+	if d := cmp.Diff(wantHelmfile, read("helmfile.yaml")); d != "" {
+		t.Errorf("unexpected helmfile.yaml: want (-), got (+):\n%s", d)
+	}
+
+	if d := cmp.Diff("replicas: 2\n", read("values/apps/web.yaml")); d != "" {
+		t.Errorf("unexpected values file: want (-), got (+):\n%s", d)
+	}
+
+	// The imported helmfile.yaml is never overwritten
+	want := filepath.Join(dir, "helmfile.yaml") + " already exists"
+	if err := app.Import(c); err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want %q, got %v", want, err)
+	}
+}
@@ -18,6 +18,10 @@ func (helm *noCallHelmExec) doPanic() {
 	panic("unexpected call to helm")
 }
 
+func (helm *noCallHelmExec) GetManifests(name, chart string, flags ...string) ([]byte, error) {
+	helm.doPanic()
+	return nil, nil
+}
 func (helm *noCallHelmExec) TemplateRelease(name, chart string, flags ...string) error {
 	helm.doPanic()
 	return nil
@@ -30,6 +34,10 @@ func (helm *noCallHelmExec) ChartExport(chart string, path string, flags ...stri
 	helm.doPanic()
 	return nil
 }
+func (helm *noCallHelmExec) VerifyChart(path string, flags ...string) error {
+	helm.doPanic()
+	return nil
+}
 func (helm *noCallHelmExec) UpdateDeps(chart string) error {
 	helm.doPanic()
 	return nil
@@ -70,6 +78,22 @@ func (helm *noCallHelmExec) ReleaseStatus(context helmexec.HelmContext, release
 	helm.doPanic()
 	return nil
 }
+func (helm *noCallHelmExec) GetReleaseStatus(context helmexec.HelmContext, release string, flags ...string) ([]byte, error) {
+	helm.doPanic()
+	return nil, nil
+}
+func (helm *noCallHelmExec) GetReleases(context helmexec.HelmContext, flags ...string) ([]byte, error) {
+	helm.doPanic()
+	return nil, nil
+}
+func (helm *noCallHelmExec) GetValues(context helmexec.HelmContext, name string, flags ...string) ([]byte, error) {
+	helm.doPanic()
+	return nil, nil
+}
+func (helm *noCallHelmExec) SearchRepo(keyword string, flags ...string) ([]byte, error) {
+	helm.doPanic()
+	return nil, nil
+}
 func (helm *noCallHelmExec) DeleteRelease(context helmexec.HelmContext, name string, flags ...string) error {
 	helm.doPanic()
 	return nil
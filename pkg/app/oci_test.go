@@ -0,0 +1,116 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/remote"
+)
+
+func testDigestOf(bs []byte) string {
+	sum := sha256.Sum256(bs)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestListWithOCIChartDigest(t *testing.T) {
+	cacheHome, err := ioutil.TempDir("", "helmfile-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheHome)
+
+	os.Setenv("XDG_CACHE_HOME", cacheHome)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	chartYaml := "apiVersion: v2\nname: mychart\nversion: 1.0.0\n"
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "mychart/Chart.yaml", Mode: 0644, Size: int64(len(chartYaml)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte(chartYaml))
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, gz.Close())
+
+	config := []byte(`{"name": "mychart", "version": "1.0.0"}`)
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion": 2, "config": {"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": %q, "size": %d}, "layers": [{"mediaType": "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "digest": %q, "size": %d}]}`,
+		testDigestOf(config), len(config), testDigestOf(archive.Bytes()), archive.Len()))
+	digest := testDigestOf(manifest)
+
+	blobs := map[string][]byte{
+		"/v2/charts/mychart/manifests/1.0.0":                        manifest,
+		"/v2/charts/mychart/blobs/" + testDigestOf(config):          config,
+		"/v2/charts/mychart/blobs/" + testDigestOf(archive.Bytes()): archive.Bytes(),
+	}
+
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	}))
+	defer registry.Close()
+
+	host := strings.TrimPrefix(registry.URL, "https://")
+
+	files := map[string]string{
+		"/path/to/helmfile.yaml": fmt.Sprintf(`
+repositories:
+- name: myoci
+  url: %s/charts
+  oci: true
+  skipTLSVerify: true
+releases:
+- name: myapp
+  chart: oci://%s/charts/mychart
+  version: 1.0.0
+- name: other
+  chart: mychart1
+`, host, host),
+	}
+
+	var buffer, out bytes.Buffer
+	logger := helmexec.NewLogger(&buffer, "debug")
+
+	app := appWithFs(&App{
+		OverrideHelmBinary:  DefaultHelmBinary,
+		glob:                filepath.Glob,
+		abs:                 filepath.Abs,
+		OverrideKubeContext: "default",
+		Env:                 "default",
+		Logger:              logger,
+		Writer:              &out,
+		helms: map[helmKey]helmexec.Interface{
+			createHelmKey("helm", "default"): &exectest.Helm{Helm3: true},
+		},
+	}, files)
+
+	assert.NilError(t, app.ListReleases(configImpl{output: "csv"}))
+
+	expected := fmt.Sprintf(`NAME,NAMESPACE,ENABLED,INSTALLED,LABELS,CHART,VERSION,DIGEST
+myapp,,true,true,,oci://%s/charts/mychart,1.0.0,%s
+other,,true,true,,mychart1,,
+`, host, digest)
+	assert.Equal(t, expected, out.String())
+
+	if _, err := os.Stat(filepath.Join(remote.CacheDir(), "oci", "mychart-"+strings.TrimPrefix(digest, "sha256:"))); err != nil {
+		t.Errorf("expected the chart to be cached by the digest: %v", err)
+	}
+}
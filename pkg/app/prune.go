@@ -0,0 +1,176 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+// pruner collects the releases to be pruned by `apply --prune` and `sync --prune` across all the helmfiles processed by the run,
+// so that a release moved from a helmfile to another one is never pruned
+type pruner struct {
+	mu sync.Mutex
+
+	// declared is the set of the DeployedReleaseIDs of the releases declared in the helmfiles
+	declared map[string]bool
+	targets  []pruneTarget
+}
+
+type pruneTarget struct {
+	run      *Run
+	releases []state.ReleaseSpec
+}
+
+func newPruner(a *App, c pruneConfig) (*pruner, error) {
+	if !c.Prune() {
+		if c.PruneDryRun() {
+			return nil, errors.New("--prune-dry-run is supported only with --prune")
+		}
+
+		return nil, nil
+	}
+
+	if len(a.Selectors) > 0 {
+		return nil, errors.New("--prune can't be used with --selector, as the releases not selected can't be told from the ones removed from the helmfile")
+	}
+
+	return &pruner{declared: map[string]bool{}}, nil
+}
+
+// detect records the releases declared in the helmfile, and the releases labeled with the helmfile but not declared in it.
+// It must be called before the releases of the run are modified by the apply or the sync.
+func (p *pruner) detect(run *Run) error {
+	if p == nil {
+		return nil
+	}
+
+	st := run.state
+	releases := st.GetReleasesWithOverrides()
+
+	for i := range releases {
+		id, err := st.DeployedReleaseID(run.helm, &releases[i])
+		if err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		p.declared[id] = true
+		p.mu.Unlock()
+	}
+
+	// The releases not selected by the sub-helmfile's selectors aren't known to be removed
+	if len(st.Selectors) > 0 {
+		return nil
+	}
+
+	detected, err := st.DetectReleasesToBePruned(run.helm, releases)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.targets = append(p.targets, pruneTarget{run: run, releases: detected})
+	p.mu.Unlock()
+
+	return nil
+}
+
+// releasesToBePruned returns the detected releases that are not declared in any helmfile, per run
+func (p *pruner) releasesToBePruned() []pruneTarget {
+	seen := map[string]bool{}
+
+	var targets []pruneTarget
+
+	for _, t := range p.targets {
+		var releases []state.ReleaseSpec
+
+		for _, r := range t.releases {
+			// The detected releases have the namespaces reported by helm, that don't need to be resolved
+			id := state.DeployedRelease{Name: r.Name, Namespace: r.Namespace, KubeContext: r.KubeContext}.ID()
+
+			if p.declared[id] || seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			releases = append(releases, r)
+		}
+
+		if len(releases) > 0 {
+			targets = append(targets, pruneTarget{run: t.run, releases: releases})
+		}
+	}
+
+	return targets
+}
+
+// prune deletes the managed releases that are no longer declared in any helmfile.
+// It returns true when any release is deleted.
+func (a *App) prune(p *pruner, helmfileCommand string, c pruneConfig) (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+
+	targets := p.releasesToBePruned()
+	if len(targets) == 0 {
+		a.Logger.Info("No releases to be pruned")
+		return false, nil
+	}
+
+	var names []string
+	for _, t := range targets {
+		for _, r := range t.releases {
+			names = append(names, fmt.Sprintf("  %s (namespace: %s, kubeContext: %s)", r.Name, r.Namespace, r.KubeContext))
+		}
+	}
+
+	msg := fmt.Sprintf(`Releases to be pruned are:
+%s
+`, strings.Join(names, "\n"))
+
+	if c.PruneDryRun() {
+		a.Writer.Write([]byte(msg))
+		return false, nil
+	}
+
+	if c.Interactive() && !targets[0].run.askForConfirmation(msg+`
+Do you really want to delete?
+  Helmfile will delete the releases that are no longer declared in any helmfile, as shown above.
+
+`) {
+		return false, nil
+	}
+
+	a.Logger.Info(msg)
+
+	var errs []error
+
+	affectedReleases := state.AffectedReleases{}
+
+	for _, t := range targets {
+		st := t.run.state
+		st.Releases = t.releases
+
+		affected := state.AffectedReleases{}
+
+		deletionErrs := st.DeleteReleases(&affected, t.run.helm, c.Concurrency(), true)
+
+		a.auditResult(st, helmfileCommand, &affected, deletionErrs)
+
+		affectedReleases.Deleted = append(affectedReleases.Deleted, affected.Deleted...)
+		affectedReleases.Failed = append(affectedReleases.Failed, affected.Failed...)
+
+		errs = append(errs, deletionErrs...)
+	}
+
+	affectedReleases.DisplayAffectedReleases(c.Logger())
+
+	if len(errs) > 0 {
+		return len(affectedReleases.Deleted) > 0, &Error{msg: "pruning releases", Errors: errs}
+	}
+
+	return true, nil
+}
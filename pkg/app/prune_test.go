@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/vals"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+func TestSync_Prune(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.d/first.yaml": `
+helmDefaults:
+  labelReleases: true
+  stateName: first
+releases:
+- name: foo
+  namespace: apps
+  chart: stable/foo
+`,
+		"/path/to/helmfile.d/second.yaml": `
+helmDefaults:
+  labelReleases: true
+  stateName: second
+releases:
+# Moved from first.yaml, that is still labeled with first
+- name: moved
+  namespace: apps
+  chart: stable/moved
+`,
+	}
+
+	synced := `Affected releases are:
+  foo (stable/foo) UPDATED
+Affected releases are:
+  moved (stable/moved) UPDATED
+`
+
+	testcases := []struct {
+		name        string
+		pruneDryRun bool
+		selectors   []string
+		error       string
+		deleted     []string
+		output      string
+	}{
+		{
+			name:    "prune",
+			deleted: []string{"removed"},
+			output:  synced,
+		},
+		{
+			name:        "prune-dry-run",
+			pruneDryRun: true,
+			output: synced + `Releases to be pruned are:
+  removed (namespace: apps, kubeContext: default)
+`,
+		},
+		{
+			name:      "selectors",
+			selectors: []string{"name=foo"},
+			error:     "--prune can't be used with --selector, as the releases not selected can't be told from the ones removed from the helmfile",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			helm := &exectest.Helm{
+				Helm3:         true,
+				Version:       semver.MustParse("3.13.0"),
+				DiffMutex:     &sync.Mutex{},
+				ChartsMutex:   &sync.Mutex{},
+				ReleasesMutex: &sync.Mutex{},
+				Deployed: map[string]string{
+					"apps": `[
+{"name":"foo","namespace":"apps","revision":"3","status":"deployed","chart":"foo-1.2.5"},
+{"name":"moved","namespace":"apps","revision":"1","status":"deployed","chart":"moved-1.0.0"},
+{"name":"removed","namespace":"apps","revision":"7","status":"deployed","chart":"removed-0.1.0"}
+]`,
+				},
+			}
+
+			var buffer, out bytes.Buffer
+			logger := helmexec.NewLogger(&buffer, "debug")
+
+			valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+			if err != nil {
+				t.Fatalf("unexpected error creating vals runtime: %v", err)
+			}
+
+			app := appWithFs(&App{
+				OverrideHelmBinary:  DefaultHelmBinary,
+				glob:                filepath.Glob,
+				abs:                 filepath.Abs,
+				OverrideKubeContext: "default",
+				Env:                 "default",
+				Logger:              logger,
+				Selectors:           tc.selectors,
+				Writer:              &out,
+				helms: map[helmKey]helmexec.Interface{
+					createHelmKey("helm", "default"): helm,
+				},
+				valsRuntime: valsRuntime,
+			}, files)
+
+			err = app.Sync(applyConfig{
+				concurrency: 1,
+				logger:      logger,
+				prune:       true,
+				pruneDryRun: tc.pruneDryRun,
+			})
+
+			var gotErr string
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if d := cmp.Diff(tc.error, gotErr); d != "" {
+				t.Fatalf("unexpected error: want (-), got (+):\n%s", d)
+			}
+
+			var deleted []string
+			for _, r := range helm.Deleted {
+				deleted = append(deleted, r.Name)
+			}
+
+			if d := cmp.Diff(tc.deleted, deleted); d != "" {
+				t.Errorf("unexpected deletions: want (-), got (+):\n%s", d)
+			}
+
+			if d := cmp.Diff(tc.output, out.String()); d != "" {
+				t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestSync_PruneDryRunWithoutPrune(t *testing.T) {
+	app := appWithFs(&App{
+		OverrideHelmBinary: DefaultHelmBinary,
+		Env:                "default",
+	}, map[string]string{})
+
+	err := app.Sync(applyConfig{pruneDryRun: true})
+	if err == nil || err.Error() != "--prune-dry-run is supported only with --prune" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
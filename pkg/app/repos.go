@@ -0,0 +1,107 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+// Repository is a repository shown by `helmfile repos list`
+type Repository struct {
+	Name    string `json:"name" yaml:"name"`
+	URL     string `json:"url" yaml:"url"`
+	OCI     bool   `json:"oci" yaml:"oci"`
+	Managed string `json:"managed,omitempty" yaml:"managed,omitempty"`
+	// Credentials is the provider of the credentials, `static` for the username and password written in the helmfile, or empty
+	Credentials string `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
+
+func validateReposOutput(output string) error {
+	switch output {
+	case "", "json", "yaml", "table":
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q: it must be one of json, yaml or table", output)
+	}
+}
+
+// ListRepos shows the repositories across all the helmfiles. The repository of the same name is shown once.
+func (a *App) ListRepos(c ReposListConfigProvider) error {
+	if err := validateReposOutput(c.Output()); err != nil {
+		return err
+	}
+
+	var repos []Repository
+
+	seen := map[string]bool{}
+
+	err := a.ForEachState(func(run *Run) (bool, []error) {
+		for _, r := range run.state.Repositories {
+			if seen[r.Name] {
+				continue
+			}
+			seen[r.Name] = true
+
+			repo := Repository{Name: r.Name, URL: r.URL, OCI: r.OCI, Managed: r.Managed}
+
+			switch {
+			case r.Credentials != nil:
+				repo.Credentials = r.Credentials.Provider
+			case r.Username != "" || r.Password != "":
+				repo.Credentials = "static"
+			}
+
+			repos = append(repos, repo)
+		}
+
+		return true, nil
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	return FormatRepositories(a.Writer, repos, c.Output())
+}
+
+// CheckRepos verifies that each repository across all the helmfiles is reachable and accepts the credentials,
+// so that the misconfigured repository is found before apply or sync
+func (a *App) CheckRepos(c ReposCheckConfigProvider) error {
+	if err := validateReposOutput(c.Output()); err != nil {
+		return err
+	}
+
+	var checks []state.RepositoryCheck
+
+	checked := map[string]bool{}
+
+	err := a.ForEachState(func(run *Run) (bool, []error) {
+		for _, check := range run.state.CheckRepos(checked) {
+			checked[check.Name] = true
+			checks = append(checks, check)
+		}
+
+		return true, nil
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	if err := FormatRepositoryChecks(a.Writer, checks, c.Output()); err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, check := range checks {
+		if check.Status == state.RepoCheckFailed {
+			failed = append(failed, check.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		code := 1
+		return &Error{msg: fmt.Sprintf("%d repositories failed the check: %s", len(failed), strings.Join(failed, ", ")), code: &code}
+	}
+
+	return nil
+}
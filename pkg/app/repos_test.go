@@ -0,0 +1,143 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+type reposOutputConfig struct {
+	output string
+}
+
+func (c reposOutputConfig) Output() string {
+	return c.output
+}
+
+func TestListRepos(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+helmfiles:
+- sub/helmfile.yaml
+repositories:
+- name: stable
+  url: https://charts.example.com/stable
+- name: private
+  url: https://charts.example.com/private
+  credentials:
+    provider: env
+`,
+		"/path/to/sub/helmfile.yaml": `
+repositories:
+- name: stable
+  url: https://charts.example.com/stable
+- name: registry
+  url: registry.example.com/charts
+  oci: true
+  username: user
+  password: password
+`,
+	}
+
+	var buffer, out bytes.Buffer
+	logger := helmexec.NewLogger(&buffer, "debug")
+
+	app := appWithFs(&App{
+		OverrideHelmBinary:  DefaultHelmBinary,
+		glob:                filepath.Glob,
+		abs:                 filepath.Abs,
+		OverrideKubeContext: "default",
+		Env:                 "default",
+		Logger:              logger,
+		Writer:              &out,
+		helms: map[helmKey]helmexec.Interface{
+			createHelmKey("helm", "default"): &exectest.Helm{Helm3: true},
+		},
+	}, files)
+
+	if err := app.ListRepos(reposOutputConfig{output: "yaml"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `- name: stable
+  url: https://charts.example.com/stable
+  oci: false
+- name: registry
+  url: registry.example.com/charts
+  oci: true
+  credentials: static
+- name: private
+  url: https://charts.example.com/private
+  oci: false
+  credentials: env
+`
+
+	if d := cmp.Diff(want, out.String()); d != "" {
+		t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+	}
+
+	if err := app.ListRepos(reposOutputConfig{output: "csv"}); err == nil {
+		t.Error("expected error for the unsupported output, got nil")
+	}
+}
+
+func TestCheckRepos(t *testing.T) {
+	charts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok/index.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer charts.Close()
+
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+repositories:
+- name: ok
+  url: ` + charts.URL + `/ok
+- name: missing
+  url: ` + charts.URL + `/missing
+`,
+	}
+
+	var buffer, out bytes.Buffer
+	logger := helmexec.NewLogger(&buffer, "debug")
+
+	app := appWithFs(&App{
+		OverrideHelmBinary:  DefaultHelmBinary,
+		glob:                filepath.Glob,
+		abs:                 filepath.Abs,
+		OverrideKubeContext: "default",
+		Env:                 "default",
+		Logger:              logger,
+		Writer:              &out,
+		helms: map[helmKey]helmexec.Interface{
+			createHelmKey("helm", "default"): &exectest.Helm{Helm3: true},
+		},
+	}, files)
+
+	err := app.CheckRepos(reposOutputConfig{output: "table"})
+	if err == nil || err.Error() != "1 repositories failed the check: missing" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	if fields := strings.Fields(lines[1]); fields[0] != "ok" || fields[3] != "ok" {
+		t.Errorf("unexpected row: %s", lines[1])
+	}
+
+	if fields := strings.Fields(lines[2]); fields[0] != "missing" || fields[3] != "failed" {
+		t.Errorf("unexpected row: %s", lines[2])
+	}
+}
@@ -19,6 +19,9 @@ type Run struct {
 
 	ReleaseToChart map[state.PrepareChartKey]string
 
+	// RenderCacheDir is the directory to cache the prepared charts across runs. The cache is disabled when empty
+	RenderCacheDir string
+
 	Ask func(string) bool
 }
 
@@ -37,11 +40,21 @@ func (r *Run) askForConfirmation(msg string) bool {
 	return AskForConfirmation(msg)
 }
 
-func (r *Run) withPreparedCharts(helmfileCommand string, opts state.ChartPrepareOptions, f func()) error {
+func (r *Run) withPreparedCharts(helmfileCommand string, opts state.ChartPrepareOptions, f func()) (err error) {
 	if r.ReleaseToChart != nil {
 		panic("Run.PrepareCharts can be called only once")
 	}
 
+	if _, err := r.state.TriggerGlobalPrecommandEvent(helmfileCommand); err != nil {
+		return err
+	}
+
+	defer func() {
+		if _, hookErr := r.state.TriggerGlobalPostcommandEvent(err, helmfileCommand); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}()
+
 	if !opts.SkipRepos {
 		ctx := r.ctx
 		if err := ctx.SyncReposOnce(r.state, r.helm); err != nil {
@@ -67,6 +80,8 @@ func (r *Run) withPreparedCharts(helmfileCommand string, opts state.ChartPrepare
 		return err
 	}
 
+	opts.RenderCacheDir = r.RenderCacheDir
+
 	releaseToChart, errs := r.state.PrepareCharts(r.helm, dir, 2, helmfileCommand, opts)
 
 	if len(errs) > 0 {
@@ -90,7 +105,7 @@ func (r *Run) withPreparedCharts(helmfileCommand string, opts state.ChartPrepare
 
 	f()
 
-	_, err := r.state.TriggerGlobalCleanupEvent(helmfileCommand)
+	_, err = r.state.TriggerGlobalCleanupEvent(helmfileCommand)
 
 	return err
 }
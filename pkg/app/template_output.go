@@ -0,0 +1,130 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/huolunl/helmfile/pkg/manifest"
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+// Modes of `helmfile template --output-mode`
+const (
+	// TemplateOutputResources writes one file per resource laid out as <output-dir>/<release>/<kind>/<name>.yaml
+	TemplateOutputResources = "resources"
+	// TemplateOutputStream writes the resources of all the releases as a single multi-document stream
+	TemplateOutputStream = "stream"
+	// TemplateOutputKustomize writes the resources like TemplateOutputResources, and a kustomization.yaml per release
+	TemplateOutputKustomize = "kustomize"
+)
+
+// templateOutput writes the resources rendered by `helmfile template --output-mode`
+type templateOutput struct {
+	mode      string
+	outputDir string
+	w         io.Writer
+
+	mu sync.Mutex
+	// written is the set of the release directories written by the run, to detect releases sharing a directory
+	written map[string]bool
+}
+
+type kustomization struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Namespace  string   `yaml:"namespace,omitempty"`
+	Resources  []string `yaml:"resources"`
+}
+
+func newTemplateOutput(c TemplateConfigProvider, w io.Writer) (*templateOutput, error) {
+	mode := c.OutputMode()
+
+	switch mode {
+	case "":
+		return nil, nil
+	case TemplateOutputStream:
+	case TemplateOutputResources, TemplateOutputKustomize:
+		if c.OutputDir() == "" {
+			return nil, fmt.Errorf("--output-mode %s requires --output-dir", mode)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported output mode %q: it must be one of resources, stream or kustomize", mode)
+	}
+
+	if c.OutputDirTemplate() != "" {
+		return nil, fmt.Errorf("--output-dir-template can't be used with --output-mode")
+	}
+
+	return &templateOutput{mode: mode, outputDir: c.OutputDir(), w: w, written: map[string]bool{}}, nil
+}
+
+func (o *templateOutput) write(release *state.ReleaseSpec, resources []manifest.Resource) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.mode == TemplateOutputStream {
+		_, err := io.WriteString(o.w, manifest.Format(resources))
+		return err
+	}
+
+	dir := filepath.Join(o.outputDir, release.Name)
+	if o.written[dir] {
+		return fmt.Errorf("release %q is written to %s that is already written for another release of the same name", release.Name, dir)
+	}
+	o.written[dir] = true
+
+	// Remove the resources written by the previous run, so that the removed resources don't remain
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	var files []string
+
+	seen := map[string]bool{}
+
+	for _, r := range resources {
+		file := filepath.Join(strings.ToLower(r.Kind), r.Name+".yaml")
+		if seen[file] {
+			return fmt.Errorf("release %q has more than one %s named %q, that can't be written to %s", release.Name, r.Kind, r.Name, filepath.Join(dir, file))
+		}
+		seen[file] = true
+
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path, []byte(r.Content+"\n"), 0644); err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(file))
+	}
+
+	if o.mode != TemplateOutputKustomize {
+		return nil
+	}
+
+	bs, err := yaml.Marshal(kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Namespace:  release.Namespace,
+		Resources:  files,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), bs, 0644)
+}
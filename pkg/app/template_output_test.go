@@ -0,0 +1,207 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/vals"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+func TestTemplate_OutputMode(t *testing.T) {
+	files := map[string]string{
+		"/path/to/helmfile.yaml": `
+releases:
+- name: foo
+  namespace: apps
+  chart: stable/foo
+- name: bar
+  chart: stable/bar
+`,
+	}
+
+	manifests := map[string]string{
+		"foo": `---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+---
+# Source: foo/templates/tests/test.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-test
+  annotations:
+    helm.sh/hook: test
+---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`,
+		"bar": `---
+# Source: bar/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bar
+`,
+	}
+
+	run := func(t *testing.T, c configImpl) string {
+		t.Helper()
+
+		var buffer, out bytes.Buffer
+		logger := helmexec.NewLogger(&buffer, "debug")
+
+		valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+		if err != nil {
+			t.Fatalf("unexpected error creating vals runtime: %v", err)
+		}
+
+		app := appWithFs(&App{
+			OverrideHelmBinary:  DefaultHelmBinary,
+			glob:                filepath.Glob,
+			abs:                 filepath.Abs,
+			OverrideKubeContext: "default",
+			Env:                 "default",
+			Logger:              logger,
+			Writer:              &out,
+			helms: map[helmKey]helmexec.Interface{
+				createHelmKey("helm", "default"): &exectest.Helm{Helm3: true, Manifests: manifests},
+			},
+			valsRuntime: valsRuntime,
+		}, files)
+
+		if err := app.Template(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return out.String()
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		want := `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+---
+# Source: bar/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bar
+`
+
+		if d := cmp.Diff(want, run(t, configImpl{outputMode: "stream"})); d != "" {
+			t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+		}
+	})
+
+	t.Run("kustomize", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "helmfile-template")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// Emulates the resource removed since the previous run
+		stale := filepath.Join(dir, "foo", "secret", "foo.yaml")
+		if err := os.MkdirAll(filepath.Dir(stale), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(stale, []byte("kind: Secret\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		run(t, configImpl{outputMode: "kustomize", outputDir: dir})
+
+		got := map[string]string{}
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			bs, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			got[filepath.ToSlash(rel)] = string(bs)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]string{
+			"foo/configmap/foo.yaml": `# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`,
+			"foo/service/foo.yaml": `# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+`,
+			"foo/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+namespace: apps
+resources:
+- configmap/foo.yaml
+- service/foo.yaml
+`,
+			"bar/deployment/bar.yaml": `# Source: bar/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bar
+`,
+			"bar/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- deployment/bar.yaml
+`,
+		}
+
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("unexpected files: want (-), got (+):\n%s", d)
+		}
+	})
+}
+
+func TestTemplate_UnsupportedOutputMode(t *testing.T) {
+	app := appWithFs(&App{
+		OverrideHelmBinary: DefaultHelmBinary,
+		Env:                "default",
+	}, map[string]string{})
+
+	want := `unsupported output mode "xml": it must be one of resources, stream or kustomize`
+
+	err := app.Template(configImpl{outputMode: "xml"})
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want %q, got %v", want, err)
+	}
+}
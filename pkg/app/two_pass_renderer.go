@@ -8,6 +8,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/huolunl/helmfile/pkg/environment"
 	"github.com/huolunl/helmfile/pkg/state"
+	"github.com/huolunl/helmfile/pkg/timing"
 	"github.com/huolunl/helmfile/pkg/tmpl"
 )
 
@@ -97,7 +98,9 @@ func (r *desiredStateLoader) twoPassRenderTemplateToYaml(inherited, overrode *en
 		r.logger.Debugf("first-pass uses: %v", initEnv)
 	}
 
+	stopTiming := r.timings.Start(timing.CategoryRender, fmt.Sprintf("first-pass rendering of %s", filename))
 	renderedEnv, prestate := r.renderPrestate(initEnv, baseDir, filename, content)
+	stopTiming()
 
 	if r.logger != nil {
 		r.logger.Debugf("first-pass produced: %v", renderedEnv)
@@ -133,7 +136,9 @@ func (r *desiredStateLoader) twoPassRenderTemplateToYaml(inherited, overrode *en
 		Values:      vals,
 	}
 	secondPassRenderer := tmpl.NewFileRenderer(r.readFile, baseDir, tmplData)
+	stopTiming = r.timings.Start(timing.CategoryRender, fmt.Sprintf("second-pass rendering of %s", filename))
 	yamlBuf, err := secondPassRenderer.RenderTemplateContentToBuffer(content)
+	stopTiming()
 	if err != nil {
 		if r.logger != nil {
 			r.logger.Debugf("second-pass rendering failed, input of \"%s\":\n%s", filename, prependLineNumbers(string(content)))
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/state"
+)
+
+// validateRenderedPart validates the rendered helmfile.yaml part against the JSON Schema.
+// Every violation is reported at the line of the source template it originates from,
+// where firstLine is the line in the file the source part starts at.
+func (ld *desiredStateLoader) validateRenderedPart(filename string, firstLine int, source, rendered []byte) error {
+	schemaErrs, err := state.ValidateSchema(rendered)
+	if err != nil {
+		return fmt.Errorf("error during %s schema validation: %v", filename, err)
+	}
+
+	if len(schemaErrs) == 0 {
+		return nil
+	}
+
+	if ld.logger != nil {
+		ld.logger.Debugf("schema validation failed, rendered part of \"%s\" starting at line %d:\n%s", filename, firstLine, prependLineNumbers(string(rendered)))
+	}
+
+	sourceLines := strings.Split(string(source), "\n")
+	renderedLines := strings.Split(string(rendered), "\n")
+
+	errs := make([]error, 0, len(schemaErrs))
+	for _, e := range schemaErrs {
+		line := sourceLineOf(sourceLines, renderedLines, e.Line)
+		if line > 0 {
+			errs = append(errs, fmt.Errorf("%s:%d: %v", filename, firstLine+line-1, e))
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %v (at line %d of the rendered template)", filename, e, e.Line))
+		}
+	}
+
+	return &MultiError{Errors: errs}
+}
+
+// sourceLineOf maps the 1-based line number of the rendered template back to the line of the source template.
+//
+// Template expressions usually don't span lines, so the line is kept as-is when both have the same number of lines.
+// Otherwise the source line with the same content that is nearest to the rendered line is picked.
+// 0 is returned when the line cannot be mapped.
+func sourceLineOf(source, rendered []string, line int) int {
+	if line <= 0 || line > len(rendered) {
+		return 0
+	}
+
+	if len(source) == len(rendered) {
+		return line
+	}
+
+	want := strings.TrimSpace(rendered[line-1])
+	if want == "" {
+		return 0
+	}
+
+	found := 0
+	for i, l := range source {
+		if strings.TrimSpace(l) != want {
+			continue
+		}
+		if found == 0 || absInt(i+1-line) < absInt(found-line) {
+			found = i + 1
+		}
+	}
+
+	return found
+}
+
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
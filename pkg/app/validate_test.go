@@ -0,0 +1,118 @@
+package app
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+type validateConfig struct {
+	printSchema bool
+}
+
+func (c validateConfig) PrintSchema() bool {
+	return c.printSchema
+}
+
+func TestValidate(t *testing.T) {
+	testcases := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			files: map[string]string{
+				"/path/to/helmfile.yaml": `
+environments:
+  default:
+  prod:
+    values:
+    - replicas: 3
+---
+releases:
+- name: foo
+  chart: stable/foo
+  wait: true
+  set:
+  - name: replicas
+    value: {{ .Values | get "replicas" 1 }}
+`,
+			},
+		},
+		{
+			name: "unknown field",
+			files: map[string]string{
+				"/path/to/helmfile.yaml": `
+releases:
+- name: foo
+  chart: stable/foo
+  wiat: true
+`,
+			},
+			wantErr: `in ./helmfile.yaml: Failed with 1 errors:
+
+Error 1:
+
+  helmfile.yaml:5: releases[0].wiat: Additional property wiat is not allowed
+`,
+		},
+		{
+			name: "invalid only in another environment",
+			files: map[string]string{
+				"/path/to/helmfile.yaml": `
+environments:
+  default:
+  prod:
+---
+releases:
+- name: foo
+  chart: stable/foo
+{{- if eq .Environment.Name "prod" }}
+  timeout: "5m"
+{{- end }}
+`,
+			},
+			wantErr: `in environment "prod": in ./helmfile.yaml: Failed with 1 errors:
+
+Error 1:
+
+  helmfile.yaml:10: releases[0].timeout: Invalid type. Expected: [integer,null], given: string
+`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			logger := helmexec.NewLogger(&buffer, "debug")
+
+			app := appWithFs(&App{
+				OverrideHelmBinary: DefaultHelmBinary,
+				glob:               filepath.Glob,
+				abs:                filepath.Abs,
+				Env:                "default",
+				Logger:             logger,
+				helms: map[helmKey]helmexec.Interface{
+					createHelmKey("helm", ""): &exectest.Helm{Helm3: true},
+				},
+			}, tc.files)
+
+			err := app.Validate(validateConfig{})
+
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("unexpected error: want %q, got %q", tc.wantErr, err)
+			}
+		})
+	}
+}
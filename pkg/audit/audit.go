@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ActionUpgrade = "upgrade"
+	ActionDelete  = "delete"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record is an entry of the audit log, that is a mutating operation on a release
+type Record struct {
+	Time time.Time `json:"time"`
+	// User is the name of the OS user who ran helmfile
+	User string `json:"user"`
+	// Description is the description passed to helm as --description
+	Description     string   `json:"description,omitempty"`
+	HelmfileCommand string   `json:"helmfileCommand"`
+	Helmfile        string   `json:"helmfile"`
+	Environment     string   `json:"environment"`
+	Selectors       []string `json:"selectors,omitempty"`
+
+	Release     string `json:"release"`
+	Namespace   string `json:"namespace,omitempty"`
+	KubeContext string `json:"kubeContext,omitempty"`
+	// Action is either upgrade or delete
+	Action       string `json:"action"`
+	Chart        string `json:"chart"`
+	ChartVersion string `json:"chartVersion,omitempty"`
+	// ValuesHash is the SHA-256 of the values passed to helm
+	ValuesHash string `json:"valuesHash,omitempty"`
+	// Result is either success or failure
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Sink persists audit records. Implement it to write the audit log to your own store
+type Sink interface {
+	Write(records []Record) error
+}
+
+// FileSink appends records to a file in the JSON Lines format
+type FileSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{Path: path}
+}
+
+func (s *FileSink) Write(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dir := filepath.Dir(s.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, r := range records {
+		bs, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(append(bs, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MultiSink writes records to all the sinks
+type MultiSink []Sink
+
+func (s MultiSink) Write(records []Record) error {
+	var errs []string
+
+	for _, sink := range s {
+		if err := sink.Write(records); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("writing audit records: %v", errs)
+	}
+
+	return nil
+}
+
+// CurrentUser returns the name of the user running helmfile
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type failingSink struct{}
+
+func (s failingSink) Write(records []Record) error {
+	return errors.New("store is down")
+}
+
+type memorySink struct {
+	records []Record
+}
+
+func (s *memorySink) Write(records []Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "audit.jsonl")
+	sink := NewFileSink(path)
+
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	if err := sink.Write([]Record{{Time: ts, User: "alice", HelmfileCommand: "apply", Release: "foo", Action: ActionUpgrade, Chart: "stable/foo", Result: ResultSuccess}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := sink.Write([]Record{{Time: ts, User: "bob", HelmfileCommand: "destroy", Release: "foo", Action: ActionDelete, Chart: "stable/foo", Result: ResultFailure, Error: "timed out"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"time":"2021-05-01T10:00:00Z","user":"alice","helmfileCommand":"apply","helmfile":"","environment":"","release":"foo","action":"upgrade","chart":"stable/foo","result":"success"}
+{"time":"2021-05-01T10:00:00Z","user":"bob","helmfileCommand":"destroy","helmfile":"","environment":"","release":"foo","action":"delete","chart":"stable/foo","result":"failure","error":"timed out"}
+`
+
+	if d := cmp.Diff(want, string(bs)); d != "" {
+		t.Errorf("unexpected audit log: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	mem := &memorySink{}
+
+	err := MultiSink{failingSink{}, mem}.Write([]Record{{Release: "foo"}})
+	if err == nil || err.Error() != "writing audit records: [store is down]" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(mem.records) != 1 {
+		t.Errorf("expected the record to be written to the other sinks, got %v", mem.records)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/huolunl/helmfile/pkg/app"
 	"github.com/huolunl/helmfile/pkg/app/version"
+	"github.com/huolunl/helmfile/pkg/audit"
 	"github.com/huolunl/helmfile/pkg/helmexec"
 	"github.com/huolunl/helmfile/pkg/maputil"
 	"github.com/huolunl/helmfile/pkg/state"
@@ -44,6 +45,10 @@ const (
 	Description = "Description"
 )
 
+// AuditSink is the sink every release changed via Exec and ExecFaster is recorded to, in addition to --audit-log.
+// Set it to write the audit log to your own store.
+var AuditSink audit.Sink
+
 func exec(extra []string, description string, args ...string) ([]byte, error) {
 	os.Args = args
 	writer := &bytes.Buffer{}
@@ -122,6 +127,24 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 			Name:  "interactive, i",
 			Usage: "Request confirmation before attempting to modify clusters",
 		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			Usage:  "Append the record of every release changed by apply, sync, delete and destroy to the JSON Lines file",
+			EnvVar: "HELMFILE_AUDIT_LOG",
+		},
+		cli.BoolFlag{
+			Name:   "render-cache",
+			Usage:  "Cache the charts generated by chartify, pulled from OCI registries and their dependencies built by `helm dep build` across runs. See `helmfile cache info`",
+			EnvVar: "HELMFILE_RENDER_CACHE",
+		},
+		cli.BoolFlag{
+			Name:  "timings",
+			Usage: "Print the wall time of loading and rendering helmfiles, syncing repositories, preparing charts, hooks, and diffing and upgrading each release at the end of the run",
+		},
+		cli.StringFlag{
+			Name:  "timings-file",
+			Usage: "Write the timings of the run in JSON to the file",
+		},
 	}
 
 	cliApp.Before = configureLogging
@@ -157,6 +180,36 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Repos(c)
 			}),
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list the repositories across all the helmfiles",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output",
+							Value: "table",
+							Usage: "output format: table, json or yaml",
+						},
+					},
+					Action: action(func(a *app.App, c configImpl) error {
+						return a.ListRepos(c)
+					}),
+				},
+				{
+					Name:  "check",
+					Usage: "verify that every repository is reachable and accepts the credentials, without adding it to helm",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output",
+							Value: "table",
+							Usage: "output format: table, json or yaml",
+						},
+					},
+					Action: action(func(a *app.App, c configImpl) error {
+						return a.CheckRepos(c)
+					}),
+				},
+			},
 		},
 		{
 			Name:  "charts",
@@ -258,6 +311,10 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Value: "",
 					Usage: "output format for diff plugin",
 				},
+				cli.StringFlag{
+					Name:  "against",
+					Usage: "diff the rendered manifests against the ones of another revision of the helmfile, that is either a directory or a git ref like main, instead of the cluster",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Diff(c)
@@ -288,6 +345,10 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Name:  "output-dir-template",
 					Usage: "go text template for generating the output directory. Default: {{ .OutputDir }}/{{ .State.BaseName }}-{{ .State.AbsPathSHA1 }}-{{ .Release.Name}}",
 				},
+				cli.StringFlag{
+					Name:  "output-mode",
+					Usage: "output the rendered manifests sorted and without helm test hooks, as one file per resource laid out as <output-dir>/<release>/<kind>/<name>.yaml (resources), the resources plus a kustomization.yaml per release (kustomize), or a single multi-document stream to stdout (stream)",
+				},
 				cli.IntFlag{
 					Name:  "concurrency",
 					Value: 0,
@@ -356,6 +417,71 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 				return a.WriteValues(c)
 			}),
 		},
+		{
+			Name:  "export",
+			Usage: "export releases as Argo CD Applications or Flux HelmReleases for GitOps",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "format of the exported resources: argocd or flux",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Usage: "output directory to write the resources to, as <output-dir>/<namespace>/<kind>/<name>.yaml. The resources are printed to stdout when omitted",
+				},
+				cli.StringFlag{
+					Name:  "resource-namespace",
+					Usage: "namespace of the Argo CD Applications or the Flux sources. Default: argocd for argocd, flux-system for flux",
+				},
+				cli.StringFlag{
+					Name:  "argocd-project",
+					Value: "default",
+					Usage: "Argo CD project of the Applications",
+				},
+				cli.StringFlag{
+					Name:  "argocd-server",
+					Value: "https://kubernetes.default.svc",
+					Usage: "Kubernetes API server the Applications of the releases without kubeContext are deployed to",
+				},
+				cli.StringFlag{
+					Name:  "values-as",
+					Value: "inline",
+					Usage: "how the values of the releases are exported: inline, or configmap that is supported only with flux",
+				},
+				cli.BoolFlag{
+					Name:  "include-secrets",
+					Usage: "export the decrypted secrets of the releases and the environment, and the secrets referenced by vals expressions, along with the values of the releases, in plain text",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Export(c)
+			}),
+		},
+		{
+			Name:  "import",
+			Usage: "import the releases installed in the cluster but not declared in the helmfiles to a new helmfile",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "kube-contexts",
+					Usage: "kube contexts to import the releases from. The default kube context of the helmfile is used when omitted",
+				},
+				cli.StringSliceFlag{
+					Name:  "namespaces",
+					Usage: "namespaces to import the releases from. The releases in all the namespaces are imported when omitted",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Usage: "output directory to write helmfile.yaml and the values files of the imported releases to",
+				},
+				cli.BoolFlag{
+					Name:  "skip-repos",
+					Usage: `skip running "helm repo add" and "helm repo update" before searching the repositories for the charts`,
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Import(c)
+			}),
+		},
 		{
 			Name:  "lint",
 			Usage: "lint charts from state file (helm lint)",
@@ -426,6 +552,10 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
 				cli.StringFlag{
 					Name:  "args",
 					Value: "",
@@ -459,6 +589,14 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Name:  "wait-for-jobs",
 					Usage: `Override helmDefaults.waitForJobs setting "helm upgrade --install --wait-for-jobs"`,
 				},
+				cli.BoolFlag{
+					Name:  "prune",
+					Usage: "delete the releases labeled by helmDefaults.labelReleases that are no longer declared in any helmfile, after syncing. Requires helm 3.13.0 or greater",
+				},
+				cli.BoolFlag{
+					Name:  "prune-dry-run",
+					Usage: "print the releases to be deleted by --prune without deleting them",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Sync(c)
@@ -481,6 +619,23 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
+				cli.BoolFlag{
+					Name:  "incremental",
+					Usage: "skip diffing releases whose charts and values are unchanged since the last successful apply, according to the fingerprints stored in --fingerprint-file",
+				},
+				cli.BoolFlag{
+					Name:  "force-diff",
+					Usage: "diff releases even if they are unchanged since the last successful apply. Only meaningful with --incremental",
+				},
+				cli.StringFlag{
+					Name:  "fingerprint-file",
+					Value: "",
+					Usage: "path to the file storing the fingerprints of the releases for --incremental. Defaults to .helmfile/fingerprints.json",
+				},
 				cli.BoolFlag{
 					Name:  "validate",
 					Usage: "validate your manifests against the Kubernetes cluster you are currently pointing at. Note that this requiers access to a Kubernetes cluster to obtain information necessary for validating, like the list of available API versions",
@@ -564,6 +719,14 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Name:  "wait-for-jobs",
 					Usage: `Override helmDefaults.waitForJobs setting "helm upgrade --install --wait-for-jobs"`,
 				},
+				cli.BoolFlag{
+					Name:  "prune",
+					Usage: "delete the releases labeled by helmDefaults.labelReleases that are no longer declared in any helmfile, after applying. Requires helm 3.13.0 or greater",
+				},
+				cli.BoolFlag{
+					Name:  "prune-dry-run",
+					Usage: "print the releases to be deleted by --prune without deleting them",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Apply(c)
@@ -583,6 +746,11 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Value: "",
 					Usage: "pass args to helm exec",
 				},
+				cli.StringFlag{
+					Name:  "output",
+					Value: "",
+					Usage: "output the status of each release in json, yaml or table, instead of the output of helm status. Exits with 1 when any release is failed or pending",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Status(c)
@@ -597,6 +765,10 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
 				cli.StringFlag{
 					Name:  "args",
 					Value: "",
@@ -624,6 +796,10 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
 				cli.StringFlag{
 					Name:  "args",
 					Value: "",
@@ -687,6 +863,51 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 				return a.PrintState(c)
 			}),
 		},
+		{
+			Name:  "validate",
+			Usage: "validate helmfile state(s) of every environment against the JSON Schema of helmfile.yaml",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "print-schema",
+					Usage: "Print the JSON Schema of helmfile.yaml instead of validating. Useful for completion and validation in editors",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Validate(c)
+			}),
+		},
+		{
+			Name:  "explain",
+			Usage: "explain where each of the final values of releases came from",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "release",
+					Value: "",
+					Usage: "explain values of the release with the name only. All the selected releases are explained by default",
+				},
+				cli.StringFlag{
+					Name:  "key",
+					Value: "",
+					Usage: "explain the value at the key path like image.tag only. All the values are explained by default",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Explain(c)
+			}),
+		},
+		{
+			Name:  "environments",
+			Usage: "list environments defined in state file, or show the resolved values of the environment",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "values",
+					Usage: "show the values of the environment specified with --environment, merged from the environments it inherits, instead of listing environments",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Environments(c)
+			}),
+		},
 		{
 			Name:  "list",
 			Usage: "list releases defined in state file",
@@ -694,7 +915,11 @@ func exec(extra []string, description string, args ...string) ([]byte, error) {
 				cli.StringFlag{
 					Name:  "output",
 					Value: "",
-					Usage: "output releases list as a json string",
+					Usage: "output releases list in the format, that is one of json, yaml, csv and table",
+				},
+				cli.BoolFlag{
+					Name:  "with-status",
+					Usage: "join the releases with the deployed ones to show the deployed revision, status and versions, and the orphans that are deployed in the managed namespaces but not declared in any helmfile. Requires helm 3",
 				},
 				cli.BoolFlag{
 					Name:  "keep-temp-dir",
@@ -817,6 +1042,10 @@ func (c configImpl) OutputDir() string {
 	return c.c.String("output-dir")
 }
 
+func (c configImpl) OutputMode() string {
+	return c.c.String("output-mode")
+}
+
 func (c configImpl) OutputDirTemplate() string {
 	return c.c.String("output-dir-template")
 }
@@ -853,6 +1082,58 @@ func (c configImpl) IncludeTransitiveNeeds() bool {
 	return c.c.Bool("include-transitive-needs")
 }
 
+func (c configImpl) Format() string {
+	return c.c.String("format")
+}
+
+func (c configImpl) ResourceNamespace() string {
+	return c.c.String("resource-namespace")
+}
+
+func (c configImpl) ArgoCDProject() string {
+	return c.c.String("argocd-project")
+}
+
+func (c configImpl) ArgoCDServer() string {
+	return c.c.String("argocd-server")
+}
+
+func (c configImpl) ValuesAs() string {
+	return c.c.String("values-as")
+}
+
+func (c configImpl) IncludeSecrets() bool {
+	return c.c.Bool("include-secrets")
+}
+
+func (c configImpl) KubeContexts() []string {
+	return c.c.StringSlice("kube-contexts")
+}
+
+func (c configImpl) Namespaces() []string {
+	return c.c.StringSlice("namespaces")
+}
+
+func (c configImpl) Against() string {
+	return c.c.String("against")
+}
+
+func (c configImpl) DAG() bool {
+	return c.c.Bool("dag")
+}
+
+func (c configImpl) Incremental() bool {
+	return c.c.Bool("incremental")
+}
+
+func (c configImpl) ForceDiff() bool {
+	return c.c.Bool("force-diff")
+}
+
+func (c configImpl) FingerprintFile() string {
+	return c.c.String("fingerprint-file")
+}
+
 // DiffConfig
 
 func (c configImpl) SkipDeps() bool {
@@ -916,6 +1197,18 @@ func (c configImpl) Output() string {
 	return c.c.String("output")
 }
 
+func (c configImpl) Prune() bool {
+	return c.c.Bool("prune")
+}
+
+func (c configImpl) PruneDryRun() bool {
+	return c.c.Bool("prune-dry-run")
+}
+
+func (c configImpl) WithStatus() bool {
+	return c.c.Bool("with-status")
+}
+
 func (c configImpl) KeepTempDir() bool {
 	return c.c.Bool("keep-temp-dir")
 }
@@ -954,6 +1247,22 @@ func (c configImpl) StateValuesFiles() []string {
 	return c.c.GlobalStringSlice("state-values-file")
 }
 
+func (c configImpl) AuditLog() string {
+	return c.c.GlobalString("audit-log")
+}
+
+func (c configImpl) RenderCache() bool {
+	return c.c.GlobalBool("render-cache")
+}
+
+func (c configImpl) Timings() bool {
+	return c.c.GlobalBool("timings")
+}
+
+func (c configImpl) TimingsFile() string {
+	return c.c.GlobalString("timings-file")
+}
+
 func (c configImpl) Interactive() bool {
 	return c.c.GlobalBool("interactive")
 }
@@ -994,6 +1303,22 @@ func (c configImpl) SkipTests() bool {
 	return c.c.Bool("skip-tests")
 }
 
+func (c configImpl) PrintSchema() bool {
+	return c.c.Bool("print-schema")
+}
+
+func (c configImpl) Release() string {
+	return c.c.String("release")
+}
+
+func (c configImpl) Key() string {
+	return c.c.String("key")
+}
+
+func (c configImpl) ShowValues() bool {
+	return c.c.Bool("values")
+}
+
 func (c configImpl) Logger() *zap.SugaredLogger {
 	return c.c.App.Metadata["logger"].(*zap.SugaredLogger)
 }
@@ -1024,6 +1349,9 @@ func action(do func(*app.App, configImpl) error) func(*cli.Context) error {
 			log.Println("no helm Description")
 		}
 		a := app.NewWithHelmExtra(conf, conf.c.App.Writer, Description, helmExtra...)
+		a.AuditSink = AuditSink
+		defer a.ReportTimings()
+
 		if err := do(a, conf); err != nil {
 			if err != nil {
 				//todo err type
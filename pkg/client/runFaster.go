@@ -88,6 +88,24 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 			Name:  "interactive, i",
 			Usage: "Request confirmation before attempting to modify clusters",
 		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			Usage:  "Append the record of every release changed by apply, sync, delete and destroy to the JSON Lines file",
+			EnvVar: "HELMFILE_AUDIT_LOG",
+		},
+		cli.BoolFlag{
+			Name:   "render-cache",
+			Usage:  "Cache the charts generated by chartify, pulled from OCI registries and their dependencies built by `helm dep build` across runs. See `helmfile cache info`",
+			EnvVar: "HELMFILE_RENDER_CACHE",
+		},
+		cli.BoolFlag{
+			Name:  "timings",
+			Usage: "Print the wall time of loading and rendering helmfiles, syncing repositories, preparing charts, hooks, and diffing and upgrading each release at the end of the run",
+		},
+		cli.StringFlag{
+			Name:  "timings-file",
+			Usage: "Write the timings of the run in JSON to the file",
+		},
 	}
 
 	cliApp.Before = configureLogging
@@ -123,6 +141,36 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Repos(c)
 			}),
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list the repositories across all the helmfiles",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output",
+							Value: "table",
+							Usage: "output format: table, json or yaml",
+						},
+					},
+					Action: action(func(a *app.App, c configImpl) error {
+						return a.ListRepos(c)
+					}),
+				},
+				{
+					Name:  "check",
+					Usage: "verify that every repository is reachable and accepts the credentials, without adding it to helm",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output",
+							Value: "table",
+							Usage: "output format: table, json or yaml",
+						},
+					},
+					Action: action(func(a *app.App, c configImpl) error {
+						return a.CheckRepos(c)
+					}),
+				},
+			},
 		},
 		{
 			Name:  "charts",
@@ -224,6 +272,10 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Value: "",
 					Usage: "output format for diff plugin",
 				},
+				cli.StringFlag{
+					Name:  "against",
+					Usage: "diff the rendered manifests against the ones of another revision of the helmfile, that is either a directory or a git ref like main, instead of the cluster",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Diff(c)
@@ -254,6 +306,10 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Name:  "output-dir-template",
 					Usage: "go text template for generating the output directory. Default: {{ .OutputDir }}/{{ .State.BaseName }}-{{ .State.AbsPathSHA1 }}-{{ .Release.Name}}",
 				},
+				cli.StringFlag{
+					Name:  "output-mode",
+					Usage: "output the rendered manifests sorted and without helm test hooks, as one file per resource laid out as <output-dir>/<release>/<kind>/<name>.yaml (resources), the resources plus a kustomization.yaml per release (kustomize), or a single multi-document stream to stdout (stream)",
+				},
 				cli.IntFlag{
 					Name:  "concurrency",
 					Value: 0,
@@ -322,6 +378,71 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 				return a.WriteValues(c)
 			}),
 		},
+		{
+			Name:  "export",
+			Usage: "export releases as Argo CD Applications or Flux HelmReleases for GitOps",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "format of the exported resources: argocd or flux",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Usage: "output directory to write the resources to, as <output-dir>/<namespace>/<kind>/<name>.yaml. The resources are printed to stdout when omitted",
+				},
+				cli.StringFlag{
+					Name:  "resource-namespace",
+					Usage: "namespace of the Argo CD Applications or the Flux sources. Default: argocd for argocd, flux-system for flux",
+				},
+				cli.StringFlag{
+					Name:  "argocd-project",
+					Value: "default",
+					Usage: "Argo CD project of the Applications",
+				},
+				cli.StringFlag{
+					Name:  "argocd-server",
+					Value: "https://kubernetes.default.svc",
+					Usage: "Kubernetes API server the Applications of the releases without kubeContext are deployed to",
+				},
+				cli.StringFlag{
+					Name:  "values-as",
+					Value: "inline",
+					Usage: "how the values of the releases are exported: inline, or configmap that is supported only with flux",
+				},
+				cli.BoolFlag{
+					Name:  "include-secrets",
+					Usage: "export the decrypted secrets of the releases and the environment, and the secrets referenced by vals expressions, along with the values of the releases, in plain text",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Export(c)
+			}),
+		},
+		{
+			Name:  "import",
+			Usage: "import the releases installed in the cluster but not declared in the helmfiles to a new helmfile",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "kube-contexts",
+					Usage: "kube contexts to import the releases from. The default kube context of the helmfile is used when omitted",
+				},
+				cli.StringSliceFlag{
+					Name:  "namespaces",
+					Usage: "namespaces to import the releases from. The releases in all the namespaces are imported when omitted",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Usage: "output directory to write helmfile.yaml and the values files of the imported releases to",
+				},
+				cli.BoolFlag{
+					Name:  "skip-repos",
+					Usage: `skip running "helm repo add" and "helm repo update" before searching the repositories for the charts`,
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Import(c)
+			}),
+		},
 		{
 			Name:  "lint",
 			Usage: "lint charts from state file (helm lint)",
@@ -392,6 +513,10 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
 				cli.StringFlag{
 					Name:  "args",
 					Value: "",
@@ -425,6 +550,14 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Name:  "wait-for-jobs",
 					Usage: `Override helmDefaults.waitForJobs setting "helm upgrade --install --wait-for-jobs"`,
 				},
+				cli.BoolFlag{
+					Name:  "prune",
+					Usage: "delete the releases labeled by helmDefaults.labelReleases that are no longer declared in any helmfile, after syncing. Requires helm 3.13.0 or greater",
+				},
+				cli.BoolFlag{
+					Name:  "prune-dry-run",
+					Usage: "print the releases to be deleted by --prune without deleting them",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Sync(c)
@@ -447,6 +580,23 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
+				cli.BoolFlag{
+					Name:  "incremental",
+					Usage: "skip diffing releases whose charts and values are unchanged since the last successful apply, according to the fingerprints stored in --fingerprint-file",
+				},
+				cli.BoolFlag{
+					Name:  "force-diff",
+					Usage: "diff releases even if they are unchanged since the last successful apply. Only meaningful with --incremental",
+				},
+				cli.StringFlag{
+					Name:  "fingerprint-file",
+					Value: "",
+					Usage: "path to the file storing the fingerprints of the releases for --incremental. Defaults to .helmfile/fingerprints.json",
+				},
 				cli.BoolFlag{
 					Name:  "validate",
 					Usage: "validate your manifests against the Kubernetes cluster you are currently pointing at. Note that this requiers access to a Kubernetes cluster to obtain information necessary for validating, like the list of available API versions",
@@ -530,6 +680,14 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Name:  "wait-for-jobs",
 					Usage: `Override helmDefaults.waitForJobs setting "helm upgrade --install --wait-for-jobs"`,
 				},
+				cli.BoolFlag{
+					Name:  "prune",
+					Usage: "delete the releases labeled by helmDefaults.labelReleases that are no longer declared in any helmfile, after applying. Requires helm 3.13.0 or greater",
+				},
+				cli.BoolFlag{
+					Name:  "prune-dry-run",
+					Usage: "print the releases to be deleted by --prune without deleting them",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Apply(c)
@@ -549,6 +707,11 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Value: "",
 					Usage: "pass args to helm exec",
 				},
+				cli.StringFlag{
+					Name:  "output",
+					Value: "",
+					Usage: "output the status of each release in json, yaml or table, instead of the output of helm status. Exits with 1 when any release is failed or pending",
+				},
 			},
 			Action: action(func(a *app.App, c configImpl) error {
 				return a.Status(c)
@@ -563,6 +726,10 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
 				cli.StringFlag{
 					Name:  "args",
 					Value: "",
@@ -590,6 +757,10 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 					Value: 0,
 					Usage: "maximum number of concurrent helm processes to run, 0 is unlimited",
 				},
+				cli.BoolFlag{
+					Name:  "dag",
+					Usage: "start processing each release as soon as the releases it needs are processed, instead of waiting for the whole group of releases. It also logs the critical path",
+				},
 				cli.StringFlag{
 					Name:  "args",
 					Value: "",
@@ -653,6 +824,51 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 				return a.PrintState(c)
 			}),
 		},
+		{
+			Name:  "validate",
+			Usage: "validate helmfile state(s) of every environment against the JSON Schema of helmfile.yaml",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "print-schema",
+					Usage: "Print the JSON Schema of helmfile.yaml instead of validating. Useful for completion and validation in editors",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Validate(c)
+			}),
+		},
+		{
+			Name:  "explain",
+			Usage: "explain where each of the final values of releases came from",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "release",
+					Value: "",
+					Usage: "explain values of the release with the name only. All the selected releases are explained by default",
+				},
+				cli.StringFlag{
+					Name:  "key",
+					Value: "",
+					Usage: "explain the value at the key path like image.tag only. All the values are explained by default",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Explain(c)
+			}),
+		},
+		{
+			Name:  "environments",
+			Usage: "list environments defined in state file, or show the resolved values of the environment",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "values",
+					Usage: "show the values of the environment specified with --environment, merged from the environments it inherits, instead of listing environments",
+				},
+			},
+			Action: action(func(a *app.App, c configImpl) error {
+				return a.Environments(c)
+			}),
+		},
 		{
 			Name:  "list",
 			Usage: "list releases defined in state file",
@@ -660,7 +876,11 @@ func execFaster(extra []string, description string, args ...string) ([]byte, err
 				cli.StringFlag{
 					Name:  "output",
 					Value: "",
-					Usage: "output releases list as a json string",
+					Usage: "output releases list in the format, that is one of json, yaml, csv and table",
+				},
+				cli.BoolFlag{
+					Name:  "with-status",
+					Usage: "join the releases with the deployed ones to show the deployed revision, status and versions, and the orphans that are deployed in the managed namespaces but not declared in any helmfile. Requires helm 3",
 				},
 				cli.BoolFlag{
 					Name:  "keep-temp-dir",
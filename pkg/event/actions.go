@@ -0,0 +1,309 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/huolunl/helmfile/pkg/tmpl"
+)
+
+const (
+	defaultWaitTimeout  = 300
+	defaultWaitInterval = 5
+)
+
+// WaitAction is the built-in hook action that waits for a status condition of a Kubernetes resource
+type WaitAction struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+	// Condition is the type of the status condition to wait for, like `Available` or `Ready`
+	Condition string `yaml:"condition"`
+	// Status is the expected status of the condition. Defaults to "True"
+	Status string `yaml:"status,omitempty"`
+	// Timeout is the time in seconds to wait for the condition. Defaults to 300
+	Timeout int `yaml:"timeout,omitempty"`
+	// Interval is the time in seconds between checks of the condition. Defaults to 5
+	Interval int `yaml:"interval,omitempty"`
+}
+
+// ApplyAction is the built-in hook action that creates or updates the resources in a manifest file
+type ApplyAction struct {
+	// Filename is the path to the manifest file, relative to the directory containing the helmfile
+	Filename  string `yaml:"filename"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// WebhookAction is the built-in hook action that sends an HTTP request
+type WebhookAction struct {
+	URL string `yaml:"url"`
+	// Method defaults to POST
+	Method  string            `yaml:"method,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Payload is the request body, that can be a template expression
+	Payload string `yaml:"payload,omitempty"`
+}
+
+// KubeClient is the interface to the Kubernetes API used by the built-in hook actions
+type KubeClient interface {
+	// Apply creates or updates the resources in the manifest
+	Apply(namespace string, manifest []byte) error
+	// ConditionStatus returns the status of the condition of the resource, or an empty string when the resource or
+	// the condition does not exist yet
+	ConditionStatus(namespace, kind, name, condition string) (string, error)
+}
+
+// defaultHTTPClient sends the requests of webhook hook actions when the bus has no HTTPClient.
+// Unlike http.DefaultClient, it gives up on an unresponsive endpoint even when the hook has no timeout
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// HTTPClient is the interface to send the requests of webhook hook actions. *http.Client implements it
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// action returns the name and the function to run the built-in action of the hook, or an empty name when the hook
+// runs an external command
+func (bus *Bus) action(hook Hook, render tmpl.TextRenderer) (string, func(context.Context) ([]byte, error), error) {
+	var actions []string
+	if hook.Command != "" || hook.Kubectl != nil {
+		actions = append(actions, "command")
+	}
+	if hook.Wait != nil {
+		actions = append(actions, "wait")
+	}
+	if hook.Apply != nil {
+		actions = append(actions, "apply")
+	}
+	if hook.Webhook != nil {
+		actions = append(actions, "webhook")
+	}
+	if hook.Sleep > 0 {
+		actions = append(actions, "sleep")
+	}
+
+	if len(actions) > 1 {
+		return "", nil, fmt.Errorf("only one of command, wait, apply, webhook and sleep can be set, but got %s", strings.Join(actions, ", "))
+	}
+
+	switch {
+	case hook.Wait != nil:
+		a, err := renderWaitAction(*hook.Wait, render)
+		if err != nil {
+			return "", nil, err
+		}
+		return "wait", func(ctx context.Context) ([]byte, error) { return nil, bus.wait(ctx, a) }, nil
+	case hook.Apply != nil:
+		a, err := renderApplyAction(*hook.Apply, render)
+		if err != nil {
+			return "", nil, err
+		}
+		return "apply", func(context.Context) ([]byte, error) { return nil, bus.apply(a) }, nil
+	case hook.Webhook != nil:
+		a, err := renderWebhookAction(*hook.Webhook, render)
+		if err != nil {
+			return "", nil, err
+		}
+		return "webhook", func(ctx context.Context) ([]byte, error) { return bus.webhook(ctx, a) }, nil
+	case hook.Sleep > 0:
+		return "sleep", func(context.Context) ([]byte, error) {
+			bus.sleeper()(time.Duration(hook.Sleep) * time.Second)
+			return nil, nil
+		}, nil
+	}
+
+	return "", nil, nil
+}
+
+func renderWaitAction(a WaitAction, render tmpl.TextRenderer) (WaitAction, error) {
+	for _, f := range []*string{&a.Kind, &a.Name, &a.Namespace, &a.Condition, &a.Status} {
+		r, err := render.RenderTemplateText(*f)
+		if err != nil {
+			return a, err
+		}
+		*f = r
+	}
+
+	if a.Kind == "" || a.Name == "" || a.Condition == "" {
+		return a, fmt.Errorf("wait: kind, name and condition must be set")
+	}
+
+	if a.Status == "" {
+		a.Status = "True"
+	}
+	if a.Timeout <= 0 {
+		a.Timeout = defaultWaitTimeout
+	}
+	if a.Interval <= 0 {
+		a.Interval = defaultWaitInterval
+	}
+
+	return a, nil
+}
+
+func renderApplyAction(a ApplyAction, render tmpl.TextRenderer) (ApplyAction, error) {
+	for _, f := range []*string{&a.Filename, &a.Namespace} {
+		r, err := render.RenderTemplateText(*f)
+		if err != nil {
+			return a, err
+		}
+		*f = r
+	}
+
+	if a.Filename == "" {
+		return a, fmt.Errorf("apply: filename must be set")
+	}
+
+	return a, nil
+}
+
+func renderWebhookAction(a WebhookAction, render tmpl.TextRenderer) (WebhookAction, error) {
+	for _, f := range []*string{&a.URL, &a.Method, &a.Payload} {
+		r, err := render.RenderTemplateText(*f)
+		if err != nil {
+			return a, err
+		}
+		*f = r
+	}
+
+	headers := map[string]string{}
+	for k, v := range a.Headers {
+		r, err := render.RenderTemplateText(v)
+		if err != nil {
+			return a, err
+		}
+		headers[k] = r
+	}
+	a.Headers = headers
+
+	if a.URL == "" {
+		return a, fmt.Errorf("webhook: url must be set")
+	}
+
+	if a.Method == "" {
+		a.Method = http.MethodPost
+	}
+
+	return a, nil
+}
+
+func (bus *Bus) kubeClient() KubeClient {
+	if bus.KubeClient == nil {
+		bus.KubeClient = NewKubeClient(bus.KubeContext)
+	}
+	return bus.KubeClient
+}
+
+func (bus *Bus) sleeper() func(time.Duration) {
+	if bus.sleep == nil {
+		return time.Sleep
+	}
+	return bus.sleep
+}
+
+func (bus *Bus) clock() func() time.Time {
+	if bus.now == nil {
+		return time.Now
+	}
+	return bus.now
+}
+
+func (bus *Bus) namespaceOr(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	if bus.KubeNamespace != "" {
+		return bus.KubeNamespace
+	}
+	return bus.Namespace
+}
+
+func (bus *Bus) wait(ctx context.Context, a WaitAction) error {
+	client := bus.kubeClient()
+	ns := bus.namespaceOr(a.Namespace)
+	interval := time.Duration(a.Interval) * time.Second
+	now := bus.clock()
+	// The deadline includes the time taken by the API calls, not only the time slept between them
+	deadline := now().Add(time.Duration(a.Timeout) * time.Second)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		status, err := client.ConditionStatus(ns, a.Kind, a.Name, a.Condition)
+		if err != nil {
+			return err
+		}
+
+		if strings.EqualFold(status, a.Status) {
+			return nil
+		}
+
+		remaining := deadline.Sub(now())
+		if remaining <= 0 {
+			return fmt.Errorf("timed out after %d seconds waiting for condition %s=%s of %s/%s", a.Timeout, a.Condition, a.Status, a.Kind, a.Name)
+		}
+
+		bus.Logger.Debugf("waiting for condition %s=%s of %s/%s, currently \"%s\"\n", a.Condition, a.Status, a.Kind, a.Name, status)
+
+		if remaining < interval {
+			bus.sleeper()(remaining)
+		} else {
+			bus.sleeper()(interval)
+		}
+	}
+}
+
+func (bus *Bus) apply(a ApplyAction) error {
+	path := a.Filename
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(bus.BasePath, path)
+	}
+
+	manifest, err := bus.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return bus.kubeClient().Apply(bus.namespaceOr(a.Namespace), manifest)
+}
+
+func (bus *Bus) webhook(ctx context.Context, a WebhookAction) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, a.Method, a.URL, strings.NewReader(a.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := bus.HTTPClient
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return body, fmt.Errorf("%s %s returned unexpected status %s", a.Method, a.URL, res.Status)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,346 @@
+package event
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huolunl/helmfile/pkg/environment"
+	"go.uber.org/zap"
+)
+
+type fakeKubeClient struct {
+	statuses []string
+	// latency is the time advanced on the clock by each call to ConditionStatus
+	latency time.Duration
+	clock   *time.Time
+
+	applied   []string
+	namespace string
+	checks    int
+}
+
+func (c *fakeKubeClient) Apply(namespace string, manifest []byte) error {
+	c.namespace = namespace
+	c.applied = append(c.applied, string(manifest))
+	return nil
+}
+
+func (c *fakeKubeClient) ConditionStatus(namespace, kind, name, condition string) (string, error) {
+	c.namespace = namespace
+	c.checks++
+	if c.clock != nil {
+		*c.clock = c.clock.Add(c.latency)
+	}
+	if kind != "deployment" || name != "myrel" || condition != "Available" {
+		return "", fmt.Errorf("unexpected resource: %s/%s %s", kind, name, condition)
+	}
+	if c.checks > len(c.statuses) {
+		return c.statuses[len(c.statuses)-1], nil
+	}
+	return c.statuses[c.checks-1], nil
+}
+
+type fakeHTTPClient struct {
+	status int
+
+	method  string
+	url     string
+	headers http.Header
+	body    string
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.method = req.Method
+	c.url = req.URL.String()
+	c.headers = req.Header
+	c.body = string(body)
+
+	return &http.Response{
+		StatusCode: c.status,
+		Status:     fmt.Sprintf("%d %s", c.status, http.StatusText(c.status)),
+		Body:       ioutil.NopCloser(strings.NewReader("ok")),
+	}, nil
+}
+
+func TestTrigger_BuiltinActions(t *testing.T) {
+	cases := []struct {
+		name           string
+		hook           Hook
+		statuses       []string
+		latency        time.Duration
+		httpStatus     int
+		expectedErr    string
+		expectedSleeps []time.Duration
+		expectedChecks int
+		expectedApply  []string
+		expectedNs     string
+		expectedHTTP   *fakeHTTPClient
+	}{
+		{
+			name: "wait",
+			hook: Hook{Events: []string{"foo"}, Wait: &WaitAction{
+				Kind: "deployment", Name: "{{ .Release }}", Condition: "Available", Interval: 2,
+			}},
+			statuses:       []string{"", "False", "True"},
+			expectedSleeps: []time.Duration{2 * time.Second, 2 * time.Second},
+			expectedChecks: 3,
+			expectedNs:     "relns",
+		},
+		{
+			name: "wait timed out",
+			hook: Hook{Events: []string{"foo"}, Wait: &WaitAction{
+				Kind: "deployment", Name: "myrel", Namespace: "other", Condition: "Available", Interval: 3, Timeout: 5,
+			}},
+			statuses:       []string{"False"},
+			expectedErr:    "hook[wait]: wait failed: timed out after 5 seconds waiting for condition Available=True of deployment/myrel",
+			expectedSleeps: []time.Duration{3 * time.Second, 2 * time.Second},
+			expectedChecks: 3,
+			expectedNs:     "other",
+		},
+		{
+			name: "wait timed out with slow API",
+			hook: Hook{Events: []string{"foo"}, Wait: &WaitAction{
+				Kind: "deployment", Name: "myrel", Condition: "Available", Interval: 1, Timeout: 5,
+			}},
+			statuses:       []string{"False"},
+			latency:        2 * time.Second,
+			expectedErr:    "hook[wait]: wait failed: timed out after 5 seconds waiting for condition Available=True of deployment/myrel",
+			expectedSleeps: []time.Duration{time.Second},
+			expectedChecks: 2,
+			expectedNs:     "relns",
+		},
+		{
+			name:          "apply",
+			hook:          Hook{Events: []string{"foo"}, Apply: &ApplyAction{Filename: "manifests/{{ .Release }}.yaml"}},
+			expectedApply: []string{"content of path/to/manifests/myrel.yaml"},
+			expectedNs:    "relns",
+		},
+		{
+			name: "webhook",
+			hook: Hook{Events: []string{"foo"}, Webhook: &WebhookAction{
+				URL:     "https://example.com/hooks",
+				Headers: map[string]string{"Authorization": "Bearer {{ .Environment.Name }}"},
+				Payload: `{"event": "{{ .Event.Name }}", "release": "{{ .Release }}"}`,
+			}},
+			httpStatus: 200,
+			expectedHTTP: &fakeHTTPClient{
+				method: "POST",
+				url:    "https://example.com/hooks",
+				body:   `{"event": "foo", "release": "myrel"}`,
+			},
+		},
+		{
+			name:        "webhook failed",
+			hook:        Hook{Events: []string{"foo"}, Webhook: &WebhookAction{URL: "https://example.com/hooks", Method: "PUT"}},
+			httpStatus:  500,
+			expectedErr: "hook[webhook]: webhook failed: PUT https://example.com/hooks returned unexpected status 500 Internal Server Error",
+		},
+		{
+			name:           "sleep",
+			hook:           Hook{Events: []string{"foo"}, Sleep: 10},
+			expectedSleeps: []time.Duration{10 * time.Second},
+		},
+		{
+			name:        "multiple actions",
+			hook:        Hook{Name: "multi", Events: []string{"foo"}, Command: "echo", Sleep: 10},
+			expectedErr: "hook[multi]: only one of command, wait, apply, webhook and sleep can be set, but got command, sleep",
+		},
+		{
+			name:        "missing field",
+			hook:        Hook{Events: []string{"foo"}, Wait: &WaitAction{Kind: "deployment"}},
+			expectedErr: "hook[wait]: wait: kind, name and condition must be set",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+			kube := &fakeKubeClient{statuses: c.statuses, latency: c.latency, clock: &clock}
+			httpClient := &fakeHTTPClient{status: c.httpStatus}
+
+			var sleeps []time.Duration
+
+			bus := &Bus{
+				Hooks:         []Hook{c.hook},
+				StateFilePath: "path/to/helmfile.yaml",
+				BasePath:      "path/to",
+				Namespace:     "myns",
+				KubeNamespace: "relns",
+				Env:           environment.Environment{Name: "prod"},
+				Logger:        zap.NewNop().Sugar(),
+				ReadFile: func(path string) ([]byte, error) {
+					return []byte("content of " + path), nil
+				},
+				Runner:     &runner{},
+				KubeClient: kube,
+				HTTPClient: httpClient,
+				sleep: func(d time.Duration) {
+					sleeps = append(sleeps, d)
+					clock = clock.Add(d)
+				},
+				now: func() time.Time {
+					return clock
+				},
+			}
+
+			ok, err := bus.Trigger("foo", nil, map[string]interface{}{"Release": "myrel"})
+
+			if c.expectedErr != "" {
+				if err == nil || err.Error() != c.expectedErr {
+					t.Fatalf("unexpected error: expected=%s, actual=%v", c.expectedErr, err)
+				}
+
+				if !reflect.DeepEqual(sleeps, c.expectedSleeps) {
+					t.Errorf("unexpected sleeps: expected=%v, actual=%v", c.expectedSleeps, sleeps)
+				}
+
+				if kube.checks != c.expectedChecks {
+					t.Errorf("unexpected number of checks: expected=%d, actual=%d", c.expectedChecks, kube.checks)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !ok {
+				t.Errorf("expected the hook to be executed")
+			}
+
+			if !reflect.DeepEqual(sleeps, c.expectedSleeps) {
+				t.Errorf("unexpected sleeps: expected=%v, actual=%v", c.expectedSleeps, sleeps)
+			}
+
+			if kube.checks != c.expectedChecks {
+				t.Errorf("unexpected number of checks: expected=%d, actual=%d", c.expectedChecks, kube.checks)
+			}
+
+			if !reflect.DeepEqual(kube.applied, c.expectedApply) {
+				t.Errorf("unexpected applied manifests: expected=%v, actual=%v", c.expectedApply, kube.applied)
+			}
+
+			if kube.namespace != c.expectedNs {
+				t.Errorf("unexpected namespace: expected=%s, actual=%s", c.expectedNs, kube.namespace)
+			}
+
+			if c.expectedHTTP != nil {
+				if httpClient.method != c.expectedHTTP.method || httpClient.url != c.expectedHTTP.url || httpClient.body != c.expectedHTTP.body {
+					t.Errorf("unexpected request: expected=%s %s %s, actual=%s %s %s",
+						c.expectedHTTP.method, c.expectedHTTP.url, c.expectedHTTP.body,
+						httpClient.method, httpClient.url, httpClient.body)
+				}
+
+				if v := httpClient.headers.Get("Authorization"); v != "Bearer prod" {
+					t.Errorf("unexpected Authorization header: %s", v)
+				}
+
+				if v := httpClient.headers.Get("Content-Type"); v != "application/json" {
+					t.Errorf("unexpected Content-Type header: %s", v)
+				}
+			}
+		})
+	}
+}
+
+// hangingHTTPClient never returns until released, ignoring the context of the request
+type hangingHTTPClient struct {
+	release chan struct{}
+	calls   int32
+}
+
+func (c *hangingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.calls, 1)
+	<-c.release
+	return nil, fmt.Errorf("released")
+}
+
+func TestTrigger_BuiltinActionHangingWithRetries(t *testing.T) {
+	client := &hangingHTTPClient{release: make(chan struct{})}
+	defer close(client.release)
+
+	bus := &Bus{
+		Hooks: []Hook{{
+			Events:  []string{"foo"},
+			Webhook: &WebhookAction{URL: "https://example.com/hooks"},
+			Timeout: 1,
+			Retries: 1,
+		}},
+		StateFilePath: "path/to/helmfile.yaml",
+		BasePath:      "path/to",
+		Env:           environment.Environment{Name: "prod"},
+		Logger:        zap.NewNop().Sugar(),
+		ReadFile:      func(string) ([]byte, error) { return nil, nil },
+		HTTPClient:    client,
+		sleep:         func(time.Duration) {},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := bus.Trigger("foo", nil, map[string]interface{}{"Release": "myrel"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		want := "hook[webhook]: webhook failed: timed out after 1 seconds"
+		if err == nil || err.Error() != want {
+			t.Errorf("unexpected error: expected=%s, actual=%v", want, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the retry is blocked by the hanging attempt")
+	}
+
+	// The retry never runs the action concurrently with the hanging attempt
+	if calls := atomic.LoadInt32(&client.calls); calls != 1 {
+		t.Errorf("unexpected number of requests: expected=1, actual=%d", calls)
+	}
+}
+
+func TestTrigger_WebhookCancelledOnTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	bus := &Bus{
+		Hooks: []Hook{{
+			Events:  []string{"foo"},
+			Webhook: &WebhookAction{URL: server.URL},
+			Timeout: 1,
+		}},
+		StateFilePath: "path/to/helmfile.yaml",
+		BasePath:      "path/to",
+		Env:           environment.Environment{Name: "prod"},
+		Logger:        zap.NewNop().Sugar(),
+		ReadFile:      func(string) ([]byte, error) { return nil, nil },
+		HTTPClient:    server.Client(),
+	}
+
+	_, err := bus.Trigger("foo", nil, map[string]interface{}{"Release": "myrel"})
+	want := "hook[webhook]: webhook failed: timed out after 1 seconds"
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error: expected=%s, actual=%v", want, err)
+	}
+
+	// The request is aborted along with the hook, instead of being left hanging in the background
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not cancelled once the hook timed out")
+	}
+}
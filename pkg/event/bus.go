@@ -1,11 +1,16 @@
 package event
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/huolunl/helmfile/pkg/environment"
 	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/timing"
 	"github.com/huolunl/helmfile/pkg/tmpl"
 	"go.uber.org/zap"
 )
@@ -17,6 +22,29 @@ type Hook struct {
 	Kubectl  map[string]string `yaml:"kubectlApply,omitempty"`
 	Args     []string          `yaml:"args"`
 	ShowLogs bool              `yaml:"showlogs"`
+
+	// Wait, Apply, Webhook and Sleep are the built-in actions run instead of the command, that require no external tool
+	Wait    *WaitAction    `yaml:"wait,omitempty"`
+	Apply   *ApplyAction   `yaml:"apply,omitempty"`
+	Webhook *WebhookAction `yaml:"webhook,omitempty"`
+	// Sleep is the time in seconds to sleep
+	Sleep int `yaml:"sleep,omitempty"`
+
+	// Condition is a template expression like `{{ eq .Environment.Name "prod" }}` that must render to either "true" or
+	// "false". The hook is skipped when it renders to "false".
+	Condition string `yaml:"condition,omitempty"`
+	// Timeout is the time in seconds to wait for the command to finish. No timeout by default
+	Timeout int `yaml:"timeout,omitempty"`
+	// Retries is the number of times the command is retried after it failed
+	Retries int `yaml:"retries,omitempty"`
+	// Backoff is the time in seconds to wait before the first retry, that is doubled on each retry
+	Backoff int `yaml:"backoff,omitempty"`
+	// Env is the environment variables set to the command. Each value can be a template expression
+	Env map[string]string `yaml:"env,omitempty"`
+	// WorkingDir is the directory the command is run in, relative to the directory containing the helmfile
+	WorkingDir string `yaml:"workingDir,omitempty"`
+	// ContinueOnError makes the failure of the command logged as a warning, instead of failing the event
+	ContinueOnError bool `yaml:"continueOnError,omitempty"`
 }
 
 type event struct {
@@ -37,6 +65,23 @@ type Bus struct {
 
 	ReadFile func(string) ([]byte, error)
 	Logger   *zap.SugaredLogger
+
+	// KubeContext is the kubeconfig context used by the built-in hook actions
+	KubeContext string
+	// KubeNamespace is the namespace used by the built-in hook actions when not specified. Defaults to Namespace
+	KubeNamespace string
+	// KubeClient and HTTPClient are used by the built-in hook actions. Defaults to the ones talking to the real
+	// cluster and servers
+	KubeClient KubeClient
+	HTTPClient HTTPClient
+
+	// Timings records the wall time of each hook for `--timings`. Nothing is recorded when nil
+	Timings *timing.Recorder
+
+	// sleep is used to wait before retrying a failed hook. Tests override it to not actually sleep
+	sleep func(time.Duration)
+	// now is used to measure the time elapsed while waiting for a condition. Tests override it along with sleep
+	now func() time.Time
 }
 
 func (bus *Bus) Trigger(evt string, evtErr error, context map[string]interface{}) (bool, error) {
@@ -64,6 +109,8 @@ func (bus *Bus) Trigger(evt string, evtErr error, context map[string]interface{}
 		if name == "" {
 			if hook.Kubectl != nil {
 				name = "kubectlApply"
+			} else if hook.Command == "" {
+				name = builtinActionName(hook)
 			} else {
 				name = hook.Command
 			}
@@ -101,8 +148,44 @@ func (bus *Bus) Trigger(evt string, evtErr error, context map[string]interface{}
 		}
 		render := tmpl.NewTextRenderer(bus.ReadFile, bus.BasePath, data)
 
+		if hook.Condition != "" {
+			cond, err := render.RenderTemplateText(hook.Condition)
+			if err != nil {
+				return false, fmt.Errorf("hook[%s]: condition: %v", name, err)
+			}
+
+			enabled, err := strconv.ParseBool(strings.TrimSpace(cond))
+			if err != nil {
+				return false, fmt.Errorf("hook[%s]: condition must be rendered to either \"true\" or \"false\", but got \"%s\"", name, cond)
+			}
+
+			if !enabled {
+				bus.Logger.Debugf("hook[%s]: skipped as the condition is false\n", name)
+				continue
+			}
+		}
+
 		bus.Logger.Debugf("hook[%s]: triggered by event \"%s\"\n", name, evt)
 
+		action, runAction, err := bus.action(hook, render)
+		if err != nil {
+			return false, fmt.Errorf("hook[%s]: %v", name, err)
+		}
+
+		if action != "" {
+			if err := bus.execute(name, evt, hook, action, withContext(runAction)); err != nil {
+				if hook.ContinueOnError {
+					bus.Logger.Warnf("hook[%s]: continuing on error: %v\n", name, err)
+					continue
+				}
+
+				return false, err
+			}
+
+			executed = true
+			continue
+		}
+
 		command, err := render.RenderTemplateText(hook.Command)
 		if err != nil {
 			return false, fmt.Errorf("hook[%s]: %v", name, err)
@@ -116,19 +199,179 @@ func (bus *Bus) Trigger(evt string, evtErr error, context map[string]interface{}
 			}
 		}
 
-		bytes, err := bus.Runner.Execute(command, args, map[string]string{})
+		env := map[string]string{}
+		for k, raw := range hook.Env {
+			env[k], err = render.RenderTemplateText(raw)
+			if err != nil {
+				return false, fmt.Errorf("hook[%s]: env %s: %v", name, k, err)
+			}
+		}
+
+		runner, err := bus.runnerFor(hook)
+		if err != nil {
+			return false, fmt.Errorf("hook[%s]: %v", name, err)
+		}
+
+		// Only the command that must be killed on the timeout or run in the working directory is run as a process.
+		// Otherwise it's run by the runner as before, that runs helm in-process in the case of ShellRunner.
+		asProcess := hook.Timeout > 0 || hook.WorkingDir != ""
+
+		if err := bus.execute(name, evt, hook, fmt.Sprintf("command `%s`", command), runCommand(runner, command, args, env, asProcess)); err != nil {
+			if hook.ContinueOnError {
+				bus.Logger.Warnf("hook[%s]: continuing on error: %v\n", name, err)
+				continue
+			}
+
+			return false, err
+		}
+
+		executed = true
+	}
+
+	return executed, nil
+}
+
+// runnerFor returns the runner that runs the command of the hook in its working directory.
+// It fails when the runner can't run the command in the working directory or kill it on the timeout.
+func (bus *Bus) runnerFor(hook Hook) (helmexec.Runner, error) {
+	runner := bus.Runner
+
+	if _, ok := runner.(helmexec.ContextRunner); hook.Timeout > 0 && !ok {
+		return nil, fmt.Errorf("timeout is not supported by runner %T", runner)
+	}
+
+	if hook.WorkingDir != "" {
+		dir := hook.WorkingDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(bus.BasePath, dir)
+		}
+
+		switch r := runner.(type) {
+		case helmexec.ShellRunner:
+			r.Dir = dir
+			runner = r
+		case *helmexec.ShellRunner:
+			copied := *r
+			copied.Dir = dir
+			runner = &copied
+		default:
+			return nil, fmt.Errorf("workingDir is not supported by runner %T", runner)
+		}
+	}
+
+	return runner, nil
+}
+
+// runCommand returns the function running the command with the runner.
+// When asProcess is true, the command is run by ExecuteContext of the runner, that kills the command once the context is done.
+func runCommand(runner helmexec.Runner, command string, args []string, env map[string]string, asProcess bool) func(context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		if r, ok := runner.(helmexec.ContextRunner); ok && asProcess {
+			return r.ExecuteContext(ctx, command, args, env)
+		}
+		return runner.Execute(command, args, env)
+	}
+}
+
+// execute runs the command or the built-in action of the hook described by desc, retrying it with the exponential
+// backoff on failure
+func (bus *Bus) execute(name, evt string, hook Hook, desc string, run func(context.Context) ([]byte, error)) error {
+	defer bus.Timings.Start(timing.CategoryHook, fmt.Sprintf("%s: %s", evt, name))()
+
+	sleep := bus.sleeper()
+
+	backoff := time.Duration(hook.Backoff) * time.Second
+
+	for attempt := 0; ; attempt++ {
+		bytes, err := executeWithTimeout(run, hook.Timeout)
 		bus.Logger.Debugf("hook[%s]: %s\n", name, string(bytes))
 		if hook.ShowLogs {
 			prefix := fmt.Sprintf("\nhook[%s] logs | ", evt)
 			bus.Logger.Infow(prefix + strings.ReplaceAll(string(bytes), "\n", prefix))
 		}
 
-		if err != nil {
-			return false, fmt.Errorf("hook[%s]: command `%s` failed: %v", name, command, err)
+		if err == nil {
+			return nil
 		}
 
-		executed = true
+		err = fmt.Errorf("hook[%s]: %s failed: %v", name, desc, err)
+
+		if attempt >= hook.Retries {
+			return err
+		}
+
+		bus.Logger.Infof("%v: retrying in %v (%d/%d)\n", err, backoff, attempt+1, hook.Retries)
+
+		sleep(backoff)
+		backoff *= 2
 	}
+}
 
-	return executed, nil
+// executeWithTimeout runs the command, that is killed once the timeout in seconds elapses
+func executeWithTimeout(run func(context.Context) ([]byte, error), timeout int) ([]byte, error) {
+	if timeout <= 0 {
+		return run(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	bytes, err := run(ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		return bytes, fmt.Errorf("timed out after %d seconds", timeout)
+	}
+
+	return bytes, err
+}
+
+// withContext makes the built-in action return once the context is done, even when the action doesn't watch the context.
+// The next run waits for the previous one to finish, so that retries never run the action concurrently.
+// The wait is bounded by the context too, so that the action hanging past the timeout never blocks the retries forever.
+func withContext(run func(context.Context) ([]byte, error)) func(context.Context) ([]byte, error) {
+	var running chan struct{}
+
+	return func(ctx context.Context) ([]byte, error) {
+		if running != nil {
+			select {
+			case <-running:
+			case <-ctx.Done():
+				return nil, fmt.Errorf("the previous attempt is still running: %v", ctx.Err())
+			}
+		}
+
+		done := make(chan struct{})
+		running = done
+
+		var (
+			bytes []byte
+			err   error
+		)
+
+		go func() {
+			defer close(done)
+			bytes, err = run(ctx)
+		}()
+
+		select {
+		case <-done:
+			return bytes, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// builtinActionName returns the name of the built-in action of the hook, used as the default name of the hook
+func builtinActionName(hook Hook) string {
+	switch {
+	case hook.Wait != nil:
+		return "wait"
+	case hook.Apply != nil:
+		return "apply"
+	case hook.Webhook != nil:
+		return "webhook"
+	case hook.Sleep > 0:
+		return "sleep"
+	}
+	return ""
 }
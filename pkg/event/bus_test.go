@@ -1,9 +1,13 @@
 package event
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/huolunl/helmfile/pkg/environment"
 	"go.uber.org/zap"
@@ -39,21 +43,21 @@ func TestTrigger(t *testing.T) {
 	}{
 		{
 			"okhook1",
-			&Hook{"okhook1", []string{"foo"}, "ok", nil, []string{}, true},
+			&Hook{Name: "okhook1", Events: []string{"foo"}, Command: "ok", Kubectl: nil, Args: []string{}, ShowLogs: true},
 			"foo",
 			true,
 			"",
 		},
 		{
 			"okhooké",
-			&Hook{"okhook2", []string{"foo"}, "ok", nil, []string{}, false},
+			&Hook{Name: "okhook2", Events: []string{"foo"}, Command: "ok", Kubectl: nil, Args: []string{}, ShowLogs: false},
 			"foo",
 			true,
 			"",
 		},
 		{
 			"missinghook1",
-			&Hook{"okhook1", []string{"foo"}, "ok", nil, []string{}, false},
+			&Hook{Name: "okhook1", Events: []string{"foo"}, Command: "ok", Kubectl: nil, Args: []string{}, ShowLogs: false},
 			"bar",
 			false,
 			"",
@@ -67,70 +71,70 @@ func TestTrigger(t *testing.T) {
 		},
 		{
 			"nghook1",
-			&Hook{"nghook1", []string{"foo"}, "ng", nil, []string{}, false},
+			&Hook{Name: "nghook1", Events: []string{"foo"}, Command: "ng", Kubectl: nil, Args: []string{}, ShowLogs: false},
 			"foo",
 			false,
 			"hook[nghook1]: command `ng` failed: cmd failed due to invalid cmd: ng",
 		},
 		{
 			"nghook2",
-			&Hook{"nghook2", []string{"foo"}, "ok", nil, []string{"ng"}, false},
+			&Hook{Name: "nghook2", Events: []string{"foo"}, Command: "ok", Kubectl: nil, Args: []string{"ng"}, ShowLogs: false},
 			"foo",
 			false,
 			"hook[nghook2]: command `ok` failed: cmd failed due to invalid arg: ng",
 		},
 		{
 			"okkubeapply1",
-			&Hook{"okkubeapply1", []string{"foo"}, "", map[string]string{"kustomize": "kustodir"}, []string{}, false},
+			&Hook{Name: "okkubeapply1", Events: []string{"foo"}, Command: "", Kubectl: map[string]string{"kustomize": "kustodir"}, Args: []string{}, ShowLogs: false},
 			"foo",
 			true,
 			"",
 		},
 		{
 			"okkubeapply2",
-			&Hook{"okkubeapply2", []string{"foo"}, "", map[string]string{"filename": "resource.yaml"}, []string{}, false},
+			&Hook{Name: "okkubeapply2", Events: []string{"foo"}, Command: "", Kubectl: map[string]string{"filename": "resource.yaml"}, Args: []string{}, ShowLogs: false},
 			"foo",
 			true,
 			"",
 		},
 		{
 			"kokubeapply",
-			&Hook{"kokubeapply", []string{"foo"}, "", map[string]string{"kustomize": "kustodir", "filename": "resource.yaml"}, []string{}, true},
+			&Hook{Name: "kokubeapply", Events: []string{"foo"}, Command: "", Kubectl: map[string]string{"kustomize": "kustodir", "filename": "resource.yaml"}, Args: []string{}, ShowLogs: true},
 			"foo",
 			false,
 			"hook[kokubeapply]: kustomize & filename cannot be used together",
 		},
 		{
 			"kokubeapply2",
-			&Hook{"kokubeapply2", []string{"foo"}, "", map[string]string{}, []string{}, true},
+			&Hook{Name: "kokubeapply2", Events: []string{"foo"}, Command: "", Kubectl: map[string]string{}, Args: []string{}, ShowLogs: true},
 			"foo",
 			false,
 			"hook[kokubeapply2]: either kustomize or filename must be given",
 		},
 		{
 			"kokubeapply3",
-			&Hook{"", []string{"foo"}, "", map[string]string{}, []string{}, true},
+			&Hook{Name: "", Events: []string{"foo"}, Command: "", Kubectl: map[string]string{}, Args: []string{}, ShowLogs: true},
 			"foo",
 			false,
 			"hook[kubectlApply]: either kustomize or filename must be given",
 		},
 		{
 			"warnkubeapply1",
-			&Hook{"warnkubeapply1", []string{"foo"}, "ok", map[string]string{"filename": "resource.yaml"}, []string{}, true},
+			&Hook{Name: "warnkubeapply1", Events: []string{"foo"}, Command: "ok", Kubectl: map[string]string{"filename": "resource.yaml"}, Args: []string{}, ShowLogs: true},
 			"foo",
 			true,
 			"",
 		},
 		{
 			"warnkubeapply2",
-			&Hook{"warnkubeapply2", []string{"foo"}, "", map[string]string{"filename": "resource.yaml"}, []string{"ng"}, true},
+			&Hook{Name: "warnkubeapply2", Events: []string{"foo"}, Command: "", Kubectl: map[string]string{"filename": "resource.yaml"}, Args: []string{"ng"}, ShowLogs: true},
 			"foo",
 			true,
 			"",
 		},
 		{
 			"warnkubeapply3",
-			&Hook{"warnkubeapply3", []string{"foo"}, "ok", map[string]string{"filename": "resource.yaml"}, []string{"ng"}, true},
+			&Hook{Name: "warnkubeapply3", Events: []string{"foo"}, Command: "ok", Kubectl: map[string]string{"filename": "resource.yaml"}, Args: []string{"ng"}, ShowLogs: true},
 			"foo",
 			true,
 			"",
@@ -183,3 +187,278 @@ func TestTrigger(t *testing.T) {
 		}
 	}
 }
+
+type recordingRunner struct {
+	failures int
+	block    chan struct{}
+
+	mu        sync.Mutex
+	calls     int
+	env       map[string]string
+	cancelled bool
+	// contextCalls is the number of the calls to ExecuteContext
+	contextCalls int
+}
+
+func (r *recordingRunner) ExecuteContext(ctx context.Context, cmd string, args []string, env map[string]string) ([]byte, error) {
+	r.mu.Lock()
+	r.calls++
+	r.contextCalls++
+	r.env = env
+	calls := r.calls
+	r.mu.Unlock()
+
+	if r.block != nil {
+		select {
+		case <-r.block:
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.cancelled = true
+			r.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	if calls <= r.failures {
+		return nil, fmt.Errorf("failure %d", calls)
+	}
+
+	return []byte(""), nil
+}
+
+func (r *recordingRunner) ExecuteStdIn(cmd string, args []string, env map[string]string, stdin io.Reader) ([]byte, error) {
+	return r.Execute(cmd, args, env)
+}
+
+func (r *recordingRunner) Execute(cmd string, args []string, env map[string]string) ([]byte, error) {
+	r.mu.Lock()
+	r.calls++
+	r.env = env
+	calls := r.calls
+	r.mu.Unlock()
+
+	if r.block != nil {
+		<-r.block
+	}
+
+	if calls <= r.failures {
+		return nil, fmt.Errorf("failure %d", calls)
+	}
+
+	return []byte(""), nil
+}
+
+func TestTrigger_HookOptions(t *testing.T) {
+	cases := []struct {
+		name           string
+		hook           Hook
+		failures       int
+		block          bool
+		expectedResult bool
+		expectedErr    string
+		expectedCalls  int
+		expectedSleeps []time.Duration
+		expectedEnv    map[string]string
+		expectedCancel bool
+	}{
+		{
+			name:           "condition true",
+			hook:           Hook{Name: "cond", Events: []string{"foo"}, Command: "ok", Condition: `{{ eq .Environment.Name "prod" }}`},
+			expectedResult: true,
+			expectedCalls:  1,
+		},
+		{
+			name:           "condition false",
+			hook:           Hook{Name: "cond", Events: []string{"foo"}, Command: "ok", Condition: `{{ eq .Environment.Name "dev" }}`},
+			expectedResult: false,
+			expectedCalls:  0,
+		},
+		{
+			name:        "invalid condition",
+			hook:        Hook{Name: "cond", Events: []string{"foo"}, Command: "ok", Condition: `{{ .Environment.Name }}`},
+			expectedErr: `hook[cond]: condition must be rendered to either "true" or "false", but got "prod"`,
+		},
+		{
+			name:           "retries",
+			hook:           Hook{Name: "retry", Events: []string{"foo"}, Command: "ok", Retries: 3, Backoff: 2},
+			failures:       2,
+			expectedResult: true,
+			expectedCalls:  3,
+			expectedSleeps: []time.Duration{2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:           "retries exhausted",
+			hook:           Hook{Name: "retry", Events: []string{"foo"}, Command: "ok", Retries: 1, Backoff: 1},
+			failures:       2,
+			expectedErr:    "hook[retry]: command `ok` failed: failure 2",
+			expectedCalls:  2,
+			expectedSleeps: []time.Duration{time.Second},
+		},
+		{
+			name:           "continue on error",
+			hook:           Hook{Name: "cont", Events: []string{"foo"}, Command: "ok", ContinueOnError: true},
+			failures:       1,
+			expectedResult: false,
+			expectedCalls:  1,
+		},
+		{
+			name:           "env",
+			hook:           Hook{Name: "env", Events: []string{"foo"}, Command: "ok", Env: map[string]string{"RELEASE": "{{ .Release }}", "FOO": "bar"}},
+			expectedResult: true,
+			expectedCalls:  1,
+			expectedEnv:    map[string]string{"RELEASE": "myrel", "FOO": "bar"},
+		},
+		{
+			name:           "timeout",
+			hook:           Hook{Name: "slow", Events: []string{"foo"}, Command: "sleep", Timeout: 1},
+			block:          true,
+			expectedErr:    "hook[slow]: command `sleep` failed: timed out after 1 seconds",
+			expectedCalls:  1,
+			expectedCancel: true,
+		},
+		{
+			name:           "timeout with retries",
+			hook:           Hook{Name: "slow", Events: []string{"foo"}, Command: "sleep", Timeout: 1, Retries: 1},
+			block:          true,
+			expectedErr:    "hook[slow]: command `sleep` failed: timed out after 1 seconds",
+			expectedCalls:  2,
+			expectedSleeps: []time.Duration{0},
+			expectedCancel: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &recordingRunner{failures: c.failures}
+			if c.block {
+				r.block = make(chan struct{})
+				defer close(r.block)
+			}
+
+			var sleeps []time.Duration
+
+			bus := &Bus{
+				Hooks:         []Hook{c.hook},
+				StateFilePath: "path/to/helmfile.yaml",
+				BasePath:      "path/to",
+				Namespace:     "myns",
+				Env:           environment.Environment{Name: "prod"},
+				Logger:        zap.NewNop().Sugar(),
+				ReadFile:      func(string) ([]byte, error) { return nil, nil },
+				Runner:        r,
+				sleep: func(d time.Duration) {
+					sleeps = append(sleeps, d)
+				},
+			}
+
+			ok, err := bus.Trigger("foo", nil, map[string]interface{}{"Release": "myrel"})
+
+			if c.expectedErr != "" {
+				if err == nil || err.Error() != c.expectedErr {
+					t.Fatalf("unexpected error: expected=%s, actual=%v", c.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ok != c.expectedResult {
+				t.Errorf("unexpected result: expected=%v, actual=%v", c.expectedResult, ok)
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if r.calls != c.expectedCalls {
+				t.Errorf("unexpected number of calls: expected=%d, actual=%d", c.expectedCalls, r.calls)
+			}
+
+			if !reflect.DeepEqual(sleeps, c.expectedSleeps) {
+				t.Errorf("unexpected sleeps: expected=%v, actual=%v", c.expectedSleeps, sleeps)
+			}
+
+			if c.expectedEnv != nil && !reflect.DeepEqual(r.env, c.expectedEnv) {
+				t.Errorf("unexpected env: expected=%v, actual=%v", c.expectedEnv, r.env)
+			}
+
+			if r.cancelled != c.expectedCancel {
+				t.Errorf("unexpected cancellation of the command: expected=%v, actual=%v", c.expectedCancel, r.cancelled)
+			}
+		})
+	}
+}
+
+func TestTrigger_RunsCommandAsProcessOnlyWhenRequired(t *testing.T) {
+	cases := []struct {
+		name                 string
+		hook                 Hook
+		expectedContextCalls int
+	}{
+		{
+			name: "no timeout",
+			hook: Hook{Name: "helm", Events: []string{"foo"}, Command: "helm", Args: []string{"version"}},
+		},
+		{
+			name:                 "timeout",
+			hook:                 Hook{Name: "helm", Events: []string{"foo"}, Command: "helm", Args: []string{"version"}, Timeout: 10},
+			expectedContextCalls: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &recordingRunner{}
+
+			bus := &Bus{
+				Hooks:    []Hook{c.hook},
+				BasePath: "path/to",
+				Logger:   zap.NewNop().Sugar(),
+				ReadFile: func(string) ([]byte, error) { return nil, nil },
+				Runner:   r,
+			}
+
+			if _, err := bus.Trigger("foo", nil, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if r.calls != 1 || r.contextCalls != c.expectedContextCalls {
+				t.Errorf("unexpected calls: expected=1 with %d to ExecuteContext, actual=%d with %d to ExecuteContext", c.expectedContextCalls, r.calls, r.contextCalls)
+			}
+		})
+	}
+}
+
+func TestTrigger_UnsupportedHookOptions(t *testing.T) {
+	cases := []struct {
+		name        string
+		hook        Hook
+		expectedErr string
+	}{
+		{
+			name:        "timeout",
+			hook:        Hook{Name: "slow", Events: []string{"foo"}, Command: "sleep", Timeout: 1},
+			expectedErr: "hook[slow]: timeout is not supported by runner *event.runner",
+		},
+		{
+			name:        "workingDir",
+			hook:        Hook{Name: "dir", Events: []string{"foo"}, Command: "ls", WorkingDir: "scripts"},
+			expectedErr: "hook[dir]: workingDir is not supported by runner *event.runner",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bus := &Bus{
+				Hooks:    []Hook{c.hook},
+				BasePath: "path/to",
+				Logger:   zap.NewNop().Sugar(),
+				ReadFile: func(string) ([]byte, error) { return nil, nil },
+				Runner:   &runner{},
+			}
+
+			_, err := bus.Trigger("foo", nil, nil)
+			if err == nil || err.Error() != c.expectedErr {
+				t.Errorf("unexpected error: expected=%s, actual=%v", c.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package event
+
+import (
+	"bytes"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// fieldManager is the name of the field manager of the resources applied by the apply hook action
+const fieldManager = "helmfile"
+
+// kubeClient is the KubeClient that talks to the cluster of the kubeconfig context
+type kubeClient struct {
+	getter genericclioptions.RESTClientGetter
+}
+
+// NewKubeClient returns the KubeClient for the kubeconfig context. The current context is used when it is empty
+func NewKubeClient(kubeContext string) KubeClient {
+	flags := genericclioptions.NewConfigFlags(true)
+	if kubeContext != "" {
+		flags.Context = &kubeContext
+	}
+
+	return &kubeClient{getter: flags}
+}
+
+func (c *kubeClient) builder(namespace string) (*resource.Builder, error) {
+	if namespace == "" {
+		ns, _, err := c.getter.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return nil, err
+		}
+		namespace = ns
+	}
+
+	return resource.NewBuilder(c.getter).
+		Unstructured().
+		NamespaceParam(namespace).
+		DefaultNamespace(), nil
+}
+
+// Apply creates or updates the resources in the manifest with the server-side apply
+func (c *kubeClient) Apply(namespace string, manifest []byte) error {
+	b, err := c.builder(namespace)
+	if err != nil {
+		return err
+	}
+
+	infos, err := b.Stream(bytes.NewReader(manifest), "manifest").Flatten().Do().Infos()
+	if err != nil {
+		return err
+	}
+
+	force := true
+
+	for _, info := range infos {
+		data, err := runtime.Encode(unstructured.UnstructuredJSONScheme, info.Object)
+		if err != nil {
+			return err
+		}
+
+		_, err = resource.NewHelper(info.Client, info.Mapping).
+			Patch(info.Namespace, info.Name, types.ApplyPatchType, data, &metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+		if err != nil {
+			return fmt.Errorf("applying %s: %v", info.ObjectName(), err)
+		}
+	}
+
+	return nil
+}
+
+func (c *kubeClient) ConditionStatus(namespace, kind, name, condition string) (string, error) {
+	b, err := c.builder(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	infos, err := b.ResourceTypeOrNameArgs(true, kind, name).SingleResourceType().Flatten().Do().Infos()
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	for _, info := range infos {
+		u, ok := info.Object.(*unstructured.Unstructured)
+		if !ok {
+			return "", fmt.Errorf("unexpected type of %s: %T", info.ObjectName(), info.Object)
+		}
+
+		conditions, _, err := unstructured.NestedSlice(u.Object, "status", "conditions")
+		if err != nil {
+			return "", err
+		}
+
+		for _, c := range conditions {
+			m, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			if t, _ := m["type"].(string); t == condition {
+				s, _ := m["status"].(string)
+				return s, nil
+			}
+		}
+	}
+
+	return "", nil
+}
@@ -1,8 +1,10 @@
 package exectest
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -35,6 +37,27 @@ type Helm struct {
 
 	UpdateDepsCallbacks map[string]func(string) error
 
+	// Statuses is the output of `helm status --output json` keyed by the release name.
+	// The release missing in the map is not installed.
+	Statuses map[string]string
+
+	// Deployed is the output of `helm list --output json` keyed by the namespace.
+	// The outputs of all the namespaces are concatenated for `--all-namespaces`.
+	Deployed map[string]string
+
+	// Manifests is the output of `helm template` keyed by the release name,
+	// or by the release name and the chart separated by a space to render the release differently per chart.
+	Manifests map[string]string
+
+	// Values is the output of `helm get values --output yaml` keyed by the release name.
+	Values map[string]string
+
+	// SearchResults is the output of `helm search repo --output json` keyed by the keyword.
+	SearchResults map[string]string
+
+	// Verified is the list of the chart archives verified by `helm verify`
+	Verified []string
+
 	DiffMutex     *sync.Mutex
 	ChartsMutex   *sync.Mutex
 	ReleasesMutex *sync.Mutex
@@ -127,6 +150,69 @@ func (helm *Helm) ReleaseStatus(context helmexec.HelmContext, release string, fl
 	helm.Releases = append(helm.Releases, Release{Name: release, Flags: flags})
 	return nil
 }
+func (helm *Helm) GetReleaseStatus(context helmexec.HelmContext, release string, flags ...string) ([]byte, error) {
+	if strings.Contains(release, "error") {
+		return nil, errors.New("error")
+	}
+	status, ok := helm.Statuses[release]
+	if !ok {
+		return nil, errors.New("Error: release: not found")
+	}
+	return []byte(status), nil
+}
+func (helm *Helm) GetReleases(context helmexec.HelmContext, flags ...string) ([]byte, error) {
+	var namespace string
+	for i := range flags {
+		if flags[i] == "--namespace" && i+1 < len(flags) {
+			namespace = flags[i+1]
+		}
+		if flags[i] == "--all-namespaces" {
+			return helm.allDeployed()
+		}
+	}
+	if strings.Contains(namespace, "error") {
+		return nil, errors.New("error")
+	}
+	deployed, ok := helm.Deployed[namespace]
+	if !ok {
+		return []byte("[]"), nil
+	}
+	return []byte(deployed), nil
+}
+func (helm *Helm) allDeployed() ([]byte, error) {
+	var namespaces []string
+	for namespace := range helm.Deployed {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	all := []interface{}{}
+	for _, namespace := range namespaces {
+		var items []interface{}
+		if err := json.Unmarshal([]byte(helm.Deployed[namespace]), &items); err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+	}
+	return json.Marshal(all)
+}
+func (helm *Helm) GetValues(context helmexec.HelmContext, name string, flags ...string) ([]byte, error) {
+	if strings.Contains(name, "error") {
+		return nil, errors.New("error")
+	}
+	values, ok := helm.Values[name]
+	if !ok {
+		return []byte("null"), nil
+	}
+	return []byte(values), nil
+}
+func (helm *Helm) SearchRepo(keyword string, flags ...string) ([]byte, error) {
+	result, ok := helm.SearchResults[keyword]
+	if !ok {
+		return []byte("[]"), nil
+	}
+	return []byte(result), nil
+}
 func (helm *Helm) DeleteRelease(context helmexec.HelmContext, name string, flags ...string) error {
 	if strings.Contains(name, "error") {
 		return errors.New("error")
@@ -161,12 +247,25 @@ func (helm *Helm) Lint(name, chart string, flags ...string) error {
 func (helm *Helm) TemplateRelease(name, chart string, flags ...string) error {
 	return nil
 }
+func (helm *Helm) GetManifests(name, chart string, flags ...string) ([]byte, error) {
+	if strings.Contains(name, "error") {
+		return nil, errors.New("error")
+	}
+	if manifests, ok := helm.Manifests[name+" "+chart]; ok {
+		return []byte(manifests), nil
+	}
+	return []byte(helm.Manifests[name]), nil
+}
 func (helm *Helm) ChartPull(chart string, flags ...string) error {
 	return nil
 }
 func (helm *Helm) ChartExport(chart string, path string, flags ...string) error {
 	return nil
 }
+func (helm *Helm) VerifyChart(path string, flags ...string) error {
+	helm.Verified = append(helm.Verified, path)
+	return nil
+}
 func (helm *Helm) IsHelm3() bool {
 	return helm.Helm3
 }
@@ -229,6 +229,33 @@ func (helm *execer) ReleaseStatus(context HelmContext, name string, flags ...str
 	return err
 }
 
+// GetReleaseStatus returns the output of `helm status --output json`, without writing it to the output like ReleaseStatus does
+func (helm *execer) GetReleaseStatus(context HelmContext, name string, flags ...string) ([]byte, error) {
+	helm.logger.Debugf("Getting status %v", name)
+	preArgs := context.GetTillerlessArgs(helm)
+	env := context.getTillerlessEnv()
+	return helm.exec(append(append(preArgs, "status", name, "--output", "json"), flags...), env)
+}
+
+func (helm *execer) GetReleases(context HelmContext, flags ...string) ([]byte, error) {
+	helm.logger.Debugf("Getting releases")
+	preArgs := context.GetTillerlessArgs(helm)
+	env := context.getTillerlessEnv()
+	return helm.exec(append(append(preArgs, "list", "--output", "json"), flags...), env)
+}
+
+func (helm *execer) GetValues(context HelmContext, name string, flags ...string) ([]byte, error) {
+	helm.logger.Debugf("Getting values of release=%v", name)
+	preArgs := context.GetTillerlessArgs(helm)
+	env := context.getTillerlessEnv()
+	return helm.exec(append(append(preArgs, "get", "values", name, "--output", "yaml"), flags...), env)
+}
+
+func (helm *execer) SearchRepo(keyword string, flags ...string) ([]byte, error) {
+	helm.logger.Debugf("Searching repositories for %v", keyword)
+	return helm.exec(append([]string{"search", "repo", keyword, "--output", "json"}, flags...), map[string]string{})
+}
+
 func (helm *execer) List(context HelmContext, filter string, flags ...string) (string, error) {
 	//return "", nil
 	helm.logger.Infof("Listing releases matching %v", filter)
@@ -394,6 +421,18 @@ func (helm *execer) TemplateRelease(name string, chart string, flags ...string)
 	return err
 }
 
+func (helm *execer) GetManifests(name string, chart string, flags ...string) ([]byte, error) {
+	helm.logger.Infof("Rendering manifests of release=%v, chart=%v", name, chart)
+	var args []string
+	if helm.IsHelm3() {
+		args = []string{"template", name, chart}
+	} else {
+		args = []string{"template", chart, "--name", name}
+	}
+
+	return helm.exec(append(args, flags...), map[string]string{})
+}
+
 func (helm *execer) DiffRelease(context HelmContext, name, chart string, suppressDiff bool, flags ...string) error {
 	if context.Writer != nil {
 		fmt.Fprintf(context.Writer, "Comparing release=%v, chart=%v\n", name, chart)
@@ -478,6 +517,13 @@ func (helm *execer) ChartExport(chart string, path string, flags ...string) erro
 	return err
 }
 
+func (helm *execer) VerifyChart(path string, flags ...string) error {
+	helm.logger.Infof("Verifying %v", path)
+	out, err := helm.exec(append([]string{"verify", path}, flags...), map[string]string{})
+	helm.info(out)
+	return err
+}
+
 func (helm *execer) DeleteRelease(context HelmContext, name string, flags ...string) error {
 	helm.logger.Infof("Deleting %v", name)
 	preArgs := context.GetTillerlessArgs(helm)
@@ -31,7 +31,7 @@ func (mock *mockRunner) Execute(cmd string, args []string, env map[string]string
 }
 
 func MockExecer(logger *zap.SugaredLogger, kubeContext string) *execer {
-	execer := New("helm", logger, kubeContext, &mockRunner{}, &bytes.Buffer{})
+	execer := New("helm", logger, kubeContext, &mockRunner{}, &bytes.Buffer{}, "")
 	return execer
 }
 
@@ -522,6 +522,86 @@ exec: helm --kube-context dev status myRelease
 	}
 }
 
+func Test_GetReleaseStatus(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "debug")
+	helm := MockExecer(logger, "dev")
+	_, err := helm.GetReleaseStatus(HelmContext{}, "myRelease", "--namespace", "myNamespace")
+	expected := `Getting status myRelease
+exec: helm --kube-context dev status myRelease --output json --namespace myNamespace
+`
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if buffer.String() != expected {
+		t.Errorf("helmexec.GetReleaseStatus()\nactual = %v\nexpect = %v", buffer.String(), expected)
+	}
+}
+
+func Test_GetManifests(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "debug")
+	helm := MockExecer(logger, "dev")
+	_, err := helm.GetManifests("myRelease", "myChart", "--namespace", "myNamespace")
+	expected := `Rendering manifests of release=myRelease, chart=myChart
+exec: helm --kube-context dev template myRelease myChart --namespace myNamespace
+`
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if buffer.String() != expected {
+		t.Errorf("helmexec.GetManifests()\nactual = %v\nexpect = %v", buffer.String(), expected)
+	}
+}
+
+func Test_GetReleases(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "debug")
+	helm := MockExecer(logger, "dev")
+	_, err := helm.GetReleases(HelmContext{}, "--all", "--namespace", "myNamespace")
+	expected := `Getting releases
+exec: helm --kube-context dev list --output json --all --namespace myNamespace
+`
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if buffer.String() != expected {
+		t.Errorf("helmexec.GetReleases()\nactual = %v\nexpect = %v", buffer.String(), expected)
+	}
+}
+
+func Test_GetValues(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "debug")
+	helm := MockExecer(logger, "dev")
+	_, err := helm.GetValues(HelmContext{}, "myRelease", "--namespace", "myNamespace")
+	expected := `Getting values of release=myRelease
+exec: helm --kube-context dev get values myRelease --output yaml --namespace myNamespace
+`
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if buffer.String() != expected {
+		t.Errorf("helmexec.GetValues()\nactual = %v\nexpect = %v", buffer.String(), expected)
+	}
+}
+
+func Test_SearchRepo(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "debug")
+	helm := MockExecer(logger, "dev")
+	_, err := helm.SearchRepo("stable/foo", "--version", "1.2.3")
+	expected := `Searching repositories for stable/foo
+exec: helm --kube-context dev search repo stable/foo --output json --version 1.2.3
+`
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if buffer.String() != expected {
+		t.Errorf("helmexec.SearchRepo()\nactual = %v\nexpect = %v", buffer.String(), expected)
+	}
+}
+
 func Test_exec(t *testing.T) {
 	var buffer bytes.Buffer
 	logger := NewLogger(&buffer, "debug")
@@ -20,11 +20,17 @@ type Interface interface {
 	SyncRelease(context HelmContext, name, chart string, flags ...string) error
 	DiffRelease(context HelmContext, name, chart string, suppressDiff bool, flags ...string) error
 	TemplateRelease(name, chart string, flags ...string) error
+	GetManifests(name, chart string, flags ...string) ([]byte, error)
 	Fetch(chart string, flags ...string) error
 	ChartPull(chart string, flags ...string) error
 	ChartExport(chart string, path string, flags ...string) error
+	VerifyChart(path string, flags ...string) error
 	Lint(name, chart string, flags ...string) error
 	ReleaseStatus(context HelmContext, name string, flags ...string) error
+	GetReleaseStatus(context HelmContext, name string, flags ...string) ([]byte, error)
+	GetReleases(context HelmContext, flags ...string) ([]byte, error)
+	GetValues(context HelmContext, name string, flags ...string) ([]byte, error)
+	SearchRepo(keyword string, flags ...string) ([]byte, error)
 	DeleteRelease(context HelmContext, name string, flags ...string) error
 	TestRelease(context HelmContext, name string, flags ...string) error
 	List(context HelmContext, filter string, flags ...string) (string, error)
@@ -2,9 +2,11 @@ package helmexec
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -21,6 +23,12 @@ type Runner interface {
 	Execute(cmd string, args []string, env map[string]string) ([]byte, error)
 	ExecuteStdIn(cmd string, args []string, env map[string]string, stdin io.Reader) ([]byte, error)
 }
+
+// ContextRunner is a Runner that kills the command once the context is done
+type ContextRunner interface {
+	ExecuteContext(ctx context.Context, cmd string, args []string, env map[string]string) ([]byte, error)
+}
+
 type DiffRunner interface {
 	ExecuteDiff(ifDiff bool, cmd string, args []string, env map[string]string) ([]byte, error)
 	ExecuteStdInDiff(isDiff bool, cmd string, args []string, env map[string]string, stdin io.Reader) ([]byte, error)
@@ -39,6 +47,29 @@ type ShellRunner struct {
 func (shell ShellRunner) Execute(cmd string, args []string, env map[string]string) ([]byte, error) {
 	return helm.Exec(false, args...)
 }
+
+// ExecuteContext runs the command in Dir, killing it once the context is done
+func (shell ShellRunner) ExecuteContext(ctx context.Context, cmd string, args []string, env map[string]string) ([]byte, error) {
+	preparedCmd := exec.CommandContext(ctx, cmd, args...)
+	preparedCmd.Dir = shell.Dir
+	preparedCmd.Env = mergeEnv(os.Environ(), env)
+
+	var stdout, stderr, combined bytes.Buffer
+	preparedCmd.Stdout = io.MultiWriter(&stdout, &combined)
+	preparedCmd.Stderr = io.MultiWriter(&stderr, &combined)
+
+	err := preparedCmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return stdout.Bytes(), ctxErr
+	}
+
+	if ee, ok := err.(*exec.ExitError); ok {
+		err = newExitError(preparedCmd.Path, preparedCmd.Args, ee.ExitCode(), ee, stderr.String(), combined.String())
+	}
+
+	return stdout.Bytes(), err
+}
+
 func (shell DiffShellRunner) ExecuteDiff(isDiff bool, cmd string, args []string, env map[string]string) ([]byte, error) {
 	return diff.Exec(isDiff, args...)
 }
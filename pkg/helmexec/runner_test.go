@@ -0,0 +1,46 @@
+package helmexec
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellRunner_ExecuteContext(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	dir := t.TempDir()
+
+	out, err := ShellRunner{Dir: dir}.ExecuteContext(context.Background(), "sh", []string{"-c", "pwd; echo $FOO"}, map[string]string{"FOO": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 2 || !strings.HasSuffix(lines[0], dir) || lines[1] != "bar" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestShellRunner_ExecuteContext_KillsOnTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := ShellRunner{}.ExecuteContext(ctx, "sleep", []string{"10"}, nil)
+	if err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: expected=%v, actual=%v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the command is not killed on the timeout: it took %v", elapsed)
+	}
+}
@@ -0,0 +1,181 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Resource is a Kubernetes resource in the manifests rendered by `helm template`
+type Resource struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	// Hooks are the values of the helm.sh/hook annotation, like pre-install and test
+	Hooks []string
+	// Content is the YAML document of the resource as rendered, without the document separator
+	Content string
+}
+
+type metadata struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name        string            `yaml:"name"`
+		Namespace   string            `yaml:"namespace"`
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+}
+
+var separator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// Parse splits the multi-document manifests into resources.
+// Documents without kind, like the ones with comments only, are skipped.
+func Parse(manifests []byte) ([]Resource, error) {
+	var resources []Resource
+
+	for _, doc := range separator.Split(string(manifests), -1) {
+		content := strings.TrimSpace(doc)
+		if content == "" {
+			continue
+		}
+
+		var m metadata
+		if err := yaml.Unmarshal([]byte(content), &m); err != nil {
+			return nil, fmt.Errorf("parsing manifest: %v\n%s", err, content)
+		}
+
+		if m.Kind == "" {
+			continue
+		}
+
+		r := Resource{
+			APIVersion: m.APIVersion,
+			Kind:       m.Kind,
+			Namespace:  m.Metadata.Namespace,
+			Name:       m.Metadata.Name,
+			Content:    content,
+		}
+
+		if hooks := m.Metadata.Annotations["helm.sh/hook"]; hooks != "" {
+			for _, h := range strings.Split(hooks, ",") {
+				r.Hooks = append(r.Hooks, strings.TrimSpace(h))
+			}
+		}
+
+		resources = append(resources, r)
+	}
+
+	return resources, nil
+}
+
+// IsTest returns true for the helm test hook, that is run by `helm test` but never installed
+func (r Resource) IsTest() bool {
+	for _, h := range r.Hooks {
+		if h == "test" || h == "test-success" || h == "test-failure" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithoutTests returns the resources except helm test hooks
+func WithoutTests(resources []Resource) []Resource {
+	var rs []Resource
+
+	for _, r := range resources {
+		if !r.IsTest() {
+			rs = append(rs, r)
+		}
+	}
+
+	return rs
+}
+
+// installOrder is the order of kinds that helm installs resources in
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"SecretList",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+func kindRank(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+
+	return len(installOrder)
+}
+
+// Sort sorts the resources in the order helm installs them, that is by kind, then by namespace and name.
+// Kinds unknown to helm, like custom resources, follow the known ones in the alphabetical order.
+func Sort(resources []Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+
+		if ra, rb := kindRank(a.Kind), kindRank(b.Kind); ra != rb {
+			return ra < rb
+		}
+
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+
+		return a.Name < b.Name
+	})
+}
+
+// Format joins the resources into the multi-document manifests
+func Format(resources []Resource) string {
+	var sb strings.Builder
+
+	for _, r := range resources {
+		sb.WriteString("---\n")
+		sb.WriteString(r.Content)
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
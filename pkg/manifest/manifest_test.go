@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const rendered = `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+---
+# Source: foo/templates/tests/test-connection.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-test-connection
+  annotations:
+    "helm.sh/hook": test
+---
+# Source: foo/templates/empty.yaml
+---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: apps
+---
+# Source: foo/templates/crd.yaml
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: foo
+---
+# Source: foo/templates/namespace.yaml
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+  annotations:
+    helm.sh/hook: pre-install, pre-upgrade
+`
+
+func TestParse(t *testing.T) {
+	resources, err := Parse([]byte(rendered))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type summary struct {
+		Kind, Namespace, Name string
+		Hooks                 []string
+		Test                  bool
+	}
+
+	var got []summary
+	for _, r := range resources {
+		got = append(got, summary{r.Kind, r.Namespace, r.Name, r.Hooks, r.IsTest()})
+	}
+
+	want := []summary{
+		{Kind: "Deployment", Name: "foo"},
+		{Kind: "Pod", Name: "foo-test-connection", Hooks: []string{"test"}, Test: true},
+		{Kind: "Service", Namespace: "apps", Name: "foo"},
+		{Kind: "Widget", Name: "foo"},
+		{Kind: "Namespace", Name: "apps", Hooks: []string{"pre-install", "pre-upgrade"}},
+	}
+
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected resources: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestSortAndFormat(t *testing.T) {
+	resources, err := Parse([]byte(rendered))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resources = WithoutTests(resources)
+	Sort(resources)
+
+	want := `---
+# Source: foo/templates/namespace.yaml
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+  annotations:
+    helm.sh/hook: pre-install, pre-upgrade
+---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: apps
+---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+---
+# Source: foo/templates/crd.yaml
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: foo
+`
+
+	if d := cmp.Diff(want, Format(resources)); d != "" {
+		t.Errorf("unexpected manifests: want (-), got (+):\n%s", d)
+	}
+}
@@ -0,0 +1,353 @@
+// Package manifestdiff compares Kubernetes manifests semantically.
+//
+// Resources are matched by apiVersion, kind, namespace and name, and compared after being parsed,
+// so that neither the order of the resources and the keys nor the formatting of the YAML matters.
+package manifestdiff
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Object is a Kubernetes resource parsed from YAML or JSON
+type Object map[string]interface{}
+
+// ID identifies the resource in the manifests
+type ID struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// String returns `<apiVersion>/<kind> <namespace>/<name>`, or `<apiVersion>/<kind> <name>` for the resource without namespace
+func (id ID) String() string {
+	if id.Namespace == "" {
+		return fmt.Sprintf("%s/%s %s", id.APIVersion, id.Kind, id.Name)
+	}
+
+	return fmt.Sprintf("%s/%s %s/%s", id.APIVersion, id.Kind, id.Namespace, id.Name)
+}
+
+// ID returns the ID of the resource
+func (o Object) ID() ID {
+	id := ID{}
+	id.APIVersion, _ = o["apiVersion"].(string)
+	id.Kind, _ = o["kind"].(string)
+
+	metadata, _ := o["metadata"].(map[string]interface{})
+	id.Namespace, _ = metadata["namespace"].(string)
+	id.Name, _ = metadata["name"].(string)
+
+	return id
+}
+
+// Parse parses the stream of YAML or JSON documents. Empty documents are skipped.
+func Parse(r io.Reader) ([]Object, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+
+	var objects []Object
+
+	for {
+		var obj Object
+
+		if err := decoder.Decode(&obj); err == io.EOF {
+			return objects, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(obj) > 0 {
+			objects = append(objects, obj)
+		}
+	}
+}
+
+// ChangeType is the type of the change of a resource
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// FieldChange is the change of a field of the modified resource.
+// Before and After are nil for the added and the removed fields respectively.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Change is the change of a resource.
+// Before and After are the resource without the ignored fields, that are nil for the added and the removed resources respectively.
+type Change struct {
+	ID     ID            `json:"id"`
+	Type   ChangeType    `json:"type"`
+	Before Object        `json:"before,omitempty"`
+	After  Object        `json:"after,omitempty"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// DefaultIgnoredFields are the fields that change on every chart version bump or values change,
+// without affecting the behaviour of the resources by themselves
+var DefaultIgnoredFields = []string{
+	`metadata.labels.helm\.sh/chart`,
+	`metadata.annotations.checksum/*`,
+	`spec.template.metadata.labels.helm\.sh/chart`,
+	`spec.template.metadata.annotations.checksum/*`,
+}
+
+// Options is the options of Diff
+type Options struct {
+	// IgnoredFields are the paths of the fields excluded from the comparison.
+	// Path segments are separated by dots, which are escaped by backslashes within keys like `helm\.sh/chart`.
+	// Each segment is a pattern of path.Match matched against map keys and list indices, like `checksum/*` and `*`.
+	IgnoredFields []string
+}
+
+// Diff returns the changes from the resources before to the ones after, ordered by ID.
+// It fails when the same resource appears more than once on either side.
+func Diff(before, after []Object, opts Options) ([]Change, error) {
+	ignored, err := compileFieldPaths(opts.IgnoredFields)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := indexObjects(before, "before", ignored)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := indexObjects(after, "after", ignored)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[ID]bool{}
+	for id := range b {
+		ids[id] = true
+	}
+	for id := range a {
+		ids[id] = true
+	}
+
+	var sorted []ID
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	var changes []Change
+
+	for _, id := range sorted {
+		ch := Change{ID: id, Before: b[id], After: a[id]}
+
+		switch {
+		case ch.Before == nil:
+			ch.Type = Added
+		case ch.After == nil:
+			ch.Type = Removed
+		default:
+			ch.Fields = diffValues("", map[string]interface{}(ch.Before), map[string]interface{}(ch.After), nil)
+			if len(ch.Fields) == 0 {
+				continue
+			}
+			ch.Type = Modified
+		}
+
+		changes = append(changes, ch)
+	}
+
+	return changes, nil
+}
+
+func indexObjects(objects []Object, side string, ignored [][]string) (map[ID]Object, error) {
+	index := map[ID]Object{}
+
+	for _, obj := range objects {
+		id := obj.ID()
+		if _, ok := index[id]; ok {
+			return nil, fmt.Errorf("%s appears more than once in the manifests %s", id, side)
+		}
+
+		pruned := deepCopy(map[string]interface{}(obj)).(map[string]interface{})
+		for _, segments := range ignored {
+			removeField(pruned, segments)
+		}
+
+		index[id] = Object(pruned)
+	}
+
+	return index, nil
+}
+
+func compileFieldPaths(paths []string) ([][]string, error) {
+	var compiled [][]string
+
+	for _, p := range paths {
+		var (
+			segments []string
+			segment  strings.Builder
+		)
+
+		for i := 0; i < len(p); i++ {
+			switch {
+			case p[i] == '\\' && i+1 < len(p) && p[i+1] == '.':
+				segment.WriteByte('.')
+				i++
+			case p[i] == '.':
+				segments = append(segments, segment.String())
+				segment.Reset()
+			default:
+				segment.WriteByte(p[i])
+			}
+		}
+		segments = append(segments, segment.String())
+
+		for _, s := range segments {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, fmt.Errorf("invalid ignored field %q: %v", p, err)
+			}
+		}
+
+		compiled = append(compiled, segments)
+	}
+
+	return compiled, nil
+}
+
+func removeField(v interface{}, segments []string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if ok, _ := path.Match(segments[0], k); !ok {
+				continue
+			}
+
+			if len(segments) == 1 {
+				delete(t, k)
+			} else {
+				removeField(child, segments[1:])
+			}
+		}
+	case []interface{}:
+		// Items are never removed, so that the indices of the rest don't shift
+		if len(segments) == 1 {
+			return
+		}
+
+		for i, child := range t {
+			if ok, _ := path.Match(segments[0], strconv.Itoa(i)); ok {
+				removeField(child, segments[1:])
+			}
+		}
+	}
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			m[k] = deepCopy(child)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, child := range t {
+			l[i] = deepCopy(child)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func diffValues(p string, before, after interface{}, changes []FieldChange) []FieldChange {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := map[string]bool{}
+		for k := range b {
+			keys[k] = true
+		}
+		for k := range a {
+			keys[k] = true
+		}
+
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			bv, inBefore := b[k]
+			av, inAfter := a[k]
+
+			switch {
+			case !inBefore:
+				changes = append(changes, FieldChange{Path: joinKey(p, k), After: av})
+			case !inAfter:
+				changes = append(changes, FieldChange{Path: joinKey(p, k), Before: bv})
+			default:
+				changes = diffValues(joinKey(p, k), bv, av, changes)
+			}
+		}
+
+		return changes
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(b) || i < len(a); i++ {
+			item := fmt.Sprintf("%s[%d]", p, i)
+
+			switch {
+			case i >= len(b):
+				changes = append(changes, FieldChange{Path: item, After: a[i]})
+			case i >= len(a):
+				changes = append(changes, FieldChange{Path: item, Before: b[i]})
+			default:
+				changes = diffValues(item, b[i], a[i], changes)
+			}
+		}
+
+		return changes
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		changes = append(changes, FieldChange{Path: p, Before: before, After: after})
+	}
+
+	return changes
+}
+
+func joinKey(p, k string) string {
+	if strings.ContainsAny(k, ".[]") {
+		return fmt.Sprintf("%s[%q]", p, k)
+	}
+
+	if p == "" {
+		return k
+	}
+
+	return p + "." + k
+}
@@ -0,0 +1,264 @@
+package manifestdiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func mustParse(t *testing.T, manifests string) []Object {
+	t.Helper()
+
+	objects, err := Parse(strings.NewReader(manifests))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return objects
+}
+
+func TestDiff(t *testing.T) {
+	before := mustParse(t, `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: apps
+  labels:
+    helm.sh/chart: foo-1.0.0
+data:
+  a: "1"
+  b: "2"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: apps
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: foo
+`)
+
+	// The Service differs from the previous one only in the order of the resources and the formatting
+	after := mustParse(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  namespace: apps
+---
+apiVersion: v1
+kind: Service
+metadata: {namespace: apps, name: foo}
+spec: {ports: [{port: 80}]}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: apps
+  labels:
+    helm.sh/chart: foo-1.1.0
+data:
+  b: "2"
+  a: "3"
+  c: "4"
+`)
+
+	changes, err := Diff(before, after, Options{IgnoredFields: DefaultIgnoredFields})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, ch := range changes {
+		got = append(got, string(ch.Type)+" "+ch.ID.String())
+	}
+
+	want := []string{
+		"added apps/v1/Deployment apps/foo",
+		"removed rbac.authorization.k8s.io/v1/ClusterRole foo",
+		"modified v1/ConfigMap apps/foo",
+	}
+
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("unexpected changes: want (-), got (+):\n%s", d)
+	}
+
+	wantFields := []FieldChange{
+		{Path: "data.a", Before: "1", After: "3"},
+		{Path: "data.c", After: "4"},
+	}
+
+	if d := cmp.Diff(wantFields, changes[2].Fields); d != "" {
+		t.Errorf("unexpected fields: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestDiff_IgnoredFields(t *testing.T) {
+	before := mustParse(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    metadata:
+      annotations:
+        checksum/config: abc
+  containers:
+  - name: foo
+    image: foo:1
+`)
+	after := mustParse(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    metadata:
+      annotations:
+        checksum/config: def
+  containers:
+  - name: foo
+    image: foo:2
+`)
+
+	testcases := []struct {
+		ignored []string
+		want    []FieldChange
+	}{
+		{
+			ignored: nil,
+			want: []FieldChange{
+				{Path: "spec.containers[0].image", Before: "foo:1", After: "foo:2"},
+				{Path: "spec.template.metadata.annotations.checksum/config", Before: "abc", After: "def"},
+			},
+		},
+		{
+			ignored: DefaultIgnoredFields,
+			want: []FieldChange{
+				{Path: "spec.containers[0].image", Before: "foo:1", After: "foo:2"},
+			},
+		},
+		{
+			ignored: append([]string{`spec.containers.*.image`}, DefaultIgnoredFields...),
+			want:    nil,
+		},
+	}
+
+	for _, tc := range testcases {
+		changes, err := Diff(before, after, Options{IgnoredFields: tc.ignored})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []FieldChange
+		for _, ch := range changes {
+			got = append(got, ch.Fields...)
+		}
+
+		if d := cmp.Diff(tc.want, got); d != "" {
+			t.Errorf("ignoring %v: unexpected fields: want (-), got (+):\n%s", tc.ignored, d)
+		}
+	}
+
+	if _, err := Diff(before, after, Options{IgnoredFields: []string{"metadata.[invalid"}}); err == nil {
+		t.Error("expected error for the invalid ignored field, got nil")
+	}
+}
+
+func TestDiff_Duplicate(t *testing.T) {
+	objects := mustParse(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`)
+
+	_, err := Diff(objects, nil, Options{})
+	if err == nil || err.Error() != "v1/ConfigMap foo appears more than once in the manifests before" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	before := mustParse(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  a: "1"
+  b: "2"
+  c: "3"
+  d: "4"
+`)
+	after := mustParse(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  a: "1"
+  b: "2"
+  c: "5"
+  d: "4"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: bar
+`)
+
+	changes, err := Diff(before, after, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, changes, FormatOptions{Context: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `v1/ConfigMap foo has changed:
+...
+    b: "2"
+-   c: "3"
++   c: "5"
+    d: "4"
+...
+v1/Secret bar has been added:
++ apiVersion: v1
++ kind: Secret
++ metadata:
++   name: bar
+`
+
+	if d := cmp.Diff(want, buf.String()); d != "" {
+		t.Errorf("unexpected output: want (-), got (+):\n%s", d)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, changes[1:], FormatOptions{Context: -1, Color: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "\x1b[32m+ kind: Secret\x1b[0m\n") {
+		t.Errorf("added lines must be green: %q", buf.String())
+	}
+}
@@ -0,0 +1,121 @@
+package manifestdiff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aryann/difflib"
+	"github.com/logrusorgru/aurora"
+	"gopkg.in/yaml.v2"
+)
+
+// FormatOptions is the options of the unified output
+type FormatOptions struct {
+	// Context is the number of the unchanged lines shown around the changed ones. All the lines are shown when negative.
+	Context int
+	// Color colors the removed lines red and the added lines green
+	Color bool
+}
+
+// Unified returns the line-based diff of the YAML of the resource before and after the change,
+// whose lines are prefixed by `- `, `+ ` or two spaces. The omitted unchanged lines are replaced with `...`.
+func (c Change) Unified(opts FormatOptions) (string, error) {
+	before, err := yamlLines(c.Before)
+	if err != nil {
+		return "", err
+	}
+
+	after, err := yamlLines(c.After)
+	if err != nil {
+		return "", err
+	}
+
+	records := difflib.Diff(before, after)
+
+	au := aurora.NewAurora(opts.Color)
+
+	var b strings.Builder
+
+	omitting := false
+	for i, r := range records {
+		if opts.Context >= 0 && !nearChange(records, i, opts.Context) {
+			if !omitting {
+				b.WriteString("...\n")
+				omitting = true
+			}
+			continue
+		}
+		omitting = false
+
+		switch r.Delta {
+		case difflib.LeftOnly:
+			b.WriteString(au.Red("- " + r.Payload).String())
+		case difflib.RightOnly:
+			b.WriteString(au.Green("+ " + r.Payload).String())
+		default:
+			b.WriteString("  " + r.Payload)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String(), nil
+}
+
+// Write writes the changes in the unified format, each of which is headed by the ID of the resource
+func Write(w io.Writer, changes []Change, opts FormatOptions) error {
+	au := aurora.NewAurora(opts.Color)
+
+	for _, ch := range changes {
+		unified, err := ch.Unified(opts)
+		if err != nil {
+			return err
+		}
+
+		verb := "has changed"
+		switch ch.Type {
+		case Added:
+			verb = "has been added"
+		case Removed:
+			verb = "has been removed"
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\n%s", au.Yellow(fmt.Sprintf("%s %s:", ch.ID, verb)), unified); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func yamlLines(obj Object) ([]string, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	bs, err := yaml.Marshal(map[string]interface{}(obj))
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n"), nil
+}
+
+// nearChange returns true when the record is within the context lines of any change
+func nearChange(records []difflib.DiffRecord, i, context int) bool {
+	from, to := i-context, i+context
+	if from < 0 {
+		from = 0
+	}
+	if to > len(records)-1 {
+		to = len(records) - 1
+	}
+
+	for j := from; j <= to; j++ {
+		if records[j].Delta != difflib.Common {
+			return true
+		}
+	}
+
+	return false
+}
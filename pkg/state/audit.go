@@ -0,0 +1,92 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/audit"
+)
+
+// AuditRecords returns the audit records of the releases affected by the helmfile command.
+// base is the record whose release-specific fields are filled for each release.
+// errs are the errors returned by the command, that are used to tell why each release failed.
+func (st *HelmState) AuditRecords(base audit.Record, affected *AffectedReleases, errs []error) []audit.Record {
+	base.Helmfile = st.FilePath
+	base.Environment = st.Env.Name
+
+	var records []audit.Record
+
+	for _, r := range affected.Upgraded {
+		records = append(records, st.auditRecord(base, affected, r, audit.ActionUpgrade, nil))
+	}
+
+	for _, r := range affected.Deleted {
+		records = append(records, st.auditRecord(base, affected, r, audit.ActionDelete, nil))
+	}
+
+	for _, r := range affected.Failed {
+		action := audit.ActionUpgrade
+		if !r.Desired() || base.HelmfileCommand == "delete" || base.HelmfileCommand == "destroy" {
+			action = audit.ActionDelete
+		}
+
+		rec := st.auditRecord(base, affected, r, action, releaseErrorOf(r, errs))
+		rec.Result = audit.ResultFailure
+
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func (st *HelmState) auditRecord(base audit.Record, affected *AffectedReleases, r *ReleaseSpec, action string, err error) audit.Record {
+	rec := base
+	rec.Release = r.Name
+	rec.Namespace = r.Namespace
+	rec.KubeContext = st.releaseKubeContext(r)
+	rec.Action = action
+	rec.Chart = r.Chart
+	rec.ChartVersion = r.Version
+	if r.installedVersion != "" {
+		rec.ChartVersion = r.installedVersion
+	}
+	rec.ValuesHash = affected.valuesHashes[ReleaseToID(r)]
+	rec.Result = audit.ResultSuccess
+	if err != nil {
+		rec.Result = audit.ResultFailure
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// hashValues returns the SHA-256 of the contents of the values files and the --set flags passed to helm.
+// --values flags are excluded as they point to the temporary files whose names change on every run, and so are
+// the other flags that aren't values, like --wait.
+// An empty string is returned when any of the files is unreadable, as the hash is informational.
+func (st *HelmState) hashValues(files []string, flags []string) string {
+	h := sha256.New()
+
+	for _, f := range files {
+		bs, err := st.readFile(f)
+		if err != nil {
+			st.logger.Debugf("hashing values: %v", err)
+			return ""
+		}
+		h.Write(bs)
+		h.Write([]byte{0})
+	}
+
+	for i := 0; i+1 < len(flags); i++ {
+		if !strings.HasPrefix(flags[i], "--set") {
+			continue
+		}
+		h.Write([]byte(flags[i]))
+		h.Write([]byte{0})
+		h.Write([]byte(flags[i+1]))
+		h.Write([]byte{0})
+		i++
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
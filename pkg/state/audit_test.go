@@ -0,0 +1,89 @@
+package state
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/audit"
+	"github.com/huolunl/helmfile/pkg/environment"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+func TestHelmState_AuditRecords(t *testing.T) {
+	disabled := false
+
+	foo := &ReleaseSpec{Name: "foo", Namespace: "apps", Chart: "stable/foo", Version: "~1.0", installedVersion: "1.0.3"}
+	bar := &ReleaseSpec{Name: "bar", Chart: "stable/bar", KubeContext: "other"}
+	baz := &ReleaseSpec{Name: "baz", Chart: "stable/baz"}
+	qux := &ReleaseSpec{Name: "qux", Chart: "stable/qux", Installed: &disabled}
+
+	st := &HelmState{
+		FilePath: "helmfile.yaml",
+		ReleaseSetSpec: ReleaseSetSpec{
+			Env:          environment.Environment{Name: "prod"},
+			HelmDefaults: HelmSpec{KubeContext: "default"},
+		},
+	}
+
+	base := audit.Record{User: "alice", HelmfileCommand: "apply", Selectors: []string{"tier=web"}}
+	affected := &AffectedReleases{
+		Upgraded: []*ReleaseSpec{foo},
+		Deleted:  []*ReleaseSpec{bar},
+		Failed:   []*ReleaseSpec{baz, qux},
+	}
+	affected.setValuesHash(foo, "abc")
+	errs := []error{newReleaseFailedError(baz, errors.New("timed out"))}
+
+	common := audit.Record{User: "alice", HelmfileCommand: "apply", Helmfile: "helmfile.yaml", Environment: "prod", Selectors: []string{"tier=web"}}
+
+	want := []audit.Record{common, common, common, common}
+
+	want[0].Release, want[0].Namespace, want[0].KubeContext = "foo", "apps", "default"
+	want[0].Action, want[0].Chart, want[0].ChartVersion, want[0].ValuesHash, want[0].Result = "upgrade", "stable/foo", "1.0.3", "abc", "success"
+
+	want[1].Release, want[1].KubeContext = "bar", "other"
+	want[1].Action, want[1].Chart, want[1].Result = "delete", "stable/bar", "success"
+
+	want[2].Release, want[2].KubeContext = "baz", "default"
+	want[2].Action, want[2].Chart, want[2].Result, want[2].Error = "upgrade", "stable/baz", "failure", "failed processing release baz: timed out"
+
+	want[3].Release, want[3].KubeContext = "qux", "default"
+	want[3].Action, want[3].Chart, want[3].Result = "delete", "stable/qux", "failure"
+
+	if d := cmp.Diff(want, st.AuditRecords(base, affected, errs)); d != "" {
+		t.Errorf("unexpected records: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_hashValues(t *testing.T) {
+	fs := testhelper.NewTestFs(map[string]string{
+		"/tmp/a/values.yaml": "foo: bar\n",
+		"/tmp/b/values.yaml": "foo: bar\n",
+		"/tmp/c/values.yaml": "foo: baz\n",
+	})
+
+	st := &HelmState{logger: logger, readFile: fs.ReadFile}
+
+	a := st.hashValues([]string{"/tmp/a/values.yaml"}, []string{"--namespace", "ns", "--values", "/tmp/a/values.yaml", "--set", "x=1"})
+	b := st.hashValues([]string{"/tmp/b/values.yaml"}, []string{"--namespace", "ns", "--values", "/tmp/b/values.yaml", "--set", "x=1"})
+	c := st.hashValues([]string{"/tmp/c/values.yaml"}, []string{"--namespace", "ns", "--values", "/tmp/c/values.yaml", "--set", "x=1"})
+	d := st.hashValues([]string{"/tmp/a/values.yaml"}, []string{"--namespace", "ns", "--values", "/tmp/a/values.yaml", "--set", "x=2"})
+	e := st.hashValues([]string{"/tmp/a/values.yaml"}, []string{"--namespace", "ns", "--wait", "--values", "/tmp/a/values.yaml", "--set", "x=1"})
+
+	if a == "" || a != b {
+		t.Errorf("expected the same hash for the same values in different files: %q, %q", a, b)
+	}
+
+	if a != e {
+		t.Errorf("expected the same hash regardless of the flags other than values: %q, %q", a, e)
+	}
+
+	if a == c || a == d {
+		t.Errorf("expected different hashes for different values: %q, %q, %q", a, c, d)
+	}
+
+	if h := st.hashValues([]string{"/tmp/missing.yaml"}, nil); h != "" {
+		t.Errorf("expected an empty hash for an unreadable file, got %q", h)
+	}
+}
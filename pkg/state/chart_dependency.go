@@ -230,6 +230,11 @@ func getUnresolvedDependenciess(st *HelmState) (string, *UnresolvedDependencies,
 			continue
 		}
 
+		// The OCI chart pinned to the digest is locked already
+		if strings.Contains(chart, "@") || strings.HasPrefix(r.Version, "sha256:") || r.ChartDigest != "" {
+			continue
+		}
+
 		url, ok := repoToURL[repo]
 		// Skip this chart from dependency management, as there's no matching `repository` in the helmfile state,
 		// which may imply that this is a local chart within a directory, like `charts/myapp`
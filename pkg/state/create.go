@@ -60,6 +60,10 @@ type StateCreator struct {
 	overrideHelmBinary string
 
 	remote *remote.Remote
+
+	// Templates are the release templates defined in the preceding parts of the helmfile being loaded.
+	// Releases can inherit them in addition to the templates defined in the part itself and its bases.
+	Templates map[string]TemplateSpec
 }
 
 func NewCreator(logger *zap.SugaredLogger, readFile func(string) ([]byte, error), fileExists func(string) (bool, error), abs func(string) (string, error), glob func(string) ([]string, error), directoryExistsAt func(string) bool, valsRuntime vals.Evaluator, getHelm func(*HelmState) helmexec.Interface, overrideHelmBinary string, remote *remote.Remote) *StateCreator {
@@ -164,6 +168,15 @@ func (c *StateCreator) LoadEnvValues(target *HelmState, env string, ctxEnv *envi
 
 	state.Env = *e
 
+	spec, ok, err := state.ResolveEnvironment(env)
+	if err != nil {
+		return nil, &StateLoadError{fmt.Sprintf("failed to read %s", state.FilePath), err}
+	}
+
+	if ok {
+		state.HelmDefaults = overrideHelmDefaults(state.HelmDefaults, spec)
+	}
+
 	return &state, nil
 }
 
@@ -184,6 +197,11 @@ func (c *StateCreator) ParseAndLoad(content []byte, baseDir, file string, envNam
 		if err != nil {
 			return nil, err
 		}
+
+		// Resolved only when bases are evaluated, so that releases in bases can inherit templates in other bases
+		if err := state.resolveInheritance(c.Templates); err != nil {
+			return nil, err
+		}
 	}
 
 	state, err = c.LoadEnvValues(state, envName, envValues, evaluateBases)
@@ -213,20 +231,34 @@ func (c *StateCreator) loadBases(envValues *environment.Environment, st *HelmSta
 	}
 	layers = append(layers, st)
 
+	templates := map[string]TemplateSpec{}
+	for _, l := range layers {
+		for name, t := range l.Templates {
+			templates[name] = t
+		}
+	}
+
 	for i := 1; i < len(layers); i++ {
 		if err := mergo.Merge(layers[0], layers[i], mergo.WithAppendSlice); err != nil {
 			return nil, err
 		}
 	}
 
+	// A template in a later layer overrides the one with the same name in an earlier layer, as a whole
+	if len(templates) > 0 {
+		layers[0].Templates = templates
+	}
+
 	return layers[0], nil
 }
 
 func (c *StateCreator) loadEnvValues(st *HelmState, name string, failOnMissingEnv bool, ctxEnv *environment.Environment, readFile func(string) ([]byte, error), glob func(string) ([]string, error)) (*environment.Environment, error) {
 	envVals := map[string]interface{}{}
-	envSpec, ok := st.Environments[name]
+	envSpec, ok, err := st.ResolveEnvironment(name)
+	if err != nil {
+		return nil, err
+	}
 	if ok {
-		var err error
 		envVals, err = st.loadValuesEntries(envSpec.MissingFileHandler, envSpec.Values, c.remote, ctxEnv)
 		if err != nil {
 			return nil, err
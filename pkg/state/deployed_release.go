@@ -0,0 +1,179 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+// DeployedRelease is a release installed in a namespace of the desired releases, as listed by `helm list`
+type DeployedRelease struct {
+	Name string
+	// Namespace is the namespace the release is installed in, as reported by `helm list`
+	Namespace   string
+	KubeContext string
+	Revision    int
+	Status      string
+	// Chart is the name of the deployed chart, without the repository
+	Chart        string
+	ChartVersion string
+	AppVersion   string
+	Updated      string
+}
+
+// ID returns the key to join the deployed release with the desired release having the same DeployedReleaseID
+func (r DeployedRelease) ID() string {
+	return deployedReleaseID(r.KubeContext, r.Namespace, r.Name)
+}
+
+func deployedReleaseID(kubeContext, namespace, name string) string {
+	return kubeContext + "/" + namespace + "/" + name
+}
+
+// DeployedReleaseID returns the key to join the desired release with the deployed release.
+// Unlike ReleaseToID, it takes the default kube context of the helmfile into account as `helm list` does,
+// and resolves the empty namespace to the namespace of the kube context, so that `namespace: default` and the empty namespace result in the same ID.
+func (st *HelmState) DeployedReleaseID(helm helmexec.Interface, release *ReleaseSpec) (string, error) {
+	namespace, err := st.resolveNamespace(helm, release)
+	if err != nil {
+		return "", err
+	}
+
+	return deployedReleaseID(st.releaseKubeContext(release), namespace, release.Name), nil
+}
+
+// resolveNamespace returns the namespace of the release, or the namespace of the kube context that `helm list` reports for the empty namespace.
+// The empty namespace is resolved to "" when no release is installed in the namespace of the kube context, as no deployed release can be joined then.
+func (st *HelmState) resolveNamespace(helm helmexec.Interface, release *ReleaseSpec) (string, error) {
+	if release.Namespace != "" {
+		return release.Namespace, nil
+	}
+
+	kubeContext := st.releaseKubeContext(release)
+
+	if namespace, ok := st.defaultNamespaces[kubeContext]; ok {
+		return namespace, nil
+	}
+
+	deployed, err := st.listDeployedReleasesWith(helm, release, "--all", "--max", "1")
+	if err != nil {
+		return "", fmt.Errorf("listing releases in the default namespace of kube context %q: %v", kubeContext, err)
+	}
+
+	var namespace string
+	if len(deployed) > 0 {
+		namespace = deployed[0].Namespace
+	}
+
+	if st.defaultNamespaces == nil {
+		st.defaultNamespaces = map[string]string{}
+	}
+	st.defaultNamespaces[kubeContext] = namespace
+
+	return namespace, nil
+}
+
+// helmListedRelease is an item of the output of `helm list --output json`
+type helmListedRelease struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Revision   string `json:"revision"`
+	Updated    string `json:"updated"`
+	Status     string `json:"status"`
+	Chart      string `json:"chart"`
+	AppVersion string `json:"app_version"`
+}
+
+// chartNameVersion splits `helm list`'s chart like `foo-bar-1.2.3-rc.1` into the name and the version
+var chartNameVersion = regexp.MustCompile(`^(.+)-(v?[0-9]+\.[0-9]+.*)$`)
+
+// ListDeployedReleases lists the releases installed in the namespaces of the desired releases, in any status.
+// Each pair of a kube context and a namespace is listed once, in the order of the releases.
+func (st *HelmState) ListDeployedReleases(helm helmexec.Interface) ([]DeployedRelease, error) {
+	if !helm.IsHelm3() {
+		return nil, errors.New("helmfile list --with-status requires helm 3")
+	}
+
+	return st.listDeployedReleases(helm, st.GetReleasesWithOverrides())
+}
+
+// listDeployedReleases lists the releases installed in the namespaces of the releases with `helm list`
+func (st *HelmState) listDeployedReleases(helm helmexec.Interface, releases []ReleaseSpec) ([]DeployedRelease, error) {
+	var deployed []DeployedRelease
+
+	listed := map[string]bool{}
+
+	for _, release := range releases {
+		kubeContext := st.releaseKubeContext(&release)
+
+		namespace, err := st.resolveNamespace(helm, &release)
+		if err != nil {
+			return nil, err
+		}
+
+		// Nothing is installed in the default namespace that couldn't be resolved
+		if namespace == "" {
+			continue
+		}
+
+		key := kubeContext + "/" + namespace
+		if listed[key] {
+			continue
+		}
+		listed[key] = true
+
+		ds, err := st.listDeployedReleasesWith(helm, &release, "--all", "--max", "0", "--namespace", namespace)
+		if err != nil {
+			return nil, fmt.Errorf("listing releases in namespace %q of kube context %q: %v", namespace, kubeContext, err)
+		}
+
+		deployed = append(deployed, ds...)
+	}
+
+	return deployed, nil
+}
+
+// listDeployedReleasesWith runs `helm list` with the flags in the kube context of the target release, and returns the listed releases
+func (st *HelmState) listDeployedReleasesWith(helm helmexec.Interface, target *ReleaseSpec, flags ...string) ([]DeployedRelease, error) {
+	flags = st.appendConnectionFlags(flags, helm, target)
+
+	out, err := helm.GetReleases(st.createHelmContext(target, 0), flags...)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []helmListedRelease
+	if err := json.Unmarshal(out, &items); err != nil {
+		return nil, fmt.Errorf("parsing the output of helm list: %v", err)
+	}
+
+	var deployed []DeployedRelease
+
+	for _, item := range items {
+		d := DeployedRelease{
+			Name:        item.Name,
+			Namespace:   item.Namespace,
+			KubeContext: st.releaseKubeContext(target),
+			Status:      item.Status,
+			Chart:       item.Chart,
+			AppVersion:  item.AppVersion,
+			Updated:     item.Updated,
+		}
+
+		if m := chartNameVersion.FindStringSubmatch(item.Chart); m != nil {
+			d.Chart, d.ChartVersion = m[1], m[2]
+		}
+
+		if revision, err := strconv.Atoi(item.Revision); err == nil {
+			d.Revision = revision
+		}
+
+		deployed = append(deployed, d)
+	}
+
+	return deployed, nil
+}
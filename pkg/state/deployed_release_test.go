@@ -0,0 +1,131 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+func TestHelmState_ListDeployedReleases(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			HelmDefaults: HelmSpec{KubeContext: "default"},
+			Releases: []ReleaseSpec{
+				{Name: "foo", Chart: "stable/foo", Namespace: "apps"},
+				{Name: "bar", Chart: "stable/bar", Namespace: "apps"},
+				{Name: "baz", Chart: "stable/baz", Namespace: "infra"},
+			},
+		},
+		logger: logger,
+	}
+
+	helm := &exectest.Helm{
+		Helm3: true,
+		Deployed: map[string]string{
+			"apps": `[
+{"name":"foo","namespace":"apps","revision":"3","updated":"2021-05-01 10:00:00.0 +0000 UTC","status":"deployed","chart":"foo-bar-1.2.5-rc.1","app_version":"2.0"},
+{"name":"legacy","namespace":"apps","revision":"12","updated":"2020-01-01 10:00:00.0 +0000 UTC","status":"failed","chart":"legacy-0.1.0","app_version":""}
+]`,
+		},
+	}
+
+	deployed, err := st.ListDeployedReleases(helm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []DeployedRelease{
+		{
+			Name:         "foo",
+			Namespace:    "apps",
+			KubeContext:  "default",
+			Revision:     3,
+			Status:       "deployed",
+			Chart:        "foo-bar",
+			ChartVersion: "1.2.5-rc.1",
+			AppVersion:   "2.0",
+			Updated:      "2021-05-01 10:00:00.0 +0000 UTC",
+		},
+		{
+			Name:         "legacy",
+			Namespace:    "apps",
+			KubeContext:  "default",
+			Revision:     12,
+			Status:       "failed",
+			Chart:        "legacy",
+			ChartVersion: "0.1.0",
+			Updated:      "2020-01-01 10:00:00.0 +0000 UTC",
+		},
+	}
+
+	if d := cmp.Diff(want, deployed); d != "" {
+		t.Errorf("unexpected deployed releases: want (-), got (+):\n%s", d)
+	}
+
+	if id, err := st.DeployedReleaseID(helm, &st.Releases[0]); err != nil || id != deployed[0].ID() {
+		t.Errorf("expected the desired release to be joined with the deployed release: %s, %s: %v", id, deployed[0].ID(), err)
+	}
+}
+
+func TestHelmState_ListDeployedReleases_DefaultNamespace(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			HelmDefaults: HelmSpec{KubeContext: "default"},
+			Releases: []ReleaseSpec{
+				{Name: "foo", Chart: "stable/foo"},
+				{Name: "bar", Chart: "stable/bar", Namespace: "default"},
+			},
+		},
+		logger: logger,
+	}
+
+	listed := `[
+{"name":"foo","namespace":"default","revision":"1","status":"deployed","chart":"foo-1.0.0"},
+{"name":"bar","namespace":"default","revision":"2","status":"deployed","chart":"bar-1.0.0"}
+]`
+
+	helm := &exectest.Helm{
+		Helm3: true,
+		// `helm list` without --namespace lists the releases in the namespace of the kube context
+		Deployed: map[string]string{"": listed, "default": listed},
+	}
+
+	deployed, err := st.ListDeployedReleases(helm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(deployed) != 2 {
+		t.Fatalf("expected the default namespace to be listed once: %v", deployed)
+	}
+
+	for i := range st.Releases {
+		id, err := st.DeployedReleaseID(helm, &st.Releases[i])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if id != deployed[i].ID() {
+			t.Errorf("expected release %q to be joined with the deployed release: %s, %s", st.Releases[i].Name, id, deployed[i].ID())
+		}
+	}
+
+	// Nothing can be joined with the empty namespace when no release is installed in the namespace of the kube context
+	st = &HelmState{ReleaseSetSpec: ReleaseSetSpec{Releases: []ReleaseSpec{{Name: "foo", Chart: "stable/foo"}}}, logger: logger}
+	if id, err := st.DeployedReleaseID(&exectest.Helm{Helm3: true}, &st.Releases[0]); err != nil || id != "//foo" {
+		t.Errorf("unexpected ID of the release in the unresolved namespace: %q: %v", id, err)
+	}
+}
+
+func TestHelmState_ListDeployedReleases_Helm2(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{Releases: []ReleaseSpec{{Name: "foo", Chart: "stable/foo"}}},
+		logger:         logger,
+	}
+
+	if _, err := st.ListDeployedReleases(&exectest.Helm{}); err == nil {
+		t.Error("expected an error for helm 2")
+	}
+}
@@ -1,5 +1,11 @@
 package state
 
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
 type EnvironmentSpec struct {
 	Values      []interface{} `yaml:"values,omitempty"`
 	Secrets     []string      `yaml:"secrets,omitempty"`
@@ -13,4 +19,179 @@ type EnvironmentSpec struct {
 	// Use "Warn", "Info", or "Debug" if you want helmfile to not fail when a values file is missing, while just leaving
 	// a message about the missing file at the log-level.
 	MissingFileHandler *string `yaml:"missingFileHandler,omitempty"`
+
+	// Inherits is the list of names of environments this environment inherits from.
+	// The values and secrets of the inherited environments are deep-merged in order, and then overridden by this environment's.
+	Inherits []string `yaml:"inherits,omitempty"`
+
+	// HelmDefaults overrides the fields of `helmDefaults` like `kubeContext`, `wait` and `timeout` in this environment
+	HelmDefaults *HelmDefaultsOverrides `yaml:"helmDefaults,omitempty"`
+}
+
+// HelmDefaultsOverrides is `helmDefaults` of an environment.
+// Each field has the same name as the one of HelmSpec, and overrides it only when set, so that `wait: false` can
+// override `wait: true` of the top-level `helmDefaults`.
+type HelmDefaultsOverrides struct {
+	KubeContext                *string    `yaml:"kubeContext,omitempty"`
+	TillerNamespace            *string    `yaml:"tillerNamespace,omitempty"`
+	Tillerless                 *bool      `yaml:"tillerless,omitempty"`
+	Args                       []string   `yaml:"args,omitempty"`
+	Verify                     *bool      `yaml:"verify,omitempty"`
+	Devel                      *bool      `yaml:"devel,omitempty"`
+	Wait                       *bool      `yaml:"wait,omitempty"`
+	WaitForJobs                *bool      `yaml:"waitForJobs,omitempty"`
+	Timeout                    *int       `yaml:"timeout,omitempty"`
+	RecreatePods               *bool      `yaml:"recreatePods,omitempty"`
+	Force                      *bool      `yaml:"force,omitempty"`
+	Atomic                     *bool      `yaml:"atomic,omitempty"`
+	CleanupOnFail              *bool      `yaml:"cleanupOnFail,omitempty"`
+	HistoryMax                 *int       `yaml:"historyMax,omitempty"`
+	CreateNamespace            *bool      `yaml:"createNamespace,omitempty"`
+	SkipDeps                   *bool      `yaml:"skipDeps,omitempty"`
+	MaxConcurrency             *int       `yaml:"maxConcurrency,omitempty"`
+	MaxConcurrencyPerContext   *int       `yaml:"maxConcurrencyPerContext,omitempty"`
+	MaxConcurrencyPerNamespace *int       `yaml:"maxConcurrencyPerNamespace,omitempty"`
+	Retry                      *RetrySpec `yaml:"retry,omitempty"`
+	LabelReleases              *bool      `yaml:"labelReleases,omitempty"`
+	StateName                  *string    `yaml:"stateName,omitempty"`
+	TLS                        *bool      `yaml:"tls,omitempty"`
+	TLSCACert                  *string    `yaml:"tlsCACert,omitempty"`
+	TLSKey                     *string    `yaml:"tlsKey,omitempty"`
+	TLSCert                    *string    `yaml:"tlsCert,omitempty"`
+	DisableValidation          *bool      `yaml:"disableValidation,omitempty"`
+	DisableOpenAPIValidation   *bool      `yaml:"disableOpenAPIValidation,omitempty"`
+}
+
+// overrideFields sets each non-nil field of src to the field of the same name of the struct dst points to.
+// The pointer is dereferenced unless the field of dst is a pointer as well.
+func overrideFields(dst interface{}, src interface{}) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src)
+
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if f.IsNil() {
+			continue
+		}
+
+		target := d.FieldByName(s.Type().Field(i).Name)
+		if f.Kind() == reflect.Ptr && target.Kind() != reflect.Ptr {
+			f = f.Elem()
+		}
+
+		target.Set(f)
+	}
+}
+
+// ResolveEnvironment returns the spec of the environment merged over the environments it inherits.
+// It returns false when the environment is not defined.
+func (st *HelmState) ResolveEnvironment(name string) (EnvironmentSpec, bool, error) {
+	spec, ok := st.Environments[name]
+	if !ok {
+		return spec, false, nil
+	}
+
+	resolved, err := st.resolveEnvironmentSpec(name, spec, []string{name})
+	if err != nil {
+		return spec, true, fmt.Errorf("failed to resolve inherits of environment \"%s\": %v", name, err)
+	}
+
+	return withKubeContext(resolved), true, nil
+}
+
+// resolveEnvironmentSpec recursively merges the spec over the environments it inherits.
+// chain is the names of the environments being resolved, used to detect inheritance cycles.
+func (st *HelmState) resolveEnvironmentSpec(name string, spec EnvironmentSpec, chain []string) (EnvironmentSpec, error) {
+	if len(spec.Inherits) == 0 {
+		return spec, nil
+	}
+
+	var merged EnvironmentSpec
+
+	for _, p := range spec.Inherits {
+		for _, n := range chain {
+			if n == p {
+				return spec, fmt.Errorf("inheritance cycle detected: %s -> %s", strings.Join(chain, " -> "), p)
+			}
+		}
+
+		parent, ok := st.Environments[p]
+		if !ok {
+			return spec, fmt.Errorf("environment \"%s\" inherited by \"%s\" is not defined", p, name)
+		}
+
+		parent, err := st.resolveEnvironmentSpec(p, parent, append(append([]string{}, chain...), p))
+		if err != nil {
+			return spec, err
+		}
+
+		merged = mergeEnvironmentSpecs(merged, parent)
+	}
+
+	merged = mergeEnvironmentSpecs(merged, spec)
+	merged.Inherits = spec.Inherits
+
+	return merged, nil
+}
+
+// mergeEnvironmentSpecs returns the child merged over the parent.
+// Values and secrets are appended so that they are deep-merged in order on load, and the other fields are overridden when set.
+func mergeEnvironmentSpecs(parent, child EnvironmentSpec) EnvironmentSpec {
+	parent, child = withKubeContext(parent), withKubeContext(child)
+
+	merged := parent
+
+	merged.Values = append(append([]interface{}{}, parent.Values...), child.Values...)
+	merged.Secrets = append(append([]string{}, parent.Secrets...), child.Secrets...)
+
+	if child.KubeContext != "" {
+		merged.KubeContext = child.KubeContext
+	}
+
+	if child.MissingFileHandler != nil {
+		merged.MissingFileHandler = child.MissingFileHandler
+	}
+
+	if child.HelmDefaults != nil {
+		overrides := HelmDefaultsOverrides{}
+		if parent.HelmDefaults != nil {
+			overrides = *parent.HelmDefaults
+		}
+		overrideFields(&overrides, *child.HelmDefaults)
+		merged.HelmDefaults = &overrides
+	}
+
+	return merged
+}
+
+// withKubeContext returns the spec with the kubeconfig context of the environment in KubeContext, that is either
+// `kubeContext` or `helmDefaults.kubeContext`, the former taking precedence.
+// Folding the two into one field makes the context set by an environment in either field take precedence over the
+// one it inherits.
+func withKubeContext(spec EnvironmentSpec) EnvironmentSpec {
+	if spec.HelmDefaults == nil || spec.HelmDefaults.KubeContext == nil {
+		return spec
+	}
+
+	overrides := *spec.HelmDefaults
+	if spec.KubeContext == "" {
+		spec.KubeContext = *overrides.KubeContext
+	}
+	overrides.KubeContext = nil
+	spec.HelmDefaults = &overrides
+
+	return spec
+}
+
+// overrideHelmDefaults returns the helmDefaults overridden by the environment's `helmDefaults` and `kubeContext`
+func overrideHelmDefaults(defaults HelmSpec, spec EnvironmentSpec) HelmSpec {
+	if spec.HelmDefaults != nil {
+		overrideFields(&defaults, *spec.HelmDefaults)
+	}
+
+	if spec.KubeContext != "" {
+		defaults.KubeContext = spec.KubeContext
+	}
+
+	return defaults
 }
@@ -0,0 +1,277 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/remote"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+func TestReadFromYaml_EnvironmentInherits(t *testing.T) {
+	yamlFile := "/example/path/to/helmfile.yaml"
+	yamlContent := []byte(`helmDefaults:
+  kubeContext: default
+  wait: true
+  timeout: 300
+
+environments:
+  base:
+    values:
+    - base.yaml
+    helmDefaults:
+      atomic: true
+      timeout: 100
+  region-eu:
+    kubeContext: eu
+    values:
+    - region: eu
+      image:
+        registry: eu.gcr.io
+  prod-eu:
+    inherits: [base, region-eu]
+    values:
+    - image:
+        tag: v2
+    helmDefaults:
+      wait: false
+
+releases:
+- name: myrelease
+  chart: mychart
+`)
+
+	testFs := testhelper.NewTestFs(map[string]string{
+		"/example/path/to/base.yaml": "image:\n  tag: v1\n  registry: gcr.io\nreplicas: 1\n",
+	})
+	testFs.Cwd = "/example/path/to"
+
+	r := remote.NewRemote(logger, testFs.Cwd, testFs.ReadFile, testFs.DirectoryExistsAt, testFs.FileExistsAt)
+
+	st, err := NewCreator(logger, testFs.ReadFile, testFs.FileExists, testFs.Abs, testFs.Glob, testFs.DirectoryExistsAt, nil, nil, "", r).
+		ParseAndLoad(yamlContent, filepath.Dir(yamlFile), yamlFile, "prod-eu", true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantValues := map[string]interface{}{
+		"image": map[string]interface{}{
+			"registry": "eu.gcr.io",
+			"tag":      "v2",
+		},
+		"region":   "eu",
+		"replicas": 1,
+	}
+
+	if d := cmp.Diff(wantValues, st.Env.Values); d != "" {
+		t.Errorf("unexpected environment values: want (-), got (+):\n%s", d)
+	}
+
+	wantDefaults := HelmSpec{
+		KubeContext: "eu",
+		Wait:        false,
+		Atomic:      true,
+		Timeout:     100,
+	}
+
+	if d := cmp.Diff(wantDefaults, st.HelmDefaults); d != "" {
+		t.Errorf("unexpected helmDefaults: want (-), got (+):\n%s", d)
+	}
+
+	spec, ok, err := st.ResolveEnvironment("prod-eu")
+	if err != nil || !ok {
+		t.Fatalf("unexpected result: ok=%v, err=%v", ok, err)
+	}
+
+	if d := cmp.Diff([]string{"base", "region-eu"}, spec.Inherits); d != "" {
+		t.Errorf("unexpected inherits: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestReadFromYaml_EnvironmentInheritsErrors(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "undefined environment",
+			content: `environments:
+  prod:
+    inherits: [base]
+`,
+			wantErr: `failed to resolve inherits of environment "prod": environment "base" inherited by "prod" is not defined`,
+		},
+		{
+			name: "cycle",
+			content: `environments:
+  prod:
+    inherits: [base]
+  base:
+    inherits: [common]
+  common:
+    inherits: [base]
+`,
+			wantErr: `failed to resolve inherits of environment "prod": inheritance cycle detected: prod -> base -> common -> base`,
+		},
+		{
+			name: "unknown helmDefaults field",
+			content: `environments:
+  prod:
+    helmDefaults:
+      wiat: true
+`,
+			wantErr: `field wiat not found in type state.HelmDefaultsOverrides`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := createFromYaml([]byte(tc.content), "/example/path/to/helmfile.yaml", "prod", logger)
+			if err == nil {
+				t.Fatalf("expected error %q, got none", tc.wantErr)
+			}
+
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("unexpected error: want %q, got %q", tc.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestOverrideHelmDefaults_ZeroValues(t *testing.T) {
+	f, zero, one := false, 0, 1
+	base := EnvironmentSpec{HelmDefaults: &HelmDefaultsOverrides{Atomic: &[]bool{true}[0], Timeout: &one, HistoryMax: &one}}
+	child := EnvironmentSpec{HelmDefaults: &HelmDefaultsOverrides{Atomic: &f, Timeout: &zero}}
+
+	merged := mergeEnvironmentSpecs(base, child)
+
+	got := overrideHelmDefaults(HelmSpec{Wait: true, Atomic: true, Timeout: 300}, merged)
+
+	want := HelmSpec{Wait: true, Atomic: false, Timeout: 0, HistoryMax: &one}
+
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected helmDefaults: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmDefaultsOverrides_FieldsOfHelmSpec(t *testing.T) {
+	overrides := reflect.TypeOf(HelmDefaultsOverrides{})
+	defaults := reflect.TypeOf(HelmSpec{})
+
+	// overrideFields sets each field of HelmDefaultsOverrides to the field of HelmSpec of the same name, that panics
+	// unless the field exists in HelmSpec with the type the override can be assigned to
+	for i := 0; i < overrides.NumField(); i++ {
+		o := overrides.Field(i)
+
+		d, ok := defaults.FieldByName(o.Name)
+		if !ok {
+			t.Errorf("field %s of HelmDefaultsOverrides is not found in HelmSpec", o.Name)
+			continue
+		}
+
+		typ := o.Type
+		if typ.Kind() == reflect.Ptr && d.Type.Kind() != reflect.Ptr {
+			typ = typ.Elem()
+		}
+
+		if !typ.AssignableTo(d.Type) {
+			t.Errorf("field %s of HelmDefaultsOverrides is %s, that is not assignable to %s of HelmSpec", o.Name, o.Type, d.Type)
+		}
+
+		oKey := strings.Split(o.Tag.Get("yaml"), ",")[0]
+		dKey := strings.Split(d.Tag.Get("yaml"), ",")[0]
+		if oKey != dKey {
+			t.Errorf("field %s of HelmDefaultsOverrides has the key %q, that differs from %q of HelmSpec", o.Name, oKey, dKey)
+		}
+	}
+}
+
+func TestReadFromYaml_EnvironmentInheritsKubeContext(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "helmDefaults of the environment over kubeContext of the inherited one",
+			content: `environments:
+  base:
+    kubeContext: base
+  prod:
+    inherits: [base]
+    helmDefaults:
+      kubeContext: prod
+`,
+			want: "prod",
+		},
+		{
+			name: "kubeContext of the environment over helmDefaults of the inherited one",
+			content: `environments:
+  base:
+    helmDefaults:
+      kubeContext: base
+  prod:
+    inherits: [base]
+    kubeContext: prod
+`,
+			want: "prod",
+		},
+		{
+			name: "kubeContext over helmDefaults of the same environment",
+			content: `environments:
+  base:
+    helmDefaults:
+      kubeContext: base
+  prod:
+    inherits: [base]
+    kubeContext: prod
+    helmDefaults:
+      kubeContext: prod-defaults
+`,
+			want: "prod",
+		},
+		{
+			name: "inherited",
+			content: `helmDefaults:
+  kubeContext: default
+environments:
+  base:
+    helmDefaults:
+      kubeContext: base
+  prod:
+    inherits: [base]
+`,
+			want: "base",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := createFromYaml([]byte(tc.content), "/example/path/to/helmfile.yaml", "prod", logger)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if st.HelmDefaults.KubeContext != tc.want {
+				t.Errorf("unexpected helmDefaults.kubeContext: want %q, got %q", tc.want, st.HelmDefaults.KubeContext)
+			}
+
+			if got := st.releaseKubeContext(&ReleaseSpec{Name: "foo"}); got != tc.want {
+				t.Errorf("unexpected kubeContext of the release: want %q, got %q", tc.want, got)
+			}
+
+			spec, _, err := st.ResolveEnvironment("prod")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if spec.KubeContext != tc.want {
+				t.Errorf("unexpected kubeContext of the environment: want %q, got %q", tc.want, spec.KubeContext)
+			}
+		})
+	}
+}
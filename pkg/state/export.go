@@ -0,0 +1,608 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/huolunl/helm/v3/pkg/strvals"
+	"github.com/imdario/mergo"
+	"gopkg.in/yaml.v2"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/manifest"
+	"github.com/huolunl/helmfile/pkg/maputil"
+)
+
+// Formats of `helmfile export`
+const (
+	// ExportArgoCD exports each release as an Argo CD Application
+	ExportArgoCD = "argocd"
+	// ExportFlux exports each release as a Flux HelmRelease, and each repository as a Flux HelmRepository or OCIRepository
+	ExportFlux = "flux"
+)
+
+// Ways to export the values of releases
+const (
+	// ExportValuesInline embeds the values in the exported resources
+	ExportValuesInline = "inline"
+	// ExportValuesConfigMap writes the values to a ConfigMap per release, that is referenced by the Flux HelmRelease
+	ExportValuesConfigMap = "configmap"
+)
+
+// exportFluxInterval is the interval Flux reconciles the exported resources at
+const exportFluxInterval = "10m"
+
+// ExportOpts is the options of ExportReleases
+type ExportOpts struct {
+	Format string
+	// Namespace is the namespace of the Argo CD Applications or the Flux sources
+	Namespace string
+	// ArgoCDProject is the Argo CD project the Applications belong to
+	ArgoCDProject string
+	// ArgoCDServer is the Kubernetes API server of the Applications of the releases without kubeContext
+	ArgoCDServer string
+	// ValuesAs is either ExportValuesInline or ExportValuesConfigMap
+	ValuesAs string
+	// IncludeSecrets allows exporting the decrypted secrets of the releases and the environment, and the secrets referenced
+	// by vals expressions like `ref+vault://...`, along with the values of the releases
+	IncludeSecrets bool
+}
+
+type exportMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type exportObject struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   exportMetadata `yaml:"metadata"`
+	Spec       interface{}    `yaml:"spec,omitempty"`
+	Data       interface{}    `yaml:"data,omitempty"`
+}
+
+type argoApplicationSpec struct {
+	Project     string          `yaml:"project"`
+	Source      argoSource      `yaml:"source"`
+	Destination argoDestination `yaml:"destination"`
+	SyncPolicy  *argoSyncPolicy `yaml:"syncPolicy,omitempty"`
+}
+
+type argoSource struct {
+	RepoURL        string   `yaml:"repoURL"`
+	Chart          string   `yaml:"chart"`
+	TargetRevision string   `yaml:"targetRevision"`
+	Helm           argoHelm `yaml:"helm"`
+}
+
+type argoHelm struct {
+	ReleaseName string `yaml:"releaseName"`
+	Values      string `yaml:"values,omitempty"`
+}
+
+type argoDestination struct {
+	Server    string `yaml:"server,omitempty"`
+	Name      string `yaml:"name,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type argoSyncPolicy struct {
+	SyncOptions []string `yaml:"syncOptions"`
+}
+
+type fluxHelmReleaseSpec struct {
+	ReleaseName string                 `yaml:"releaseName"`
+	Interval    string                 `yaml:"interval"`
+	Chart       *fluxChart             `yaml:"chart,omitempty"`
+	ChartRef    *fluxReference         `yaml:"chartRef,omitempty"`
+	Install     *fluxInstall           `yaml:"install,omitempty"`
+	DependsOn   []fluxReference        `yaml:"dependsOn,omitempty"`
+	Values      map[string]interface{} `yaml:"values,omitempty"`
+	ValuesFrom  []fluxValuesReference  `yaml:"valuesFrom,omitempty"`
+}
+
+type fluxChart struct {
+	Spec fluxChartSpec `yaml:"spec"`
+}
+
+type fluxChartSpec struct {
+	Chart     string        `yaml:"chart"`
+	Version   string        `yaml:"version"`
+	SourceRef fluxReference `yaml:"sourceRef"`
+}
+
+type fluxReference struct {
+	Kind      string `yaml:"kind,omitempty"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type fluxInstall struct {
+	CreateNamespace bool `yaml:"createNamespace"`
+}
+
+type fluxValuesReference struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	ValuesKey string `yaml:"valuesKey"`
+}
+
+type fluxHelmRepositorySpec struct {
+	URL      string `yaml:"url"`
+	Interval string `yaml:"interval"`
+}
+
+type fluxOCIRepositorySpec struct {
+	URL           string                 `yaml:"url"`
+	Interval      string                 `yaml:"interval"`
+	Ref           map[string]string      `yaml:"ref"`
+	LayerSelector map[string]interface{} `yaml:"layerSelector"`
+}
+
+// ExportReleases converts the releases to the resources of the GitOps tool specified by opts.Format.
+// The releases are expected to be a subset of the releases of the state with overrides applied,
+// and the needs of them are resolved against all the releases of the state.
+func (st *HelmState) ExportReleases(helm helmexec.Interface, releases []ReleaseSpec, opts ExportOpts) ([]manifest.Resource, error) {
+	all := st.GetReleasesWithOverrides()
+
+	byID := map[string]*ReleaseSpec{}
+	for i := range all {
+		byID[ReleaseToID(&all[i])] = &all[i]
+	}
+
+	waves, err := exportWaves(all)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []manifest.Resource
+
+	sources := map[string]bool{}
+
+	for i := range releases {
+		release := releases[i]
+
+		if !release.Desired() {
+			continue
+		}
+
+		st.ApplyOverrides(&release)
+
+		repo, chart, err := st.exportedChart(&release)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := st.exportedValues(helm, &release, i, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []exportObject
+
+		switch opts.Format {
+		case ExportArgoCD:
+			objects, err = st.argoCDApplication(&release, repo, chart, values, waves[ReleaseToID(&release)], opts)
+		case ExportFlux:
+			objects, err = st.fluxHelmRelease(&release, repo, chart, values, byID, sources, opts)
+		default:
+			err = fmt.Errorf("unsupported export format %q", opts.Format)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range objects {
+			bs, err := yaml.Marshal(o)
+			if err != nil {
+				return nil, err
+			}
+
+			resources = append(resources, manifest.Resource{
+				APIVersion: o.APIVersion,
+				Kind:       o.Kind,
+				Namespace:  o.Metadata.Namespace,
+				Name:       o.Metadata.Name,
+				Content:    strings.TrimSpace(string(bs)),
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+// exportedChart returns the repository and the name of the chart of the release
+func (st *HelmState) exportedChart(release *ReleaseSpec) (*RepositorySpec, string, error) {
+	if len(release.Dependencies) > 0 || len(release.JSONPatches) > 0 || len(release.StrategicMergePatches) > 0 || len(release.Transformers) > 0 || release.Directory != "" {
+		return nil, "", fmt.Errorf("release %q: the chart modified by helmfile can't be exported", release.Name)
+	}
+
+	repoName, chart, ok := resolveRemoteChart(release.Chart)
+	if !ok {
+		return nil, "", fmt.Errorf("release %q: chart %q must be in one of the repositories to be exported", release.Name, release.Chart)
+	}
+
+	for i := range st.Repositories {
+		if st.Repositories[i].Name == repoName {
+			return &st.Repositories[i], chart, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("release %q: repository %q isn't defined in repositories", release.Name, repoName)
+}
+
+// exportedValues returns the values of the release merged in the order helm merges them, including `set` entries
+func (st *HelmState) exportedValues(helm helmexec.Interface, release *ReleaseSpec, workerIndex int, opts ExportOpts) (map[string]interface{}, error) {
+	if !opts.IncludeSecrets {
+		if len(release.Secrets) > 0 {
+			return nil, fmt.Errorf("release %q has secrets, that are exported as plain text only with --include-secrets", release.Name)
+		}
+
+		// The decrypted secrets of the environment can reach the values via templates like `{{ .Values.password }}`
+		spec, ok, err := st.ResolveEnvironment(st.Env.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok && len(spec.Secrets) > 0 {
+			return nil, fmt.Errorf("release %q: environment %q has secrets, that can be exported as plain text via the values only with --include-secrets", release.Name, st.Env.Name)
+		}
+
+		// The values and the set entries are rendered with the evaluator refusing vals expressions, so that no secret is inlined
+		refusing := *st
+		refusing.valsRuntime = exportValsEvaluator{}
+		st = &refusing
+	}
+
+	files, err := st.generateValuesFiles(helm, release, workerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("release %q: %v", release.Name, err)
+	}
+	defer st.removeFiles(files)
+
+	merged, err := st.mergeValuesFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := maputil.CastKeysToStrings(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	setFlags, err := st.setFlags(release.SetValues)
+	if err != nil {
+		return nil, fmt.Errorf("release %q: %v", release.Name, err)
+	}
+
+	for i := 0; i+1 < len(setFlags); i += 2 {
+		switch setFlags[i] {
+		case "--set":
+			err = strvals.ParseInto(setFlags[i+1], values)
+		case "--set-file":
+			err = strvals.ParseIntoFile(setFlags[i+1], values, func(rs []rune) (interface{}, error) {
+				bs, err := ioutil.ReadFile(string(rs))
+				return string(bs), err
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("release %q: parsing %s: %v", release.Name, setFlags[i+1], err)
+		}
+	}
+
+	return values, nil
+}
+
+// exportValsEvaluator fails on vals expressions rather than evaluating them, as they would be exported as plain text
+type exportValsEvaluator struct{}
+
+func (e exportValsEvaluator) Eval(input map[string]interface{}) (map[string]interface{}, error) {
+	if ref := findValsRef(input); ref != "" {
+		return nil, fmt.Errorf("the secret referenced by %q is exported as plain text only with --include-secrets", ref)
+	}
+
+	// The string slices are returned as []interface{} as vals does
+	output := map[string]interface{}{}
+	for k, v := range input {
+		if ss, ok := v.([]string); ok {
+			items := make([]interface{}, len(ss))
+			for i := range ss {
+				items[i] = ss[i]
+			}
+			v = items
+		}
+		output[k] = v
+	}
+
+	return output, nil
+}
+
+// findValsRef returns the first string containing a vals expression like `ref+vault://...` in the value
+func findValsRef(v interface{}) string {
+	switch typed := v.(type) {
+	case string:
+		if strings.Contains(typed, "ref+") {
+			return typed
+		}
+	case []string:
+		for _, s := range typed {
+			if ref := findValsRef(s); ref != "" {
+				return ref
+			}
+		}
+	case []interface{}:
+		for _, e := range typed {
+			if ref := findValsRef(e); ref != "" {
+				return ref
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range typed {
+			if ref := findValsRef(e); ref != "" {
+				return ref
+			}
+		}
+	case map[interface{}]interface{}:
+		for _, e := range typed {
+			if ref := findValsRef(e); ref != "" {
+				return ref
+			}
+		}
+	}
+
+	return ""
+}
+
+// mergeValuesFiles merges the values files, the latter overriding the former like helm does
+func (st *HelmState) mergeValuesFiles(files []string) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+
+	for _, f := range files {
+		src := map[string]interface{}{}
+
+		srcBytes, err := st.readFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f, err)
+		}
+
+		if err := yaml.Unmarshal(srcBytes, &src); err != nil {
+			return nil, fmt.Errorf("unmarshalling yaml %s: %w", f, err)
+		}
+
+		if err := mergo.Merge(&merged, &src, mergo.WithOverride, mergo.WithOverwriteWithEmptyValue); err != nil {
+			return nil, fmt.Errorf("merging %s: %w", f, err)
+		}
+	}
+
+	return merged, nil
+}
+
+func (st *HelmState) exportCreateNamespace(release *ReleaseSpec) bool {
+	if release.CreateNamespace != nil {
+		return *release.CreateNamespace
+	}
+
+	return st.HelmDefaults.CreateNamespace == nil || *st.HelmDefaults.CreateNamespace
+}
+
+func (st *HelmState) argoCDApplication(release *ReleaseSpec, repo *RepositorySpec, chart string, values map[string]interface{}, wave int, opts ExportOpts) ([]exportObject, error) {
+	if opts.ValuesAs == ExportValuesConfigMap {
+		return nil, fmt.Errorf("values can't be exported as ConfigMaps for Argo CD, that supports inline values only")
+	}
+
+	spec := argoApplicationSpec{
+		Project: opts.ArgoCDProject,
+		Source: argoSource{
+			RepoURL:        repo.URL,
+			Chart:          chart,
+			TargetRevision: exportedVersion(release),
+			Helm:           argoHelm{ReleaseName: release.Name},
+		},
+		Destination: argoDestination{Namespace: release.Namespace},
+	}
+
+	if len(values) > 0 {
+		bs, err := yaml.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		spec.Source.Helm.Values = string(bs)
+	}
+
+	// Argo CD refers to the clusters by name, that are expected to be registered with the same names as the kubeContexts
+	if release.KubeContext != "" {
+		spec.Destination.Name = release.KubeContext
+	} else {
+		spec.Destination.Server = opts.ArgoCDServer
+	}
+
+	if st.exportCreateNamespace(release) {
+		spec.SyncPolicy = &argoSyncPolicy{SyncOptions: []string{"CreateNamespace=true"}}
+	}
+
+	metadata := exportMetadata{Name: release.Name, Namespace: opts.Namespace}
+	if wave > 0 {
+		metadata.Annotations = map[string]string{"argocd.argoproj.io/sync-wave": fmt.Sprintf("%d", wave)}
+	}
+
+	return []exportObject{{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Application",
+		Metadata:   metadata,
+		Spec:       spec,
+	}}, nil
+}
+
+func (st *HelmState) fluxHelmRelease(release *ReleaseSpec, repo *RepositorySpec, chart string, values map[string]interface{}, byID map[string]*ReleaseSpec, sources map[string]bool, opts ExportOpts) ([]exportObject, error) {
+	if release.KubeContext != "" {
+		st.logger.Warnf("release %q: kubeContext %q is ignored, as Flux installs the release to the cluster it runs in", release.Name, release.KubeContext)
+	}
+
+	var objects []exportObject
+
+	namespace := fluxReleaseNamespace(release)
+
+	spec := fluxHelmReleaseSpec{
+		ReleaseName: release.Name,
+		Interval:    exportFluxInterval,
+	}
+
+	if st.exportCreateNamespace(release) {
+		spec.Install = &fluxInstall{CreateNamespace: true}
+	}
+
+	if repo.OCI {
+		// An OCIRepository points to a chart rather than a repository, so it's exported per release
+		spec.ChartRef = &fluxReference{Kind: "OCIRepository", Name: release.Name, Namespace: namespace}
+
+		objects = append(objects, exportObject{
+			APIVersion: "source.toolkit.fluxcd.io/v1beta2",
+			Kind:       "OCIRepository",
+			Metadata:   exportMetadata{Name: release.Name, Namespace: namespace},
+			Spec: fluxOCIRepositorySpec{
+				URL:      "oci://" + strings.TrimSuffix(repo.URL, "/") + "/" + chart,
+				Interval: exportFluxInterval,
+				Ref:      map[string]string{"semver": exportedVersion(release)},
+				LayerSelector: map[string]interface{}{
+					"mediaType": "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+					"operation": "copy",
+				},
+			},
+		})
+	} else {
+		spec.Chart = &fluxChart{Spec: fluxChartSpec{
+			Chart:     chart,
+			Version:   exportedVersion(release),
+			SourceRef: fluxReference{Kind: "HelmRepository", Name: repo.Name, Namespace: opts.Namespace},
+		}}
+
+		if !sources[repo.Name] {
+			sources[repo.Name] = true
+
+			objects = append(objects, exportObject{
+				APIVersion: "source.toolkit.fluxcd.io/v1",
+				Kind:       "HelmRepository",
+				Metadata:   exportMetadata{Name: repo.Name, Namespace: opts.Namespace},
+				Spec:       fluxHelmRepositorySpec{URL: repo.URL, Interval: exportFluxInterval},
+			})
+		}
+	}
+
+	for _, id := range release.Needs {
+		need, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("release %q needs %q that isn't defined in %s", release.Name, id, st.FilePath)
+		}
+
+		spec.DependsOn = append(spec.DependsOn, fluxReference{Name: need.Name, Namespace: fluxReleaseNamespace(need)})
+	}
+
+	if len(values) > 0 {
+		switch opts.ValuesAs {
+		case ExportValuesConfigMap:
+			bs, err := yaml.Marshal(values)
+			if err != nil {
+				return nil, err
+			}
+
+			name := release.Name + "-values"
+
+			objects = append(objects, exportObject{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Metadata:   exportMetadata{Name: name, Namespace: namespace},
+				Data:       map[string]string{"values.yaml": string(bs)},
+			})
+
+			spec.ValuesFrom = []fluxValuesReference{{Kind: "ConfigMap", Name: name, ValuesKey: "values.yaml"}}
+		default:
+			spec.Values = values
+		}
+	}
+
+	objects = append(objects, exportObject{
+		APIVersion: "helm.toolkit.fluxcd.io/v2",
+		Kind:       "HelmRelease",
+		Metadata:   exportMetadata{Name: release.Name, Namespace: namespace},
+		Spec:       spec,
+	})
+
+	return objects, nil
+}
+
+// fluxReleaseNamespace returns the namespace of the Flux HelmRelease, that is the namespace the release is installed to
+func fluxReleaseNamespace(release *ReleaseSpec) string {
+	if release.Namespace != "" {
+		return release.Namespace
+	}
+
+	return "default"
+}
+
+func exportedVersion(release *ReleaseSpec) string {
+	if release.Version != "" {
+		return release.Version
+	}
+
+	return "*"
+}
+
+// exportWaves returns the Argo CD sync wave of each release by the ID, that is the length of the longest chain of its needs
+func exportWaves(releases []ReleaseSpec) (map[string]int, error) {
+	needs := map[string][]string{}
+	for i := range releases {
+		needs[ReleaseToID(&releases[i])] = releases[i].Needs
+	}
+
+	waves := map[string]int{}
+	visiting := map[string]bool{}
+
+	var wave func(id string, path []string) (int, error)
+	wave = func(id string, path []string) (int, error) {
+		if w, ok := waves[id]; ok {
+			return w, nil
+		}
+
+		if visiting[id] {
+			return 0, fmt.Errorf("found a cycle in needs: %s", strings.Join(append(path, id), " -> "))
+		}
+		visiting[id] = true
+
+		var w int
+
+		for _, n := range needs[id] {
+			if _, ok := needs[n]; !ok {
+				continue
+			}
+
+			nw, err := wave(n, append(path, id))
+			if err != nil {
+				return 0, err
+			}
+
+			if nw+1 > w {
+				w = nw + 1
+			}
+		}
+
+		waves[id] = w
+
+		return w, nil
+	}
+
+	var ids []string
+	for id := range needs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if _, err := wave(id, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return waves, nil
+}
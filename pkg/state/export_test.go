@@ -0,0 +1,392 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/environment"
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/manifest"
+)
+
+func TestHelmState_ExportReleases(t *testing.T) {
+	newState := func() *HelmState {
+		st := &HelmState{
+			ReleaseSetSpec: ReleaseSetSpec{
+				Repositories: []RepositorySpec{
+					{Name: "stable", URL: "https://charts.example.com"},
+					{Name: "registry", URL: "registry.example.com/charts", OCI: true},
+				},
+				Releases: []ReleaseSpec{
+					{
+						Name:      "db",
+						Namespace: "data",
+						Chart:     "registry/postgres",
+						Version:   "12.1.0",
+					},
+					{
+						Name:      "web",
+						Namespace: "apps",
+						Chart:     "stable/web",
+						Version:   "~1.2.0",
+						Needs:     []string{"data/db"},
+						Values:    []interface{}{map[interface{}]interface{}{"image": map[interface{}]interface{}{"tag": "v1", "repository": "web"}}},
+						SetValues: []SetValue{{Name: "image.tag", Value: "v2"}, {Name: "hosts", Values: []string{"a", "b"}}},
+					},
+					{
+						Name:      "worker",
+						Namespace: "apps",
+						Chart:     "stable/worker",
+						Needs:     []string{"web"},
+					},
+					{
+						Name:        "metrics",
+						Namespace:   "monitoring",
+						KubeContext: "prod",
+						Chart:       "stable/metrics",
+					},
+				},
+			},
+			logger:            logger,
+			valsRuntime:       valsRuntime,
+			RenderedValues:    map[string]interface{}{},
+			readFile:          ioutil.ReadFile,
+			fileExists:        func(string) (bool, error) { return false, nil },
+			directoryExistsAt: func(string) bool { return false },
+			removeFile:        os.Remove,
+		}
+
+		return st
+	}
+
+	export := func(t *testing.T, opts ExportOpts, names ...string) string {
+		t.Helper()
+
+		st := newState()
+
+		var releases []ReleaseSpec
+		for _, r := range st.GetReleasesWithOverrides() {
+			for _, n := range names {
+				if r.Name == n {
+					releases = append(releases, r)
+				}
+			}
+		}
+
+		resources, err := st.ExportReleases(&exectest.Helm{Helm3: true}, releases, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		manifest.Sort(resources)
+
+		return manifest.Format(resources)
+	}
+
+	t.Run("argocd", func(t *testing.T) {
+		got := export(t, ExportOpts{
+			Format:        ExportArgoCD,
+			Namespace:     "argocd",
+			ArgoCDProject: "default",
+			ArgoCDServer:  "https://kubernetes.default.svc",
+			ValuesAs:      ExportValuesInline,
+		}, "web", "worker", "metrics")
+
+		want := `---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: metrics
+  namespace: argocd
+spec:
+  project: default
+  source:
+    repoURL: https://charts.example.com
+    chart: metrics
+    targetRevision: '*'
+    helm:
+      releaseName: metrics
+  destination:
+    name: prod
+    namespace: monitoring
+  syncPolicy:
+    syncOptions:
+    - CreateNamespace=true
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: web
+  namespace: argocd
+  annotations:
+    argocd.argoproj.io/sync-wave: "1"
+spec:
+  project: default
+  source:
+    repoURL: https://charts.example.com
+    chart: web
+    targetRevision: ~1.2.0
+    helm:
+      releaseName: web
+      values: |
+        hosts:
+        - a
+        - b
+        image:
+          repository: web
+          tag: v2
+  destination:
+    server: https://kubernetes.default.svc
+    namespace: apps
+  syncPolicy:
+    syncOptions:
+    - CreateNamespace=true
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: worker
+  namespace: argocd
+  annotations:
+    argocd.argoproj.io/sync-wave: "2"
+spec:
+  project: default
+  source:
+    repoURL: https://charts.example.com
+    chart: worker
+    targetRevision: '*'
+    helm:
+      releaseName: worker
+  destination:
+    server: https://kubernetes.default.svc
+    namespace: apps
+  syncPolicy:
+    syncOptions:
+    - CreateNamespace=true
+`
+
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("unexpected resources: want (-), got (+):\n%s", d)
+		}
+	})
+
+	t.Run("flux", func(t *testing.T) {
+		got := export(t, ExportOpts{
+			Format:    ExportFlux,
+			Namespace: "flux-system",
+			ValuesAs:  ExportValuesConfigMap,
+		}, "db", "web")
+
+		want := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-values
+  namespace: apps
+data:
+  values.yaml: |
+    hosts:
+    - a
+    - b
+    image:
+      repository: web
+      tag: v2
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: web
+  namespace: apps
+spec:
+  releaseName: web
+  interval: 10m
+  chart:
+    spec:
+      chart: web
+      version: ~1.2.0
+      sourceRef:
+        kind: HelmRepository
+        name: stable
+        namespace: flux-system
+  install:
+    createNamespace: true
+  dependsOn:
+  - name: db
+    namespace: data
+  valuesFrom:
+  - kind: ConfigMap
+    name: web-values
+    valuesKey: values.yaml
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: db
+  namespace: data
+spec:
+  releaseName: db
+  interval: 10m
+  chartRef:
+    kind: OCIRepository
+    name: db
+    namespace: data
+  install:
+    createNamespace: true
+---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: stable
+  namespace: flux-system
+spec:
+  url: https://charts.example.com
+  interval: 10m
+---
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: OCIRepository
+metadata:
+  name: db
+  namespace: data
+spec:
+  url: oci://registry.example.com/charts/postgres
+  interval: 10m
+  ref:
+    semver: 12.1.0
+  layerSelector:
+    mediaType: application/vnd.cncf.helm.chart.content.v1.tar+gzip
+    operation: copy
+`
+
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("unexpected resources: want (-), got (+):\n%s", d)
+		}
+	})
+}
+
+func TestHelmState_ExportReleases_Errors(t *testing.T) {
+	testcases := []struct {
+		name         string
+		release      ReleaseSpec
+		environments map[string]EnvironmentSpec
+		opts         ExportOpts
+		want         string
+	}{
+		{
+			name:    "local chart",
+			release: ReleaseSpec{Name: "foo", Chart: "./charts/foo"},
+			opts:    ExportOpts{Format: ExportFlux},
+			want:    `release "foo": chart "./charts/foo" must be in one of the repositories to be exported`,
+		},
+		{
+			name:    "undefined repository",
+			release: ReleaseSpec{Name: "foo", Chart: "incubator/foo"},
+			opts:    ExportOpts{Format: ExportFlux},
+			want:    `release "foo": repository "incubator" isn't defined in repositories`,
+		},
+		{
+			name:    "secrets",
+			release: ReleaseSpec{Name: "foo", Chart: "stable/foo", Secrets: []interface{}{"secrets.yaml"}},
+			opts:    ExportOpts{Format: ExportFlux},
+			want:    `release "foo" has secrets, that are exported as plain text only with --include-secrets`,
+		},
+		{
+			name:         "environment secrets",
+			release:      ReleaseSpec{Name: "foo", Chart: "stable/foo"},
+			environments: map[string]EnvironmentSpec{"prod": {Secrets: []string{"secrets.yaml"}}},
+			opts:         ExportOpts{Format: ExportFlux},
+			want:         `release "foo": environment "prod" has secrets, that can be exported as plain text via the values only with --include-secrets`,
+		},
+		{
+			name:    "vals expression in values",
+			release: ReleaseSpec{Name: "foo", Chart: "stable/foo", Values: []interface{}{map[string]interface{}{"password": "ref+echo://secret"}}},
+			opts:    ExportOpts{Format: ExportFlux},
+			want:    `release "foo": the secret referenced by "ref+echo://secret" is exported as plain text only with --include-secrets`,
+		},
+		{
+			name:    "vals expression in set",
+			release: ReleaseSpec{Name: "foo", Chart: "stable/foo", SetValues: []SetValue{{Name: "password", Value: "ref+echo://secret"}}},
+			opts:    ExportOpts{Format: ExportFlux},
+			want:    `release "foo": the secret referenced by "ref+echo://secret" is exported as plain text only with --include-secrets`,
+		},
+		{
+			name:    "undefined needs",
+			release: ReleaseSpec{Name: "foo", Chart: "stable/foo", Needs: []string{"bar"}},
+			opts:    ExportOpts{Format: ExportFlux},
+			want:    `release "foo" needs "bar" that isn't defined in helmfile.yaml`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &HelmState{
+				FilePath: "helmfile.yaml",
+				ReleaseSetSpec: ReleaseSetSpec{
+					Repositories: []RepositorySpec{{Name: "stable", URL: "https://charts.example.com"}},
+					Releases:     []ReleaseSpec{tc.release},
+					Environments: tc.environments,
+					Env:          environment.Environment{Name: "prod"},
+				},
+				logger:            logger,
+				valsRuntime:       valsRuntime,
+				RenderedValues:    map[string]interface{}{},
+				readFile:          ioutil.ReadFile,
+				fileExists:        func(string) (bool, error) { return false, nil },
+				directoryExistsAt: func(string) bool { return false },
+				removeFile:        os.Remove,
+			}
+
+			_, err := st.ExportReleases(&exectest.Helm{Helm3: true}, st.GetReleasesWithOverrides(), tc.opts)
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("unexpected error: want %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestExportWaves_Cycle(t *testing.T) {
+	_, err := exportWaves([]ReleaseSpec{
+		{Name: "a", Needs: []string{"b"}},
+		{Name: "b", Needs: []string{"a"}},
+	})
+
+	want := "found a cycle in needs: a -> b -> a"
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want %q, got %v", want, err)
+	}
+}
+
+func TestHelmState_ExportReleases_IncludeSecrets(t *testing.T) {
+	st := &HelmState{
+		FilePath: "helmfile.yaml",
+		ReleaseSetSpec: ReleaseSetSpec{
+			Repositories: []RepositorySpec{{Name: "stable", URL: "https://charts.example.com"}},
+			Releases: []ReleaseSpec{{
+				Name:      "foo",
+				Chart:     "stable/foo",
+				Values:    []interface{}{map[string]interface{}{"password": "ref+echo://secret"}},
+				SetValues: []SetValue{{Name: "token", Value: "ref+echo://token"}},
+			}},
+		},
+		logger:            logger,
+		valsRuntime:       valsRuntime,
+		RenderedValues:    map[string]interface{}{},
+		readFile:          ioutil.ReadFile,
+		fileExists:        func(string) (bool, error) { return false, nil },
+		directoryExistsAt: func(string) bool { return false },
+		removeFile:        os.Remove,
+	}
+
+	resources, err := st.ExportReleases(&exectest.Helm{Helm3: true}, st.GetReleasesWithOverrides(), ExportOpts{Format: ExportFlux, IncludeSecrets: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := manifest.Format(resources)
+	if !strings.Contains(got, "password: secret") || !strings.Contains(got, "token: token") {
+		t.Errorf("expected the vals expressions to be evaluated with --include-secrets:\n%s", got)
+	}
+}
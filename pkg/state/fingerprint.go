@@ -0,0 +1,261 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+// DefaultFingerprintFile is the default path to the file storing the fingerprints of the releases for `apply --incremental`
+const DefaultFingerprintFile = ".helmfile/fingerprints.json"
+
+// FingerprintStore stores the fingerprints of the releases as of the last successful apply, in a local JSON file.
+//
+// A fingerprint is the hash of the chart and the values of a release, so that the release whose fingerprint
+// is unchanged since the last apply is known to have no changes without running helm-diff.
+type FingerprintStore struct {
+	Path string
+
+	mu sync.Mutex
+	// saved is the fingerprints loaded from the file, keyed by fingerprintKey
+	saved map[string]string
+	// observed is the fingerprints computed in this run, that are saved only for the releases applied successfully
+	observed map[string]string
+}
+
+// NewFingerprintStore returns the store backed by the file at the path, that is created on the first save
+func NewFingerprintStore(path string) *FingerprintStore {
+	return &FingerprintStore{
+		Path:     path,
+		observed: map[string]string{},
+	}
+}
+
+func (s *FingerprintStore) load() error {
+	if s.saved != nil {
+		return nil
+	}
+
+	saved := map[string]string{}
+
+	bs, err := ioutil.ReadFile(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(bs) > 0 {
+		if err := json.Unmarshal(bs, &saved); err != nil {
+			return fmt.Errorf("reading fingerprints from %s: %v", s.Path, err)
+		}
+	}
+
+	s.saved = saved
+
+	return nil
+}
+
+// observe records the fingerprint computed in this run, and returns true when it matches the saved one
+func (s *FingerprintStore) observe(key, fingerprint string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	s.observed[key] = fingerprint
+
+	return s.saved[key] == fingerprint, nil
+}
+
+// save saves the observed fingerprints of the keys, and forgets the fingerprints of the deleted keys
+func (s *FingerprintStore) save(keys []string, deleted []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if fp, ok := s.observed[k]; ok {
+			s.saved[k] = fp
+		}
+	}
+
+	for _, k := range deleted {
+		delete(s.saved, k)
+	}
+
+	bs, err := json.MarshalIndent(s.saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(s.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(s.Path, append(bs, '\n'), 0644)
+}
+
+// SaveFingerprints saves the fingerprints of the releases applied successfully or found unchanged,
+// and forgets the fingerprints of the deleted releases so that they are diffed once reinstalled
+func (st *HelmState) SaveFingerprints(store *FingerprintStore, applied []ReleaseSpec, deleted []ReleaseSpec) error {
+	keys := func(releases []ReleaseSpec) []string {
+		var ks []string
+		for i := range releases {
+			r := releases[i]
+			st.ApplyOverrides(&r)
+			ks = append(ks, st.fingerprintKey(&r))
+		}
+		return ks
+	}
+
+	return store.save(keys(applied), keys(deleted))
+}
+
+// fingerprintKey returns the key to identify the release across helmfile runs, that includes the kube context
+// even if the release doesn't specify one
+func (st *HelmState) fingerprintKey(release *ReleaseSpec) string {
+	r := *release
+	r.KubeContext = st.releaseKubeContext(release)
+	return ReleaseToID(&r)
+}
+
+// releaseFingerprint returns the fingerprint of the release to be diffed with the flags,
+// that is the hash of the chart digest and the hash of the values files and the flags.
+// An empty string is returned when any of the values files or the chart is unreadable.
+func (st *HelmState) releaseFingerprint(release *ReleaseSpec, flags []string) string {
+	var files []string
+	for i := 0; i < len(flags)-1; i++ {
+		if flags[i] == "--values" {
+			files = append(files, flags[i+1])
+		}
+	}
+
+	valuesHash := st.hashValues(files, flags)
+	if valuesHash == "" {
+		return ""
+	}
+
+	chartDigest, err := st.chartDigest(release)
+	if err != nil {
+		st.logger.Debugf("computing the digest of chart %s: %v", release.Chart, err)
+		return ""
+	}
+
+	fingerprint, err := HashObject([]string{chartDigest, valuesHash})
+	if err != nil {
+		return ""
+	}
+
+	return fingerprint
+}
+
+// chartDigest returns the SHA-256 of the files of the local chart, or the digest of the remote chart.
+// The digest of the remote chart is the one pulled from the OCI registry, or the hash of the chart name and version.
+// It fails when the version of the remote chart isn't exact, like `~1.0` or `latest`, as the version can be resolved to
+// a new chart on every run.
+func (st *HelmState) chartDigest(release *ReleaseSpec) (string, error) {
+	if release.ChartDigest != "" {
+		return release.ChartDigest, nil
+	}
+
+	chart := normalizeChart(st.basePath, release.Chart)
+
+	if !st.directoryExistsAt(chart) {
+		if exists, _ := st.fileExists(chart); exists {
+			bs, err := st.readFile(chart)
+			if err != nil {
+				return "", err
+			}
+			sum := sha256.Sum256(bs)
+			return hex.EncodeToString(sum[:]), nil
+		}
+
+		if _, err := semver.StrictNewVersion(strings.TrimPrefix(release.Version, "v")); err != nil {
+			return "", fmt.Errorf("version %q of the remote chart is not exact", release.Version)
+		}
+
+		return HashObject([]string{chart, release.Version})
+	}
+
+	h := sha256.New()
+
+	err := filepath.Walk(chart, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(chart, path)
+		if err != nil {
+			return err
+		}
+
+		bs, err := st.readFile(path)
+		if err != nil {
+			return err
+		}
+
+		h.Write([]byte(rel))
+		h.Write([]byte{0})
+		h.Write(bs)
+		h.Write([]byte{0})
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// unchangedSinceLastApply records the fingerprint of the release to the store in the options,
+// and returns true when the diff can be skipped as the release is installed and unchanged since the last apply
+func (st *HelmState) unchangedSinceLastApply(helm helmexec.Interface, release *ReleaseSpec, flags []string, workerIndex int, opts *DiffOpts) bool {
+	if opts.Fingerprints == nil {
+		return false
+	}
+
+	fingerprint := st.releaseFingerprint(release, flags)
+	if fingerprint == "" {
+		return false
+	}
+
+	unchanged, err := opts.Fingerprints.observe(st.fingerprintKey(release), fingerprint)
+	if err != nil {
+		st.logger.Warnf("warn: %v", err)
+		return false
+	}
+
+	if !unchanged || opts.ForceDiff {
+		return false
+	}
+
+	// The release might have been deleted by other than `helmfile apply`
+	installed, err := st.isReleaseInstalled(st.createHelmContext(release, workerIndex), helm, *release)
+	if err != nil {
+		st.logger.Debugf("confirming if the release is already installed or not: %v", err)
+		return false
+	}
+
+	return installed
+}
@@ -0,0 +1,169 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+func TestFingerprintStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-fingerprints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".helmfile", "fingerprints.json")
+
+	store := NewFingerprintStore(path)
+
+	for _, kv := range [][]string{{"ctx/ns/a", "1"}, {"ctx/ns/b", "2"}, {"ctx/ns/c", "3"}} {
+		if unchanged, err := store.observe(kv[0], kv[1]); err != nil || unchanged {
+			t.Fatalf("unexpected result for %s: unchanged=%v, err=%v", kv[0], unchanged, err)
+		}
+	}
+
+	// c is not saved as it has not been applied
+	if err := store.save([]string{"ctx/ns/a", "ctx/ns/b"}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store = NewFingerprintStore(path)
+
+	testcases := []struct {
+		key, fingerprint string
+		want             bool
+	}{
+		{"ctx/ns/a", "1", true},
+		{"ctx/ns/b", "changed", false},
+		{"ctx/ns/c", "3", false},
+	}
+
+	for _, tc := range testcases {
+		unchanged, err := store.observe(tc.key, tc.fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if unchanged != tc.want {
+			t.Errorf("unexpected result for %s: want %v, got %v", tc.key, tc.want, unchanged)
+		}
+	}
+
+	if err := store.save([]string{"ctx/ns/b"}, []string{"ctx/ns/a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "ctx/ns/b": "changed"
+}
+`
+
+	if d := cmp.Diff(want, string(bs)); d != "" {
+		t.Errorf("unexpected fingerprints: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_DiffReleases_Fingerprints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-fingerprints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFingerprintStore(filepath.Join(dir, "fingerprints.json"))
+
+	newState := func(image string) *HelmState {
+		return &HelmState{
+			ReleaseSetSpec: ReleaseSetSpec{
+				HelmDefaults: HelmSpec{KubeContext: "default"},
+				Releases: []ReleaseSpec{
+					{Name: "foo", Chart: "stable/foo", Version: "1.0.0", Namespace: "apps", SetValues: []SetValue{{Name: "image", Value: image}}},
+					{Name: "bar", Chart: "stable/bar", Namespace: "apps"},
+					// The chart of the version range can be resolved to a new version on every run
+					{Name: "baz", Chart: "stable/baz", Version: "~1.0", Namespace: "apps"},
+					{Name: "qux", Chart: "oci://registry.example.com/charts/qux", Version: "latest", ChartDigest: "sha256:" + strings.Repeat("0", 64), Namespace: "apps"},
+				},
+			},
+			logger:            logger,
+			valsRuntime:       valsRuntime,
+			RenderedValues:    map[string]interface{}{},
+			readFile:          ioutil.ReadFile,
+			fileExists:        func(string) (bool, error) { return false, nil },
+			directoryExistsAt: func(string) bool { return false },
+		}
+	}
+
+	newHelm := func() *exectest.Helm {
+		return &exectest.Helm{
+			Helm3: true,
+			Lists: map[exectest.ListKey]string{
+				{Filter: "^foo$", Flags: "--kube-contextdefault--namespaceapps--uninstalling--deployed--failed--pending"}: "foo 1 deployed",
+				{Filter: "^bar$", Flags: "--kube-contextdefault--namespaceapps--uninstalling--deployed--failed--pending"}: "",
+				{Filter: "^baz$", Flags: "--kube-contextdefault--namespaceapps--uninstalling--deployed--failed--pending"}: "baz 1 deployed",
+				{Filter: "^qux$", Flags: "--kube-contextdefault--namespaceapps--uninstalling--deployed--failed--pending"}: "qux 1 deployed",
+			},
+		}
+	}
+
+	diffed := func(helm *exectest.Helm) []string {
+		var names []string
+		for _, r := range helm.Diffed {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	// The first apply diffs all the releases
+	st := newState("v1")
+	helm := newHelm()
+	if _, errs := st.DiffReleases(helm, nil, 1, false, false, nil, false, false, false, false, &DiffOpts{Fingerprints: store}); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := cmp.Diff([]string{"foo", "bar", "baz", "qux"}, diffed(helm)); d != "" {
+		t.Errorf("unexpected diffs: want (-), got (+):\n%s", d)
+	}
+	if err := st.SaveFingerprints(store, st.Releases, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unchanged releases are skipped unless they are not installed or their charts can be changed
+	st = newState("v1")
+	helm = newHelm()
+	if _, errs := st.DiffReleases(helm, nil, 1, false, false, nil, false, false, false, false, &DiffOpts{Fingerprints: store}); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := cmp.Diff([]string{"bar", "baz"}, diffed(helm)); d != "" {
+		t.Errorf("unexpected diffs: want (-), got (+):\n%s", d)
+	}
+
+	// --force-diff diffs unchanged releases
+	st = newState("v1")
+	helm = newHelm()
+	if _, errs := st.DiffReleases(helm, nil, 1, false, false, nil, false, false, false, false, &DiffOpts{Fingerprints: store, ForceDiff: true}); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := cmp.Diff([]string{"foo", "bar", "baz", "qux"}, diffed(helm)); d != "" {
+		t.Errorf("unexpected diffs: want (-), got (+):\n%s", d)
+	}
+
+	// Changed values are diffed
+	st = newState("v2")
+	helm = newHelm()
+	if _, errs := st.DiffReleases(helm, nil, 1, false, false, nil, false, false, false, false, &DiffOpts{Fingerprints: store}); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := cmp.Diff([]string{"foo", "bar", "baz"}, diffed(helm)); d != "" {
+		t.Errorf("unexpected diffs: want (-), got (+):\n%s", d)
+	}
+}
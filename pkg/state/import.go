@@ -0,0 +1,197 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/maputil"
+)
+
+// ImportOpts is the options of ImportReleases
+type ImportOpts struct {
+	// KubeContexts are the kube contexts to import the releases from. The default kube context of the helmfile is used when empty.
+	KubeContexts []string
+	// Namespaces are the namespaces to import the releases from. All the namespaces are imported when empty.
+	Namespaces []string
+	// Declared is the set of the DeployedReleaseIDs of the releases declared in the helmfiles, that are not imported
+	Declared map[string]bool
+}
+
+// ImportedRelease is a release installed in the cluster, converted to the release of the helmfile
+type ImportedRelease struct {
+	ReleaseSpec
+	// Repository is the repository the chart is found in, that is nil when it isn't found in any of the repositories
+	Repository *RepositorySpec
+	// UserValues are the values supplied on install or upgrade, as returned by `helm get values`
+	UserValues map[string]interface{}
+}
+
+// helmSearchedChart is an item of the output of `helm search repo --output json`
+type helmSearchedChart struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ImportReleases lists the releases installed in the kube contexts and namespaces, and converts each of them to the release
+// whose chart is in one of the repositories.
+// The repositories are expected to be added to helm beforehand, so that the charts can be searched.
+func (st *HelmState) ImportReleases(helm helmexec.Interface, repositories []RepositorySpec, opts ImportOpts) ([]ImportedRelease, error) {
+	if !helm.IsHelm3() {
+		return nil, errors.New("helmfile import requires helm 3")
+	}
+
+	kubeContexts := opts.KubeContexts
+	if len(kubeContexts) == 0 {
+		kubeContexts = []string{""}
+	}
+
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	var imported []ImportedRelease
+
+	for _, kubeContext := range kubeContexts {
+		for _, namespace := range namespaces {
+			target := ReleaseSpec{KubeContext: kubeContext, Namespace: namespace}
+
+			flags := []string{"--max", "0"}
+			if namespace != "" {
+				flags = append(flags, "--namespace", namespace)
+			} else {
+				flags = append(flags, "--all-namespaces")
+			}
+			flags = st.appendConnectionFlags(flags, helm, &target)
+
+			out, err := helm.GetReleases(st.createHelmContext(&target, 0), flags...)
+			if err != nil {
+				return nil, fmt.Errorf("listing releases in kube context %q: %v", st.releaseKubeContext(&target), err)
+			}
+
+			var items []helmListedRelease
+			if err := json.Unmarshal(out, &items); err != nil {
+				return nil, fmt.Errorf("parsing the output of helm list: %v", err)
+			}
+
+			for _, item := range items {
+				r, err := st.importRelease(helm, repositories, kubeContext, namespace, item, opts)
+				if err != nil {
+					return nil, err
+				}
+
+				if r != nil {
+					imported = append(imported, *r)
+				}
+			}
+		}
+	}
+
+	return imported, nil
+}
+
+// importRelease converts the listed release to the release of the helmfile. It returns nil for the release declared already.
+func (st *HelmState) importRelease(helm helmexec.Interface, repositories []RepositorySpec, kubeContext, namespace string, item helmListedRelease, opts ImportOpts) (*ImportedRelease, error) {
+	if namespace == "" {
+		namespace = item.Namespace
+	}
+
+	release := ReleaseSpec{
+		Name:        item.Name,
+		Namespace:   namespace,
+		KubeContext: kubeContext,
+	}
+
+	// The namespace is always the one reported by helm, so resolving it never lists the releases again
+	id, err := st.DeployedReleaseID(helm, &release)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Declared[id] {
+		st.logger.Infof("Skipping release %q in namespace %q that is declared in the helmfile", release.Name, release.Namespace)
+		return nil, nil
+	}
+
+	chart, version := item.Chart, ""
+	if m := chartNameVersion.FindStringSubmatch(item.Chart); m != nil {
+		chart, version = m[1], m[2]
+	}
+
+	r := &ImportedRelease{ReleaseSpec: release}
+	r.Version = version
+
+	repo, err := st.findChartRepository(helm, repositories, chart, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if repo != nil {
+		r.Repository = repo
+		r.Chart = repo.Name + "/" + chart
+	} else {
+		st.logger.Warnf("Chart %s of release %q in namespace %q isn't found in any of the repositories. Fix the chart of the release after the import", item.Chart, release.Name, release.Namespace)
+		r.Chart = chart
+	}
+
+	flags := st.appendConnectionFlags([]string{"--namespace", namespace}, helm, &release)
+
+	out, err := helm.GetValues(st.createHelmContext(&release, 0), release.Name, flags...)
+	if err != nil {
+		return nil, fmt.Errorf("getting values of release %q in namespace %q: %v", release.Name, release.Namespace, err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(out, &values); err != nil {
+		return nil, fmt.Errorf("parsing values of release %q in namespace %q: %v", release.Name, release.Namespace, err)
+	}
+
+	if len(values) > 0 {
+		r.UserValues, err = maputil.CastKeysToStrings(values)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// findChartRepository returns the first repository that has the version of the chart.
+// OCI repositories are never searched, as helm doesn't support searching them.
+func (st *HelmState) findChartRepository(helm helmexec.Interface, repositories []RepositorySpec, chart, version string) (*RepositorySpec, error) {
+	for i := range repositories {
+		repo := &repositories[i]
+		if repo.OCI {
+			continue
+		}
+
+		name := repo.Name + "/" + chart
+
+		flags := []string{}
+		if version != "" {
+			flags = append(flags, "--version", version)
+		}
+
+		out, err := helm.SearchRepo(name, flags...)
+		if err != nil {
+			return nil, fmt.Errorf("searching %s: %v", name, err)
+		}
+
+		var charts []helmSearchedChart
+		if err := json.Unmarshal(out, &charts); err != nil {
+			return nil, fmt.Errorf("parsing the output of helm search: %v", err)
+		}
+
+		for _, c := range charts {
+			if c.Name == name {
+				return repo, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,78 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+func TestHelmState_ImportReleases(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			HelmDefaults: HelmSpec{KubeContext: "default"},
+		},
+		logger: logger,
+	}
+
+	repositories := []RepositorySpec{
+		{Name: "registry", URL: "registry.example.com/charts", OCI: true},
+		{Name: "incubator", URL: "https://incubator.example.com"},
+		{Name: "stable", URL: "https://charts.example.com"},
+	}
+
+	helm := &exectest.Helm{
+		Helm3: true,
+		Deployed: map[string]string{
+			"": `[
+{"name": "web", "namespace": "apps", "revision": "3", "status": "deployed", "chart": "web-1.2.3", "app_version": "2.0"},
+{"name": "db", "namespace": "data", "revision": "1", "status": "deployed", "chart": "postgres-12.1.0", "app_version": "15"},
+{"name": "legacy", "namespace": "apps", "revision": "7", "status": "failed", "chart": "legacy-0.1.0", "app_version": ""}
+]`,
+		},
+		Values: map[string]string{
+			"web": "replicas: 2\nimage:\n  tag: v1\n",
+		},
+		SearchResults: map[string]string{
+			"incubator/web": `[{"name": "incubator/web-ui", "version": "1.2.3"}]`,
+			"stable/web":    `[{"name": "stable/web", "version": "1.2.3"}]`,
+		},
+	}
+
+	imported, err := st.ImportReleases(helm, repositories, ImportOpts{
+		Declared: map[string]bool{"default/data/db": true},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []ImportedRelease{
+		{
+			ReleaseSpec: ReleaseSpec{Name: "web", Namespace: "apps", Chart: "stable/web", Version: "1.2.3"},
+			Repository:  &repositories[2],
+			UserValues: map[string]interface{}{
+				"replicas": 2,
+				"image":    map[string]interface{}{"tag": "v1"},
+			},
+		},
+		{
+			ReleaseSpec: ReleaseSpec{Name: "legacy", Namespace: "apps", Chart: "legacy", Version: "0.1.0"},
+		},
+	}
+
+	if d := cmp.Diff(want, imported, cmp.AllowUnexported(ReleaseSpec{})); d != "" {
+		t.Errorf("unexpected releases: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_ImportReleases_Helm2(t *testing.T) {
+	st := &HelmState{logger: logger}
+
+	_, err := st.ImportReleases(&exectest.Helm{}, nil, ImportOpts{})
+
+	want := "helmfile import requires helm 3"
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want %q, got %v", want, err)
+	}
+}
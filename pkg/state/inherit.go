@@ -0,0 +1,184 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/event"
+	"github.com/imdario/mergo"
+)
+
+const (
+	InheritStrategyAppend  = "append"
+	InheritStrategyReplace = "replace"
+)
+
+// inheritStrategyFields is the list of fields whose merge strategy can be set via `inheritStrategy`
+var inheritStrategyFields = []string{"values", "secrets", "set", "needs", "hooks", "labels"}
+
+// resolveInheritance expands `inherit` of every release into the fields inherited from the templates.
+// templates are the ones defined in the preceding parts of the helmfile and its bases, that are overridden by the
+// templates defined in the state itself.
+func (st *HelmState) resolveInheritance(templates map[string]TemplateSpec) error {
+	all := map[string]TemplateSpec{}
+	for name, t := range templates {
+		all[name] = t
+	}
+	for name, t := range st.Templates {
+		all[name] = t
+	}
+
+	r := &inheritanceResolver{templates: all, resolved: map[string]ReleaseSpec{}}
+
+	for i := range st.Releases {
+		release := st.Releases[i]
+
+		resolved, err := r.resolve(release, nil)
+		if err != nil {
+			return fmt.Errorf("failed to resolve inherit of release \"%s\" in %s: %v", release.Name, st.FilePath, err)
+		}
+
+		st.Releases[i] = resolved
+	}
+
+	return nil
+}
+
+type inheritanceResolver struct {
+	templates map[string]TemplateSpec
+	// resolved is the cache of the templates whose inherit are resolved
+	resolved map[string]ReleaseSpec
+}
+
+// resolve returns the spec merged over the templates it inherits, that are recursively resolved.
+// chain is the names of the templates being resolved, used to detect inheritance cycles.
+func (r *inheritanceResolver) resolve(spec ReleaseSpec, chain []string) (ReleaseSpec, error) {
+	if len(spec.Inherit) == 0 {
+		return spec, nil
+	}
+
+	var base ReleaseSpec
+
+	for i, name := range spec.Inherit {
+		for _, n := range chain {
+			if n == name {
+				return spec, fmt.Errorf("inheritance cycle detected: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+		}
+
+		parent, ok := r.resolved[name]
+		if !ok {
+			t, ok := r.templates[name]
+			if !ok {
+				return spec, fmt.Errorf("template \"%s\" is not defined", name)
+			}
+
+			var err error
+			parent, err = r.resolve(t.ReleaseSpec, append(append([]string{}, chain...), name))
+			if err != nil {
+				return spec, err
+			}
+
+			r.resolved[name] = parent
+		}
+
+		if i == 0 {
+			base = parent
+			continue
+		}
+
+		merged, err := mergeInherited(base, parent, nil)
+		if err != nil {
+			return spec, fmt.Errorf("merging template \"%s\": %v", name, err)
+		}
+		base = merged
+	}
+
+	return mergeInherited(base, spec, spec.InheritStrategy)
+}
+
+// mergeInherited returns the child merged over the parent.
+// The fields listed in inheritStrategyFields are merged according to the strategy, and any other field of the child
+// replaces the parent's one when set.
+func mergeInherited(parent, child ReleaseSpec, strategy map[string]string) (ReleaseSpec, error) {
+	appends := map[string]bool{}
+	for _, f := range inheritStrategyFields {
+		appends[f] = true
+	}
+
+	var fields []string
+	for f := range strategy {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	for _, f := range fields {
+		if _, ok := appends[f]; !ok {
+			return child, fmt.Errorf("unsupported field \"%s\" in inheritStrategy: it must be one of %s", f, strings.Join(inheritStrategyFields, ", "))
+		}
+
+		switch s := strategy[f]; s {
+		case InheritStrategyAppend:
+		case InheritStrategyReplace:
+			appends[f] = false
+		default:
+			return child, fmt.Errorf("unsupported inheritStrategy \"%s\" for \"%s\": it must be either \"%s\" or \"%s\"", s, f, InheritStrategyAppend, InheritStrategyReplace)
+		}
+	}
+
+	merged := parent
+
+	// Never modify the labels of the template that is shared among releases
+	merged.Labels = nil
+	if len(parent.Labels) > 0 && (appends["labels"] || len(child.Labels) == 0) {
+		merged.Labels = map[string]string{}
+		for k, v := range parent.Labels {
+			merged.Labels[k] = v
+		}
+	}
+
+	if err := mergo.Merge(&merged, child, mergo.WithOverride); err != nil {
+		return child, err
+	}
+
+	// The fields with the append strategy are left as the parent's ones by mergo when the child's are empty
+	if appends["values"] && len(child.Values) > 0 {
+		merged.Values = append(append([]interface{}{}, parent.Values...), child.Values...)
+	}
+
+	if appends["secrets"] && len(child.Secrets) > 0 {
+		merged.Secrets = append(append([]interface{}{}, parent.Secrets...), child.Secrets...)
+	}
+
+	if appends["set"] && len(child.SetValues) > 0 {
+		merged.SetValues = append(append([]SetValue{}, parent.SetValues...), child.SetValues...)
+	}
+
+	if appends["hooks"] && len(child.Hooks) > 0 {
+		merged.Hooks = append(append([]event.Hook{}, parent.Hooks...), child.Hooks...)
+	}
+
+	if appends["needs"] && len(child.Needs) > 0 {
+		merged.Needs = append([]string{}, parent.Needs...)
+		for _, n := range child.Needs {
+			if !containsString(merged.Needs, n) {
+				merged.Needs = append(merged.Needs, n)
+			}
+		}
+	}
+
+	merged.Inherit = nil
+	merged.InheritStrategy = nil
+
+	return merged, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/event"
+)
+
+func TestReadFromYaml_Inherit(t *testing.T) {
+	yamlFile := "example/path/to/yaml/file"
+	yamlContent := []byte(`templates:
+  default:
+    namespace: apps
+    labels:
+      tier: backend
+    values:
+    - common.yaml
+    hooks:
+    - events: ["presync"]
+      command: echo
+  web:
+    inherit: [default]
+    chart: stable/nginx
+    needs: [apps/db]
+    labels:
+      kind: web
+    values:
+    - web.yaml
+  monitored:
+    needs: [monitoring/prometheus]
+    set:
+    - name: metrics.enabled
+      value: "true"
+
+releases:
+- name: db
+  inherit: [default]
+  chart: stable/postgresql
+- name: frontend
+  inherit: [web, monitored]
+  needs: [apps/db, apps/api]
+  labels:
+    tier: frontend
+  values:
+  - frontend.yaml
+- name: api
+  inherit: [web]
+  namespace: api
+  inheritStrategy:
+    values: replace
+    labels: replace
+    needs: replace
+  labels:
+    team: api
+  values:
+  - api.yaml
+`)
+
+	st, err := createFromYaml(yamlContent, yamlFile, DefaultEnv, logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hooks := []event.Hook{{Events: []string{"presync"}, Command: "echo"}}
+
+	want := []ReleaseSpec{
+		{
+			Name:      "db",
+			Chart:     "stable/postgresql",
+			Namespace: "apps",
+			Labels:    map[string]string{"tier": "backend"},
+			Values:    []interface{}{"common.yaml"},
+			Hooks:     hooks,
+		},
+		{
+			Name:      "frontend",
+			Chart:     "stable/nginx",
+			Namespace: "apps",
+			Labels:    map[string]string{"tier": "frontend", "kind": "web"},
+			Values:    []interface{}{"common.yaml", "web.yaml", "frontend.yaml"},
+			SetValues: []SetValue{{Name: "metrics.enabled", Value: "true"}},
+			Needs:     []string{"apps/db", "monitoring/prometheus", "apps/api"},
+			Hooks:     hooks,
+		},
+		{
+			Name:      "api",
+			Chart:     "stable/nginx",
+			Namespace: "api",
+			Labels:    map[string]string{"team": "api"},
+			Values:    []interface{}{"api.yaml"},
+			Needs:     []string{"apps/db"},
+			Hooks:     hooks,
+		},
+	}
+
+	if d := cmp.Diff(want, st.Releases, cmp.AllowUnexported(ReleaseSpec{})); d != "" {
+		t.Errorf("unexpected releases: want (-), got (+):\n%s", d)
+	}
+
+	if d := cmp.Diff(map[string]string{"tier": "backend"}, st.Templates["default"].Labels); d != "" {
+		t.Errorf("unexpected modification to the template: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestReadFromYaml_InheritTemplatesOfPrecedingParts(t *testing.T) {
+	c := &StateCreator{
+		logger: logger,
+		Strict: true,
+		Templates: map[string]TemplateSpec{
+			"default": {ReleaseSpec: ReleaseSpec{Chart: "stable/nginx", Namespace: "default"}},
+			"web":     {ReleaseSpec: ReleaseSpec{Chart: "stable/nginx"}},
+		},
+	}
+
+	st, err := c.ParseAndLoad([]byte(`templates:
+  default:
+    namespace: apps
+releases:
+- name: foo
+  inherit: [web, default]
+`), "/path/to", "/path/to/helmfile.yaml", DefaultEnv, true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []ReleaseSpec{{Name: "foo", Chart: "stable/nginx", Namespace: "apps"}}
+
+	if d := cmp.Diff(want, st.Releases, cmp.AllowUnexported(ReleaseSpec{})); d != "" {
+		t.Errorf("unexpected releases: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestReadFromYaml_InheritErrors(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "undefined template",
+			content: `releases:
+- name: foo
+  chart: stable/foo
+  inherit: [default]
+`,
+			wantErr: `failed to resolve inherit of release "foo" in example/path/to/yaml/file: template "default" is not defined`,
+		},
+		{
+			name: "cycle",
+			content: `templates:
+  a:
+    inherit: [b]
+  b:
+    inherit: [a]
+releases:
+- name: foo
+  chart: stable/foo
+  inherit: [a]
+`,
+			wantErr: `failed to resolve inherit of release "foo" in example/path/to/yaml/file: inheritance cycle detected: a -> b -> a`,
+		},
+		{
+			name: "unsupported field",
+			content: `templates:
+  a:
+    chart: stable/foo
+releases:
+- name: foo
+  inherit: [a]
+  inheritStrategy:
+    chart: append
+`,
+			wantErr: `failed to resolve inherit of release "foo" in example/path/to/yaml/file: unsupported field "chart" in inheritStrategy: it must be one of values, secrets, set, needs, hooks, labels`,
+		},
+		{
+			name: "unsupported strategy",
+			content: `templates:
+  a:
+    chart: stable/foo
+releases:
+- name: foo
+  inherit: [a]
+  inheritStrategy:
+    values: merge
+`,
+			wantErr: `failed to resolve inherit of release "foo" in example/path/to/yaml/file: unsupported inheritStrategy "merge" for "values": it must be either "append" or "replace"`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := createFromYaml([]byte(tc.content), "example/path/to/yaml/file", DefaultEnv, logger)
+			if err == nil {
+				t.Fatalf("expected error %q, got none", tc.wantErr)
+			}
+
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("unexpected error: want %q, got %q", tc.wantErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/huolunl/helmfile/pkg/event"
+)
+
+const (
+	NotificationStart    = "start"
+	NotificationSuccess  = "success"
+	NotificationFailure  = "failure"
+	NotificationComplete = "complete"
+)
+
+// defaultNotificationPayload posts the notification as JSON when the payload is not specified
+const defaultNotificationPayload = "{{ toJson .Notification }}"
+
+var notificationEvents = []string{NotificationStart, NotificationSuccess, NotificationFailure, NotificationComplete}
+
+// NotificationSpec is an HTTP endpoint notified of the deployment lifecycle
+type NotificationSpec struct {
+	Name    string            `yaml:"name,omitempty"`
+	URL     string            `yaml:"url,omitempty"`
+	Method  string            `yaml:"method,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Events is the list of the events to notify of, that are start, success, failure and complete. Defaults to all
+	Events []string `yaml:"events,omitempty"`
+	// Payload is the template of the request body. Defaults to the notification encoded as JSON
+	Payload string `yaml:"payload,omitempty"`
+	// Retries, Backoff and Timeout are the same as the ones of hooks
+	Retries int `yaml:"retries,omitempty"`
+	Backoff int `yaml:"backoff,omitempty"`
+	Timeout int `yaml:"timeout,omitempty"`
+}
+
+// Notification is the data of a notification, that is available as `.Notification` in the payload template
+type Notification struct {
+	Event           string               `json:"event"`
+	HelmfileCommand string               `json:"helmfileCommand"`
+	Environment     string               `json:"environment"`
+	Release         *NotificationRelease `json:"release,omitempty"`
+	Error           string               `json:"error,omitempty"`
+	Summary         *NotificationSummary `json:"summary,omitempty"`
+}
+
+type NotificationRelease struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace,omitempty"`
+	KubeContext string `json:"kubeContext,omitempty"`
+	Chart       string `json:"chart"`
+	Version     string `json:"version,omitempty"`
+}
+
+// NotificationSummary is the names of the releases affected by the command, notified on completion
+type NotificationSummary struct {
+	Upgraded []string `json:"upgraded"`
+	Deleted  []string `json:"deleted"`
+	Failed   []string `json:"failed"`
+}
+
+// NotifyStart notifies that the helmfile command starts processing the releases.
+// It also makes the releases affected by the command notify of their success or failure as soon as each release is
+// processed, instead of waiting for all the releases.
+func (st *HelmState) NotifyStart(helmfileCommand string, affected *AffectedReleases) error {
+	if len(st.Notifications) == 0 {
+		return nil
+	}
+
+	affected.notify = func(r *ReleaseSpec, relErr error) {
+		evt := NotificationSuccess
+		n := Notification{HelmfileCommand: helmfileCommand, Release: notificationRelease(r)}
+		if relErr != nil {
+			evt = NotificationFailure
+			n.Error = relErr.Error()
+		}
+
+		if err := st.notify(evt, n, relErr); err != nil {
+			st.logger.Warnf("warn: %v\n", err)
+		}
+	}
+
+	return st.notify(NotificationStart, Notification{HelmfileCommand: helmfileCommand}, nil)
+}
+
+// NotifyComplete notifies the completion of the helmfile command with the summary of the affected releases.
+// errs are the errors returned by the command.
+func (st *HelmState) NotifyComplete(helmfileCommand string, affected *AffectedReleases, errs []error) error {
+	if len(st.Notifications) == 0 {
+		return nil
+	}
+
+	summary := &NotificationSummary{Upgraded: []string{}, Deleted: []string{}, Failed: []string{}}
+
+	for _, r := range affected.Upgraded {
+		summary.Upgraded = append(summary.Upgraded, r.Name)
+	}
+
+	for _, r := range affected.Deleted {
+		summary.Deleted = append(summary.Deleted, r.Name)
+	}
+
+	for _, r := range affected.Failed {
+		summary.Failed = append(summary.Failed, r.Name)
+	}
+
+	var cmdErr error
+	if len(errs) > 0 {
+		cmdErr = errs[0]
+	}
+
+	n := Notification{HelmfileCommand: helmfileCommand, Summary: summary}
+	if cmdErr != nil {
+		n.Error = cmdErr.Error()
+	}
+
+	return st.notify(NotificationComplete, n, cmdErr)
+}
+
+func (st *HelmState) notify(evt string, n Notification, evtErr error) error {
+	var hooks []event.Hook
+
+	for i, spec := range st.Notifications {
+		events := spec.Events
+		if len(events) == 0 {
+			events = notificationEvents
+		}
+
+		if !containsString(events, evt) {
+			continue
+		}
+
+		name := spec.Name
+		if name == "" {
+			name = fmt.Sprintf("notifications[%d]", i)
+		}
+
+		payload := spec.Payload
+		if payload == "" {
+			payload = defaultNotificationPayload
+		}
+
+		hooks = append(hooks, event.Hook{
+			Name:   name,
+			Events: events,
+			Webhook: &event.WebhookAction{
+				URL:     spec.URL,
+				Method:  spec.Method,
+				Headers: spec.Headers,
+				Payload: payload,
+			},
+			Retries: spec.Retries,
+			Backoff: spec.Backoff,
+			Timeout: spec.Timeout,
+			// A notification failure must not fail the deployment
+			ContinueOnError: true,
+		})
+	}
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	n.Event = evt
+	n.Environment = st.Env.Name
+
+	bus := &event.Bus{
+		Hooks:         hooks,
+		StateFilePath: st.FilePath,
+		BasePath:      st.basePath,
+		Namespace:     st.OverrideNamespace,
+		Chart:         st.OverrideChart,
+		Env:           st.Env,
+		Logger:        st.logger,
+		ReadFile:      st.readFile,
+		HTTPClient:    st.httpClient,
+		Timings:       st.Timings,
+	}
+	data := map[string]interface{}{
+		"Values":          st.Values(),
+		"HelmfileCommand": n.HelmfileCommand,
+		"Notification":    n,
+	}
+
+	_, err := bus.Trigger(evt, evtErr, data)
+
+	return err
+}
+
+func notificationRelease(r *ReleaseSpec) *NotificationRelease {
+	return &NotificationRelease{
+		Name:        r.Name,
+		Namespace:   r.Namespace,
+		KubeContext: r.KubeContext,
+		Chart:       r.Chart,
+		Version:     r.Version,
+	}
+}
+
+// releaseErrorOf returns the error of the release among errs, if any
+func releaseErrorOf(r *ReleaseSpec, errs []error) error {
+	for _, err := range errs {
+		if relErr, ok := err.(*ReleaseError); ok && relErr.ReleaseSpec != nil && ReleaseToID(relErr.ReleaseSpec) == ReleaseToID(r) {
+			return relErr
+		}
+	}
+	return nil
+}
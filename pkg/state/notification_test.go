@@ -0,0 +1,211 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/environment"
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+type recordingHTTPClient struct {
+	failures int
+
+	// mu guards requests sent concurrently by the releases processed in parallel
+	mu       sync.Mutex
+	requests []string
+}
+
+func (c *recordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = append(c.requests, fmt.Sprintf("%s %s %s", req.Method, req.URL, body))
+
+	status := http.StatusOK
+	if len(c.requests) <= c.failures {
+		status = http.StatusServiceUnavailable
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func TestHelmState_Notify(t *testing.T) {
+	foo := &ReleaseSpec{Name: "foo", Namespace: "apps", Chart: "stable/foo", Version: "1.0.0"}
+	bar := &ReleaseSpec{Name: "bar", Chart: "stable/bar"}
+	baz := &ReleaseSpec{Name: "baz", Chart: "stable/baz"}
+
+	errs := []error{newReleaseFailedError(baz, errors.New("timed out"))}
+
+	testcases := []struct {
+		name          string
+		notifications []NotificationSpec
+		failures      int
+		want          []string
+	}{
+		{
+			name:          "default payload",
+			notifications: []NotificationSpec{{URL: "https://example.com/all"}},
+			want: []string{
+				`POST https://example.com/all {"event":"start","helmfileCommand":"apply","environment":"prod"}`,
+				`POST https://example.com/all {"event":"success","helmfileCommand":"apply","environment":"prod","release":{"name":"foo","namespace":"apps","chart":"stable/foo","version":"1.0.0"}}`,
+				`POST https://example.com/all {"event":"success","helmfileCommand":"apply","environment":"prod","release":{"name":"bar","chart":"stable/bar"}}`,
+				`POST https://example.com/all {"event":"failure","helmfileCommand":"apply","environment":"prod","release":{"name":"baz","chart":"stable/baz"},"error":"failed processing release baz: timed out"}`,
+				`POST https://example.com/all {"event":"complete","helmfileCommand":"apply","environment":"prod","error":"failed processing release baz: timed out","summary":{"upgraded":["foo"],"deleted":["bar"],"failed":["baz"]}}`,
+			},
+		},
+		{
+			name: "events and payload",
+			notifications: []NotificationSpec{{
+				URL:     "https://example.com/{{ .Values.channel }}",
+				Method:  "PUT",
+				Events:  []string{"failure", "complete"},
+				Payload: `{{ .Notification.Event }}:{{ with .Notification.Release }}{{ .Name }}{{ else }}{{ len .Notification.Summary.Failed }} failed{{ end }}`,
+			}},
+			want: []string{
+				`PUT https://example.com/deploys failure:baz`,
+				`PUT https://example.com/deploys complete:1 failed`,
+			},
+		},
+		{
+			name:          "retries",
+			notifications: []NotificationSpec{{URL: "https://example.com/retry", Events: []string{"start"}, Payload: "start", Retries: 2}},
+			failures:      2,
+			want: []string{
+				`POST https://example.com/retry start`,
+				`POST https://example.com/retry start`,
+				`POST https://example.com/retry start`,
+			},
+		},
+		{
+			name:          "failure never fails the command",
+			notifications: []NotificationSpec{{URL: "https://example.com/fail", Events: []string{"start"}, Payload: "start"}},
+			failures:      1,
+			want: []string{
+				`POST https://example.com/fail start`,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &recordingHTTPClient{failures: tc.failures}
+
+			st := &HelmState{
+				ReleaseSetSpec: ReleaseSetSpec{
+					Notifications: tc.notifications,
+					Env:           environment.Environment{Name: "prod"},
+				},
+				RenderedValues: map[string]interface{}{"channel": "deploys"},
+				logger:         logger,
+				readFile:       ioutil.ReadFile,
+				httpClient:     client,
+			}
+
+			affected := &AffectedReleases{}
+
+			if err := st.NotifyStart("apply", affected); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			affected.Upgraded = append(affected.Upgraded, foo)
+			affected.notifyRelease(foo, nil)
+			affected.Deleted = append(affected.Deleted, bar)
+			affected.notifyRelease(bar, nil)
+			affected.Failed = append(affected.Failed, baz)
+			affected.notifyRelease(baz, errs[0])
+
+			if err := st.NotifyComplete("apply", affected, errs); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if d := cmp.Diff(tc.want, client.requests); d != "" {
+				t.Errorf("unexpected requests: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestHelmState_SyncReleases_NotifiesEachRelease(t *testing.T) {
+	testcases := []struct {
+		name     string
+		failures int
+		want     []string
+	}{
+		{
+			name: "success",
+			want: []string{
+				`success:foo`,
+			},
+		},
+		{
+			name:     "failure",
+			failures: 1,
+			want: []string{
+				`failure:foo:failed processing release foo: UPGRADE FAILED: timed out`,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &recordingHTTPClient{}
+			helm := &flakyHelm{
+				Helm:     &exectest.Helm{Lists: map[exectest.ListKey]string{}},
+				failures: tc.failures,
+				err:      errors.New("UPGRADE FAILED: timed out"),
+			}
+
+			st := &HelmState{
+				basePath: ".",
+				ReleaseSetSpec: ReleaseSetSpec{
+					Releases: []ReleaseSpec{{Name: "foo", Chart: "stable/foo"}},
+					Notifications: []NotificationSpec{{
+						URL:     "https://example.com/releases",
+						Events:  []string{"success", "failure"},
+						Payload: `{{ .Notification.Event }}:{{ .Notification.Release.Name }}{{ with .Notification.Error }}:{{ . }}{{ end }}`,
+					}},
+				},
+				logger:         logger,
+				valsRuntime:    valsRuntime,
+				RenderedValues: map[string]interface{}{},
+				readFile:       ioutil.ReadFile,
+				httpClient:     client,
+			}
+			st = injectFs(st, testhelper.NewTestFs(map[string]string{}))
+
+			affected := &AffectedReleases{}
+			if err := st.NotifyStart("sync", affected); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			st.SyncReleases(affected, helm, []string{}, 1)
+
+			// The release is notified as soon as it's processed, without waiting for the completion of the command
+			var got []string
+			for _, r := range client.requests {
+				got = append(got, strings.TrimPrefix(r, "POST https://example.com/releases "))
+			}
+
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("unexpected requests: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
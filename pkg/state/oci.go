@@ -0,0 +1,458 @@
+package state
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/remote"
+)
+
+const ociScheme = "oci://"
+
+// ociChart is a chart in an OCI registry, that is referred either by `oci://<registry>/<repository>/<chart>`
+// or by the chart name prefixed with the name of an OCI repository
+type ociChart struct {
+	host string
+	// repository is the path to the chart in the registry
+	repository string
+	tag        string
+	digest     string
+	// repo is the repository providing the credentials and the TLS config of the registry, if any
+	repo *RepositorySpec
+}
+
+func (c *ociChart) name() string {
+	return path.Base(c.repository)
+}
+
+// reference is the digest when the chart is pinned to it, or the tag otherwise
+func (c *ociChart) reference() string {
+	if c.digest != "" {
+		return c.digest
+	}
+
+	return c.tag
+}
+
+func (c *ociChart) String() string {
+	ref := ociScheme + c.host + "/" + c.repository
+
+	if c.tag != "" {
+		ref += ":" + c.tag
+	}
+
+	if c.digest != "" {
+		ref += "@" + c.digest
+	}
+
+	return ref
+}
+
+// releaseOCIChart returns the OCI chart of the release, or nil when the chart isn't in an OCI registry.
+//
+// The chart is pinned to the digest given by any of the `@sha256:` suffix of the chart, `version: sha256:...`
+// and `chartDigest`. Otherwise the version is the tag of the chart, that defaults to `latest`.
+func (st *HelmState) releaseOCIChart(release *ReleaseSpec) (*ociChart, error) {
+	var (
+		ref  string
+		repo *RepositorySpec
+	)
+
+	if strings.HasPrefix(release.Chart, ociScheme) {
+		ref = strings.TrimPrefix(release.Chart, ociScheme)
+	} else {
+		r, name := st.GetRepositoryAndNameFromChartName(release.Chart)
+		if r == nil || !r.OCI {
+			return nil, nil
+		}
+
+		ref = strings.TrimSuffix(strings.TrimPrefix(r.URL, ociScheme), "/") + "/" + name
+		repo = r
+	}
+
+	chart := &ociChart{repo: repo}
+
+	var pins []string
+
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref, pins = ref[:i], append(pins, ref[i+1:])
+	}
+
+	if strings.HasPrefix(release.Version, "sha256:") {
+		pins = append(pins, release.Version)
+	} else {
+		chart.tag = release.Version
+	}
+
+	if release.ChartDigest != "" {
+		pins = append(pins, release.ChartDigest)
+	}
+
+	for _, pin := range pins {
+		if !ociDigestPattern.MatchString(pin) {
+			return nil, fmt.Errorf("invalid digest %q of chart %s: it must be sha256:<64 lowercase hex digits>", pin, release.Chart)
+		}
+
+		if chart.digest != "" && chart.digest != pin {
+			return nil, fmt.Errorf("chart %s is pinned to different digests %s and %s", release.Chart, chart.digest, pin)
+		}
+
+		chart.digest = pin
+	}
+
+	i := strings.Index(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return nil, fmt.Errorf("invalid OCI chart %s: it must be oci://<registry>/<repository>/<chart>", release.Chart)
+	}
+
+	chart.host, chart.repository = ref[:i], ref[i+1:]
+
+	if chart.tag == "" && chart.digest == "" {
+		chart.tag = "latest"
+	}
+
+	if chart.repo == nil {
+		chart.repo = st.ociRepositoryOf(ref)
+	}
+
+	return chart, nil
+}
+
+// ociRepositoryOf returns the OCI repository of the longest URL containing the chart, or nil when there's none
+func (st *HelmState) ociRepositoryOf(ref string) *RepositorySpec {
+	var found *RepositorySpec
+
+	for i := range st.Repositories {
+		r := &st.Repositories[i]
+		if !r.OCI {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(strings.TrimPrefix(r.URL, ociScheme), "/") + "/"
+		if strings.HasPrefix(ref, prefix) && (found == nil || len(r.URL) > len(found.URL)) {
+			found = r
+		}
+	}
+
+	return found
+}
+
+func (st *HelmState) ociRegistry(chart *ociChart) (*ociRegistry, error) {
+	var (
+		repo               RepositorySpec
+		username, password string
+		err                error
+	)
+
+	if chart.repo != nil {
+		repo = *chart.repo
+
+		username, password, err = st.repoCredentials(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The chart without the credentials of the repository uses the credentials of `helm registry login`, if any
+	if username == "" && password == "" {
+		username, password = st.helmRegistryCredentials(chart.host)
+	}
+
+	client, err := st.repoHTTPClient(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociRegistry{client: client, host: chart.host, username: username, password: password}, nil
+}
+
+func (st *HelmState) helmRegistryCredentials(host string) (string, string) {
+	config := os.Getenv("HELM_REGISTRY_CONFIG")
+	if config == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", ""
+		}
+
+		config = filepath.Join(dir, "helm", "registry", "config.json")
+	}
+
+	if _, err := os.Stat(config); err != nil {
+		return "", ""
+	}
+
+	username, password, err := dockerConfigCredentials(st, RepositorySpec{URL: host, Credentials: &RepositoryCredentialsSpec{ConfigFile: config}})
+	if err != nil {
+		st.logger.Debugf("pulling charts from %s anonymously: %v", host, err)
+		return "", ""
+	}
+
+	return username, password
+}
+
+func (st *HelmState) verifiesChart(release *ReleaseSpec) bool {
+	return release.Verify != nil && *release.Verify || release.Verify == nil && st.HelmDefaults.Verify
+}
+
+// getOCIChart pulls the chart of the release from the OCI registry, and returns the path to the chart directory
+// along with the digest of the chart. It returns empty strings when the chart isn't in an OCI registry.
+//
+// Pulled charts are cached by their digests, that are never reused for other charts unlike tags,
+// so the cache is used even when the render cache is disabled. The chart pinned to the digest is
+// served from the cache without requesting the registry.
+// The cached chart is copied to tempDir, as `helm dep build` modifies the chart in place.
+func (st *HelmState) getOCIChart(release *ReleaseSpec, tempDir string, helm helmexec.Interface, cache *renderCache) (string, string, error) {
+	chart, err := st.releaseOCIChart(release)
+	if err != nil || chart == nil {
+		return "", "", err
+	}
+
+	if cache == nil {
+		cache = newRenderCache(remote.CacheDir())
+	}
+
+	verify := st.verifiesChart(release)
+	// The chart pushed along with the provenance is verified unless the verification is disabled for the release,
+	// while the chart pushed without it is used as is
+	verifyIfSigned := !verify && release.Verify == nil
+
+	// The cache key tells whether the chart was verified, so that the chart pulled without the verification isn't used
+	// for the release requiring it
+	cacheKey := func(digest, suffix string) string {
+		return chart.name() + "-" + strings.TrimPrefix(digest, "sha256:") + suffix
+	}
+
+	var suffixes []string
+	switch {
+	case verify:
+		suffixes = []string{"-verified"}
+	case verifyIfSigned:
+		// Either of them is cached for the digest, as the digest of the manifest tells whether the provenance is pushed
+		suffixes = []string{"-verified", "-unsigned"}
+	default:
+		suffixes = []string{""}
+	}
+
+	lookup := func(digest string) string {
+		for _, suffix := range suffixes {
+			if cached := cache.lookup(renderCacheOCI, cacheKey(digest, suffix)); cached != "" {
+				return cached
+			}
+		}
+		return ""
+	}
+
+	pathElems := []string{
+		tempDir,
+	}
+
+	if release.Namespace != "" {
+		pathElems = append(pathElems, release.Namespace)
+	}
+
+	if release.KubeContext != "" {
+		pathElems = append(pathElems, release.KubeContext)
+	}
+
+	pathElems = append(pathElems, release.Name, chart.name())
+
+	restore := func(cached, digest string) (string, error) {
+		dir := path.Join(append(pathElems, strings.TrimPrefix(digest, "sha256:"))...)
+
+		if err := copyDir(cached, dir); err != nil {
+			return "", fmt.Errorf("restoring chart %s@%s from the cache: %v", chart, digest, err)
+		}
+
+		return dir, nil
+	}
+
+	if chart.digest != "" {
+		if cached := lookup(chart.digest); cached != "" {
+			st.logger.Debugf("using chart %s cached at %s", chart, cached)
+
+			chartPath, err := restore(cached, chart.digest)
+			if err != nil {
+				return "", "", err
+			}
+
+			return chartPath, chart.digest, nil
+		}
+	}
+
+	var chartPath, digest string
+
+	_, err = st.withRetry(fmt.Sprintf("pulling chart %s", chart), st.retryPolicy(release), func() error {
+		registry, err := st.ociRegistry(chart)
+		if err != nil {
+			return err
+		}
+
+		manifest, d, err := registry.manifest(chart.repository, chart.reference())
+		if err != nil {
+			return err
+		}
+		digest = d
+
+		if cached := lookup(digest); cached != "" {
+			st.logger.Debugf("using chart %s@%s cached at %s", chart, digest, cached)
+
+			chartPath, err = restore(cached, digest)
+
+			return err
+		}
+
+		content := manifest.layer(helmChartContentMediaType)
+		if content == nil {
+			return fmt.Errorf("%s is not a helm chart: no layer of %s is found", chart, helmChartContentMediaType)
+		}
+
+		tgz, err := registry.blob(chart.repository, *content)
+		if err != nil {
+			return err
+		}
+
+		var suffix string
+		switch {
+		case verify || verifyIfSigned && manifest.layer(helmChartProvenanceMediaType) != nil:
+			if err := verifyOCIChart(registry, chart, manifest, tgz, helm); err != nil {
+				return err
+			}
+			suffix = "-verified"
+		case verifyIfSigned:
+			suffix = "-unsigned"
+		}
+
+		dir := path.Join(append(pathElems, strings.TrimPrefix(digest, "sha256:"))...)
+
+		if err := untarChart(tgz, dir); err != nil {
+			return fmt.Errorf("extracting chart %s: %v", chart, err)
+		}
+
+		fullChartPath, err := findChartDirectory(dir)
+		if err != nil {
+			return err
+		}
+
+		chartPath = filepath.Dir(fullChartPath)
+
+		if _, err := cache.store(renderCacheOCI, cacheKey(digest, suffix), chartPath); err != nil {
+			st.logger.Warnf("WARN: failed to cache chart %s@%s: %v", chart, digest, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	st.logger.Debugf("pulled chart %s@%s", chart, digest)
+
+	return chartPath, digest, nil
+}
+
+// verifyOCIChart verifies the chart against the provenance pushed along with it by `helm push`, by running `helm verify`
+func verifyOCIChart(registry *ociRegistry, chart *ociChart, manifest *ociManifest, tgz []byte, helm helmexec.Interface) error {
+	prov := manifest.layer(helmChartProvenanceMediaType)
+	if prov == nil {
+		return fmt.Errorf("verifying chart %s: no provenance is pushed along with the chart", chart)
+	}
+
+	configBlob, err := registry.blob(chart.repository, manifest.Config)
+	if err != nil {
+		return err
+	}
+
+	var config struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return fmt.Errorf("parsing the config of chart %s: %v", chart, err)
+	}
+
+	provBlob, err := registry.blob(chart.repository, *prov)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "helmfile-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// The provenance signs the archive by its file name, that is <name>-<version>.tgz
+	archive := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", config.Name, config.Version))
+
+	if err := ioutil.WriteFile(archive, tgz, 0644); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(archive+".prov", provBlob, 0644); err != nil {
+		return err
+	}
+
+	if err := helm.VerifyChart(archive); err != nil {
+		return fmt.Errorf("verifying chart %s: %w", chart, err)
+	}
+
+	return nil
+}
+
+func untarChart(tgz []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %s in the archive", hdr.Name)
+		}
+
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			bs, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+
+			if err := ioutil.WriteFile(target, bs, 0644); err != nil {
+				return err
+			}
+		}
+	}
+}
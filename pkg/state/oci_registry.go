@@ -0,0 +1,208 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+)
+
+// The media types of the OCI artifacts pushed by `helm push`
+const (
+	ociManifestMediaType         = "application/vnd.oci.image.manifest.v1+json"
+	helmChartConfigMediaType     = "application/vnd.cncf.helm.config.v1+json"
+	helmChartContentMediaType    = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	helmChartProvenanceMediaType = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+var ociDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// layer returns the first layer of the media type, or nil when there's none
+func (m *ociManifest) layer(mediaType string) *ociDescriptor {
+	for i := range m.Layers {
+		if m.Layers[i].MediaType == mediaType {
+			return &m.Layers[i]
+		}
+	}
+
+	return nil
+}
+
+// ociRegistry is a client of the OCI distribution API, that is just enough to resolve and pull helm charts.
+// The credentials are sent as they are when the registry asks for the basic authentication,
+// or exchanged for the pull token when it asks for the bearer token.
+type ociRegistry struct {
+	client   *http.Client
+	host     string
+	username string
+	password string
+
+	basic bool
+	token string
+}
+
+func (r *ociRegistry) url(repository, path string) string {
+	return fmt.Sprintf("https://%s/v2/%s/%s", r.host, repository, path)
+}
+
+// get requests the path under the repository, authenticating once when the registry responds with 401
+func (r *ociRegistry) get(repository, path, accept string) (*http.Response, error) {
+	u := r.url(repository, path)
+
+	res, err := r.do(u, accept)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || r.basic || r.token != "" {
+		return res, err
+	}
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+
+	if err := r.authorize(challenge, fmt.Sprintf("repository:%s:pull", repository)); err != nil {
+		return nil, fmt.Errorf("authenticating with %s: %w", r.host, err)
+	}
+
+	return r.do(u, accept)
+}
+
+func (r *ociRegistry) do(u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	switch {
+	case r.token != "":
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	case r.basic:
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	return r.client.Do(req)
+}
+
+func (r *ociRegistry) authorize(challenge, scope string) error {
+	switch scheme, params := parseChallenge(challenge); scheme {
+	case "basic":
+		if r.username == "" && r.password == "" {
+			return fmt.Errorf("%s requires authentication but no credentials are given", r.host)
+		}
+
+		r.basic = true
+
+		return nil
+	case "bearer":
+		u, err := tokenURL(params, scope)
+		if err != nil {
+			return fmt.Errorf("invalid authentication challenge %q", challenge)
+		}
+
+		// Without the credentials the token is requested anonymously, that is allowed by public registries
+		res, err := httpGet(r.client, u, r.username, r.password)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode >= 300 {
+			return checkStatus(res, u)
+		}
+		defer res.Body.Close()
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+			return fmt.Errorf("parsing the token from %s: %v", u, err)
+		}
+
+		r.token = token.Token
+		if r.token == "" {
+			r.token = token.AccessToken
+		}
+
+		if r.token == "" {
+			return fmt.Errorf("no token is returned from %s", u)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+}
+
+// read returns the body of the path under the repository, verifying that it has the digest when it's given
+func (r *ociRegistry) read(repository, path, accept, digest string) ([]byte, string, error) {
+	res, err := r.get(repository, path, accept)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, "", checkStatus(res, r.url(repository, path))
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	actual := sha256Digest(body)
+	if digest != "" && actual != digest {
+		return nil, "", fmt.Errorf("%s has the digest %s rather than %s", r.url(repository, path), actual, digest)
+	}
+
+	return body, actual, nil
+}
+
+// manifest returns the manifest of the tag or the digest along with the digest of the manifest
+func (r *ociRegistry) manifest(repository, reference string) (*ociManifest, string, error) {
+	var digest string
+	if ociDigestPattern.MatchString(reference) {
+		digest = reference
+	}
+
+	body, digest, err := r.read(repository, "manifests/"+reference, ociManifestMediaType, digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var m ociManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, "", fmt.Errorf("parsing the manifest of %s/%s@%s: %v", r.host, repository, digest, err)
+	}
+
+	return &m, digest, nil
+}
+
+// blob returns the content of the blob, that is verified against the digest of the descriptor
+func (r *ociRegistry) blob(repository string, desc ociDescriptor) ([]byte, error) {
+	body, _, err := r.read(repository, "blobs/"+desc.Digest, "", desc.Digest)
+
+	return body, err
+}
+
+func sha256Digest(bs []byte) string {
+	sum := sha256.Sum256(bs)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,508 @@
+package state
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestHelmState_ReleaseOCIChart(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			Repositories: []RepositorySpec{
+				{Name: "stable", URL: "https://charts.example.com"},
+				{Name: "myoci", URL: "registry.example.com/charts", OCI: true},
+				{Name: "team", URL: "oci://registry.example.com/charts/team", OCI: true},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		release ReleaseSpec
+		want    string
+		repo    string
+		wantErr string
+	}{
+		{
+			name:    "chart repository",
+			release: ReleaseSpec{Chart: "stable/mychart"},
+		},
+		{
+			name:    "oci repository",
+			release: ReleaseSpec{Chart: "myoci/mychart", Version: "1.0.0"},
+			want:    "oci://registry.example.com/charts/mychart:1.0.0",
+			repo:    "myoci",
+		},
+		{
+			name:    "latest",
+			release: ReleaseSpec{Chart: "myoci/mychart"},
+			want:    "oci://registry.example.com/charts/mychart:latest",
+			repo:    "myoci",
+		},
+		{
+			name:    "oci reference",
+			release: ReleaseSpec{Chart: "oci://registry.example.com/charts/team/mychart", Version: "1.0.0"},
+			want:    "oci://registry.example.com/charts/team/mychart:1.0.0",
+			repo:    "team",
+		},
+		{
+			name:    "oci reference without repository",
+			release: ReleaseSpec{Chart: "oci://other.example.com/mychart", Version: "1.0.0"},
+			want:    "oci://other.example.com/mychart:1.0.0",
+		},
+		{
+			name:    "digest in the chart",
+			release: ReleaseSpec{Chart: "oci://registry.example.com/charts/mychart@" + testDigest, Version: "1.0.0"},
+			want:    "oci://registry.example.com/charts/mychart:1.0.0@" + testDigest,
+			repo:    "myoci",
+		},
+		{
+			name:    "digest in the version",
+			release: ReleaseSpec{Chart: "myoci/mychart", Version: testDigest},
+			want:    "oci://registry.example.com/charts/mychart@" + testDigest,
+			repo:    "myoci",
+		},
+		{
+			name:    "chartDigest",
+			release: ReleaseSpec{Chart: "myoci/mychart@" + testDigest, Version: "1.0.0", ChartDigest: testDigest},
+			want:    "oci://registry.example.com/charts/mychart:1.0.0@" + testDigest,
+			repo:    "myoci",
+		},
+		{
+			name:    "different digests",
+			release: ReleaseSpec{Chart: "myoci/mychart@" + testDigest, ChartDigest: "sha256:" + strings.Repeat("0", 64)},
+			wantErr: fmt.Sprintf("chart myoci/mychart@%s is pinned to different digests %s and sha256:%s", testDigest, testDigest, strings.Repeat("0", 64)),
+		},
+		{
+			name:    "invalid digest",
+			release: ReleaseSpec{Chart: "oci://registry.example.com/mychart", Version: "sha256:abc"},
+			wantErr: `invalid digest "sha256:abc" of chart oci://registry.example.com/mychart: it must be sha256:<64 lowercase hex digits>`,
+		},
+		{
+			name:    "no repository",
+			release: ReleaseSpec{Chart: "oci://mychart"},
+			wantErr: "invalid OCI chart oci://mychart: it must be oci://<registry>/<repository>/<chart>",
+		},
+	}
+
+	for i := range tests {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := st.releaseOCIChart(&tt.release)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.want == "" {
+				if chart != nil {
+					t.Fatalf("unexpected OCI chart: %s", chart)
+				}
+				return
+			}
+
+			if chart.String() != tt.want {
+				t.Errorf("unexpected chart: want %s, got %s", tt.want, chart)
+			}
+
+			var repo string
+			if chart.repo != nil {
+				repo = chart.repo.Name
+			}
+
+			if repo != tt.repo {
+				t.Errorf("unexpected repository: want %q, got %q", tt.repo, repo)
+			}
+		})
+	}
+}
+
+func testChartArchive(t *testing.T, name, version string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := map[string]string{
+		name + "/Chart.yaml":               fmt.Sprintf("apiVersion: v2\nname: %s\nversion: %s\n", name, version),
+		name + "/templates/configmap.yaml": "kind: ConfigMap\n",
+	}
+
+	for p, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testRegistry serves the charts pushed by push with the bearer token authentication
+type testRegistry struct {
+	*httptest.Server
+
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	requests  int
+}
+
+func newTestRegistry() *testRegistry {
+	r := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests++
+
+		if req.URL.Path == "/token" {
+			if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "password" || req.URL.Query().Get("scope") != "repository:charts/mychart:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "token"}`)
+			return
+		}
+
+		if req.Header.Get("Authorization") != "Bearer token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, r.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/v2/charts/mychart/manifests/"):
+			m, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/charts/mychart/manifests/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			w.Write(m)
+		case strings.HasPrefix(req.URL.Path, "/v2/charts/mychart/blobs/"):
+			b, ok := r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/charts/mychart/blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "https://")
+}
+
+func (r *testRegistry) blob(mediaType string, content []byte) ociDescriptor {
+	digest := sha256Digest(content)
+	r.blobs[digest] = content
+
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}
+}
+
+// push pushes the chart in the same way as `helm push`, and returns the digest of the manifest
+func (r *testRegistry) push(t *testing.T, tag string, archive, prov []byte) string {
+	t.Helper()
+
+	m := ociManifest{
+		Config: r.blob(helmChartConfigMediaType, []byte(`{"name": "mychart", "version": "1.0.0"}`)),
+		Layers: []ociDescriptor{r.blob(helmChartContentMediaType, archive)},
+	}
+
+	if prov != nil {
+		m.Layers = append(m.Layers, r.blob(helmChartProvenanceMediaType, prov))
+	}
+
+	bs, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256Digest(bs)
+	r.manifests[tag] = bs
+	r.manifests[digest] = bs
+
+	return digest
+}
+
+func TestHelmState_GetOCIChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := newTestRegistry()
+	defer registry.Close()
+
+	digest := registry.push(t, "1.0.0", testChartArchive(t, "mychart", "1.0.0"), nil)
+	signedDigest := registry.push(t, "1.0.0-signed", testChartArchive(t, "mychart", "1.0.0"), []byte("provenance"))
+
+	st := newRenderCacheTestState(dir)
+	st.Repositories = []RepositorySpec{
+		{Name: "myoci", URL: registry.host() + "/charts", OCI: true, SkipTLSVerify: "true", Username: "user", Password: "password"},
+	}
+
+	cache := newRenderCache(filepath.Join(dir, "cache"))
+	helm := &exectest.Helm{Helm3: true}
+
+	t.Run("tag", func(t *testing.T) {
+		release := &ReleaseSpec{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0"}
+
+		chartPath, gotDigest, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, cache)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if gotDigest != digest {
+			t.Errorf("unexpected digest: want %s, got %s", digest, gotDigest)
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(chartPath, "Chart.yaml"))
+		if err != nil || !strings.Contains(string(bs), "name: mychart") {
+			t.Errorf("unexpected chart at %s: %s: %v", chartPath, bs, err)
+		}
+
+		// The chart pushed without the provenance is cached as is
+		if cache.lookup(renderCacheOCI, "mychart-"+strings.TrimPrefix(digest, "sha256:")+"-unsigned") == "" {
+			t.Errorf("expected the chart to be cached by the digest")
+		}
+	})
+
+	t.Run("digest served from the cache", func(t *testing.T) {
+		release := &ReleaseSpec{Name: "foo", Chart: "oci://" + registry.host() + "/charts/mychart@" + digest}
+
+		requests := registry.requests
+
+		chartPath, gotDigest, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, cache)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The cached chart is copied, so that `helm dep build` doesn't modify the cache
+		if gotDigest != digest || !strings.HasPrefix(chartPath, filepath.Join(dir, "tmp")) {
+			t.Errorf("unexpected chart: %s@%s", chartPath, gotDigest)
+		}
+
+		if registry.requests != requests {
+			t.Errorf("expected no request to the registry, but %d requests were made", registry.requests-requests)
+		}
+
+		writeTestFiles(t, chartPath, map[string]string{"charts/dep.tgz": "dep"})
+
+		cached := cache.lookup(renderCacheOCI, "mychart-"+strings.TrimPrefix(digest, "sha256:")+"-unsigned")
+		if _, err := os.Stat(filepath.Join(cached, "charts", "dep.tgz")); !os.IsNotExist(err) {
+			t.Errorf("expected the cached chart to be unchanged: %v", err)
+		}
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		release := &ReleaseSpec{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0", ChartDigest: signedDigest}
+
+		registry.manifests[signedDigest] = registry.manifests["1.0.0"]
+		defer func() {
+			registry.manifests[signedDigest] = registry.manifests["1.0.0-signed"]
+		}()
+
+		_, _, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, newRenderCache(filepath.Join(dir, "empty")))
+
+		want := fmt.Sprintf("%s/v2/charts/mychart/manifests/%s has the digest %s rather than %s", registry.URL, signedDigest, digest, signedDigest)
+		if err == nil || err.Error() != want {
+			t.Errorf("unexpected error: want %q, got %v", want, err)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		verify := true
+		release := &ReleaseSpec{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0-signed", Verify: &verify}
+
+		_, gotDigest, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, cache)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if gotDigest != signedDigest {
+			t.Errorf("unexpected digest: want %s, got %s", signedDigest, gotDigest)
+		}
+
+		if len(helm.Verified) != 1 || filepath.Base(helm.Verified[0]) != "mychart-1.0.0.tgz" {
+			t.Errorf("unexpected verified charts: %v", helm.Verified)
+		}
+	})
+
+	t.Run("verify by default with provenance", func(t *testing.T) {
+		helm := &exectest.Helm{Helm3: true}
+		release := &ReleaseSpec{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0-signed"}
+
+		if _, _, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, newRenderCache(filepath.Join(dir, "default"))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(helm.Verified) != 1 {
+			t.Errorf("expected the chart pushed with the provenance to be verified: %v", helm.Verified)
+		}
+	})
+
+	t.Run("verification disabled", func(t *testing.T) {
+		helm := &exectest.Helm{Helm3: true}
+		verify := false
+		release := &ReleaseSpec{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0-signed", Verify: &verify}
+
+		if _, _, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, newRenderCache(filepath.Join(dir, "disabled"))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(helm.Verified) != 0 {
+			t.Errorf("expected no verification: %v", helm.Verified)
+		}
+	})
+
+	t.Run("verify without provenance", func(t *testing.T) {
+		verify := true
+		release := &ReleaseSpec{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0", Verify: &verify}
+
+		_, _, err := st.getOCIChart(release, filepath.Join(dir, "tmp"), helm, cache)
+
+		want := fmt.Sprintf("verifying chart oci://%s/charts/mychart:1.0.0: no provenance is pushed along with the chart", registry.host())
+		if err == nil || err.Error() != want {
+			t.Errorf("unexpected error: want %q, got %v", want, err)
+		}
+	})
+}
+
+func TestHelmState_PrepareCharts_OCIChartDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := newTestRegistry()
+	defer registry.Close()
+
+	digest := registry.push(t, "1.0.0", testChartArchive(t, "mychart", "1.0.0"), nil)
+
+	st := newRenderCacheTestState(dir)
+	st.RenderedValues = map[string]interface{}{}
+	st.fileExists = func(path string) (bool, error) { _, err := os.Stat(path); return err == nil, nil }
+	st.Repositories = []RepositorySpec{
+		{Name: "myoci", URL: registry.host() + "/charts", OCI: true, SkipTLSVerify: "true", Username: "user", Password: "password"},
+	}
+	st.Releases = []ReleaseSpec{
+		{Name: "foo", Chart: "myoci/mychart", Version: "1.0.0"},
+		{Name: "bar", Chart: "./local"},
+	}
+	writeTestFiles(t, filepath.Join(dir, "local"), map[string]string{"Chart.yaml": "name: local\n"})
+
+	charts, errs := st.PrepareCharts(&exectest.Helm{Helm3: true}, filepath.Join(dir, "tmp"), 1, "build", ChartPrepareOptions{
+		SkipRepos:      true,
+		SkipDeps:       true,
+		SkipResolve:    true,
+		RenderCacheDir: filepath.Join(dir, "cache"),
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if _, err := os.Stat(filepath.Join(charts[PrepareChartKey{Name: "foo"}], "Chart.yaml")); err != nil {
+		t.Errorf("expected the chart to be pulled: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, r := range st.Releases {
+		got[r.Name] = r.ChartDigest
+	}
+
+	if d := cmp.Diff(map[string]string{"foo": digest, "bar": ""}, got); d != "" {
+		t.Errorf("unexpected digests: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_OCIRegistry_HelmRegistryCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config.json")
+	writeTestFiles(t, dir, map[string]string{"config.json": `{"auths": {"registry.example.com": {"username": "user", "password": "password"}}}`})
+
+	os.Setenv("HELM_REGISTRY_CONFIG", config)
+	defer os.Unsetenv("HELM_REGISTRY_CONFIG")
+
+	st := newRenderCacheTestState(dir)
+	st.readFile = ioutil.ReadFile
+
+	testcases := []struct {
+		name               string
+		repo               *RepositorySpec
+		username, password string
+	}{
+		{
+			name:     "no repository",
+			username: "user",
+			password: "password",
+		},
+		{
+			name:     "repository without credentials",
+			repo:     &RepositorySpec{Name: "myoci", URL: "registry.example.com/charts", OCI: true},
+			username: "user",
+			password: "password",
+		},
+		{
+			name:     "repository with credentials",
+			repo:     &RepositorySpec{Name: "myoci", URL: "registry.example.com/charts", OCI: true, Username: "other", Password: "secret"},
+			username: "other",
+			password: "secret",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry, err := st.ociRegistry(&ociChart{host: "registry.example.com", repository: "charts/mychart", tag: "1.0.0", repo: tc.repo})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if registry.username != tc.username || registry.password != tc.password {
+				t.Errorf("unexpected credentials: %s:%s", registry.username, registry.password)
+			}
+		})
+	}
+}
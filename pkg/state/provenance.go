@@ -0,0 +1,322 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/maputil"
+)
+
+// ValueSource is a values file, an inline values entry or a `set` entry that sets a value
+type ValueSource struct {
+	// Source is the path to the values file, or the description of the inline values or the `set` entry
+	Source string
+	// Line is the line number of the value in the values file, or 0 when unknown
+	Line  int
+	Value interface{}
+}
+
+func (s ValueSource) Location() string {
+	if s.Line > 0 {
+		return fmt.Sprintf("%s:%d", s.Source, s.Line)
+	}
+	return s.Source
+}
+
+// ValueProvenance is the final value at a key path along with the sources that set or overrode it.
+// Sources are ordered from the lowest to the highest precedence, so that the last one is the one that won.
+type ValueProvenance struct {
+	Key     string
+	Value   interface{}
+	Set     bool
+	Sources []ValueSource
+}
+
+// ReleaseValuesProvenance explains where each of the values that are passed to helm for the release came from.
+// Values files and inline values including `valuesTemplate`, `secrets` and `set` are taken into account in the
+// same order as helm merges them.
+// When key is empty, every leaf value is explained.
+func (st *HelmState) ReleaseValuesProvenance(helm helmexec.Interface, release *ReleaseSpec, key string) ([]*ValueProvenance, error) {
+	generatedFiles, err := st.generateValuesFiles(helm, release, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer st.removeFiles(generatedFiles)
+
+	layers, err := st.releaseValuesLayers(release, generatedFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, set := range release.SetValues {
+		var value string
+		switch {
+		case set.File != "":
+			value = fmt.Sprintf("(contents of %s)", set.File)
+		case len(set.Values) > 0:
+			value = "{" + strings.Join(set.Values, ",") + "}"
+		default:
+			value = set.Value
+		}
+
+		vals := map[string]interface{}{}
+		maputil.Set(vals, maputil.ParseKey(set.Name), value)
+
+		layers = append(layers, valuesLayer{
+			source: fmt.Sprintf("set[%d] of release \"%s\" in %s", i, release.Name, st.FilePath),
+			inline: true,
+			values: vals,
+		})
+	}
+
+	return explainValuesLayers(layers, key, nil)
+}
+
+// StateValuesProvenance explains where each of the helmfile-wide values, that is `.Values` in templates, came from.
+// `values`, the environment values and secrets, and the overrides like `--state-values-set` are taken into account in
+// the order of precedence.
+// When key is empty, every leaf value is explained.
+func (st *HelmState) StateValuesProvenance(helm helmexec.Interface, key string, overrides []interface{}) ([]*ValueProvenance, error) {
+	var layers []valuesLayer
+
+	add := func(missingFileHandler *string, entries []interface{}, describe func(int) string) error {
+		for i, entry := range entries {
+			var vals map[string]interface{}
+			var err error
+			if m, ok := entry.(map[string]interface{}); ok {
+				vals, err = maputil.CastKeysToStrings(m)
+			} else {
+				vals, err = st.loadValuesEntries(missingFileHandler, []interface{}{entry}, st.newRemote(), &st.Env)
+			}
+			if err != nil {
+				return err
+			}
+
+			l := valuesLayer{values: vals}
+
+			if path, ok := entry.(string); ok {
+				l.source = path
+				st.loadValuesLayerNode(&l, path)
+			} else {
+				l.source = describe(i)
+				l.inline = true
+			}
+
+			layers = append(layers, l)
+		}
+		return nil
+	}
+
+	if err := add(nil, st.DefaultValues, func(i int) string {
+		return fmt.Sprintf("values[%d] in %s", i, st.FilePath)
+	}); err != nil {
+		return nil, err
+	}
+
+	spec, ok, err := st.ResolveEnvironment(st.Env.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		if err := add(spec.MissingFileHandler, spec.Values, func(i int) string {
+			return fmt.Sprintf("environments.%s.values[%d] in %s", st.Env.Name, i, st.FilePath)
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, path := range spec.Secrets {
+			files, skipped, err := st.storage().resolveFile(spec.MissingFileHandler, "environment values", path)
+			if err != nil {
+				return nil, err
+			}
+			if skipped {
+				continue
+			}
+
+			for _, f := range files {
+				release := &ReleaseSpec{}
+				flags := st.appendConnectionFlags([]string{}, helm, release)
+				decFile, err := helm.DecryptSecret(st.createHelmContext(release, 0), f, flags...)
+				if err != nil {
+					return nil, err
+				}
+
+				bs, err := st.readFile(decFile)
+				if err != nil {
+					return nil, fmt.Errorf("failed to load environment secrets file \"%s\": %v", f, err)
+				}
+				st.removeFiles([]string{decFile})
+
+				l, err := newValuesLayer(f, false, bs)
+				if err != nil {
+					return nil, err
+				}
+
+				layers = append(layers, *l)
+			}
+		}
+	}
+
+	if err := add(nil, overrides, func(int) string {
+		return "--state-values-set"
+	}); err != nil {
+		return nil, err
+	}
+
+	return explainValuesLayers(layers, key, st.Values())
+}
+
+// loadValuesLayerNode loads the YAML node of the local values file so that the line numbers of values can be reported.
+// Values files that are not valid YAML before rendering, or remote ones, are left without line numbers.
+func (st *HelmState) loadValuesLayerNode(l *valuesLayer, path string) {
+	files, err := st.storage().ExpandPaths(path)
+	if err != nil || len(files) != 1 {
+		return
+	}
+
+	bs, err := st.readFile(files[0])
+	if err != nil {
+		return
+	}
+
+	if fromFile, err := newValuesLayer(path, false, bs); err == nil {
+		l.node = fromFile.node
+	}
+}
+
+// explainValuesLayers returns the provenance of the value at the key, or of every leaf value when the key is empty.
+// When actual is non-nil, it is used as the final values, and any difference from the values merged from the layers
+// is attributed to the parent helmfile.
+func explainValuesLayers(layers []valuesLayer, key string, actual map[string]interface{}) ([]*ValueProvenance, error) {
+	merged, err := mergeValuesLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	final := merged
+	if actual != nil {
+		final = actual
+	}
+
+	var paths [][]string
+	if key != "" {
+		paths = append(paths, maputil.ParseKey(key))
+	} else {
+		paths = leafPaths(nil, final)
+		sort.Slice(paths, func(i, j int) bool {
+			return strings.Join(paths[i], ".") < strings.Join(paths[j], ".")
+		})
+	}
+
+	var result []*ValueProvenance
+
+	for _, path := range paths {
+		p := &ValueProvenance{Key: strings.Join(path, ".")}
+		p.Value, p.Set = lookupValue(final, path)
+
+		for _, l := range layers {
+			v, ok := lookupValue(l.values, path)
+			if !ok {
+				continue
+			}
+
+			s := ValueSource{Source: l.source, Value: v}
+			if !l.inline && l.node != nil {
+				if line, depth := lookupYAMLLine(l.node, path); depth == len(path) {
+					s.Line = line
+				}
+			}
+
+			p.Sources = append(p.Sources, s)
+		}
+
+		if fromLayers, ok := lookupValue(merged, path); p.Set && (!ok || !reflect.DeepEqual(fromLayers, p.Value)) {
+			p.Sources = append(p.Sources, ValueSource{Source: "the parent helmfile", Value: p.Value})
+		}
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+func lookupValue(values interface{}, path []string) (interface{}, bool) {
+	cur := values
+
+	for _, k := range path {
+		switch typed := cur.(type) {
+		case map[string]interface{}:
+			v, ok := typed[k]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case map[interface{}]interface{}:
+			v, ok := typed[k]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			idx, err := strconv.Atoi(k)
+			if err != nil || idx < 0 || idx >= len(typed) {
+				return nil, false
+			}
+			cur = typed[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// leafPaths returns the paths to all the non-hash values. Arrays are treated as leaves as helm replaces them as a whole.
+func leafPaths(prefix []string, values map[string]interface{}) [][]string {
+	var paths [][]string
+
+	for k, v := range values {
+		path := append(append([]string{}, prefix...), k)
+
+		var child map[string]interface{}
+		switch typed := v.(type) {
+		case map[string]interface{}:
+			child = typed
+		case map[interface{}]interface{}:
+			casted, err := maputil.CastKeysToStrings(typed)
+			if err == nil {
+				child = casted
+			}
+		}
+
+		if len(child) > 0 {
+			paths = append(paths, leafPaths(path, child)...)
+		} else {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// FormatValue formats the value in a single line so that it can be printed along with its source
+func FormatValue(v interface{}) string {
+	if m, ok := v.(map[interface{}]interface{}); ok {
+		if casted, err := maputil.CastKeysToStrings(m); err == nil {
+			v = casted
+		}
+	}
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(bs)
+}
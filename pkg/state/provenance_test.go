@@ -0,0 +1,186 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/environment"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+func TestHelmState_StateValuesProvenance(t *testing.T) {
+	st := &HelmState{
+		basePath: "/path/to",
+		FilePath: "/path/to/helmfile.yaml",
+		logger:   logger,
+		RenderedValues: map[string]interface{}{
+			"image":    map[string]interface{}{"tag": "v3", "repository": "nginx"},
+			"replicas": 3,
+		},
+		ReleaseSetSpec: ReleaseSetSpec{
+			Env: environment.Environment{
+				Name: "prod",
+				Values: map[string]interface{}{
+					"image": map[string]interface{}{"tag": "v2"},
+				},
+			},
+			DefaultValues: []interface{}{
+				map[interface{}]interface{}{
+					"image": map[interface{}]interface{}{"tag": "v1", "repository": "nginx"},
+				},
+			},
+			Environments: map[string]EnvironmentSpec{
+				"prod": {Values: []interface{}{"prod.yaml"}},
+			},
+		},
+	}
+	st = injectFs(st, testhelper.NewTestFs(map[string]string{
+		"/path/to/prod.yaml": "replicas: 3\nimage:\n  tag: v2\n",
+	}))
+
+	overrides := []interface{}{
+		map[string]interface{}{"image": map[string]interface{}{"tag": "v3"}},
+	}
+
+	got, err := st.StateValuesProvenance(nil, "", overrides)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []*ValueProvenance{
+		{
+			Key:   "image.repository",
+			Value: "nginx",
+			Set:   true,
+			Sources: []ValueSource{
+				{Source: "values[0] in /path/to/helmfile.yaml", Value: "nginx"},
+			},
+		},
+		{
+			Key:   "image.tag",
+			Value: "v3",
+			Set:   true,
+			Sources: []ValueSource{
+				{Source: "values[0] in /path/to/helmfile.yaml", Value: "v1"},
+				{Source: "prod.yaml", Line: 3, Value: "v2"},
+				{Source: "--state-values-set", Value: "v3"},
+			},
+		},
+		{
+			Key:   "replicas",
+			Value: 3,
+			Set:   true,
+			Sources: []ValueSource{
+				{Source: "prod.yaml", Line: 1, Value: 3},
+			},
+		},
+	}
+
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected provenance:\n%s", d)
+	}
+}
+
+func TestExplainValuesLayers(t *testing.T) {
+	layers := []valuesLayer{
+		mustValuesLayer(t, "values.yaml", false, "image:\n  repository: nginx\n  tag: latest\nreplicas: 2\n"),
+		mustValuesLayer(t, `values[1] of release "foo" in helmfile.yaml`, true, "replicas: 5\n"),
+		mustValuesLayer(t, "override.yaml", false, "\nimage:\n  tag: v2\n"),
+	}
+
+	testcases := []struct {
+		name   string
+		key    string
+		actual map[string]interface{}
+		want   []*ValueProvenance
+	}{
+		{
+			name: "all values",
+			want: []*ValueProvenance{
+				{
+					Key: "image.repository", Value: "nginx", Set: true,
+					Sources: []ValueSource{{Source: "values.yaml", Line: 2, Value: "nginx"}},
+				},
+				{
+					Key: "image.tag", Value: "v2", Set: true,
+					Sources: []ValueSource{
+						{Source: "values.yaml", Line: 3, Value: "latest"},
+						{Source: "override.yaml", Line: 3, Value: "v2"},
+					},
+				},
+				{
+					Key: "replicas", Value: 5, Set: true,
+					Sources: []ValueSource{
+						{Source: "values.yaml", Line: 4, Value: 2},
+						{Source: `values[1] of release "foo" in helmfile.yaml`, Value: 5},
+					},
+				},
+			},
+		},
+		{
+			name: "hash",
+			key:  "image",
+			want: []*ValueProvenance{
+				{
+					Key: "image", Value: map[string]interface{}{"repository": "nginx", "tag": "v2"}, Set: true,
+					Sources: []ValueSource{
+						{Source: "values.yaml", Line: 1, Value: map[string]interface{}{"repository": "nginx", "tag": "latest"}},
+						{Source: "override.yaml", Line: 2, Value: map[string]interface{}{"tag": "v2"}},
+					},
+				},
+			},
+		},
+		{
+			name: "not set",
+			key:  "foo.bar",
+			want: []*ValueProvenance{{Key: "foo.bar"}},
+		},
+		{
+			name:   "set by the parent helmfile",
+			key:    "replicas",
+			actual: map[string]interface{}{"replicas": 10},
+			want: []*ValueProvenance{
+				{
+					Key: "replicas", Value: 10, Set: true,
+					Sources: []ValueSource{
+						{Source: "values.yaml", Line: 4, Value: 2},
+						{Source: `values[1] of release "foo" in helmfile.yaml`, Value: 5},
+						{Source: "the parent helmfile", Value: 10},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := explainValuesLayers(layers, tc.key, tc.actual)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("unexpected provenance:\n%s", d)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	v := map[interface{}]interface{}{"tag": "v1", "pullPolicy": nil}
+
+	if got, want := FormatValue(v), `{"pullPolicy":null,"tag":"v1"}`; got != want {
+		t.Errorf("unexpected formatted value: want %s, got %s", want, got)
+	}
+}
+
+func mustValuesLayer(t *testing.T, source string, inline bool, content string) valuesLayer {
+	t.Helper()
+
+	l, err := newValuesLayer(source, inline, []byte(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return *l
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+// Labels of the releases managed by helmfile, that are set on install/upgrade when helmDefaults.labelReleases is true
+const (
+	ManagedLabel = "helmfile.io/managed"
+	StateLabel   = "helmfile.io/state"
+	EnvLabel     = "helmfile.io/env"
+)
+
+// releaseLabelsMinHelmVersion is the first version of helm that supports `helm upgrade --labels`
+const releaseLabelsMinHelmVersion = "3.13.0"
+
+var invalidLabelValueChars = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// labelValue converts the string like the path of the state file to a valid label value.
+// The value longer than 63 characters is truncated with the hash of the string so that it stays unique.
+func labelValue(s string) string {
+	v := invalidLabelValueChars.ReplaceAllString(s, "_")
+
+	if len(v) > 63 {
+		sum := sha256.Sum256([]byte(s))
+		v = v[:52] + "-" + hex.EncodeToString(sum[:])[:10]
+	}
+
+	return strings.Trim(v, "-_.")
+}
+
+// stateName returns the name of the state to label the releases with
+func (st *HelmState) stateName() string {
+	if st.HelmDefaults.StateName != "" {
+		return st.HelmDefaults.StateName
+	}
+
+	return filepath.Base(st.FilePath)
+}
+
+// managedReleaseLabels returns the labels that identify the releases of this state and environment, in the format of `helm upgrade --labels`
+func (st *HelmState) managedReleaseLabels() string {
+	return fmt.Sprintf("%s=true,%s=%s,%s=%s", ManagedLabel, StateLabel, labelValue(st.stateName()), EnvLabel, labelValue(st.Env.Name))
+}
+
+// DetectReleasesToBePruned returns the releases labeled with this state and environment in all the namespaces of the kube contexts
+// of the helmfile and the releases, that are not declared in the releases.
+// helmDefaults.stateName is required, as the default state name is the file name like `helmfile.yaml` shared by many helmfiles,
+// whose releases would be pruned otherwise.
+//
+// The caller is responsible for excluding the releases declared in other helmfiles processed by the same run,
+// like the release moved from a helmfile to another one that isn't relabeled yet.
+func (st *HelmState) DetectReleasesToBePruned(helm helmexec.Interface, releases []ReleaseSpec) ([]ReleaseSpec, error) {
+	if !helm.IsVersionAtLeast(releaseLabelsMinHelmVersion) {
+		return nil, fmt.Errorf("pruning releases requires Helm %s or greater", releaseLabelsMinHelmVersion)
+	}
+
+	if st.HelmDefaults.StateName == "" {
+		return nil, fmt.Errorf("pruning releases of %s requires helmDefaults.stateName, that is unique to the helmfile across all the helmfiles deployed to the clusters", st.FilePath)
+	}
+
+	declared := map[string]bool{}
+	for i := range releases {
+		id, err := st.DeployedReleaseID(helm, &releases[i])
+		if err != nil {
+			return nil, err
+		}
+
+		declared[id] = true
+	}
+
+	// The kube context of the helmfile is searched even without releases, so that the releases of an emptied helmfile are pruned
+	targets := append([]ReleaseSpec{{}}, releases...)
+
+	var detected []ReleaseSpec
+
+	listed := map[string]bool{}
+
+	for i := range targets {
+		kubeContext := st.releaseKubeContext(&targets[i])
+		if listed[kubeContext] {
+			continue
+		}
+		listed[kubeContext] = true
+
+		// All the namespaces are searched, as the releases may be removed along with the last release in the namespace
+		deployed, err := st.listDeployedReleasesWith(helm, &targets[i], "--all", "--max", "0", "--all-namespaces", "--selector", st.managedReleaseLabels())
+		if err != nil {
+			return nil, fmt.Errorf("listing releases labeled with %s in kube context %q: %v", st.managedReleaseLabels(), kubeContext, err)
+		}
+
+		for _, d := range deployed {
+			if declared[d.ID()] {
+				continue
+			}
+
+			detected = append(detected, ReleaseSpec{
+				Name:        d.Name,
+				Namespace:   d.Namespace,
+				KubeContext: d.KubeContext,
+				Chart:       d.Chart,
+			})
+		}
+	}
+
+	return detected, nil
+}
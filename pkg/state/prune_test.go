@@ -0,0 +1,104 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/environment"
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+func TestLabelValue(t *testing.T) {
+	testcases := []struct {
+		in, want string
+	}{
+		{"helmfile.yaml", "helmfile.yaml"},
+		{"helmfile.d/apps.yaml", "helmfile.d_apps.yaml"},
+		{"/path/to/helmfile.yaml", "path_to_helmfile.yaml"},
+	}
+
+	for _, tc := range testcases {
+		if got := labelValue(tc.in); got != tc.want {
+			t.Errorf("unexpected label value of %q: want %q, got %q", tc.in, tc.want, got)
+		}
+	}
+
+	long, other := labelValue(strings.Repeat("a", 70)), labelValue(strings.Repeat("a", 71))
+	if len(long) != 63 || !strings.HasPrefix(long, strings.Repeat("a", 52)+"-") {
+		t.Errorf("unexpected label value of the long string: %s", long)
+	}
+	if long == other {
+		t.Errorf("expected the truncated label values to differ: %s", long)
+	}
+}
+
+func TestHelmState_DetectReleasesToBePruned(t *testing.T) {
+	st := &HelmState{
+		FilePath: "helmfile.yaml",
+		ReleaseSetSpec: ReleaseSetSpec{
+			Env:          environment.Environment{Name: "production"},
+			HelmDefaults: HelmSpec{KubeContext: "default", LabelReleases: true, StateName: "apps"},
+			Releases: []ReleaseSpec{
+				{Name: "foo", Chart: "stable/foo", Namespace: "apps"},
+				{Name: "bar", Chart: "stable/bar", Namespace: "apps", Installed: boolValue(false)},
+			},
+		},
+		logger: logger,
+	}
+
+	helm := &exectest.Helm{
+		Helm3:   true,
+		Version: semver.MustParse("3.13.0"),
+		Deployed: map[string]string{
+			"apps": `[
+{"name":"foo","namespace":"apps","revision":"3","status":"deployed","chart":"foo-1.2.5"},
+{"name":"bar","namespace":"apps","revision":"1","status":"deployed","chart":"bar-1.0.0"},
+{"name":"removed","namespace":"apps","revision":"7","status":"deployed","chart":"baz-0.1.0"}
+]`,
+			// The namespace no longer used by any release of the helmfile
+			"old": `[{"name":"moved","namespace":"old","revision":"2","status":"deployed","chart":"moved-1.0.0"}]`,
+		},
+	}
+
+	if got := st.managedReleaseLabels(); got != "helmfile.io/managed=true,helmfile.io/state=apps,helmfile.io/env=production" {
+		t.Errorf("unexpected labels: %s", got)
+	}
+
+	detected, err := st.DetectReleasesToBePruned(helm, st.GetReleasesWithOverrides())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []ReleaseSpec{
+		{Name: "removed", Namespace: "apps", KubeContext: "default", Chart: "baz"},
+		{Name: "moved", Namespace: "old", KubeContext: "default", Chart: "moved"},
+	}
+
+	if d := cmp.Diff(want, detected, cmp.AllowUnexported(ReleaseSpec{})); d != "" {
+		t.Errorf("unexpected releases to be pruned: want (-), got (+):\n%s", d)
+	}
+
+	// All the releases of the helmfile whose releases are all removed are pruned
+	detected, err = st.DetectReleasesToBePruned(helm, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(detected) != 4 {
+		t.Errorf("expected all the labeled releases to be pruned: %v", detected)
+	}
+
+	st.HelmDefaults.StateName = ""
+	if _, err := st.DetectReleasesToBePruned(helm, st.GetReleasesWithOverrides()); err == nil || !strings.Contains(err.Error(), "requires helmDefaults.stateName") {
+		t.Errorf("expected an error without helmDefaults.stateName: %v", err)
+	}
+	st.HelmDefaults.StateName = "apps"
+
+	helm.Version = semver.MustParse("3.12.3")
+	if _, err := st.DetectReleasesToBePruned(helm, st.GetReleasesWithOverrides()); err == nil {
+		t.Error("expected an error for helm older than 3.13.0")
+	}
+}
@@ -10,6 +10,8 @@ type ReleaseError struct {
 	*ReleaseSpec
 	err  error
 	Code int
+	// Attempts is the number of attempts made to upgrade the release, that is greater than 1 when retried
+	Attempts int
 }
 
 func (e *ReleaseError) Error() string {
@@ -0,0 +1,171 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+// ReleaseStatus is the status of a release as reported by helm, for `helmfile status --output`
+type ReleaseStatus struct {
+	Name        string `json:"name" yaml:"name"`
+	Namespace   string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	KubeContext string `json:"kubeContext,omitempty" yaml:"kubeContext,omitempty"`
+	Installed   bool   `json:"installed" yaml:"installed"`
+	Revision    int    `json:"revision,omitempty" yaml:"revision,omitempty"`
+	// Status is the status of the latest revision reported by helm, like deployed, failed and pending-upgrade
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+	Chart  string `json:"chart" yaml:"chart"`
+	// ChartVersion and AppVersion are the versions of the deployed chart
+	ChartVersion string `json:"chartVersion,omitempty" yaml:"chartVersion,omitempty"`
+	AppVersion   string `json:"appVersion,omitempty" yaml:"appVersion,omitempty"`
+	// DesiredVersion is the version of the chart specified in the helmfile, that can be a version range
+	DesiredVersion string `json:"desiredVersion,omitempty" yaml:"desiredVersion,omitempty"`
+	// VersionMismatch is true when the deployed chart version doesn't satisfy the desired version
+	VersionMismatch bool   `json:"versionMismatch" yaml:"versionMismatch"`
+	LastDeployed    string `json:"lastDeployed,omitempty" yaml:"lastDeployed,omitempty"`
+	// Healthy is false when the latest revision is failed or pending, that needs attention
+	Healthy bool `json:"healthy" yaml:"healthy"`
+}
+
+// helmReleaseStatus is the subset of the output of `helm status --output json`
+type helmReleaseStatus struct {
+	Version int `json:"version"`
+	Info    struct {
+		LastDeployed string `json:"last_deployed"`
+		Status       string `json:"status"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+}
+
+// isHealthyHelmStatus returns false for the statuses of the failed or the interrupted helm operations
+func isHealthyHelmStatus(status string) bool {
+	return status != "failed" && !strings.HasPrefix(status, "pending-")
+}
+
+// isReleaseNotFound returns true when `helm status` failed as the release is not installed
+func isReleaseNotFound(err error) bool {
+	return strings.Contains(err.Error(), "release: not found")
+}
+
+// GetReleaseStatuses returns the statuses of the desired releases, in the order of the releases.
+// Unlike ReleaseStatuses that writes the output of `helm status` as is, it parses it so that it can be aggregated.
+func (st *HelmState) GetReleaseStatuses(helm helmexec.Interface, workerLimit int) ([]ReleaseStatus, []error) {
+	if !helm.IsHelm3() {
+		return nil, []error{errors.New("helmfile status --output requires helm 3")}
+	}
+
+	var mu sync.Mutex
+
+	statuses := map[string]ReleaseStatus{}
+
+	errs := st.scatterGatherReleases(helm, workerLimit, func(release ReleaseSpec, workerIndex int) error {
+		if !release.Desired() {
+			return nil
+		}
+
+		st.ApplyOverrides(&release)
+
+		status, err := st.getReleaseStatus(helm, &release, workerIndex)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		statuses[ReleaseToID(&release)] = *status
+		mu.Unlock()
+
+		return nil
+	})
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var result []ReleaseStatus
+
+	for i := range st.Releases {
+		r := st.Releases[i]
+		st.ApplyOverrides(&r)
+
+		if s, ok := statuses[ReleaseToID(&r)]; ok {
+			result = append(result, s)
+		}
+	}
+
+	return result, nil
+}
+
+func (st *HelmState) getReleaseStatus(helm helmexec.Interface, release *ReleaseSpec, workerIndex int) (*ReleaseStatus, error) {
+	flags := []string{}
+	if release.Namespace != "" {
+		flags = append(flags, "--namespace", release.Namespace)
+	}
+	flags = st.appendConnectionFlags(flags, helm, release)
+
+	status := &ReleaseStatus{
+		Name:           release.Name,
+		Namespace:      release.Namespace,
+		KubeContext:    st.releaseKubeContext(release),
+		Chart:          release.Chart,
+		DesiredVersion: release.Version,
+		Healthy:        true,
+	}
+
+	out, err := helm.GetReleaseStatus(st.createHelmContext(release, workerIndex), release.Name, flags...)
+	if err != nil {
+		if isReleaseNotFound(err) {
+			return status, nil
+		}
+
+		return nil, newReleaseFailedError(release, err)
+	}
+
+	var s helmReleaseStatus
+	if err := json.Unmarshal(out, &s); err != nil {
+		return nil, newReleaseFailedError(release, fmt.Errorf("parsing the output of helm status: %v", err))
+	}
+
+	status.Installed = true
+	status.Revision = s.Version
+	status.Status = s.Info.Status
+	status.LastDeployed = s.Info.LastDeployed
+	status.ChartVersion = s.Chart.Metadata.Version
+	status.AppVersion = s.Chart.Metadata.AppVersion
+	status.VersionMismatch = !chartVersionSatisfies(s.Chart.Metadata.Version, release.Version)
+	status.Healthy = isHealthyHelmStatus(s.Info.Status)
+
+	return status, nil
+}
+
+// chartVersionSatisfies returns true when the deployed chart version is the desired version, or within the desired version range.
+// Any version satisfies the empty desired version.
+func chartVersionSatisfies(deployed, desired string) bool {
+	if desired == "" || deployed == desired {
+		return true
+	}
+
+	c, err := semver.NewConstraint(desired)
+	if err != nil {
+		return false
+	}
+
+	v, err := semver.NewVersion(deployed)
+	if err != nil {
+		return false
+	}
+
+	return c.Check(v)
+}
@@ -0,0 +1,91 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+func TestHelmState_GetReleaseStatuses(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			HelmDefaults: HelmSpec{KubeContext: "default"},
+			Releases: []ReleaseSpec{
+				{Name: "deployed", Chart: "stable/foo", Version: "~1.2.0", Namespace: "apps"},
+				{Name: "outdated", Chart: "stable/foo", Version: "1.3.0", Namespace: "apps"},
+				{Name: "failed", Chart: "stable/bar", Namespace: "apps"},
+				{Name: "pending", Chart: "stable/bar", Namespace: "apps"},
+				{Name: "missing", Chart: "stable/bar", Namespace: "apps"},
+				{Name: "uninstalled", Chart: "stable/bar", Namespace: "apps", Installed: boolValue(false)},
+			},
+		},
+		logger:         logger,
+		RenderedValues: map[string]interface{}{},
+	}
+
+	status := func(revision, status, version string) string {
+		return `{"name":"x","version":` + revision + `,"info":{"last_deployed":"2021-05-01T10:00:00Z","status":"` + status + `"},"chart":{"metadata":{"name":"foo","version":"` + version + `","appVersion":"2.0"}}}`
+	}
+
+	helm := &exectest.Helm{
+		Helm3: true,
+		Statuses: map[string]string{
+			"deployed": status("3", "deployed", "1.2.5"),
+			"outdated": status("1", "deployed", "1.2.5"),
+			"failed":   status("2", "failed", "1.0.0"),
+			"pending":  status("4", "pending-upgrade", "1.0.0"),
+		},
+	}
+
+	statuses, errs := st.GetReleaseStatuses(helm, 1)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	installed := func(name, status string, revision int, version, desired string, mismatch, healthy bool) ReleaseStatus {
+		chart := "stable/bar"
+		if desired != "" {
+			chart = "stable/foo"
+		}
+		return ReleaseStatus{
+			Name:            name,
+			Namespace:       "apps",
+			KubeContext:     "default",
+			Installed:       true,
+			Revision:        revision,
+			Status:          status,
+			Chart:           chart,
+			ChartVersion:    version,
+			AppVersion:      "2.0",
+			DesiredVersion:  desired,
+			VersionMismatch: mismatch,
+			LastDeployed:    "2021-05-01T10:00:00Z",
+			Healthy:         healthy,
+		}
+	}
+
+	want := []ReleaseStatus{
+		installed("deployed", "deployed", 3, "1.2.5", "~1.2.0", false, true),
+		installed("outdated", "deployed", 1, "1.2.5", "1.3.0", true, true),
+		installed("failed", "failed", 2, "1.0.0", "", false, false),
+		installed("pending", "pending-upgrade", 4, "1.0.0", "", false, false),
+		{Name: "missing", Namespace: "apps", KubeContext: "default", Chart: "stable/bar", Healthy: true},
+	}
+
+	if d := cmp.Diff(want, statuses); d != "" {
+		t.Errorf("unexpected statuses: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_GetReleaseStatuses_Helm2(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{Releases: []ReleaseSpec{{Name: "foo", Chart: "stable/foo"}}},
+		logger:         logger,
+	}
+
+	if _, errs := st.GetReleaseStatuses(&exectest.Helm{}, 1); len(errs) != 1 {
+		t.Errorf("expected an error for helm 2, got %v", errs)
+	}
+}
@@ -0,0 +1,271 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/variantdev/chartify"
+)
+
+const (
+	renderCacheChartify = "chartify"
+	renderCacheOCI      = "oci"
+	renderCacheDeps     = "deps"
+)
+
+// renderCache is the on-disk cache of the charts prepared by PrepareCharts, shared across helmfile runs.
+//
+// Each entry is a directory keyed by the hash of all the inputs to the preparation, like the chart files,
+// the exact chart version and the chartify inputs, so that a stale entry is never used.
+// Inputs that can't be pinned, like a version range of a remote chart, make the preparation uncacheable.
+// Rendered manifests aren't cached, as they can depend on the cluster via `lookup` and `--validate`.
+type renderCache struct {
+	dir string
+}
+
+func newRenderCache(dir string) *renderCache {
+	if dir == "" {
+		return nil
+	}
+
+	return &renderCache{dir: dir}
+}
+
+func (c *renderCache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, key)
+}
+
+// lookup returns the path to the cached directory, or an empty string when it is not cached
+func (c *renderCache) lookup(kind, key string) string {
+	p := c.path(kind, key)
+
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		return p
+	}
+
+	return ""
+}
+
+// store copies the directory at src to the cache and returns the path to the cached directory.
+// The copy is renamed into place so that concurrent helmfile runs never see a partially written entry.
+func (c *renderCache) store(kind, key, src string) (string, error) {
+	dst := c.path(kind, key)
+
+	parent := filepath.Dir(dst)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempDir(parent, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+
+	if err := copyDir(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("caching %s: %v", src, err)
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+
+		// Another helmfile run cached the same entry in the meantime
+		if c.lookup(kind, key) != "" {
+			return dst, nil
+		}
+
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// copyDir copies the files in the src directory to the dst directory recursively, following symlinks
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, bs, info.Mode().Perm())
+	})
+}
+
+// isExactVersion returns true when the version pins a single chart version, as opposed to a range or the latest version
+func isExactVersion(v string) bool {
+	_, err := semver.StrictNewVersion(v)
+	return err == nil
+}
+
+// dirDigest returns the SHA-256 of the relative paths and the contents of the files in the directory,
+// excluding the top-level directories of the names in exclude
+func (st *HelmState) dirDigest(dir string, exclude ...string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			for _, e := range exclude {
+				if rel == e {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		bs, err := st.readFile(path)
+		if err != nil {
+			return err
+		}
+
+		h.Write([]byte(rel))
+		h.Write([]byte{0})
+		h.Write(bs)
+		h.Write([]byte{0})
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// filesDigest returns the SHA-256 of the contents of the files
+func (st *HelmState) filesDigest(files []string) (string, error) {
+	h := sha256.New()
+
+	for _, f := range files {
+		bs, err := st.readFile(f)
+		if err != nil {
+			return "", err
+		}
+		h.Write(bs)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// chartifyCacheKey returns the key of the chart generated by chartify from the chart and the options,
+// or false when the chart can't be cached as any of the inputs is not pinned
+func (st *HelmState) chartifyCacheKey(helm3 bool, release *ReleaseSpec, chart string, opts *chartify.ChartifyOpts) (string, bool) {
+	source, ok := st.chartSourceDigest(chart, release.Version)
+	if !ok {
+		return "", false
+	}
+
+	var deps []string
+	for _, d := range opts.AdhocChartDependencies {
+		digest, ok := st.chartSourceDigest(d.Chart, d.Version)
+		if !ok {
+			return "", false
+		}
+		deps = append(deps, d.Alias, digest)
+	}
+
+	var files []string
+	for _, fs := range [][]string{opts.ValuesFiles, opts.JsonPatches, opts.StrategicMergePatches, opts.Transformers} {
+		digest, err := st.filesDigest(fs)
+		if err != nil {
+			st.logger.Debugf("render cache: %v", err)
+			return "", false
+		}
+		files = append(files, digest)
+	}
+
+	hash, err := HashObject([]interface{}{
+		source,
+		deps,
+		files,
+		opts.SetFlags,
+		opts.ID,
+		opts.Namespace,
+		opts.OverrideNamespace,
+		opts.ChartVersion,
+		opts.EnableKustomizeAlphaPlugins,
+		opts.SkipDeps,
+		opts.IncludeCRDs,
+		opts.Validate,
+		opts.ApiVersions,
+		opts.TemplateData,
+		helm3,
+		st.DefaultHelmBinary,
+	})
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s-%s", release.Name, hash), true
+}
+
+// chartSourceDigest returns the digest of the files of the local chart, or the repository URL, the name and the version
+// of the remote chart.
+// It returns false for the remote chart without an exact version, as it may resolve to another chart later.
+func (st *HelmState) chartSourceDigest(chart, version string) (string, bool) {
+	if dir := normalizeChart(st.basePath, chart); st.directoryExistsAt(dir) {
+		digest, err := st.dirDigest(dir)
+		if err != nil {
+			st.logger.Debugf("render cache: %v", err)
+			return "", false
+		}
+		return digest, true
+	}
+
+	if !isExactVersion(version) {
+		return "", false
+	}
+
+	// The same chart name can refer to charts in different repositories, like the ones of the environments
+	if repo, name := st.GetRepositoryAndNameFromChartName(chart); repo != nil {
+		return repo.URL + "/" + name + "@" + version, true
+	}
+
+	return chart + "@" + version, true
+}
+
+// depsCacheKey returns the key of the dependencies built for the local chart at the path
+func (st *HelmState) depsCacheKey(helm3 bool, chartPath string) (string, bool) {
+	digest, err := st.dirDigest(chartPath, "charts", "tmpcharts")
+	if err != nil {
+		st.logger.Debugf("render cache: %v", err)
+		return "", false
+	}
+
+	hash, err := HashObject([]interface{}{digest, helm3, st.DefaultHelmBinary})
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s-%s", filepath.Base(chartPath), hash), true
+}
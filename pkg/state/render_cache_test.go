@@ -0,0 +1,307 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/variantdev/chartify"
+
+	"github.com/huolunl/helmfile/pkg/exectest"
+)
+
+func newRenderCacheTestState(basePath string) *HelmState {
+	return &HelmState{
+		basePath:          basePath,
+		logger:            logger,
+		readFile:          ioutil.ReadFile,
+		directoryExistsAt: func(path string) bool { info, err := os.Stat(path); return err == nil && info.IsDir() },
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRenderCache_StoreAndLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-render-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	writeTestFiles(t, src, map[string]string{
+		"Chart.yaml":            "name: foo\n",
+		"templates/cm.yaml":     "kind: ConfigMap\n",
+		"charts/bar/Chart.yaml": "name: bar\n",
+	})
+
+	cache := newRenderCache(filepath.Join(dir, "cache"))
+
+	if cached := cache.lookup(renderCacheChartify, "foo-1"); cached != "" {
+		t.Fatalf("unexpected cache hit: %s", cached)
+	}
+
+	stored, err := cache.store(renderCacheChartify, "foo-1", src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cached := cache.lookup(renderCacheChartify, "foo-1"); cached != stored {
+		t.Fatalf("unexpected cache lookup: want %s, got %s", stored, cached)
+	}
+
+	// Storing the same entry again is a no-op, as another helmfile run might have cached it concurrently
+	if _, err := cache.store(renderCacheChartify, "foo-1", src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	st := newRenderCacheTestState(dir)
+
+	want, err := st.dirDigest(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.dirDigest(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want != got {
+		t.Errorf("unexpected contents of the cached directory")
+	}
+
+	if newRenderCache("") != nil {
+		t.Errorf("expected the cache to be disabled by the empty directory")
+	}
+}
+
+func TestHelmState_ChartifyCacheKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-render-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		"manifests/deploy.yaml": "kind: Deployment\n",
+		"patch.yaml":            "op: replace\n",
+	})
+
+	st := newRenderCacheTestState(dir)
+
+	key := func(chart, version string, opts chartify.ChartifyOpts) (string, bool) {
+		return st.chartifyCacheKey(true, &ReleaseSpec{Name: "foo", Version: version}, chart, &opts)
+	}
+
+	patched := chartify.ChartifyOpts{JsonPatches: []string{filepath.Join(dir, "patch.yaml")}}
+
+	local, ok := key("./manifests", "", patched)
+	if !ok {
+		t.Fatal("expected the local chart to be cacheable")
+	}
+
+	if again, _ := key("./manifests", "", patched); again != local {
+		t.Errorf("expected the same key for the same inputs: %s, %s", local, again)
+	}
+
+	if other, _ := key("./manifests", "", chartify.ChartifyOpts{}); other == local {
+		t.Errorf("expected a different key without the patch")
+	}
+
+	writeTestFiles(t, dir, map[string]string{"patch.yaml": "op: remove\n"})
+
+	if changed, _ := key("./manifests", "", patched); changed == local {
+		t.Errorf("expected a different key for the changed patch")
+	}
+
+	writeTestFiles(t, dir, map[string]string{"manifests/deploy.yaml": "kind: StatefulSet\n"})
+
+	if changed, _ := key("./manifests", "", patched); changed == local {
+		t.Errorf("expected a different key for the changed chart")
+	}
+
+	testcases := []struct {
+		chart, version string
+		want           bool
+	}{
+		{"stable/foo", "1.2.3", true},
+		{"stable/foo", "~1.2", false},
+		{"stable/foo", "", false},
+	}
+
+	for _, tc := range testcases {
+		if _, ok := key(tc.chart, tc.version, patched); ok != tc.want {
+			t.Errorf("unexpected cacheability of %s@%s: want %v, got %v", tc.chart, tc.version, tc.want, ok)
+		}
+	}
+
+	remote, _ := key("stable/foo", "1.2.3", patched)
+
+	st.Repositories = []RepositorySpec{{Name: "stable", URL: "https://charts.example.com/stable"}}
+
+	if other, _ := key("stable/foo", "1.2.3", patched); other == remote {
+		t.Errorf("expected a different key for the chart in the repository")
+	}
+
+	withRepo, _ := key("stable/foo", "1.2.3", patched)
+
+	st.Repositories = []RepositorySpec{{Name: "stable", URL: "https://mirror.example.com/stable"}}
+
+	if other, _ := key("stable/foo", "1.2.3", patched); other == withRepo {
+		t.Errorf("expected a different key for the chart in another repository of the same name")
+	}
+
+		deps := chartify.ChartifyOpts{AdhocChartDependencies: []chartify.ChartDependency{{Chart: "stable/bar", Version: ">= 1.0.0"}}}
+	if _, ok := key("./manifests", "", deps); ok {
+		t.Errorf("expected the chart with the unpinned adhoc dependency to be uncacheable")
+	}
+}
+
+func TestHelmState_RunHelmDepBuilds_RenderCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-render-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chart := filepath.Join(dir, "foo")
+	writeTestFiles(t, chart, map[string]string{
+		"Chart.yaml": "name: foo\ndependencies:\n- name: bar\n",
+		"Chart.lock": "digest: abc\n",
+		// Emulates the dependency downloaded by `helm dep build`
+		"charts/bar-1.0.0.tgz": "bar",
+	})
+
+	st := newRenderCacheTestState(dir)
+	cache := newRenderCache(filepath.Join(dir, "cache"))
+	builds := []*chartPrepareResult{{releaseName: "foo", chartPath: chart}}
+
+	helm := &exectest.Helm{Helm3: true}
+	if err := st.runHelmDepBuilds(helm, 1, builds, cache); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(chart, "charts")); err != nil {
+		t.Fatal(err)
+	}
+
+	helm = &exectest.Helm{Helm3: true}
+	if err := st.runHelmDepBuilds(helm, 1, builds, cache); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(helm.Charts) > 0 {
+		t.Errorf("expected the dependencies to be restored from the cache, but built: %v", helm.Charts)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(chart, "charts", "bar-1.0.0.tgz"))
+	if err != nil {
+		t.Fatalf("expected the dependency to be restored: %v", err)
+	}
+
+	if d := cmp.Diff("bar", string(bs)); d != "" {
+		t.Errorf("unexpected dependency: want (-), got (+):\n%s", d)
+	}
+
+	// The updated lock file results in `helm dep build` again
+	writeTestFiles(t, chart, map[string]string{"Chart.lock": "digest: def\n"})
+
+	helm = &exectest.Helm{Helm3: true}
+	if err := st.runHelmDepBuilds(helm, 1, builds, cache); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d := cmp.Diff([]string{chart}, helm.Charts); d != "" {
+		t.Errorf("unexpected dependency builds: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_PrepareCharts_ChartifyCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-render-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, filepath.Join(dir, "local"), map[string]string{
+		"Chart.yaml":        "name: local\n",
+		"templates/cm.yaml": "kind: ConfigMap\n",
+	})
+
+	st := newRenderCacheTestState(dir)
+	st.RenderedValues = map[string]interface{}{}
+	st.fileExists = func(path string) (bool, error) { _, err := os.Stat(path); return err == nil, nil }
+	st.valsRuntime = valsRuntime
+	st.removeFile = os.Remove
+	st.Releases = []ReleaseSpec{
+		{Name: "foo", Chart: "./local", JSONPatches: []interface{}{map[string]interface{}{"target": map[string]interface{}{"kind": "ConfigMap"}}}},
+	}
+
+	helm := &exectest.Helm{Helm3: true}
+	cacheDir := filepath.Join(dir, "cache")
+
+	// Seed the cache with the chart chartify would generate, so that chartify isn't run in the test
+	release := st.Releases[0]
+	chartification, clean, err := st.PrepareChartify(helm, &release, release.Chart, 0)
+	if err != nil || chartification == nil {
+		t.Fatalf("expected the release to be chartified: %v", err)
+	}
+	chartifyOpts := chartification.Opts
+	chartifyOpts.SkipDeps = true
+	chartifyOpts.IncludeCRDs = true
+	key, ok := st.chartifyCacheKey(true, &release, release.Chart, chartifyOpts)
+	clean()
+	if !ok {
+		t.Fatal("expected the release to be cacheable")
+	}
+
+	generated := filepath.Join(dir, "generated")
+	writeTestFiles(t, generated, map[string]string{"Chart.yaml": "name: foo\n", "templates/patched.yaml": "kind: ConfigMap\n"})
+	cached, err := newRenderCache(cacheDir).store(renderCacheChartify, key, generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chart restored from the cache must outlive PrepareCharts, as it is used by helm afterwards
+	for i := 0; i < 2; i++ {
+		charts, errs := st.PrepareCharts(helm, tmp, 1, "template", ChartPrepareOptions{
+			SkipRepos:      true,
+			SkipDeps:       true,
+			SkipResolve:    true,
+			RenderCacheDir: cacheDir,
+		})
+		if len(errs) > 0 {
+			t.Fatalf("run %d: unexpected errors: %v", i, errs)
+		}
+
+		chart := charts[PrepareChartKey{Name: "foo"}]
+		if chart == cached || !strings.HasPrefix(chart, tmp) {
+			t.Errorf("run %d: expected the cached chart to be copied into the temporary directory, got %s", i, chart)
+		}
+
+		if _, err := os.Stat(filepath.Join(chart, "templates", "patched.yaml")); err != nil {
+			t.Errorf("run %d: expected the cached chart to be available after PrepareCharts: %v", i, err)
+		}
+	}
+}
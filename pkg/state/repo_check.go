@@ -0,0 +1,244 @@
+package state
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The statuses of the repositories checked by CheckRepos
+const (
+	RepoCheckOK      = "ok"
+	RepoCheckFailed  = "failed"
+	RepoCheckSkipped = "skipped"
+)
+
+// RepositoryCheck is the result of checking a repository
+type RepositoryCheck struct {
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+	OCI  bool   `json:"oci" yaml:"oci"`
+	// Status is one of ok, failed and skipped
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+const repoCheckTimeout = 30 * time.Second
+
+// CheckRepos verifies that each repository is reachable and accepts the credentials, without adding it to helm.
+// The index.yaml of a chart repository is fetched, and an OCI registry is logged in to in the same way as `helm registry login`.
+func (st *HelmState) CheckRepos(shouldSkip map[string]bool) []RepositoryCheck {
+	var checks []RepositoryCheck
+
+	for _, repo := range st.Repositories {
+		if shouldSkip[repo.Name] {
+			continue
+		}
+
+		check := RepositoryCheck{Name: repo.Name, URL: repo.URL, OCI: repo.OCI}
+
+		msg, err := st.checkRepo(repo)
+		switch {
+		case err != nil:
+			check.Status, check.Message = RepoCheckFailed, err.Error()
+		case msg != "":
+			check.Status, check.Message = RepoCheckSkipped, msg
+		default:
+			check.Status = RepoCheckOK
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// checkRepo returns the reason why the repository isn't checked, or the error when it's unreachable or rejects the credentials
+func (st *HelmState) checkRepo(repo RepositorySpec) (string, error) {
+	if repo.Managed != "" {
+		return fmt.Sprintf("managed by %s", repo.Managed), nil
+	}
+
+	username, password, err := st.repoCredentials(repo)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := st.repoHTTPClient(repo)
+	if err != nil {
+		return "", err
+	}
+
+	if repo.OCI {
+		return "", checkRegistry(client, registryHost(repo.URL), username, password)
+	}
+
+	u, err := url.Parse(repo.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Sprintf("%s repositories are served by helm plugins", u.Scheme), nil
+	}
+
+	index := strings.TrimSuffix(repo.URL, "/") + "/index.yaml"
+
+	res, err := httpGet(client, index, username, password)
+	if err != nil {
+		return "", err
+	}
+
+	return "", checkStatus(res, index)
+}
+
+func (st *HelmState) repoHTTPClient(repo RepositorySpec) (*http.Client, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: repo.SkipTLSVerify == "true",
+	}
+
+	if repo.CaFile != "" {
+		ca, err := st.readFile(st.storage().normalizePath(repo.CaFile))
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates are found in %s", repo.CaFile)
+		}
+	}
+
+	if repo.CertFile != "" && repo.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(st.storage().normalizePath(repo.CertFile), st.storage().normalizePath(repo.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   repoCheckTimeout,
+		Transport: &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment},
+	}, nil
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// checkRegistry requests the API of the OCI registry, exchanging the credentials for the token
+// when the registry requires the bearer token. Without the credentials the token is requested anonymously, like helm does.
+func checkRegistry(client *http.Client, host, username, password string) error {
+	base := "https://" + host + "/v2/"
+
+	res, err := httpGet(client, base, "", "")
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return checkStatus(res, base)
+	}
+	res.Body.Close()
+
+	challenge := res.Header.Get("WWW-Authenticate")
+
+	switch scheme, params := parseChallenge(challenge); scheme {
+	case "basic":
+		if username == "" && password == "" {
+			return fmt.Errorf("%s requires authentication but no credentials are given", host)
+		}
+
+		res, err := httpGet(client, base, username, password)
+		if err != nil {
+			return err
+		}
+
+		return checkStatus(res, base)
+	case "bearer":
+		realm, err := tokenURL(params, "")
+		if err != nil {
+			return fmt.Errorf("invalid authentication challenge of %s: %q", host, challenge)
+		}
+
+		res, err := httpGet(client, realm, username, password)
+		if err != nil {
+			return err
+		}
+
+		return checkStatus(res, realm)
+	default:
+		return fmt.Errorf("unsupported authentication challenge of %s: %q", host, challenge)
+	}
+}
+
+// parseChallenge returns the lower-cased scheme and the parameters of the WWW-Authenticate header
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme := strings.ToLower(strings.SplitN(strings.TrimSpace(challenge), " ", 2)[0])
+
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+
+	return scheme, params
+}
+
+// tokenURL returns the URL to request the bearer token from, as given by the parameters of the challenge
+func tokenURL(params map[string]string, scope string) (string, error) {
+	if params["realm"] == "" {
+		return "", fmt.Errorf("no realm")
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	realm.RawQuery = q.Encode()
+
+	return realm.String(), nil
+}
+
+func httpGet(client *http.Client, url, username, password string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	return client.Do(req)
+}
+
+func checkStatus(res *http.Response, url string) error {
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+	}()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("authentication failed with %s: %s", url, res.Status)
+	case res.StatusCode >= 300:
+		return fmt.Errorf("unexpected response from %s: %s", url, res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHelmState_CheckRepos(t *testing.T) {
+	charts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/public/index.yaml":
+		case "/private/index.yaml":
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer charts.Close()
+
+	var registry *httptest.Server
+	registry = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.example.com"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "password" || r.URL.Query().Get("service") != "registry.example.com" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "token"}`)
+		}
+	}))
+	defer registry.Close()
+
+	registryHost := strings.TrimPrefix(registry.URL, "https://")
+
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			Repositories: []RepositorySpec{
+				{Name: "public", URL: charts.URL + "/public"},
+				{Name: "private", URL: charts.URL + "/private", Username: "user", Password: "password"},
+				{Name: "unauthorized", URL: charts.URL + "/private", Username: "user", Password: "wrong"},
+				{Name: "notfound", URL: charts.URL + "/notfound"},
+				{Name: "registry", URL: registryHost + "/charts", OCI: true, SkipTLSVerify: "true", Username: "user", Password: "password"},
+				{Name: "anonymous", URL: registryHost + "/charts", OCI: true, SkipTLSVerify: "true", Credentials: &RepositoryCredentialsSpec{Provider: "env", UsernameEnv: "NO_SUCH_USERNAME", PasswordEnv: "NO_SUCH_PASSWORD"}},
+				{Name: "acr", Managed: "acr"},
+				{Name: "s3", URL: "s3://bucket/charts"},
+				{Name: "skipped", URL: charts.URL + "/public"},
+			},
+		},
+	}
+
+	got := st.CheckRepos(map[string]bool{"skipped": true})
+
+	want := []RepositoryCheck{
+		{Name: "public", URL: charts.URL + "/public", Status: RepoCheckOK},
+		{Name: "private", URL: charts.URL + "/private", Status: RepoCheckOK},
+		{Name: "unauthorized", URL: charts.URL + "/private", Status: RepoCheckFailed, Message: fmt.Sprintf("authentication failed with %s/private/index.yaml: 401 Unauthorized", charts.URL)},
+		{Name: "notfound", URL: charts.URL + "/notfound", Status: RepoCheckFailed, Message: fmt.Sprintf("unexpected response from %s/notfound/index.yaml: 404 Not Found", charts.URL)},
+		{Name: "registry", URL: registryHost + "/charts", OCI: true, Status: RepoCheckOK},
+		{Name: "anonymous", URL: registryHost + "/charts", OCI: true, Status: RepoCheckFailed, Message: "getting credentials of repository anonymous from env: environment variables not set: NO_SUCH_USERNAME, NO_SUCH_PASSWORD"},
+		{Name: "acr", Status: RepoCheckSkipped, Message: "managed by acr"},
+		{Name: "s3", URL: "s3://bucket/charts", Status: RepoCheckSkipped, Message: "s3 repositories are served by helm plugins"},
+	}
+
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected checks: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestCheckRegistry_NoCredentials(t *testing.T) {
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer registry.Close()
+
+	host := strings.TrimPrefix(registry.URL, "https://")
+
+	err := checkRegistry(registry.Client(), host, "", "")
+	if err == nil || err.Error() != fmt.Sprintf("%s requires authentication but no credentials are given", host) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = checkRegistry(registry.Client(), host, "user", "password")
+	if err == nil || err.Error() != fmt.Sprintf("authentication failed with https://%s/v2/: 401 Unauthorized", host) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckRegistry_AnonymousToken(t *testing.T) {
+	var registry *httptest.Server
+	registry = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.example.com"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			// Public registries issue tokens to anonymous users
+			if _, _, ok := r.BasicAuth(); ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "anonymous"}`)
+		}
+	}))
+	defer registry.Close()
+
+	host := strings.TrimPrefix(registry.URL, "https://")
+
+	if err := checkRegistry(registry.Client(), host, "", ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := checkRegistry(registry.Client(), host, "user", "password")
+	if err == nil || err.Error() != fmt.Sprintf("authentication failed with https://%s/token?service=registry.example.com: 401 Unauthorized", host) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package state
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The providers of the credentials of the repositories
+const (
+	CredentialsProviderEnv          = "env"
+	CredentialsProviderFile         = "file"
+	CredentialsProviderDockerConfig = "dockerconfig"
+	CredentialsProviderVals         = "vals"
+	CredentialsProviderCommand      = "command"
+)
+
+// RepositoryCredentialsSpec is the source of the username and password of the repository, that is used
+// instead of the username and password written in the helmfile
+type RepositoryCredentialsSpec struct {
+	// Provider is one of env, file, dockerconfig, vals and command
+	Provider string `yaml:"provider,omitempty"`
+	// UsernameEnv and PasswordEnv are the environment variables read by the env provider,
+	// that default to <NAME>_USERNAME and <NAME>_PASSWORD where <NAME> is the upper-cased name of the repository
+	UsernameEnv string `yaml:"usernameEnv,omitempty"`
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+	// UsernameFile and PasswordFile are the files read by the file provider, relative to the helmfile.
+	// Leading and trailing whitespaces are trimmed.
+	UsernameFile string `yaml:"usernameFile,omitempty"`
+	PasswordFile string `yaml:"passwordFile,omitempty"`
+	// ConfigFile is the docker config.json read by the dockerconfig provider,
+	// that defaults to $DOCKER_CONFIG/config.json or ~/.docker/config.json
+	ConfigFile string `yaml:"configFile,omitempty"`
+	// Username and Password are evaluated by the vals provider, like ref+vault://secret/helm#/password
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Command and Args run the credential helper of the command provider, that follows the protocol of docker credential helpers.
+	// `<command> <args...> get` is given the URL of the repository on stdin, and prints {"Username": "...", "Secret": "..."}.
+	Command string   `yaml:"command,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+}
+
+type credentialsProvider func(st *HelmState, repo RepositorySpec) (string, string, error)
+
+var credentialsProviders = map[string]credentialsProvider{
+	CredentialsProviderEnv:          envCredentials,
+	CredentialsProviderFile:         fileCredentials,
+	CredentialsProviderDockerConfig: dockerConfigCredentials,
+	CredentialsProviderVals:         valsCredentials,
+	CredentialsProviderCommand:      commandCredentials,
+}
+
+// repoCredentials returns the username and password of the repository.
+// Without the credentials provider, the username and password written in the helmfile are used, and the ones of OCI
+// registries are read from <NAME>_USERNAME and <NAME>_PASSWORD when omitted.
+func (st *HelmState) repoCredentials(repo RepositorySpec) (string, string, error) {
+	if repo.Credentials == nil {
+		if repo.OCI {
+			username, password := gatherOCIUsernamePassword(repo.Name, repo.Username, repo.Password)
+			return username, password, nil
+		}
+
+		return repo.Username, repo.Password, nil
+	}
+
+	if repo.Username != "" || repo.Password != "" {
+		return "", "", fmt.Errorf("repository %s: username and password can't be set along with credentials", repo.Name)
+	}
+
+	provider, ok := credentialsProviders[repo.Credentials.Provider]
+	if !ok {
+		return "", "", fmt.Errorf("repository %s: unsupported credentials provider %q: it must be one of %s, %s, %s, %s or %s",
+			repo.Name, repo.Credentials.Provider,
+			CredentialsProviderEnv, CredentialsProviderFile, CredentialsProviderDockerConfig, CredentialsProviderVals, CredentialsProviderCommand)
+	}
+
+	username, password, err := provider(st, repo)
+	if err != nil {
+		return "", "", fmt.Errorf("getting credentials of repository %s from %s: %v", repo.Name, repo.Credentials.Provider, err)
+	}
+
+	return username, password, nil
+}
+
+func envCredentials(st *HelmState, repo RepositorySpec) (string, string, error) {
+	c := repo.Credentials
+
+	usernameEnv, passwordEnv := c.UsernameEnv, c.PasswordEnv
+	if usernameEnv == "" {
+		usernameEnv = fmt.Sprintf("%s_USERNAME", strings.ToUpper(repo.Name))
+	}
+	if passwordEnv == "" {
+		passwordEnv = fmt.Sprintf("%s_PASSWORD", strings.ToUpper(repo.Name))
+	}
+
+	var missing []string
+
+	username, ok := os.LookupEnv(usernameEnv)
+	if !ok {
+		missing = append(missing, usernameEnv)
+	}
+
+	password, ok := os.LookupEnv(passwordEnv)
+	if !ok {
+		missing = append(missing, passwordEnv)
+	}
+
+	if len(missing) > 0 {
+		return "", "", fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+
+	return username, password, nil
+}
+
+func fileCredentials(st *HelmState, repo RepositorySpec) (string, string, error) {
+	c := repo.Credentials
+
+	if c.UsernameFile == "" || c.PasswordFile == "" {
+		return "", "", errors.New("both usernameFile and passwordFile are required")
+	}
+
+	read := func(path string) (string, error) {
+		bs, err := st.readFile(st.storage().normalizePath(path))
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(bs)), nil
+	}
+
+	username, err := read(c.UsernameFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	password, err := read(c.PasswordFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+func valsCredentials(st *HelmState, repo RepositorySpec) (string, string, error) {
+	c := repo.Credentials
+
+	if c.Username == "" || c.Password == "" {
+		return "", "", errors.New("both username and password are required")
+	}
+
+	rendered, err := renderValsSecrets(st.valsRuntime, c.Username, c.Password)
+	if err != nil {
+		return "", "", err
+	}
+
+	return rendered[0], rendered[1], nil
+}
+
+func commandCredentials(st *HelmState, repo RepositorySpec) (string, string, error) {
+	c := repo.Credentials
+
+	if c.Command == "" {
+		return "", "", errors.New("command is required")
+	}
+
+	return runCredentialHelper(c.Command, c.Args, repo.URL)
+}
+
+// runCredentialHelper gets the credentials of the server from the docker credential helper
+func runCredentialHelper(command string, args []string, serverURL string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(command, append(append([]string{}, args...), "get")...)
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("running %s: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("parsing the output of %s: %v", command, err)
+	}
+
+	return creds.Username, creds.Secret, nil
+}
+
+// dockerConfig is the part of the docker config.json that has the credentials of the registries
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+func dockerConfigCredentials(st *HelmState, repo RepositorySpec) (string, string, error) {
+	path := repo.Credentials.ConfigFile
+	if path != "" {
+		path = st.storage().normalizePath(path)
+	} else if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		path = filepath.Join(dir, "config.json")
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		path = filepath.Join(home, ".docker", "config.json")
+	}
+
+	bs, err := st.readFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(bs, &config); err != nil {
+		return "", "", fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	host := registryHost(repo.URL)
+
+	if helper, ok := config.CredHelpers[host]; ok {
+		return runCredentialHelper("docker-credential-"+helper, nil, host)
+	}
+
+	for server, auth := range config.Auths {
+		if registryHost(server) != host {
+			continue
+		}
+
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("decoding the auth of %s in %s: %v", server, path, err)
+		}
+
+		userPass := strings.SplitN(string(decoded), ":", 2)
+		if len(userPass) != 2 {
+			return "", "", fmt.Errorf("the auth of %s in %s must be base64-encoded username:password", server, path)
+		}
+
+		return userPass[0], userPass[1], nil
+	}
+
+	if config.CredsStore != "" {
+		return runCredentialHelper("docker-credential-"+config.CredsStore, nil, host)
+	}
+
+	return "", "", fmt.Errorf("no credentials for %s are found in %s", host, path)
+}
+
+// registryHost returns the host of the URL of the repository, the registry or the server in the docker config.json,
+// like example.com:5000 for oci://example.com:5000/charts and https://example.com:5000/v1/
+func registryHost(url string) string {
+	host := url
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+
+	return host
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/variantdev/vals"
+)
+
+func TestHelmState_RepoCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmfile-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"username":    "file-user\n",
+		"password":    "file-password\n",
+		"config.json": fmt.Sprintf(`{"auths": {"https://registry.example.com/v1/": {"auth": %q}}}`, base64.StdEncoding.EncodeToString([]byte("docker-user:docker-password"))),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.Setenv("MYREPO_USERNAME", "env-user")
+	os.Setenv("MYREPO_PASSWORD", "env-password")
+	os.Setenv("CUSTOM_PASSWORD", "custom-password")
+	defer func() {
+		os.Unsetenv("MYREPO_USERNAME")
+		os.Unsetenv("MYREPO_PASSWORD")
+		os.Unsetenv("CUSTOM_PASSWORD")
+	}()
+
+	valsRuntime, err := vals.New(vals.Options{CacheSize: 32})
+	if err != nil {
+		t.Fatalf("unexpected error creating vals runtime: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		repo         RepositorySpec
+		wantUsername string
+		wantPassword string
+		wantErr      string
+	}{
+		{
+			name:         "static",
+			repo:         RepositorySpec{Name: "myrepo", Username: "user", Password: "password"},
+			wantUsername: "user",
+			wantPassword: "password",
+		},
+		{
+			name:         "oci without credentials",
+			repo:         RepositorySpec{Name: "myrepo", OCI: true},
+			wantUsername: "env-user",
+			wantPassword: "env-password",
+		},
+		{
+			name:         "env",
+			repo:         RepositorySpec{Name: "myrepo", Credentials: &RepositoryCredentialsSpec{Provider: "env"}},
+			wantUsername: "env-user",
+			wantPassword: "env-password",
+		},
+		{
+			name:         "env with custom variables",
+			repo:         RepositorySpec{Name: "myrepo", Credentials: &RepositoryCredentialsSpec{Provider: "env", PasswordEnv: "CUSTOM_PASSWORD"}},
+			wantUsername: "env-user",
+			wantPassword: "custom-password",
+		},
+		{
+			name:    "env not set",
+			repo:    RepositorySpec{Name: "other", Credentials: &RepositoryCredentialsSpec{Provider: "env"}},
+			wantErr: "getting credentials of repository other from env: environment variables not set: OTHER_USERNAME, OTHER_PASSWORD",
+		},
+		{
+			name:         "file",
+			repo:         RepositorySpec{Name: "myrepo", Credentials: &RepositoryCredentialsSpec{Provider: "file", UsernameFile: "username", PasswordFile: "password"}},
+			wantUsername: "file-user",
+			wantPassword: "file-password",
+		},
+		{
+			name:         "dockerconfig",
+			repo:         RepositorySpec{Name: "myrepo", URL: "registry.example.com/charts", OCI: true, Credentials: &RepositoryCredentialsSpec{Provider: "dockerconfig", ConfigFile: "config.json"}},
+			wantUsername: "docker-user",
+			wantPassword: "docker-password",
+		},
+		{
+			name:    "dockerconfig without the registry",
+			repo:    RepositorySpec{Name: "myrepo", URL: "other.example.com/charts", OCI: true, Credentials: &RepositoryCredentialsSpec{Provider: "dockerconfig", ConfigFile: "config.json"}},
+			wantErr: fmt.Sprintf("getting credentials of repository myrepo from dockerconfig: no credentials for other.example.com are found in %s", filepath.Join(dir, "config.json")),
+		},
+		{
+			name:         "vals",
+			repo:         RepositorySpec{Name: "myrepo", Credentials: &RepositoryCredentialsSpec{Provider: "vals", Username: "ref+echo://vals-user", Password: "ref+echo://vals-password"}},
+			wantUsername: "vals-user",
+			wantPassword: "vals-password",
+		},
+		{
+			name: "command",
+			repo: RepositorySpec{Name: "myrepo", URL: "https://charts.example.com", Credentials: &RepositoryCredentialsSpec{
+				Provider: "command",
+				Command:  "sh",
+				Args:     []string{"-c", `read url; echo "{\"Username\": \"$url\", \"Secret\": \"$0\"}"`},
+			}},
+			wantUsername: "https://charts.example.com",
+			wantPassword: "get",
+		},
+		{
+			name:    "unsupported provider",
+			repo:    RepositorySpec{Name: "myrepo", Credentials: &RepositoryCredentialsSpec{Provider: "keychain"}},
+			wantErr: `repository myrepo: unsupported credentials provider "keychain": it must be one of env, file, dockerconfig, vals or command`,
+		},
+		{
+			name:    "username along with credentials",
+			repo:    RepositorySpec{Name: "myrepo", Username: "user", Credentials: &RepositoryCredentialsSpec{Provider: "env"}},
+			wantErr: "repository myrepo: username and password can't be set along with credentials",
+		},
+	}
+
+	for i := range tests {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			st := &HelmState{
+				basePath:    dir,
+				readFile:    ioutil.ReadFile,
+				valsRuntime: valsRuntime,
+			}
+
+			username, password, err := st.repoCredentials(tt.repo)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if username != tt.wantUsername || password != tt.wantPassword {
+				t.Errorf("unexpected credentials: want %s:%s, got %s:%s", tt.wantUsername, tt.wantPassword, username, password)
+			}
+		})
+	}
+}
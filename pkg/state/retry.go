@@ -0,0 +1,166 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/timing"
+)
+
+const (
+	defaultRetryBackoff    = 1
+	defaultRetryMaxBackoff = 30
+)
+
+// RetrySpec configures retries of helm operations that failed due to transient errors
+type RetrySpec struct {
+	// Attempts is the maximum number of attempts including the first one. Defaults to 1, that means no retry
+	Attempts int `yaml:"attempts,omitempty"`
+	// Backoff is the time in seconds to wait before the first retry, that is doubled on each retry. Defaults to 1
+	Backoff int `yaml:"backoff,omitempty"`
+	// MaxBackoff is the maximum time in seconds to wait between retries. Defaults to 30
+	MaxBackoff int `yaml:"maxBackoff,omitempty"`
+	// RetryableErrors is the list of regular expressions matched against error messages to retry,
+	// in addition to the built-in ones matching network errors, API server 5xx errors and lock contentions
+	RetryableErrors []string `yaml:"retryableErrors,omitempty"`
+}
+
+// retryableErrorMessages are the substrings of the messages of the errors considered transient
+var retryableErrorMessages = []string{
+	// Network errors
+	"connection refused",
+	"connection reset by peer",
+	"broken pipe",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"no such host",
+	"unexpected EOF",
+	"net/http: request canceled",
+	"http2: server sent GOAWAY",
+	// API server 5xx errors
+	"Internal error occurred",
+	"the server is currently unable to handle the request",
+	"the server was unable to return a response in the time allotted",
+	"etcdserver: request timed out",
+	"etcdserver: leader changed",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"Too Many Requests",
+	// Lock contentions
+	"another operation (install/upgrade/rollback) is in progress",
+	"the object has been modified; please apply your changes to the latest version and try again",
+}
+
+// retryPolicy returns the retry policy of the release, that is `retry` of the release merged over `helmDefaults.retry`.
+// The policy of `helmDefaults` is returned for nil release.
+func (st *HelmState) retryPolicy(release *ReleaseSpec) RetrySpec {
+	policy := st.HelmDefaults.Retry
+
+	if release == nil || release.Retry == nil {
+		return policy
+	}
+
+	r := release.Retry
+	if r.Attempts != 0 {
+		policy.Attempts = r.Attempts
+	}
+	if r.Backoff != 0 {
+		policy.Backoff = r.Backoff
+	}
+	if r.MaxBackoff != 0 {
+		policy.MaxBackoff = r.MaxBackoff
+	}
+	policy.RetryableErrors = append(append([]string{}, policy.RetryableErrors...), r.RetryableErrors...)
+
+	return policy
+}
+
+// withRetry calls f until it succeeds, fails with a non-retryable error, or the attempts are exhausted.
+// It returns the number of attempts made, along with the error of the last attempt.
+func (st *HelmState) withRetry(desc string, policy RetrySpec, f func() error) (int, error) {
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	sleep := st.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return attempt, nil
+		}
+
+		if attempt >= policy.Attempts {
+			return attempt, err
+		}
+
+		retryable, matchErr := isRetryableError(err, policy.RetryableErrors)
+		if matchErr != nil {
+			return attempt, matchErr
+		}
+
+		if !retryable {
+			return attempt, err
+		}
+
+		wait := backoff
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+
+		st.logger.Warnf("%s failed with a transient error, retrying in %d seconds (attempt %d/%d): %v", desc, wait, attempt+1, policy.Attempts, err)
+
+		sleep(time.Duration(wait) * time.Second)
+
+		backoff *= 2
+	}
+}
+
+// isRetryableError returns true when the error is transient, that is either a network error, an API server 5xx error,
+// a lock contention, or matching any of the patterns
+func isRetryableError(err error, patterns []string) (bool, error) {
+	msg := err.Error()
+
+	for _, m := range retryableErrorMessages {
+		if strings.Contains(msg, m) {
+			return true, nil
+		}
+	}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return false, fmt.Errorf("invalid retryableErrors pattern %q: %v", p, err)
+		}
+
+		if re.MatchString(msg) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// syncReleaseWithRetry upgrades the release, retrying on transient errors according to the retry policy of the release.
+// It returns the number of attempts made to be surfaced in the result, along with the error of the last attempt.
+func (st *HelmState) syncReleaseWithRetry(context helmexec.HelmContext, helm helmexec.Interface, release *ReleaseSpec, chart string, flags []string) (int, error) {
+	defer st.Timings.Start(timing.CategoryUpgrade, ReleaseToID(release))()
+
+	return st.withRetry(fmt.Sprintf("upgrading release %s", release.Name), st.retryPolicy(release), func() error {
+		return helm.SyncRelease(context, release.Name, chart, flags...)
+	})
+}
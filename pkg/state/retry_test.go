@@ -0,0 +1,263 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	testcases := []struct {
+		err      string
+		patterns []string
+		want     bool
+	}{
+		{err: `Get "https://charts.example.com/index.yaml": dial tcp 10.0.0.1:443: connect: connection refused`, want: true},
+		{err: `Get "https://charts.example.com/index.yaml": net/http: TLS handshake timeout`, want: true},
+		{err: `an error on the server ("Internal Server Error: \"/apis\"") has prevented the request from succeeding: Internal error occurred`, want: true},
+		{err: `the server is currently unable to handle the request (get deployments.apps foo)`, want: true},
+		{err: `UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress`, want: true},
+		{err: `UPGRADE FAILED: template: foo/templates/deployment.yaml:10: function "foo" not defined`, want: false},
+		{err: `timed out waiting for the condition`, want: false},
+		{err: `timed out waiting for the condition`, patterns: []string{`timed out waiting`}, want: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.err, func(t *testing.T) {
+			got, err := isRetryableError(errors.New(tc.err), tc.patterns)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("unexpected result: want %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := isRetryableError(errors.New("foo"), []string{"("}); err == nil {
+		t.Error("expected an error for the invalid pattern")
+	}
+}
+
+func TestHelmState_retryPolicy(t *testing.T) {
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			HelmDefaults: HelmSpec{Retry: RetrySpec{Attempts: 3, Backoff: 2, RetryableErrors: []string{"foo"}}},
+		},
+	}
+
+	if d := cmp.Diff(RetrySpec{Attempts: 3, Backoff: 2, RetryableErrors: []string{"foo"}}, st.retryPolicy(nil)); d != "" {
+		t.Errorf("unexpected policy: want (-), got (+):\n%s", d)
+	}
+
+	if d := cmp.Diff(RetrySpec{Attempts: 3, Backoff: 2, RetryableErrors: []string{"foo"}}, st.retryPolicy(&ReleaseSpec{Name: "a"})); d != "" {
+		t.Errorf("unexpected policy: want (-), got (+):\n%s", d)
+	}
+
+	release := &ReleaseSpec{Name: "b", Retry: &RetrySpec{Attempts: 5, MaxBackoff: 10, RetryableErrors: []string{"bar"}}}
+	if d := cmp.Diff(RetrySpec{Attempts: 5, Backoff: 2, MaxBackoff: 10, RetryableErrors: []string{"foo", "bar"}}, st.retryPolicy(release)); d != "" {
+		t.Errorf("unexpected policy: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_withRetry(t *testing.T) {
+	transient := errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+	permanent := errors.New("chart not found")
+
+	testcases := []struct {
+		name   string
+		policy RetrySpec
+		errs   []error
+
+		wantAttempts int
+		wantErr      error
+		wantSleeps   []time.Duration
+	}{
+		{
+			name:         "no retries by default",
+			errs:         []error{transient},
+			wantAttempts: 1,
+			wantErr:      transient,
+		},
+		{
+			name:         "succeeds after retries",
+			policy:       RetrySpec{Attempts: 5},
+			errs:         []error{transient, transient, nil},
+			wantAttempts: 3,
+			wantSleeps:   []time.Duration{1 * time.Second, 2 * time.Second},
+		},
+		{
+			name:         "exhausts attempts",
+			policy:       RetrySpec{Attempts: 4, Backoff: 5, MaxBackoff: 12},
+			errs:         []error{transient, transient, transient, transient},
+			wantAttempts: 4,
+			wantErr:      transient,
+			wantSleeps:   []time.Duration{5 * time.Second, 10 * time.Second, 12 * time.Second},
+		},
+		{
+			name:         "non-retryable error",
+			policy:       RetrySpec{Attempts: 3},
+			errs:         []error{transient, permanent},
+			wantAttempts: 2,
+			wantErr:      permanent,
+			wantSleeps:   []time.Duration{1 * time.Second},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sleeps []time.Duration
+
+			st := &HelmState{
+				logger: logger,
+				sleep: func(d time.Duration) {
+					sleeps = append(sleeps, d)
+				},
+			}
+
+			var calls int
+			attempts, err := st.withRetry("test", tc.policy, func() error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+
+			if err != tc.wantErr {
+				t.Errorf("unexpected error: want %v, got %v", tc.wantErr, err)
+			}
+
+			if attempts != tc.wantAttempts || calls != tc.wantAttempts {
+				t.Errorf("unexpected attempts: want %d, got %d (%d calls)", tc.wantAttempts, attempts, calls)
+			}
+
+			if d := cmp.Diff(tc.wantSleeps, sleeps); d != "" {
+				t.Errorf("unexpected backoffs: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
+
+type flakyHelm struct {
+	*exectest.Helm
+
+	failures int
+	err      error
+}
+
+func (helm *flakyHelm) SyncRelease(context helmexec.HelmContext, name, chart string, flags ...string) error {
+	if helm.failures > 0 {
+		helm.failures--
+		return helm.err
+	}
+	return helm.Helm.SyncRelease(context, name, chart, flags...)
+}
+
+func (helm *flakyHelm) AddRepo(name, repository, cafile, certfile, keyfile, username, password string, managed string, passCredentials string, skipTLSVerify string) error {
+	if helm.failures > 0 {
+		helm.failures--
+		return helm.err
+	}
+	return helm.Helm.AddRepo(name, repository, cafile, certfile, keyfile, username, password, managed, passCredentials, skipTLSVerify)
+}
+
+func TestHelmState_syncReleaseWithRetry(t *testing.T) {
+	helm := &flakyHelm{
+		Helm:     &exectest.Helm{},
+		failures: 2,
+		err:      errors.New("UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress"),
+	}
+
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{HelmDefaults: HelmSpec{Retry: RetrySpec{Attempts: 2}}},
+		logger:         logger,
+		sleep:          func(time.Duration) {},
+	}
+
+	release := &ReleaseSpec{Name: "foo", Chart: "stable/foo"}
+
+	attempts, err := st.syncReleaseWithRetry(helmexec.HelmContext{}, helm, release, "stable/foo", nil)
+	if err == nil {
+		t.Fatal("expected an error after exhausting the default attempts")
+	}
+
+	if attempts != 2 {
+		t.Errorf("unexpected attempts: want 2, got %d", attempts)
+	}
+
+	release.Retry = &RetrySpec{Attempts: 3}
+
+	attempts, err = st.syncReleaseWithRetry(helmexec.HelmContext{}, helm, release, "stable/foo", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("unexpected attempts: want 1, got %d", attempts)
+	}
+
+	if len(helm.Releases) != 1 {
+		t.Errorf("expected the release to be upgraded once, got %v", helm.Releases)
+	}
+}
+
+func TestHelmState_SyncRepos_Retry(t *testing.T) {
+	helm := &flakyHelm{
+		Helm:     &exectest.Helm{},
+		failures: 1,
+		err:      errors.New(`looks like "https://charts.example.com" is not a valid chart repository: 503 Service Unavailable`),
+	}
+
+	st := &HelmState{
+		ReleaseSetSpec: ReleaseSetSpec{
+			Repositories: []RepositorySpec{{Name: "example", URL: "https://charts.example.com"}},
+			HelmDefaults: HelmSpec{Retry: RetrySpec{Attempts: 2}},
+		},
+		logger: logger,
+		sleep:  func(time.Duration) {},
+	}
+
+	updated, err := st.SyncRepos(helm, map[string]bool{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d := cmp.Diff([]string{"example"}, updated); d != "" {
+		t.Errorf("unexpected updated repositories: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestHelmState_SyncReleases_RecordsAttempts(t *testing.T) {
+	helm := &flakyHelm{
+		Helm:     &exectest.Helm{Lists: map[exectest.ListKey]string{}},
+		failures: 1,
+		err:      errors.New("UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress"),
+	}
+
+	st := &HelmState{
+		basePath: ".",
+		ReleaseSetSpec: ReleaseSetSpec{
+			HelmDefaults: HelmSpec{Retry: RetrySpec{Attempts: 2}},
+			Releases:     []ReleaseSpec{{Name: "foo", Chart: "stable/foo"}},
+		},
+		logger:         logger,
+		valsRuntime:    valsRuntime,
+		RenderedValues: map[string]interface{}{},
+		sleep:          func(time.Duration) {},
+	}
+	st = injectFs(st, testhelper.NewTestFs(map[string]string{}))
+
+	affected := AffectedReleases{}
+	if errs := st.SyncReleases(&affected, helm, []string{}, 1); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if !affected.anyRetried(affected.Upgraded) || affected.attempts[ReleaseToID(&st.Releases[0])] != 2 {
+		t.Errorf("unexpected attempts: want 2, got %v", affected.attempts)
+	}
+}
@@ -0,0 +1,342 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/maputil"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// SchemaError is a violation of the helmfile.yaml JSON Schema found in a rendered helmfile.yaml part
+type SchemaError struct {
+	// Path is the path to the offending node, like `releases`, `0`, `chart`
+	Path []string
+	// Line is the 1-based line number of the offending node within the validated YAML, or 0 when unknown
+	Line int
+	// Description is the human-readable description of the violation
+	Description string
+}
+
+func (e *SchemaError) Field() string {
+	var buf strings.Builder
+	for _, p := range e.Path {
+		if _, err := strconv.Atoi(p); err == nil {
+			buf.WriteString("[" + p + "]")
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(".")
+		}
+		buf.WriteString(p)
+	}
+	if buf.Len() == 0 {
+		return "(root)"
+	}
+	return buf.String()
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field(), e.Description)
+}
+
+// JSONSchema returns the JSON Schema of helmfile.yaml that is generated from ReleaseSetSpec and the types it refers to.
+//
+// The schema is the one used by ValidateSchema. It is also meant to be consumed by editors for completion and validation.
+func JSONSchema() ([]byte, error) {
+	return json.MarshalIndent(schemaFor(reflect.TypeOf(ReleaseSetSpec{})), "", "  ")
+}
+
+func schemaFor(t reflect.Type) map[string]interface{} {
+	g := &schemaGenerator{definitions: map[string]interface{}{}}
+
+	root := g.objectSchema(t)
+	root["$schema"] = schemaDraft
+	root["title"] = "helmfile.yaml"
+	root["definitions"] = g.definitions
+
+	return root
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// SubHelmfileSpec is either a path to the sub-helmfile or a hash. See SubHelmfileSpec.UnmarshalYAML.
+	if t == reflect.TypeOf(SubHelmfileSpec{}) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"path":               map[string]interface{}{"type": "string"},
+						"selectors":          g.typeSchema(reflect.TypeOf([]string{})),
+						"selectorsInherited": g.typeSchema(reflect.TypeOf(true)),
+						"values":             g.typeSchema(reflect.TypeOf([]interface{}{})),
+					},
+					"additionalProperties": false,
+				},
+			},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.definitions[name]; !ok {
+			// Register the name before generating the definition so that recursive types terminate
+			g.definitions[name] = nil
+			g.definitions[name] = g.objectSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  []string{"array", "null"},
+			"items": g.typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 []string{"object", "null"},
+			"additionalProperties": g.typeSchema(t.Elem()),
+		}
+	case reflect.String:
+		// yaml.v2 decodes any non-null scalar into a string field, so does the schema
+		return map[string]interface{}{"type": []string{"string", "number", "boolean", "null"}}
+	case reflect.Bool:
+		return map[string]interface{}{"type": []string{"boolean", "null"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": []string{"integer", "null"}}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": []string{"number", "null"}}
+	}
+
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) objectSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	g.addProperties(props, t)
+
+	return map[string]interface{}{
+		"type":                 []string{"object", "null"},
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func (g *schemaGenerator) addProperties(props map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+
+		inline := false
+		for _, o := range opts[1:] {
+			if o == "inline" {
+				inline = true
+			}
+		}
+
+		if inline {
+			g.addProperties(props, f.Type)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		props[name] = g.typeSchema(f.Type)
+	}
+}
+
+// ValidateSchema validates the rendered helmfile.yaml content against the JSON Schema returned by JSONSchema.
+//
+// Every violation is returned as a SchemaError that is located at the line of the offending node.
+// The returned error is non-nil only when the content is not a valid YAML.
+func ValidateSchema(content []byte) ([]*SchemaError, error) {
+	schema := gojsonschema.NewGoLoader(schemaFor(reflect.TypeOf(ReleaseSetSpec{})))
+
+	docs, err := decodeYAMLDocuments(content)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := decodeYAMLNodes(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []*SchemaError
+
+	for i, doc := range docs {
+		if doc == nil {
+			continue
+		}
+
+		res, err := gojsonschema.Validate(schema, gojsonschema.NewGoLoader(doc))
+		if err != nil {
+			return nil, err
+		}
+
+		var node *yamlv3.Node
+		if i < len(nodes) {
+			node = nodes[i]
+		}
+
+		for _, re := range res.Errors() {
+			path := schemaErrorPath(re)
+			line, _ := lookupYAMLLine(node, path)
+
+			errs = append(errs, &SchemaError{
+				Path:        path,
+				Line:        line,
+				Description: re.Description(),
+			})
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+
+	return errs, nil
+}
+
+func schemaErrorPath(re gojsonschema.ResultError) []string {
+	const delim = "\x00"
+
+	var path []string
+
+	for _, p := range strings.Split(re.Context().String(delim), delim) {
+		if p == gojsonschema.STRING_CONTEXT_ROOT {
+			continue
+		}
+		path = append(path, p)
+	}
+
+	// Point to the unexpected key itself rather than the hash containing it
+	if re.Type() == "additional_property_not_allowed" {
+		if prop, ok := re.Details()["property"].(string); ok {
+			path = append(path, prop)
+		}
+	}
+
+	return path
+}
+
+func decodeYAMLDocuments(content []byte) ([]interface{}, error) {
+	var docs []interface{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if m, ok := doc.(map[interface{}]interface{}); ok {
+			casted, err := maputil.CastKeysToStrings(m)
+			if err != nil {
+				return nil, err
+			}
+			doc = casted
+		}
+
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+func decodeYAMLNodes(content []byte) ([]*yamlv3.Node, error) {
+	var nodes []*yamlv3.Node
+
+	decoder := yamlv3.NewDecoder(bytes.NewReader(content))
+	for {
+		var n yamlv3.Node
+		if err := decoder.Decode(&n); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &n)
+	}
+
+	return nodes, nil
+}
+
+// lookupYAMLLine returns the line of the node at the path, or of the deepest existing ancestor of it,
+// along with the number of path elements that were found.
+// For a hash entry, the line of the key is returned so that the location points to where the key is written.
+func lookupYAMLLine(n *yamlv3.Node, path []string) (int, int) {
+	if n == nil {
+		return 0, 0
+	}
+
+	if n.Kind == yamlv3.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	cur, line, depth := n, n.Line, 0
+	for _, p := range path {
+		for cur.Kind == yamlv3.AliasNode && cur.Alias != nil {
+			cur = cur.Alias
+		}
+
+		var next *yamlv3.Node
+
+		switch cur.Kind {
+		case yamlv3.MappingNode:
+			for i := 0; i+1 < len(cur.Content); i += 2 {
+				if cur.Content[i].Value == p {
+					next, line = cur.Content[i+1], cur.Content[i].Line
+					break
+				}
+			}
+		case yamlv3.SequenceNode:
+			if idx, err := strconv.Atoi(p); err == nil && idx >= 0 && idx < len(cur.Content) {
+				next = cur.Content[idx]
+				line = next.Line
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		cur = next
+		depth++
+	}
+
+	return line, depth
+}
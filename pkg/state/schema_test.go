@@ -0,0 +1,143 @@
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONSchema(t *testing.T) {
+	bs, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var schema struct {
+		Properties  map[string]interface{} `json:"properties"`
+		Definitions map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(bs, &schema); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, p := range []string{"releases", "environments", "helmDefaults", "repositories", "helmfiles", "templates"} {
+		if _, ok := schema.Properties[p]; !ok {
+			t.Errorf("missing property %q", p)
+		}
+	}
+
+	if _, ok := schema.Properties["selectors"]; ok {
+		t.Errorf("unexpected property \"selectors\" that is not a part of helmfile.yaml")
+	}
+
+	for def, p := range map[string]string{"ReleaseSpec": "chart", "HelmSpec": "kubeContext", "EnvironmentSpec": "values", "TemplateSpec": "chart"} {
+		if _, ok := schema.Definitions[def].Properties[p]; !ok {
+			t.Errorf("missing property %q in definition %q", p, def)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		want    []SchemaError
+	}{
+		{
+			name: "valid",
+			content: `helmfiles:
+- sub.yaml
+- path: sub2.yaml
+  selectors:
+  - name=foo
+repositories:
+- name: stable
+  url: https://charts.helm.sh/stable
+  passCredentials: true
+releases:
+- name: foo
+  chart: stable/foo
+  wait: true
+  timeout: 300
+  values:
+  - values.yaml
+  - foo: bar
+`,
+		},
+		{
+			name: "unknown fields",
+			content: `helmDefaults:
+  wiat: true
+releases:
+- name: foo
+  chart: stable/foo
+- name: bar
+  chartt: stable/bar
+`,
+			want: []SchemaError{
+				{Path: []string{"helmDefaults", "wiat"}, Line: 2, Description: "Additional property wiat is not allowed"},
+				{Path: []string{"releases", "1", "chartt"}, Line: 7, Description: "Additional property chartt is not allowed"},
+			},
+		},
+		{
+			name: "invalid types",
+			content: `environments:
+  default:
+    values: values.yaml
+releases:
+- name: foo
+  chart: stable/foo
+  wait: "yes"
+`,
+			want: []SchemaError{
+				{Path: []string{"environments", "default", "values"}, Line: 3, Description: "Invalid type. Expected: [array,null], given: string"},
+				{Path: []string{"releases", "0", "wait"}, Line: 7, Description: "Invalid type. Expected: [boolean,null], given: string"},
+			},
+		},
+		{
+			name: "multiple documents",
+			content: `releases:
+- name: foo
+  chart: stable/foo
+---
+releases:
+- name: bar
+  chart: stable/bar
+  lables:
+    foo: bar
+`,
+			want: []SchemaError{
+				{Path: []string{"releases", "0", "lables"}, Line: 8, Description: "Additional property lables is not allowed"},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs, err := ValidateSchema([]byte(tc.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []SchemaError
+			for _, e := range errs {
+				got = append(got, *e)
+			}
+
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("unexpected schema errors:\n%s", d)
+			}
+		})
+	}
+}
+
+func TestSchemaError(t *testing.T) {
+	e := &SchemaError{Path: []string{"releases", "1", "chartt"}, Line: 7, Description: "Additional property chartt is not allowed"}
+
+	if got, want := e.Error(), "releases[1].chartt: Additional property chartt is not allowed"; got != want {
+		t.Errorf("unexpected error message: want %q, got %q", want, got)
+	}
+}
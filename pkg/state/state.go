@@ -19,14 +19,16 @@ import (
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
-	"github.com/imdario/mergo"
 	"github.com/variantdev/chartify"
 
 	"github.com/huolunl/helmfile/pkg/environment"
 	"github.com/huolunl/helmfile/pkg/event"
 	"github.com/huolunl/helmfile/pkg/helmexec"
+	"github.com/huolunl/helmfile/pkg/manifest"
 	"github.com/huolunl/helmfile/pkg/remote"
+	"github.com/huolunl/helmfile/pkg/timing"
 	"github.com/huolunl/helmfile/pkg/tmpl"
 
 	"github.com/tatsushid/go-prettytable"
@@ -68,6 +70,9 @@ type ReleaseSetSpec struct {
 	// Hooks is a list of extension points paired with operations, that are executed in specific points of the lifecycle of releases defined in helmfile
 	Hooks []event.Hook `yaml:"hooks,omitempty"`
 
+	// Notifications is a list of HTTP endpoints notified of the start and the result of apply, sync and destroy
+	Notifications []NotificationSpec `yaml:"notifications,omitempty"`
+
 	Templates map[string]TemplateSpec `yaml:"templates"`
 
 	Env environment.Environment `yaml:"-"`
@@ -78,11 +83,6 @@ type ReleaseSetSpec struct {
 	MissingFileHandler string `yaml:"missingFileHandler,omitempty"`
 }
 
-type PullCommand struct {
-	ChartRef     string
-	responseChan chan error
-}
-
 // HelmState structure for the helmfile
 type HelmState struct {
 	basePath string
@@ -101,10 +101,22 @@ type HelmState struct {
 
 	valsRuntime vals.Evaluator
 
+	// httpClient is used to send notifications. Tests override it to not send actual requests
+	httpClient event.HTTPClient
+
+	// sleep is used to wait between retries. Tests override it to not actually wait
+	sleep func(time.Duration)
+
+	// defaultNamespaces caches the namespaces of the kube contexts reported by `helm list`, to resolve the empty namespaces of the releases
+	defaultNamespaces map[string]string
+
 	// RenderedValues is the helmfile-wide values that is `.Values`
 	// which is accessible from within the whole helmfile go template.
 	// Note that this is usually computed by DesiredStateLoader from ReleaseSetSpec.Env
 	RenderedValues map[string]interface{}
+
+	// Timings records the wall time of the steps for `--timings`. Nothing is recorded when nil
+	Timings *timing.Recorder
 }
 
 // SubHelmfileSpec defines the subhelmfile path and options
@@ -154,6 +166,21 @@ type HelmSpec struct {
 	// This is relevant only when your release uses a local chart or a directory containing K8s manifests or a Kustomization
 	// as a Helm chart.
 	SkipDeps bool `yaml:"skipDeps"`
+	// MaxConcurrency caps the number of releases processed concurrently, regardless of --concurrency. Use 0 for no limit
+	MaxConcurrency int `yaml:"maxConcurrency,omitempty"`
+	// MaxConcurrencyPerContext is the maximum number of releases processed concurrently per kube context. Use 0 for no limit
+	MaxConcurrencyPerContext int `yaml:"maxConcurrencyPerContext,omitempty"`
+	// MaxConcurrencyPerNamespace is the maximum number of releases processed concurrently per namespace of a kube context.
+	// Use 0 for no limit
+	MaxConcurrencyPerNamespace int `yaml:"maxConcurrencyPerNamespace,omitempty"`
+	// Retry configures retries of helm upgrades, repository additions and chart pulls that failed due to transient errors
+	Retry RetrySpec `yaml:"retry,omitempty"`
+	// LabelReleases, when set to true, labels the releases with the state and the environment on install/upgrade,
+	// so that `apply --prune` and `sync --prune` can find the ones removed from the helmfile. Requires Helm 3.13.0 or greater
+	LabelReleases bool `yaml:"labelReleases,omitempty"`
+	// StateName is the value of the helmfile.io/state label of the releases, that defaults to the name of the state file.
+	// Set it to tell the releases of the helmfiles sharing a namespace and the state file name
+	StateName string `yaml:"stateName,omitempty"`
 
 	TLS                      bool   `yaml:"tls"`
 	TLSCACert                string `yaml:"tlsCACert,omitempty"`
@@ -176,6 +203,8 @@ type RepositorySpec struct {
 	OCI             bool   `yaml:"oci,omitempty"`
 	PassCredentials string `yaml:"passCredentials,omitempty"`
 	SkipTLSVerify   string `yaml:"skipTLSVerify,omitempty"`
+	// Credentials is the provider of the username and password, that are never written to the helmfile
+	Credentials *RepositoryCredentialsSpec `yaml:"credentials,omitempty"`
 }
 
 // ReleaseSpec defines the structure of a helm release
@@ -185,8 +214,12 @@ type ReleaseSpec struct {
 	// Directory is an alias to Chart which may be of more fit when you want to use a local/remote directory containing
 	// K8s manifests or Kustomization as a chart
 	Directory string `yaml:"directory,omitempty"`
-	// Version is the semver version or version constraint for the chart
+	// Version is the semver version or version constraint for the chart.
+	// It's the tag of the chart in an OCI registry, or the digest of the chart like `sha256:...` to pin to
 	Version string `yaml:"version,omitempty"`
+	// ChartDigest pins the chart in an OCI registry to the digest of its manifest.
+	// It's set to the digest of the pulled chart once the chart is prepared, so that `helmfile build` reports it
+	ChartDigest string `yaml:"chartDigest,omitempty"`
 	// Verify enables signature verification on fetched chart.
 	// Beware some (or many?) chart repositories and charts don't seem to support it.
 	Verify *bool `yaml:"verify,omitempty"`
@@ -248,6 +281,10 @@ type ReleaseSpec struct {
 	Secrets   []interface{}     `yaml:"secrets,omitempty"`
 	SetValues []SetValue        `yaml:"set,omitempty"`
 
+	// ValuesSchema is the JSON Schema that the values of this release are validated against before running helm.
+	// It is either a path to a local or remote JSON or YAML file containing the schema, or the schema itself.
+	ValuesSchema interface{} `yaml:"valuesSchema,omitempty"`
+
 	ValuesTemplate    []interface{} `yaml:"valuesTemplate,omitempty"`
 	SetValuesTemplate []SetValue    `yaml:"setTemplate,omitempty"`
 
@@ -285,6 +322,9 @@ type ReleaseSpec struct {
 	Transformers []interface{} `yaml:"transformers,omitempty"`
 	Adopt        []string      `yaml:"adopt,omitempty"`
 
+	// Retry overrides helmDefaults.retry for the release
+	Retry *RetrySpec `yaml:"retry,omitempty"`
+
 	//version of the chart that has really been installed cause desired version may be fuzzy (~2.0.0)
 	installedVersion string
 
@@ -306,6 +346,15 @@ type ReleaseSpec struct {
 	// This is relevant only when your release uses a local chart or a directory containing K8s manifests or a Kustomization
 	// as a Helm chart.
 	SkipDeps *bool `yaml:"skipDeps,omitempty"`
+
+	// Inherit is the list of names of `templates` this release inherits from.
+	// Later templates override earlier ones, and the release itself overrides all of them.
+	Inherit []string `yaml:"inherit,omitempty"`
+
+	// InheritStrategy is either "append" or "replace" per field name, that is one of `values`, `secrets`, `set`,
+	// `needs`, `hooks` and `labels`, and defines how the field of this release is merged with the inherited one.
+	// Every field defaults to "append". Any other field of the release replaces the inherited one when set.
+	InheritStrategy map[string]string `yaml:"inheritStrategy,omitempty"`
 }
 
 type Release struct {
@@ -327,8 +376,57 @@ type AffectedReleases struct {
 	Upgraded []*ReleaseSpec
 	Deleted  []*ReleaseSpec
 	Failed   []*ReleaseSpec
+
+	// mu guards the affected releases shared across concurrent SyncReleases and DeleteReleasesForSync calls, that is
+	// the case when releases are processed as soon as the releases they need are processed.
+	// It also serializes the releases deleted by them
+	mu sync.Mutex
+
+	// valuesHashes are the hashes of the values passed to helm keyed by the release ID, recorded to the audit log.
+	// They aren't kept in ReleaseSpec so that they never change the hashes of the releases, like the IDs of values files.
+	valuesHashes map[string]string
+	// attempts are the numbers of attempts made to upgrade the releases keyed by the release ID, that are greater
+	// than 1 when retried
+	attempts map[string]int
+
+	// notify sends the success or the failure notification of the release as soon as the release is processed.
+	// It is set by NotifyStart before any release is processed
+	notify func(release *ReleaseSpec, err error)
 }
 
+// setAttempts records the number of attempts made to upgrade the release. The caller must hold the lock of the
+// affected releases
+func (ar *AffectedReleases) setAttempts(release *ReleaseSpec, attempts int) {
+	if ar.attempts == nil {
+		ar.attempts = map[string]int{}
+	}
+
+	ar.attempts[ReleaseToID(release)] = attempts
+}
+
+// setValuesHash records the hash of the values passed to helm for the release. The caller must hold the lock of the
+// affected releases
+func (ar *AffectedReleases) setValuesHash(release *ReleaseSpec, hash string) {
+	if hash == "" {
+		return
+	}
+
+	if ar.valuesHashes == nil {
+		ar.valuesHashes = map[string]string{}
+	}
+
+	ar.valuesHashes[ReleaseToID(release)] = hash
+}
+
+// notifyRelease notifies of the success or the failure of the release, if the notifications are configured.
+// The caller must not hold the lock of the affected releases, so that a slow notification never blocks other releases
+func (ar *AffectedReleases) notifyRelease(release *ReleaseSpec, err error) {
+	if ar.notify != nil {
+		ar.notify(release, err)
+	}
+}
+
+
 const DefaultEnv = "default"
 
 const MissingFileHandlerError = "Error"
@@ -408,16 +506,25 @@ func (st *HelmState) SyncRepos(helm RepoUpdater, shouldSkip map[string]bool) ([]
 		if shouldSkip[repo.Name] {
 			continue
 		}
-		var err error
-		if repo.OCI {
-			username, password := gatherOCIUsernamePassword(repo.Name, repo.Username, repo.Password)
-			if username != "" && password != "" {
-				err = helm.RegistryLogin(repo.URL, username, password)
-			}
-		} else {
-			err = helm.AddRepo(repo.Name, repo.URL, repo.CaFile, repo.CertFile, repo.KeyFile, repo.Username, repo.Password, repo.Managed, repo.PassCredentials, repo.SkipTLSVerify)
+		repo := repo
+
+		username, password, err := st.repoCredentials(repo)
+		if err != nil {
+			return nil, err
 		}
 
+		stop := st.Timings.Start(timing.CategoryRepos, repo.Name)
+		_, err = st.withRetry(fmt.Sprintf("adding repository %s", repo.Name), st.retryPolicy(nil), func() error {
+			if repo.OCI {
+				if username != "" && password != "" {
+					return helm.RegistryLogin(repo.URL, username, password)
+				}
+				return nil
+			}
+			return helm.AddRepo(repo.Name, repo.URL, repo.CaFile, repo.CertFile, repo.KeyFile, username, password, repo.Managed, repo.PassCredentials, repo.SkipTLSVerify)
+		})
+		stop()
+
 		if err != nil {
 			return nil, err
 		}
@@ -455,6 +562,8 @@ type syncPrepareResult struct {
 	flags   []string
 	errors  []*ReleaseError
 	files   []string
+	// valuesHash is the hash of the values passed to helm, recorded to the audit log
+	valuesHash string
 }
 
 // SyncReleases wrapper for executing helm upgrade on the releases
@@ -551,7 +660,7 @@ func (st *HelmState) prepareSyncReleases(helm helmexec.Interface, additionalValu
 					continue
 				}
 
-				results <- syncPrepareResult{release: release, flags: flags, errors: []*ReleaseError{}, files: files}
+				results <- syncPrepareResult{release: release, flags: flags, errors: []*ReleaseError{}, files: files, valuesHash: st.hashValues(files, flags)}
 			}
 		},
 		func() {
@@ -621,6 +730,11 @@ type SyncOpts struct {
 	SkipCRDs    bool
 	Wait        bool
 	WaitForJobs bool
+
+	// HelmfileCommand is the helmfile command that syncs the releases, that defaults to "sync".
+	// `preapply` and `postapply` hooks are triggered only when it is "apply".
+	// It is given to `preapply`, `postapply` and `onfailure` hooks, while the other hooks are given "sync" regardless of it.
+	HelmfileCommand string
 }
 
 type SyncOpt interface{ Apply(*SyncOpts) }
@@ -629,6 +743,13 @@ func (o *SyncOpts) Apply(opts *SyncOpts) {
 	*opts = *o
 }
 
+func (o *SyncOpts) helmfileCommand() string {
+	if o.HelmfileCommand == "" {
+		return "sync"
+	}
+	return o.HelmfileCommand
+}
+
 func ReleaseToID(r *ReleaseSpec) string {
 	var id string
 
@@ -665,34 +786,44 @@ func ReleaseToID(r *ReleaseSpec) string {
 }
 
 // DeleteReleasesForSync deletes releases that are marked for deletion
-func (st *HelmState) DeleteReleasesForSync(affectedReleases *AffectedReleases, helm helmexec.Interface, workerLimit int) []error {
+func (st *HelmState) DeleteReleasesForSync(affectedReleases *AffectedReleases, helm helmexec.Interface, workerLimit int, opt ...SyncOpt) []error {
+	opts := &SyncOpts{}
+	for _, o := range opt {
+		o.Apply(opts)
+	}
+
+	helmfileCommand := opts.helmfileCommand()
+
 	errs := []error{}
 
 	releases := st.Releases
 
-	jobQueue := make(chan *ReleaseSpec, len(releases))
 	results := make(chan syncResult, len(releases))
 	if workerLimit == 0 {
 		workerLimit = len(releases)
 	}
 
-	m := new(sync.Mutex)
+	refs := make([]*ReleaseSpec, len(releases))
+	for i := range releases {
+		refs[i] = &releases[i]
+	}
+	scheduler := st.NewReleaseScheduler(refs)
+
+	m := &affectedReleases.mu
 
 	st.scatterGather(
 		workerLimit,
 		len(releases),
-		func() {
-			for i := 0; i < len(releases); i++ {
-				jobQueue <- &releases[i]
-			}
-			close(jobQueue)
-		},
+		func() {},
 		func(workerIndex int) {
-			for release := range jobQueue {
+			for i, ok := scheduler.next(workerIndex); ok; i, ok = scheduler.next(workerIndex) {
+				release := refs[i]
 				var relErr *ReleaseError
 				context := st.createHelmContext(release, workerIndex)
 
-				if _, err := st.triggerPresyncEvent(release, "sync"); err != nil {
+				if _, err := st.triggerPreapplyEvent(release, helmfileCommand); err != nil {
+					relErr = newReleaseFailedError(release, err)
+				} else if _, err := st.triggerPresyncEvent(release, "sync"); err != nil {
 					relErr = newReleaseFailedError(release, err)
 				} else {
 					var args []string
@@ -705,26 +836,48 @@ func (st *HelmState) DeleteReleasesForSync(affectedReleases *AffectedReleases, h
 						args = []string{"--purge"}
 					}
 					deletionFlags := st.appendConnectionFlags(args, helm, release)
-					m.Lock()
+					var deleteErr error
 					if _, err := st.triggerReleaseEvent("preuninstall", nil, release, "sync"); err != nil {
-						affectedReleases.Failed = append(affectedReleases.Failed, release)
-						relErr = newReleaseFailedError(release, err)
-					} else if err := helm.DeleteRelease(context, release.Name, deletionFlags...); err != nil {
-						affectedReleases.Failed = append(affectedReleases.Failed, release)
-						relErr = newReleaseFailedError(release, err)
+						deleteErr = err
+					} else if err := st.timed(timing.CategoryDelete, release, func() error {
+						// The in-process helm isn't safe to delete releases concurrently
+						m.Lock()
+						defer m.Unlock()
+						return helm.DeleteRelease(context, release.Name, deletionFlags...)
+					}); err != nil {
+						deleteErr = err
 					} else if _, err := st.triggerReleaseEvent("postuninstall", nil, release, "sync"); err != nil {
+						deleteErr = err
+					}
+					m.Lock()
+					if deleteErr != nil {
 						affectedReleases.Failed = append(affectedReleases.Failed, release)
-						relErr = newReleaseFailedError(release, err)
 					} else {
 						affectedReleases.Deleted = append(affectedReleases.Deleted, release)
 					}
 					m.Unlock()
+					if deleteErr != nil {
+						relErr = newReleaseFailedError(release, deleteErr)
+						affectedReleases.notifyRelease(release, relErr)
+					} else {
+						affectedReleases.notifyRelease(release, nil)
+					}
 				}
 
 				if _, err := st.triggerPostsyncEvent(release, relErr, "sync"); err != nil {
 					st.logger.Warnf("warn: %v\n", err)
 				}
 
+				if _, err := st.triggerPostapplyEvent(release, relErr, helmfileCommand); err != nil {
+					st.logger.Warnf("warn: %v\n", err)
+				}
+
+				if relErr != nil {
+					if _, err := st.triggerOnfailureEvent(release, relErr, helmfileCommand); err != nil {
+						st.logger.Warnf("warn: %v\n", err)
+					}
+				}
+
 				if _, err := st.TriggerCleanupEvent(release, "sync"); err != nil {
 					st.logger.Warnf("warn: %v\n", err)
 				}
@@ -761,6 +914,8 @@ func (st *HelmState) SyncReleases(affectedReleases *AffectedReleases, helm helme
 		o.Apply(opts)
 	}
 
+	helmfileCommand := opts.helmfileCommand()
+
 	preps, prepErrs := st.prepareSyncReleases(helm, additionalValues, workerLimit, opts)
 
 	if !opts.SkipCleanup {
@@ -776,32 +931,35 @@ func (st *HelmState) SyncReleases(affectedReleases *AffectedReleases, helm helme
 	}
 
 	errs := []error{}
-	jobQueue := make(chan *syncPrepareResult, len(preps))
 	results := make(chan syncResult, len(preps))
 	if workerLimit == 0 {
 		workerLimit = len(preps)
 	}
 
-	m := new(sync.Mutex)
+	refs := make([]*ReleaseSpec, len(preps))
+	for i := range preps {
+		refs[i] = preps[i].release
+	}
+	scheduler := st.NewReleaseScheduler(refs)
+
+	m := &affectedReleases.mu
 
 	st.scatterGather(
 		workerLimit,
 		len(preps),
-		func() {
-			for i := 0; i < len(preps); i++ {
-				jobQueue <- &preps[i]
-			}
-			close(jobQueue)
-		},
+		func() {},
 		func(workerIndex int) {
-			for prep := range jobQueue {
+			for i, ok := scheduler.next(workerIndex); ok; i, ok = scheduler.next(workerIndex) {
+				prep := &preps[i]
 				release := prep.release
 				flags := prep.flags
 				chart := normalizeChart(st.basePath, release.Chart)
 				var relErr *ReleaseError
 				context := st.createHelmContext(release, workerIndex)
 
-				if _, err := st.triggerPresyncEvent(release, "sync"); err != nil {
+				if _, err := st.triggerPreapplyEvent(release, helmfileCommand); err != nil {
+					relErr = newReleaseFailedError(release, err)
+				} else if _, err := st.triggerPresyncEvent(release, "sync"); err != nil {
 					relErr = newReleaseFailedError(release, err)
 				} else if !release.Desired() {
 					installed, err := st.isReleaseInstalled(context, helm, *release)
@@ -815,30 +973,49 @@ func (st *HelmState) SyncReleases(affectedReleases *AffectedReleases, helm helme
 							args = []string{"--purge"}
 						}
 						deletionFlags := st.appendConnectionFlags(args, helm, release)
-						m.Lock()
+						var deleteErr error
 						if _, err := st.triggerReleaseEvent("preuninstall", nil, release, "sync"); err != nil {
-							affectedReleases.Failed = append(affectedReleases.Failed, release)
-							relErr = newReleaseFailedError(release, err)
-						} else if err := helm.DeleteRelease(context, release.Name, deletionFlags...); err != nil {
-							affectedReleases.Failed = append(affectedReleases.Failed, release)
-							relErr = newReleaseFailedError(release, err)
+							deleteErr = err
+						} else if err := st.timed(timing.CategoryDelete, release, func() error {
+							// The in-process helm isn't safe to delete releases concurrently
+							m.Lock()
+							defer m.Unlock()
+							return helm.DeleteRelease(context, release.Name, deletionFlags...)
+						}); err != nil {
+							deleteErr = err
 						} else if _, err := st.triggerReleaseEvent("postuninstall", nil, release, "sync"); err != nil {
+							deleteErr = err
+						}
+						m.Lock()
+						if deleteErr != nil {
 							affectedReleases.Failed = append(affectedReleases.Failed, release)
-							relErr = newReleaseFailedError(release, err)
 						} else {
 							affectedReleases.Deleted = append(affectedReleases.Deleted, release)
 						}
 						m.Unlock()
+						if deleteErr != nil {
+							relErr = newReleaseFailedError(release, deleteErr)
+							affectedReleases.notifyRelease(release, relErr)
+						} else {
+							affectedReleases.notifyRelease(release, nil)
+						}
 					}
-				} else if err := helm.SyncRelease(context, release.Name, chart, flags...); err != nil {
+				} else if attempts, err := st.syncReleaseWithRetry(context, helm, release, chart, flags); err != nil {
 					m.Lock()
 					affectedReleases.Failed = append(affectedReleases.Failed, release)
+					affectedReleases.setValuesHash(release, prep.valuesHash)
+					affectedReleases.setAttempts(release, attempts)
 					m.Unlock()
 					relErr = newReleaseFailedError(release, err)
+					relErr.Attempts = attempts
+					affectedReleases.notifyRelease(release, relErr)
 				} else {
 					m.Lock()
 					affectedReleases.Upgraded = append(affectedReleases.Upgraded, release)
+					affectedReleases.setValuesHash(release, prep.valuesHash)
+					affectedReleases.setAttempts(release, attempts)
 					m.Unlock()
+					affectedReleases.notifyRelease(release, nil)
 					installedVersion, err := st.getDeployedVersion(context, helm, release)
 					if err != nil { //err is not really impacting so just log it
 						st.logger.Debugf("getting deployed release version failed:%v", err)
@@ -855,6 +1032,20 @@ func (st *HelmState) SyncReleases(affectedReleases *AffectedReleases, helm helme
 					}
 				}
 
+				if _, err := st.triggerPostapplyEvent(release, relErr, helmfileCommand); err != nil {
+					if relErr == nil {
+						relErr = newReleaseFailedError(release, err)
+					} else {
+						st.logger.Warnf("warn: %v\n", err)
+					}
+				}
+
+				if relErr != nil {
+					if _, err := st.triggerOnfailureEvent(release, relErr, helmfileCommand); err != nil {
+						st.logger.Warnf("warn: %v\n", err)
+					}
+				}
+
 				if _, err := st.TriggerCleanupEvent(release, "sync"); err != nil {
 					if relErr == nil {
 						relErr = newReleaseFailedError(release, err)
@@ -947,6 +1138,9 @@ type ChartPrepareOptions struct {
 	WaitForJobs            bool
 	OutputDir              string
 	IncludeTransitiveNeeds bool
+	// RenderCacheDir is the directory to cache the charts generated by chartify, pulled from OCI registries,
+	// and the dependencies built by `helm dep build`, across helmfile runs. The cache is disabled when empty.
+	RenderCacheDir string
 }
 
 type chartPrepareResult struct {
@@ -955,6 +1149,7 @@ type chartPrepareResult struct {
 	releaseContext         string
 	chartName              string
 	chartPath              string
+	chartDigest            string
 	err                    error
 	buildDeps              bool
 	chartFetchedByGoGetter bool
@@ -1032,11 +1227,10 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 	}
 
 	var builds []*chartPrepareResult
-	pullChan := make(chan PullCommand)
-	defer func() {
-		close(pullChan)
-	}()
-	go st.pullChartWorker(pullChan, helm)
+
+	digests := map[PrepareChartKey]string{}
+
+	cache := newRenderCache(opts.RenderCacheDir)
 
 	st.scatterGather(
 		concurrency,
@@ -1049,6 +1243,8 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 		},
 		func(workerIndex int) {
 			for release := range jobQueue {
+				stopTiming := st.Timings.Start(timing.CategoryPrepare, ReleaseToID(release))
+
 				if st.OverrideChart != "" {
 					release.Chart = st.OverrideChart
 				}
@@ -1071,16 +1267,18 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 				}
 				chartFetchedByGoGetter := chartPath != chartName
 
+				var chartDigest string
+
 				if !chartFetchedByGoGetter {
-					ociChartPath, err := st.getOCIChart(pullChan, release, dir, helm)
+					ociChartPath, digest, err := st.getOCIChart(release, dir, helm, cache)
 					if err != nil {
 						results <- &chartPrepareResult{err: fmt.Errorf("release %q: %w", release.Name, err)}
 
 						return
 					}
 
-					if ociChartPath != nil {
-						chartPath = *ociChartPath
+					if ociChartPath != "" {
+						chartPath, chartDigest = ociChartPath, digest
 					}
 				}
 
@@ -1123,12 +1321,46 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 
 					chartifyOpts.Validate = opts.Validate
 
-					out, err := c.Chartify(release.Name, chartPath, chartify.WithChartifyOpts(chartifyOpts))
-					if err != nil {
-						results <- &chartPrepareResult{err: err}
-						return
-					} else {
+					var cacheKey string
+					var cacheable bool
+					if cache != nil {
+						cacheKey, cacheable = st.chartifyCacheKey(helm3, release, chartPath, chartifyOpts)
+					}
+
+					var cached string
+					if cacheable {
+						cached = cache.lookup(renderCacheChartify, cacheKey)
+					}
+
+					if cached != "" {
+						st.logger.Debugf("using the chart for release %q cached at %s", release.Name, cached)
+
+						// The chart is copied out of the cache, as `helm dep build` below modifies the chart in place.
+						// The copy is used by helm after this function returns, so it's removed along with the temporary directory.
+						out, err := ioutil.TempDir(dir, "chartify")
+						if err == nil {
+							err = copyDir(cached, out)
+						}
+						if err != nil {
+							results <- &chartPrepareResult{err: fmt.Errorf("restoring the chart for release %q from the cache: %w", release.Name, err)}
+							return
+						}
+
 						chartPath = out
+					} else {
+						out, err := c.Chartify(release.Name, chartPath, chartify.WithChartifyOpts(chartifyOpts))
+						if err != nil {
+							results <- &chartPrepareResult{err: err}
+							return
+						} else {
+							chartPath = out
+						}
+
+						if cacheable {
+							if _, err := cache.store(renderCacheChartify, cacheKey, out); err != nil {
+								st.logger.Warnf("WARN: failed to cache the chart for release %q: %v", release.Name, err)
+							}
+						}
 					}
 
 					// Skip `helm dep build` and `helm dep up` altogether when the chart is from remote or the dep is
@@ -1216,12 +1448,15 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 					}
 				}
 
+				stopTiming()
+
 				results <- &chartPrepareResult{
 					releaseName:            release.Name,
 					chartName:              chartName,
 					releaseNamespace:       release.Namespace,
 					releaseContext:         release.KubeContext,
 					chartPath:              chartPath,
+					chartDigest:            chartDigest,
 					buildDeps:              buildDeps,
 					chartFetchedByGoGetter: chartFetchedByGoGetter,
 				}
@@ -1236,11 +1471,17 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 
 					return
 				}
-				temp[PrepareChartKey{
+				key := PrepareChartKey{
 					Namespace:   downloadRes.releaseNamespace,
 					KubeContext: downloadRes.releaseContext,
 					Name:        downloadRes.releaseName,
-				}] = downloadRes.chartPath
+				}
+
+				temp[key] = downloadRes.chartPath
+
+				if downloadRes.chartDigest != "" {
+					digests[key] = downloadRes.chartDigest
+				}
 
 				if downloadRes.buildDeps {
 					builds = append(builds, downloadRes)
@@ -1253,8 +1494,15 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 		return nil, errs
 	}
 
+	for i := range st.Releases {
+		r := &st.Releases[i]
+		if digest, ok := digests[PrepareChartKey{Namespace: r.Namespace, KubeContext: r.KubeContext, Name: r.Name}]; ok {
+			r.ChartDigest = digest
+		}
+	}
+
 	if len(builds) > 0 {
-		if err := st.runHelmDepBuilds(helm, concurrency, builds); err != nil {
+		if err := st.runHelmDepBuilds(helm, concurrency, builds, cache); err != nil {
 			return nil, []error{err}
 		}
 	}
@@ -1262,7 +1510,7 @@ func (st *HelmState) PrepareCharts(helm helmexec.Interface, dir string, concurre
 	return temp, nil
 }
 
-func (st *HelmState) runHelmDepBuilds(helm helmexec.Interface, concurrency int, builds []*chartPrepareResult) error {
+func (st *HelmState) runHelmDepBuilds(helm helmexec.Interface, concurrency int, builds []*chartPrepareResult, cache *renderCache) error {
 	// NOTES:
 	// 1. `helm dep build` fails when it was run concurrency on the same chart.
 	//    To avoid that, we run `helm dep build` only once per each local chart.
@@ -1273,7 +1521,29 @@ func (st *HelmState) runHelmDepBuilds(helm helmexec.Interface, concurrency int,
 	//
 	//    See https://github.com/huolunl/helmfile/issues/1521
 	for _, r := range builds {
-		if err := helm.BuildDeps(r.releaseName, r.chartPath); err != nil {
+		var cacheKey string
+		var cacheable bool
+		if cache != nil {
+			cacheKey, cacheable = st.depsCacheKey(helm.IsHelm3(), r.chartPath)
+		}
+
+		if cacheable {
+			if cached := cache.lookup(renderCacheDeps, cacheKey); cached != "" {
+				st.logger.Debugf("using the dependencies of chart %s cached at %s", r.chartPath, cached)
+
+				if err := copyDir(cached, filepath.Join(r.chartPath, "charts")); err != nil {
+					return fmt.Errorf("restoring dependencies of chart %s from the cache: %w", r.chartPath, err)
+				}
+
+				continue
+			}
+		}
+
+		stopTiming := st.Timings.Start(timing.CategoryPrepare, fmt.Sprintf("helm dep build %s", r.chartPath))
+		err := helm.BuildDeps(r.releaseName, r.chartPath)
+		stopTiming()
+
+		if err != nil {
 			if r.chartFetchedByGoGetter {
 				diagnostic := fmt.Sprintf(
 					"WARN: `helm dep build` failed. While processing release %q, Helmfile observed that remote chart %q fetched by go-getter is seemingly broken. "+
@@ -1292,6 +1562,12 @@ func (st *HelmState) runHelmDepBuilds(helm helmexec.Interface, concurrency int,
 
 			return fmt.Errorf("building dependencies of local chart: %w", err)
 		}
+
+		if charts := filepath.Join(r.chartPath, "charts"); cacheable && st.directoryExistsAt(charts) {
+			if _, err := cache.store(renderCacheDeps, cacheKey, charts); err != nil {
+				st.logger.Warnf("WARN: failed to cache the dependencies of chart %s: %v", r.chartPath, err)
+			}
+		}
 	}
 
 	return nil
@@ -1303,6 +1579,9 @@ type TemplateOpts struct {
 	OutputDirTemplate string
 	IncludeCRDs       bool
 	SkipTests         bool
+	// OnRendered, when set, receives the resources rendered for each release instead of helm writing them.
+	// The resources are sorted in the order helm installs them, and exclude helm test hooks
+	OnRendered func(release *ReleaseSpec, resources []manifest.Resource) error
 }
 
 type TemplateOpt interface{ Apply(*TemplateOpts) }
@@ -1311,7 +1590,32 @@ func (o *TemplateOpts) Apply(opts *TemplateOpts) {
 	*opts = *o
 }
 
-// TemplateReleases wrapper for executing helm template on the releases
+// renderRelease runs `helm template` on the release and gives the rendered resources to onRendered
+func (st *HelmState) renderRelease(helm helmexec.Interface, release *ReleaseSpec, flags []string, onRendered func(*ReleaseSpec, []manifest.Resource) error) error {
+	var out []byte
+
+	err := st.timed(timing.CategoryTemplate, release, func() error {
+		var err error
+		out, err = helm.GetManifests(release.Name, release.Chart, flags...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	resources, err := manifest.Parse(out)
+	if err != nil {
+		return newReleaseFailedError(release, err)
+	}
+
+	resources = manifest.WithoutTests(resources)
+	manifest.Sort(resources)
+
+	return onRendered(release, resources)
+}
+
+// TemplateReleases wrapper for executing helm template on the releases.
+// The rendered output is never cached by the render cache, as it can depend on the cluster via `lookup` and `--validate`
 func (st *HelmState) TemplateReleases(helm helmexec.Interface, outputDir string, additionalValues []string, args []string, workerLimit int,
 	validate bool, opt ...TemplateOpt) []error {
 
@@ -1359,7 +1663,7 @@ func (st *HelmState) TemplateReleases(helm helmexec.Interface, outputDir string,
 			}
 		}
 
-		if len(outputDir) > 0 || len(opts.OutputDirTemplate) > 0 {
+		if opts.OnRendered == nil && (len(outputDir) > 0 || len(opts.OutputDirTemplate) > 0) {
 			releaseOutputDir, err := st.GenerateOutputDir(outputDir, release, opts.OutputDirTemplate)
 			if err != nil {
 				errs = append(errs, err)
@@ -1386,7 +1690,11 @@ func (st *HelmState) TemplateReleases(helm helmexec.Interface, outputDir string,
 		}
 
 		if len(errs) == 0 {
-			if err := helm.TemplateRelease(release.Name, release.Chart, flags...); err != nil {
+			if opts.OnRendered != nil {
+				if err := st.renderRelease(helm, release, flags, opts.OnRendered); err != nil {
+					errs = append(errs, err)
+				}
+			} else if err := st.timed(timing.CategoryTemplate, release, func() error { return helm.TemplateRelease(release.Name, release.Chart, flags...) }); err != nil {
 				errs = append(errs, err)
 			}
 		}
@@ -1462,23 +1770,9 @@ func (st *HelmState) WriteReleasesValues(helm helmexec.Interface, additionalValu
 
 		st.logger.Infof("Writing values file %s", outputValuesFile)
 
-		merged := map[string]interface{}{}
-
-		for _, f := range append(generatedFiles, additionalValues...) {
-			src := map[string]interface{}{}
-
-			srcBytes, err := st.readFile(f)
-			if err != nil {
-				return []error{fmt.Errorf("reading %s: %w", f, err)}
-			}
-
-			if err := yaml.Unmarshal(srcBytes, &src); err != nil {
-				return []error{fmt.Errorf("unmarshalling yaml %s: %w", f, err)}
-			}
-
-			if err := mergo.Merge(&merged, &src, mergo.WithOverride, mergo.WithOverwriteWithEmptyValue); err != nil {
-				return []error{fmt.Errorf("merging %s: %w", f, err)}
-			}
+		merged, err := st.mergeValuesFiles(append(generatedFiles, additionalValues...))
+		if err != nil {
+			return []error{err}
 		}
 
 		var buf bytes.Buffer
@@ -1800,6 +2094,11 @@ type DiffOpts struct {
 	Set               []string
 	SkipCleanup       bool
 	SkipDiffOnInstall bool
+
+	// Fingerprints is used to skip diffing releases unchanged since the last apply, when not nil
+	Fingerprints *FingerprintStore
+	// ForceDiff diffs releases even if they are unchanged since the last apply, while still computing the fingerprints
+	ForceDiff bool
 }
 
 func (o *DiffOpts) Apply(opts *DiffOpts) {
@@ -1836,9 +2135,14 @@ func (st *HelmState) DiffReleases(helm helmexec.Interface, additionalValues []st
 		return []ReleaseSpec{}, prepErrs
 	}
 
-	jobQueue := make(chan *diffPrepareResult, len(preps))
 	results := make(chan diffResult, len(preps))
 
+	refs := make([]*ReleaseSpec, len(preps))
+	for i := range preps {
+		refs[i] = preps[i].release
+	}
+	scheduler := st.NewReleaseScheduler(refs)
+
 	rs := []ReleaseSpec{}
 	outputs := map[string]*bytes.Buffer{}
 	errs := []error{}
@@ -1849,34 +2153,55 @@ func (st *HelmState) DiffReleases(helm helmexec.Interface, additionalValues []st
 	st.scatterGather(
 		workerLimit,
 		len(preps),
-		func() {
-			for i := 0; i < len(preps); i++ {
-				jobQueue <- &preps[i]
-			}
-			close(jobQueue)
-		},
+		func() {},
 		func(workerIndex int) {
-			for prep := range jobQueue {
+			for i, ok := scheduler.next(workerIndex); ok; i, ok = scheduler.next(workerIndex) {
+				prep := &preps[i]
 				flags := prep.flags
 				release := prep.release
 				buf := &bytes.Buffer{}
+
+				unchanged := !prep.upgradeDueToSkippedDiff && st.unchangedSinceLastApply(helm, release, flags, workerIndex, opts)
+
+				var relErr *ReleaseError
 				if prep.upgradeDueToSkippedDiff {
-					results <- diffResult{release, &ReleaseError{ReleaseSpec: release, err: nil, Code: HelmDiffExitCodeChanged}, buf}
-				} else if err := helm.DiffRelease(st.createHelmContextWithWriter(release, buf), release.Name, normalizeChart(st.basePath, release.Chart), suppressDiff, flags...); err != nil {
+					relErr = &ReleaseError{ReleaseSpec: release, err: nil, Code: HelmDiffExitCodeChanged}
+				} else if unchanged {
+					st.logger.Infof("Skipping diff of release %s as it is unchanged since the last apply. Use --force-diff to diff it anyway", release.Name)
+				} else if _, err := st.triggerPrediffEvent(release, "diff"); err != nil {
+					relErr = &ReleaseError{ReleaseSpec: release, err: err, Code: 0}
+				} else if err := st.timed(timing.CategoryDiff, release, func() error {
+					return helm.DiffRelease(st.createHelmContextWithWriter(release, buf), release.Name, normalizeChart(st.basePath, release.Chart), suppressDiff, flags...)
+				}); err != nil {
 					switch e := err.(type) {
 					case helmv3.PluginError:
 						// Propagate any non-zero exit status from the external command like `helm` that is failed under the hood
-						results <- diffResult{release, &ReleaseError{release, err, e.Code}, buf}
+						relErr = &ReleaseError{ReleaseSpec: release, err: err, Code: e.Code}
 					case diff.Error:
-						results <- diffResult{release, &ReleaseError{release, err, e.Code}, buf}
+						relErr = &ReleaseError{ReleaseSpec: release, err: err, Code: e.Code}
 					default:
-						results <- diffResult{release, &ReleaseError{release, err, 0}, buf}
+						relErr = &ReleaseError{ReleaseSpec: release, err: err, Code: 0}
+					}
+				}
+
+				if !prep.upgradeDueToSkippedDiff && !unchanged {
+					// Detected changes are not a failure of the diff
+					var diffErr error
+					if relErr != nil && relErr.Code != HelmDiffExitCodeChanged {
+						diffErr = relErr
+					}
+
+					if _, err := st.triggerPostdiffEvent(release, diffErr, "diff"); err != nil {
+						if relErr == nil {
+							relErr = &ReleaseError{ReleaseSpec: release, err: err, Code: 0}
+						} else {
+							st.logger.Warnf("warn: %v\n", err)
+						}
 					}
-				} else {
-					// diff succeeded, found no changes
-					results <- diffResult{release, nil, buf}
 				}
 
+				results <- diffResult{release, relErr, buf}
+
 				if triggerCleanupEvents {
 					if _, err := st.TriggerCleanupEvent(prep.release, "diff"); err != nil {
 						st.logger.Warnf("warn: %v\n", err)
@@ -1944,23 +2269,40 @@ func (st *HelmState) DeleteReleases(affectedReleases *AffectedReleases, helm hel
 		}
 		context := st.createHelmContext(&release, workerIndex)
 
-		if _, err := st.triggerReleaseEvent("preuninstall", nil, &release, "delete"); err != nil {
-			affectedReleases.Failed = append(affectedReleases.Failed, &release)
+		err := func() error {
+			if _, err := st.triggerReleaseEvent("preuninstall", nil, &release, "delete"); err != nil {
+				return err
+			}
 
-			return err
-		}
+			if err := st.timed(timing.CategoryDelete, &release, func() error { return helm.DeleteRelease(context, release.Name, flags...) }); err != nil {
+				return err
+			}
 
-		if err := helm.DeleteRelease(context, release.Name, flags...); err != nil {
-			affectedReleases.Failed = append(affectedReleases.Failed, &release)
-			return err
-		}
+			if _, err := st.triggerReleaseEvent("postuninstall", nil, &release, "delete"); err != nil {
+				return err
+			}
 
-		if _, err := st.triggerReleaseEvent("postuninstall", nil, &release, "delete"); err != nil {
+			return nil
+		}()
+
+		if err != nil {
+			affectedReleases.mu.Lock()
 			affectedReleases.Failed = append(affectedReleases.Failed, &release)
+			affectedReleases.mu.Unlock()
+			affectedReleases.notifyRelease(&release, err)
+
+			if _, hookErr := st.triggerOnfailureEvent(&release, err, "delete"); hookErr != nil {
+				st.logger.Warnf("warn: %v\n", hookErr)
+			}
+
 			return err
 		}
 
+		affectedReleases.mu.Lock()
 		affectedReleases.Deleted = append(affectedReleases.Deleted, &release)
+		affectedReleases.mu.Unlock()
+		affectedReleases.notifyRelease(&release, nil)
+
 		return nil
 	})
 }
@@ -2186,6 +2528,17 @@ func (st *HelmState) TriggerGlobalCleanupEvent(helmfileCommand string) (bool, er
 	return st.triggerGlobalReleaseEvent("cleanup", nil, helmfileCommand)
 }
 
+// TriggerGlobalPrecommandEvent triggers the global `precommand` hooks before running the helmfile command against this helmfile
+func (st *HelmState) TriggerGlobalPrecommandEvent(helmfileCommand string) (bool, error) {
+	return st.triggerGlobalReleaseEvent("precommand", nil, helmfileCommand)
+}
+
+// TriggerGlobalPostcommandEvent triggers the global `postcommand` hooks after running the helmfile command against this helmfile.
+// evtErr is the error the command failed with, if any.
+func (st *HelmState) TriggerGlobalPostcommandEvent(evtErr error, helmfileCommand string) (bool, error) {
+	return st.triggerGlobalReleaseEvent("postcommand", evtErr, helmfileCommand)
+}
+
 func (st *HelmState) triggerGlobalReleaseEvent(evt string, evtErr error, helmfileCmd string) (bool, error) {
 	bus := &event.Bus{
 		Hooks:         st.Hooks,
@@ -2196,6 +2549,8 @@ func (st *HelmState) triggerGlobalReleaseEvent(evt string, evtErr error, helmfil
 		Env:           st.Env,
 		Logger:        st.logger,
 		ReadFile:      st.readFile,
+		KubeContext:   st.releaseKubeContext(&ReleaseSpec{}),
+		Timings:       st.Timings,
 	}
 	data := map[string]interface{}{
 		"HelmfileCommand": helmfileCmd,
@@ -2219,6 +2574,34 @@ func (st *HelmState) triggerPostsyncEvent(r *ReleaseSpec, evtErr error, helmfile
 	return st.triggerReleaseEvent("postsync", evtErr, r, helmfileCommand)
 }
 
+// triggerPreapplyEvent triggers `preapply` hooks only when the release is about to be changed by `helmfile apply`
+func (st *HelmState) triggerPreapplyEvent(r *ReleaseSpec, helmfileCommand string) (bool, error) {
+	if helmfileCommand != "apply" {
+		return false, nil
+	}
+	return st.triggerReleaseEvent("preapply", nil, r, helmfileCommand)
+}
+
+// triggerPostapplyEvent triggers `postapply` hooks only when the release is changed by `helmfile apply`
+func (st *HelmState) triggerPostapplyEvent(r *ReleaseSpec, evtErr error, helmfileCommand string) (bool, error) {
+	if helmfileCommand != "apply" {
+		return false, nil
+	}
+	return st.triggerReleaseEvent("postapply", evtErr, r, helmfileCommand)
+}
+
+func (st *HelmState) triggerOnfailureEvent(r *ReleaseSpec, evtErr error, helmfileCommand string) (bool, error) {
+	return st.triggerReleaseEvent("onfailure", evtErr, r, helmfileCommand)
+}
+
+func (st *HelmState) triggerPrediffEvent(r *ReleaseSpec, helmfileCommand string) (bool, error) {
+	return st.triggerReleaseEvent("prediff", nil, r, helmfileCommand)
+}
+
+func (st *HelmState) triggerPostdiffEvent(r *ReleaseSpec, evtErr error, helmfileCommand string) (bool, error) {
+	return st.triggerReleaseEvent("postdiff", evtErr, r, helmfileCommand)
+}
+
 func (st *HelmState) triggerReleaseEvent(evt string, evtErr error, r *ReleaseSpec, helmfileCmd string) (bool, error) {
 	bus := &event.Bus{
 		Hooks:         r.Hooks,
@@ -2229,6 +2612,9 @@ func (st *HelmState) triggerReleaseEvent(evt string, evtErr error, r *ReleaseSpe
 		Env:           st.Env,
 		Logger:        st.logger,
 		ReadFile:      st.readFile,
+		KubeContext:   st.releaseKubeContext(r),
+		KubeNamespace: r.Namespace,
+		Timings:       st.Timings,
 	}
 	vals := st.Values()
 	data := map[string]interface{}{
@@ -2239,6 +2625,15 @@ func (st *HelmState) triggerReleaseEvent(evt string, evtErr error, r *ReleaseSpe
 	return bus.Trigger(evt, evtErr, data)
 }
 
+// releaseKubeContext returns the kubeconfig context of the release, that is passed as --kube-context to helm.
+// The context of the environment is already in HelmDefaults, as it's overridden by the environment on load
+func (st *HelmState) releaseKubeContext(r *ReleaseSpec) string {
+	if r.KubeContext != "" {
+		return r.KubeContext
+	}
+	return st.HelmDefaults.KubeContext
+}
+
 // ResolveDeps returns a copy of this helmfile state with the concrete chart version numbers filled in for remote chart dependencies
 func (st *HelmState) ResolveDeps() (*HelmState, error) {
 	return st.mergeLockedDependencies()
@@ -2364,12 +2759,8 @@ func (st *HelmState) connectionFlags(helm helmexec.Interface, release *ReleaseSp
 			flags = append(flags, "--tls-ca-cert", st.HelmDefaults.TLSCACert)
 		}
 
-		if release.KubeContext != "" {
-			flags = append(flags, "--kube-context", release.KubeContext)
-		} else if st.Environments[st.Env.Name].KubeContext != "" {
-			flags = append(flags, "--kube-context", st.Environments[st.Env.Name].KubeContext)
-		} else if st.HelmDefaults.KubeContext != "" {
-			flags = append(flags, "--kube-context", st.HelmDefaults.KubeContext)
+		if kubeContext := st.releaseKubeContext(release); kubeContext != "" {
+			flags = append(flags, "--kube-context", kubeContext)
 		}
 	}
 
@@ -2397,7 +2788,8 @@ func (st *HelmState) timeoutFlags(helm helmexec.Interface, release *ReleaseSpec)
 func (st *HelmState) flagsForUpgrade(helm helmexec.Interface, release *ReleaseSpec, workerIndex int) ([]string, []string, error) {
 	flags := st.chartVersionFlags(release)
 
-	if release.Verify != nil && *release.Verify || release.Verify == nil && st.HelmDefaults.Verify {
+	// The chart pulled from the OCI registry has been verified by helmfile, and helm can't verify the chart directory
+	if st.verifiesChart(release) && release.ChartDigest == "" {
 		flags = append(flags, "--verify")
 	}
 
@@ -2442,6 +2834,13 @@ func (st *HelmState) flagsForUpgrade(helm helmexec.Interface, release *ReleaseSp
 		flags = append(flags, "--disable-openapi-validation")
 	}
 
+	if st.HelmDefaults.LabelReleases {
+		if !helm.IsVersionAtLeast(releaseLabelsMinHelmVersion) {
+			return nil, nil, fmt.Errorf("helmDefaults.labelReleases requires Helm %s or greater", releaseLabelsMinHelmVersion)
+		}
+		flags = append(flags, "--labels", st.managedReleaseLabels())
+	}
+
 	flags = st.appendConnectionFlags(flags, helm, release)
 
 	var err error
@@ -2529,7 +2928,8 @@ func (st *HelmState) flagsForDiff(helm helmexec.Interface, release *ReleaseSpec,
 func (st *HelmState) chartVersionFlags(release *ReleaseSpec) []string {
 	flags := []string{}
 
-	if release.Version != "" {
+	// The digest of the OCI chart isn't a version known to helm, and the chart is pulled by helmfile
+	if release.Version != "" && !strings.HasPrefix(release.Version, "sha256:") {
 		flags = append(flags, "--version", release.Version)
 	}
 
@@ -2605,7 +3005,7 @@ func (st *HelmState) RenderReleaseValuesFileToBytes(release *ReleaseSpec, path s
 			return nil, err
 		}
 
-		parsedYaml, err := st.valsRuntime.Eval(rawYaml)
+		parsedYaml, err := st.evalVals(path, rawYaml)
 		if err != nil {
 			return nil, err
 		}
@@ -2724,6 +3124,20 @@ func (st *HelmState) generateTemporaryReleaseValuesFiles(release *ReleaseSpec, v
 	return generatedFiles, nil
 }
 
+// evalVals evaluates the vals expressions like `ref+vault://...` in the input, recording the wall time for `--timings`
+func (st *HelmState) evalVals(name string, input map[string]interface{}) (map[string]interface{}, error) {
+	defer st.Timings.Start(timing.CategoryVals, name)()
+
+	return st.valsRuntime.Eval(input)
+}
+
+// timed runs the helm command for the release, recording the wall time for `--timings`
+func (st *HelmState) timed(category string, release *ReleaseSpec, f func() error) error {
+	defer st.Timings.Start(category, ReleaseToID(release))()
+
+	return f()
+}
+
 func (st *HelmState) generateVanillaValuesFiles(release *ReleaseSpec) ([]string, error) {
 	values := []interface{}{}
 	for _, v := range release.Values {
@@ -2736,7 +3150,7 @@ func (st *HelmState) generateVanillaValuesFiles(release *ReleaseSpec) ([]string,
 		}
 	}
 
-	valuesMapSecretsRendered, err := st.valsRuntime.Eval(map[string]interface{}{"values": values})
+	valuesMapSecretsRendered, err := st.evalVals(fmt.Sprintf("values of release %s", release.Name), map[string]interface{}{"values": values})
 	if err != nil {
 		return nil, err
 	}
@@ -2852,6 +3266,10 @@ func (st *HelmState) namespaceAndValuesFlags(helm helmexec.Interface, release *R
 
 	files = generatedFiles
 
+	if err := st.validateReleaseValues(release, generatedFiles); err != nil {
+		return nil, files, err
+	}
+
 	for _, f := range generatedFiles {
 		flags = append(flags, "--values", f)
 	}
@@ -2945,17 +3363,28 @@ func renderValsSecrets(e vals.Evaluator, input ...string) ([]string, error) {
 	return output, nil
 }
 
-// DisplayAffectedReleases logs the upgraded, deleted and in error releases
+// DisplayAffectedReleases logs the upgraded, deleted and in error releases.
+// The number of attempts is shown only when any of the releases has been retried.
 func (ar *AffectedReleases) DisplayAffectedReleases(logger *zap.SugaredLogger) {
 	if ar.Upgraded != nil && len(ar.Upgraded) > 0 {
 		logger.Info("\nUPDATED RELEASES:")
-		tbl, _ := prettytable.NewTable(prettytable.Column{Header: "NAME"},
-			prettytable.Column{Header: "CHART", MinWidth: 6},
-			prettytable.Column{Header: "VERSION", AlignRight: true},
-		)
+		retried := ar.anyRetried(ar.Upgraded)
+		columns := []prettytable.Column{
+			{Header: "NAME"},
+			{Header: "CHART", MinWidth: 6},
+			{Header: "VERSION", AlignRight: true},
+		}
+		if retried {
+			columns = append(columns, prettytable.Column{Header: "ATTEMPTS", AlignRight: true})
+		}
+		tbl, _ := prettytable.NewTable(columns...)
 		tbl.Separator = "   "
 		for _, release := range ar.Upgraded {
-			err := tbl.AddRow(release.Name, release.Chart, release.installedVersion)
+			row := []interface{}{release.Name, release.Chart, release.installedVersion}
+			if retried {
+				row = append(row, ar.attempts[ReleaseToID(release)])
+			}
+			err := tbl.AddRow(row...)
 			if err != nil {
 				logger.Warn("Could not add row, %v", err)
 			}
@@ -2971,11 +3400,34 @@ func (ar *AffectedReleases) DisplayAffectedReleases(logger *zap.SugaredLogger) {
 	}
 	if ar.Failed != nil && len(ar.Failed) > 0 {
 		logger.Info("\nFAILED RELEASES:")
-		logger.Info("NAME")
-		for _, release := range ar.Failed {
-			logger.Info(release.Name)
+		if ar.anyRetried(ar.Failed) {
+			tbl, _ := prettytable.NewTable(prettytable.Column{Header: "NAME"},
+				prettytable.Column{Header: "ATTEMPTS", AlignRight: true},
+			)
+			tbl.Separator = "   "
+			for _, release := range ar.Failed {
+				err := tbl.AddRow(release.Name, ar.attempts[ReleaseToID(release)])
+				if err != nil {
+					logger.Warn("Could not add row, %v", err)
+				}
+			}
+			logger.Info(tbl.String())
+		} else {
+			logger.Info("NAME")
+			for _, release := range ar.Failed {
+				logger.Info(release.Name)
+			}
+		}
+	}
+}
+
+func (ar *AffectedReleases) anyRetried(releases []*ReleaseSpec) bool {
+	for _, r := range releases {
+		if ar.attempts[ReleaseToID(r)] > 1 {
+			return true
 		}
 	}
+	return false
 }
 
 func escape(value string) string {
@@ -3229,74 +3681,3 @@ func (st *HelmState) Reverse() {
 		st.Helmfiles[i], st.Helmfiles[j] = st.Helmfiles[j], st.Helmfiles[i]
 	}
 }
-
-func (st *HelmState) getOCIChart(pullChan chan PullCommand, release *ReleaseSpec, tempDir string, helm helmexec.Interface) (*string, error) {
-	repo, name := st.GetRepositoryAndNameFromChartName(release.Chart)
-	if repo == nil {
-		return nil, nil
-	}
-
-	if !repo.OCI {
-		return nil, nil
-	}
-
-	chartVersion := "latest"
-	if release.Version != "" {
-		chartVersion = release.Version
-	}
-
-	qualifiedChartName := fmt.Sprintf("%s/%s:%s", repo.URL, name, chartVersion)
-
-	err := st.pullChart(pullChan, qualifiedChartName)
-	if err != nil {
-		return nil, err
-	}
-
-	pathElems := []string{
-		tempDir,
-	}
-
-	if release.Namespace != "" {
-		pathElems = append(pathElems, release.Namespace)
-	}
-
-	if release.KubeContext != "" {
-		pathElems = append(pathElems, release.KubeContext)
-	}
-
-	pathElems = append(pathElems, release.Name, name, chartVersion)
-
-	chartPath := path.Join(pathElems...)
-	err = helm.ChartExport(qualifiedChartName, chartPath)
-	if err != nil {
-		return nil, err
-	}
-
-	fullChartPath, err := findChartDirectory(chartPath)
-	if err != nil {
-		return nil, err
-	}
-
-	chartPath = filepath.Dir(fullChartPath)
-
-	return &chartPath, nil
-}
-
-// Pull charts one by one to prevent concurrent pull problems with Helm
-func (st *HelmState) pullChartWorker(pullChan chan PullCommand, helm helmexec.Interface) {
-	for pullCmd := range pullChan {
-		err := helm.ChartPull(pullCmd.ChartRef)
-		pullCmd.responseChan <- err
-	}
-}
-
-// Send a pull command to the pull worker
-func (st *HelmState) pullChart(pullChan chan PullCommand, chartRef string) error {
-	response := make(chan error, 1)
-	cmd := PullCommand{
-		responseChan: response,
-		ChartRef:     chartRef,
-	}
-	pullChan <- cmd
-	return <-response
-}
@@ -17,11 +17,38 @@ type result struct {
 }
 
 func (st *HelmState) scatterGather(concurrency int, items int, produceInputs func(), receiveInputsAndProduceIntermediates func(int), aggregateIntermediates func()) {
+	concurrency = st.ConcurrencyLimit(concurrency, items)
 
+	// WaitGroup is required to wait until goroutine per job in job queue cleanly stops.
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(concurrency)
+
+	go produceInputs()
+
+	for w := 1; w <= concurrency; w++ {
+		go func(id int) {
+			receiveInputsAndProduceIntermediates(id)
+			waitGroup.Done()
+		}(w)
+	}
+
+	aggregateIntermediates()
+
+	// Wait until all the goroutines to gracefully finish
+	waitGroup.Wait()
+}
+
+// ConcurrencyLimit returns the number of items processed at once, that is the concurrency limited by the number of
+// items and helmDefaults.maxConcurrency. It is 1 when any of the releases is tillerless.
+func (st *HelmState) ConcurrencyLimit(concurrency int, items int) int {
 	if concurrency < 1 || concurrency > items {
 		concurrency = items
 	}
 
+	if max := st.HelmDefaults.MaxConcurrency; max > 0 && concurrency > max {
+		concurrency = max
+	}
+
 	for _, r := range st.Releases {
 		if r.Tillerless != nil {
 			if *r.Tillerless {
@@ -32,23 +59,119 @@ func (st *HelmState) scatterGather(concurrency int, items int, produceInputs fun
 		}
 	}
 
-	// WaitGroup is required to wait until goroutine per job in job queue cleanly stops.
-	var waitGroup sync.WaitGroup
-	waitGroup.Add(concurrency)
+	return concurrency
+}
 
-	go produceInputs()
+// ReleaseScheduler hands out releases to workers in order, holding back the ones whose kube context or namespace has
+// reached maxConcurrencyPerContext or maxConcurrencyPerNamespace, so that releases to other kube contexts and
+// namespaces are processed in the meantime.
+type ReleaseScheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
 
-	for w := 1; w <= concurrency; w++ {
-		go func(id int) {
-			receiveInputsAndProduceIntermediates(id)
-			waitGroup.Done()
-		}(w)
+	maxPerContext   int
+	maxPerNamespace int
+
+	contexts   []string
+	namespaces []string
+	pending    []int
+
+	running  map[string]int
+	assigned map[int]int
+}
+
+// NewReleaseScheduler returns the scheduler of the releases. The releases are referred by their indices.
+func (st *HelmState) NewReleaseScheduler(releases []*ReleaseSpec) *ReleaseScheduler {
+	s := &ReleaseScheduler{
+		maxPerContext:   st.HelmDefaults.MaxConcurrencyPerContext,
+		maxPerNamespace: st.HelmDefaults.MaxConcurrencyPerNamespace,
+		running:         map[string]int{},
+		assigned:        map[int]int{},
 	}
+	s.cond = sync.NewCond(&s.mu)
 
-	aggregateIntermediates()
+	for i, r := range releases {
+		kubeContext := st.releaseKubeContext(r)
+		s.contexts = append(s.contexts, "context:"+kubeContext)
+		s.namespaces = append(s.namespaces, "namespace:"+kubeContext+"/"+r.Namespace)
+		s.pending = append(s.pending, i)
+	}
 
-	// Wait until all the goroutines to gracefully finish
-	waitGroup.Wait()
+	return s
+}
+
+// next marks the release previously handed out to the worker as processed, and then returns the index of the next
+// release for the worker to process. It blocks until any pending release is allowed to be processed.
+// ok is false when there is no release left.
+func (s *ReleaseScheduler) next(workerIndex int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prev, ok := s.assigned[workerIndex]; ok {
+		delete(s.assigned, workerIndex)
+		s.finish(prev)
+	}
+
+	for len(s.pending) > 0 {
+		for p, i := range s.pending {
+			if !s.allows(i) {
+				continue
+			}
+
+			s.pending = append(s.pending[:p], s.pending[p+1:]...)
+			s.start(i)
+			s.assigned[workerIndex] = i
+
+			return i, true
+		}
+
+		s.cond.Wait()
+	}
+
+	return 0, false
+}
+
+// TryStart marks the release as being processed and returns true, or returns false without blocking when its kube
+// context or namespace has reached the limit. It is used along with Done by the caller handing out the releases by
+// itself instead of next, like the one processing the releases as soon as the releases they need are processed.
+func (s *ReleaseScheduler) TryStart(i int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.allows(i) {
+		return false
+	}
+
+	s.start(i)
+
+	return true
+}
+
+// Done marks the release started by TryStart as processed
+func (s *ReleaseScheduler) Done(i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finish(i)
+}
+
+func (s *ReleaseScheduler) allows(i int) bool {
+	if s.maxPerContext > 0 && s.running[s.contexts[i]] >= s.maxPerContext {
+		return false
+	}
+
+	return s.maxPerNamespace <= 0 || s.running[s.namespaces[i]] < s.maxPerNamespace
+}
+
+func (s *ReleaseScheduler) start(i int) {
+	s.running[s.contexts[i]]++
+	s.running[s.namespaces[i]]++
+}
+
+func (s *ReleaseScheduler) finish(i int) {
+	s.running[s.contexts[i]]--
+	s.running[s.namespaces[i]]--
+	s.cond.Broadcast()
 }
 
 func (st *HelmState) scatterGatherReleases(helm helmexec.Interface, concurrency int,
@@ -63,20 +186,21 @@ func (st *HelmState) iterateOnReleases(helm helmexec.Interface, concurrency int,
 
 	inputsSize := len(inputs)
 
-	releases := make(chan ReleaseSpec)
 	results := make(chan result)
 
+	refs := make([]*ReleaseSpec, inputsSize)
+	for i := range inputs {
+		refs[i] = &inputs[i]
+	}
+	scheduler := st.NewReleaseScheduler(refs)
+
 	st.scatterGather(
 		concurrency,
 		inputsSize,
-		func() {
-			for _, release := range inputs {
-				releases <- release
-			}
-			close(releases)
-		},
+		func() {},
 		func(id int) {
-			for release := range releases {
+			for i, ok := scheduler.next(id); ok; i, ok = scheduler.next(id) {
+				release := inputs[i]
 				err := do(release, id)
 				st.logger.Debugf("release %q processed", release.Name)
 				results <- result{release: release, err: err}
@@ -270,3 +394,88 @@ func GroupReleasesByDependency(releases []Release, opts PlanOptions) ([][]Releas
 
 	return result, nil
 }
+
+// ReleaseNode is a release in the plan along with the releases that need to be processed before it
+type ReleaseNode struct {
+	Release
+
+	// Group is the index of the group containing the release in the plan returned by PlanReleases
+	Group int
+	// Dependencies is the indices of the nodes that need to be processed before the release
+	Dependencies []int
+}
+
+// PlanReleaseGraph returns the releases in the same order as PlanReleases, along with the dependencies among them,
+// so that each release can be processed as soon as its own dependencies are processed, without waiting for the whole group.
+//
+// Needs on releases not in the plan are followed transitively, so that the order is consistent with PlanReleases.
+// The dependencies are reversed when opts.Reverse is true, so that a release is processed after the releases that need it.
+func (st *HelmState) PlanReleaseGraph(opts PlanOptions) ([]ReleaseNode, error) {
+	marked, err := st.SelectReleasesWithOverrides(opts.IncludeTransitiveNeeds)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := SortedReleaseGroups(marked, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	needs := map[string][]string{}
+	for _, r := range marked {
+		id := ReleaseToID(&r.ReleaseSpec)
+		needs[id] = append(needs[id], r.Needs...)
+	}
+
+	var nodes []ReleaseNode
+
+	idToIndices := map[string][]int{}
+
+	for g, group := range groups {
+		for _, r := range group {
+			id := ReleaseToID(&r.ReleaseSpec)
+			idToIndices[id] = append(idToIndices[id], len(nodes))
+			nodes = append(nodes, ReleaseNode{Release: r, Group: g})
+		}
+	}
+
+	deps := make([][]int, len(nodes))
+
+	for i := range nodes {
+		visited := map[string]bool{}
+		queue := append([]string{}, nodes[i].Needs...)
+
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+
+			if indices, ok := idToIndices[id]; ok {
+				deps[i] = append(deps[i], indices...)
+				continue
+			}
+
+			queue = append(queue, needs[id]...)
+		}
+	}
+
+	for i, ds := range deps {
+		for _, d := range ds {
+			if opts.Reverse {
+				nodes[d].Dependencies = append(nodes[d].Dependencies, i)
+			} else {
+				nodes[i].Dependencies = append(nodes[i].Dependencies, d)
+			}
+		}
+	}
+
+	for i := range nodes {
+		sort.Ints(nodes[i].Dependencies)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,289 @@
+package state
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/exectest"
+	"github.com/huolunl/helmfile/pkg/helmexec"
+)
+
+func TestHelmState_iterateOnReleases_ConcurrencyLimits(t *testing.T) {
+	releases := []ReleaseSpec{
+		{Name: "a1", KubeContext: "a", Namespace: "ns1"},
+		{Name: "a2", KubeContext: "a", Namespace: "ns1"},
+		{Name: "a3", KubeContext: "a", Namespace: "ns2"},
+		{Name: "a4", KubeContext: "a", Namespace: "ns2"},
+		{Name: "b1", KubeContext: "b", Namespace: "ns1"},
+		{Name: "b2", KubeContext: "b", Namespace: "ns1"},
+		{Name: "c1", Namespace: "ns1"},
+		{Name: "c2", Namespace: "ns1"},
+	}
+
+	testcases := []struct {
+		name        string
+		defaults    HelmSpec
+		concurrency int
+
+		// The maximum numbers of releases processed concurrently
+		wantTotal        int
+		wantPerContext   int
+		wantPerNamespace int
+		// wantMinTotal is the number of releases that must have been processed concurrently, to ensure releases to
+		// other kube contexts and namespaces are not held back
+		wantMinTotal int
+	}{
+		{
+			name:             "no limits",
+			concurrency:      0,
+			wantTotal:        8,
+			wantPerContext:   4,
+			wantPerNamespace: 2,
+		},
+		{
+			name:             "global cap",
+			defaults:         HelmSpec{MaxConcurrency: 3},
+			concurrency:      0,
+			wantTotal:        3,
+			wantPerContext:   3,
+			wantPerNamespace: 2,
+		},
+		{
+			name:             "global cap lower than concurrency",
+			defaults:         HelmSpec{MaxConcurrency: 2},
+			concurrency:      5,
+			wantTotal:        2,
+			wantPerContext:   2,
+			wantPerNamespace: 2,
+		},
+		{
+			name:             "per context",
+			defaults:         HelmSpec{MaxConcurrencyPerContext: 1},
+			concurrency:      0,
+			wantTotal:        3,
+			wantPerContext:   1,
+			wantPerNamespace: 1,
+			wantMinTotal:     2,
+		},
+		{
+			name:             "per namespace",
+			defaults:         HelmSpec{MaxConcurrencyPerNamespace: 1},
+			concurrency:      0,
+			wantTotal:        4,
+			wantPerContext:   2,
+			wantPerNamespace: 1,
+			wantMinTotal:     2,
+		},
+		{
+			name:             "per context with default kube context",
+			defaults:         HelmSpec{KubeContext: "a", MaxConcurrencyPerContext: 2},
+			concurrency:      0,
+			wantTotal:        4,
+			wantPerContext:   2,
+			wantPerNamespace: 2,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &HelmState{
+				ReleaseSetSpec: ReleaseSetSpec{HelmDefaults: tc.defaults},
+				logger:         logger,
+			}
+			st.Releases = releases
+
+			var mu sync.Mutex
+			running := map[string]int{}
+			max := map[string]int{}
+			processed := map[string]bool{}
+
+			track := func(key string, delta int) {
+				running[key] += delta
+				if running[key] > max[key] {
+					max[key] = running[key]
+				}
+			}
+
+			errs := st.iterateOnReleases(&exectest.Helm{}, tc.concurrency, releases, func(r ReleaseSpec, _ int) error {
+				kubeContext := st.releaseKubeContext(&r)
+				keys := []string{"total", "context:" + kubeContext, "namespace:" + kubeContext + "/" + r.Namespace}
+
+				mu.Lock()
+				for _, k := range keys {
+					track(k, 1)
+				}
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				for _, k := range keys {
+					track(k, -1)
+				}
+				processed[r.Name] = true
+				mu.Unlock()
+
+				return nil
+			})
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			if len(processed) != len(releases) {
+				t.Errorf("expected all the releases to be processed, got %v", processed)
+			}
+
+			if max["total"] > tc.wantTotal {
+				t.Errorf("total concurrency exceeded the limit: want %d, got %d", tc.wantTotal, max["total"])
+			}
+
+			if max["total"] < tc.wantMinTotal {
+				t.Errorf("releases were unexpectedly held back: want at least %d processed concurrently, got %d", tc.wantMinTotal, max["total"])
+			}
+
+			var perContext, perNamespace int
+			for k, v := range max {
+				switch {
+				case strings.HasPrefix(k, "context:") && v > perContext:
+					perContext = v
+				case strings.HasPrefix(k, "namespace:") && v > perNamespace:
+					perNamespace = v
+				}
+			}
+
+			if perContext > tc.wantPerContext {
+				t.Errorf("concurrency per context exceeded the limit: want %d, got %d", tc.wantPerContext, perContext)
+			}
+
+			if perNamespace > tc.wantPerNamespace {
+				t.Errorf("concurrency per namespace exceeded the limit: want %d, got %d", tc.wantPerNamespace, perNamespace)
+			}
+		})
+	}
+}
+
+func TestHelmState_PlanReleaseGraph(t *testing.T) {
+	releases := []ReleaseSpec{
+		{Name: "a", Namespace: "ns1"},
+		{Name: "b", Namespace: "ns1", Needs: []string{"a"}},
+		{Name: "c", Namespace: "ns2"},
+		{Name: "d", Namespace: "ns2", Needs: []string{"ns1/b"}},
+	}
+
+	testcases := []struct {
+		name     string
+		opts     PlanOptions
+		want     []string
+		wantDeps [][]int
+	}{
+		{
+			name:     "all",
+			opts:     PlanOptions{SelectedReleases: releases, SkipNeeds: true},
+			want:     []string{"ns1/a", "ns2/c", "ns1/b", "ns2/d"},
+			wantDeps: [][]int{nil, nil, {0}, {2}},
+		},
+		{
+			name:     "reverse",
+			opts:     PlanOptions{SelectedReleases: releases, SkipNeeds: true, Reverse: true},
+			want:     []string{"ns2/d", "ns1/b", "ns1/a", "ns2/c"},
+			wantDeps: [][]int{nil, {0}, {1}, nil},
+		},
+		{
+			name:     "transitive needs on releases not in the plan",
+			opts:     PlanOptions{SelectedReleases: []ReleaseSpec{releases[0], releases[3]}, SkipNeeds: true},
+			want:     []string{"ns1/a", "ns2/d"},
+			wantDeps: [][]int{nil, {0}},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &HelmState{
+				ReleaseSetSpec: ReleaseSetSpec{Releases: releases},
+				RenderedValues: map[string]interface{}{},
+				logger:         logger,
+			}
+
+			nodes, err := st.PlanReleaseGraph(tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var ids []string
+			var deps [][]int
+			for _, n := range nodes {
+				ids = append(ids, ReleaseToID(&n.ReleaseSpec))
+				deps = append(deps, n.Dependencies)
+			}
+
+			if d := cmp.Diff(tc.want, ids); d != "" {
+				t.Errorf("unexpected releases: want (-), got (+):\n%s", d)
+			}
+
+			if d := cmp.Diff(tc.wantDeps, deps); d != "" {
+				t.Errorf("unexpected dependencies: want (-), got (+):\n%s", d)
+			}
+		})
+	}
+}
+
+// overlappingDeleteHelm records the maximum number of releases deleted concurrently
+type overlappingDeleteHelm struct {
+	*exectest.Helm
+
+	mu       sync.Mutex
+	deleting int
+	max      int
+}
+
+func (helm *overlappingDeleteHelm) DeleteRelease(context helmexec.HelmContext, name string, flags ...string) error {
+	helm.mu.Lock()
+	helm.deleting++
+	if helm.deleting > helm.max {
+		helm.max = helm.deleting
+	}
+	helm.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	helm.mu.Lock()
+	helm.deleting--
+	helm.mu.Unlock()
+
+	return nil
+}
+
+func TestHelmState_DeleteReleasesForSync_DeletesOneAtATime(t *testing.T) {
+	helm := &overlappingDeleteHelm{Helm: &exectest.Helm{Helm3: true}}
+
+	st := &HelmState{
+		basePath: ".",
+		ReleaseSetSpec: ReleaseSetSpec{
+			Releases: []ReleaseSpec{
+				{Name: "a", Chart: "stable/a"},
+				{Name: "b", Chart: "stable/b"},
+				{Name: "c", Chart: "stable/c"},
+				{Name: "d", Chart: "stable/d"},
+			},
+		},
+		logger:         logger,
+		valsRuntime:    valsRuntime,
+		RenderedValues: map[string]interface{}{},
+	}
+
+	affected := AffectedReleases{}
+	if errs := st.DeleteReleasesForSync(&affected, helm, 4); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if len(affected.Deleted) != 4 {
+		t.Errorf("unexpected deleted releases: want 4, got %d", len(affected.Deleted))
+	}
+
+	if helm.max != 1 {
+		t.Errorf("unexpected number of releases deleted concurrently: want 1, got %d", helm.max)
+	}
+}
@@ -684,6 +684,40 @@ func TestHelmState_flagsForUpgrade(t *testing.T) {
 			},
 			wantErr: "releases[].createNamespace requires Helm 3.2.0 or greater",
 		},
+		{
+			name: "label-releases",
+			defaults: HelmSpec{
+				LabelReleases: true,
+				StateName:     "platform/apps",
+			},
+			version: semver.MustParse("3.13.0"),
+			release: &ReleaseSpec{
+				Chart:     "test/chart",
+				Version:   "0.1",
+				Name:      "test-charts",
+				Namespace: "test-namespace",
+			},
+			want: []string{
+				"--version", "0.1",
+				"--create-namespace",
+				"--labels", "helmfile.io/managed=true,helmfile.io/state=platform_apps,helmfile.io/env=",
+				"--namespace", "test-namespace",
+			},
+		},
+		{
+			name: "label-releases-unsupported",
+			defaults: HelmSpec{
+				LabelReleases: true,
+			},
+			version: semver.MustParse("3.12.3"),
+			release: &ReleaseSpec{
+				Chart:     "test/chart",
+				Version:   "0.1",
+				Name:      "test-charts",
+				Namespace: "test-namespace",
+			},
+			wantErr: "helmDefaults.labelReleases requires Helm 3.13.0 or greater",
+		},
 	}
 	for i := range tests {
 		tt := tests[i]
@@ -983,6 +1017,26 @@ func TestHelmState_SyncRepos(t *testing.T) {
 			helm: &exectest.Helm{},
 			want: []string{"name", "http://example.com/", "", "", "", "", "", "", "", "true"},
 		},
+		{
+			name: "repository with credentials from env",
+			repos: []RepositorySpec{
+				{
+					Name: "name",
+					URL:  "http://example.com/",
+					Credentials: &RepositoryCredentialsSpec{
+						Provider:    "env",
+						UsernameEnv: "SYNC_REPOS_USERNAME",
+						PasswordEnv: "SYNC_REPOS_PASSWORD",
+					},
+				},
+			},
+			envs: map[string]string{
+				"SYNC_REPOS_USERNAME": "env_user",
+				"SYNC_REPOS_PASSWORD": "env_password",
+			},
+			helm: &exectest.Helm{},
+			want: []string{"name", "http://example.com/", "", "", "", "env_user", "env_password", "", "", ""},
+		},
 	}
 	for i := range tests {
 		tt := tests[i]
@@ -0,0 +1,294 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/huolunl/helmfile/pkg/maputil"
+	"github.com/huolunl/helmfile/pkg/remote"
+	"github.com/imdario/mergo"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ValuesSchemaError is returned when the merged values of a release do not conform to the release's `valuesSchema`
+type ValuesSchemaError struct {
+	Release string
+	Errors  []string
+}
+
+func (e *ValuesSchemaError) Error() string {
+	return fmt.Sprintf("values of release \"%s\" do not conform to the values schema:\n%s", e.Release, strings.Join(e.Errors, "\n"))
+}
+
+// validateReleaseValues validates the values merged from the generated values files against the release's `valuesSchema`.
+// Each violation is reported against the values file or the inline values that contributed the offending value.
+func (st *HelmState) validateReleaseValues(release *ReleaseSpec, generatedFiles []string) error {
+	if release.ValuesSchema == nil {
+		return nil
+	}
+
+	schema, err := st.loadValuesSchema(release)
+	if err != nil {
+		return fmt.Errorf("loading values schema of release \"%s\": %v", release.Name, err)
+	}
+
+	layers, err := st.releaseValuesLayers(release, generatedFiles)
+	if err != nil {
+		return err
+	}
+
+	merged, err := mergeValuesLayers(layers)
+	if err != nil {
+		return err
+	}
+
+	res, err := gojsonschema.Validate(schema, gojsonschema.NewGoLoader(merged))
+	if err != nil {
+		return fmt.Errorf("validating values of release \"%s\": %v", release.Name, err)
+	}
+
+	if res.Valid() {
+		return nil
+	}
+
+	var msgs []string
+	for _, re := range res.Errors() {
+		path := schemaErrorPath(re)
+		e := &SchemaError{Path: path, Description: re.Description()}
+
+		msgs = append(msgs, fmt.Sprintf("%s: %v", locateValue(layers, path), e))
+	}
+
+	return &ValuesSchemaError{Release: release.Name, Errors: msgs}
+}
+
+// locateValue returns the location of the values layer that contributed the value at the path.
+// The last layer that sets the path, or the deepest ancestor of it, wins as helm merges values files in order.
+func locateValue(layers []valuesLayer, path []string) string {
+	found, foundLine, foundDepth := -1, 0, -1
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		if layers[i].node == nil {
+			continue
+		}
+
+		line, depth := lookupYAMLLine(layers[i].node, path)
+		if depth > foundDepth {
+			found, foundLine, foundDepth = i, line, depth
+		}
+		if depth == len(path) {
+			break
+		}
+	}
+
+	if found < 0 {
+		return "(no values)"
+	}
+
+	if foundLine > 0 && !layers[found].inline {
+		return fmt.Sprintf("%s:%d", layers[found].source, foundLine)
+	}
+
+	return layers[found].source
+}
+
+// valuesLayer is a set of values loaded from a values file or an inline values entry.
+// Layers are merged in order so that the latter overrides the former.
+type valuesLayer struct {
+	// source is the path to the values file, or the description of the inline values entry
+	source string
+	// inline is true when the values are not loaded from a values file so that the line numbers are meaningless
+	inline bool
+	values map[string]interface{}
+	node   *yamlv3.Node
+}
+
+// releaseValuesLayers loads the generated values files of the release into layers, along with the values files and the
+// inline values that the generated values files are generated from.
+func (st *HelmState) releaseValuesLayers(release *ReleaseSpec, generatedFiles []string) ([]valuesLayer, error) {
+	sources := st.releaseValuesSources(release)
+	if len(sources) != len(generatedFiles) {
+		sources = nil
+		for _, f := range generatedFiles {
+			sources = append(sources, valuesLayer{source: f})
+		}
+	}
+
+	layers := make([]valuesLayer, 0, len(generatedFiles))
+
+	for i, f := range generatedFiles {
+		bs, err := st.readFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f, err)
+		}
+
+		l, err := newValuesLayer(sources[i].source, sources[i].inline, bs)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, *l)
+	}
+
+	return layers, nil
+}
+
+func newValuesLayer(source string, inline bool, content []byte) (*valuesLayer, error) {
+	var src map[interface{}]interface{}
+	if err := yaml.Unmarshal(content, &src); err != nil {
+		return nil, fmt.Errorf("unmarshalling yaml %s: %w", source, err)
+	}
+
+	vals, err := maputil.CastKeysToStrings(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+
+	l := &valuesLayer{source: source, inline: inline, values: vals}
+
+	var node yamlv3.Node
+	if err := yamlv3.Unmarshal(content, &node); err == nil {
+		l.node = &node
+	}
+
+	return l, nil
+}
+
+func mergeValuesLayers(layers []valuesLayer) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+
+	for _, l := range layers {
+		if err := mergo.Merge(&merged, copyValues(l.values), mergo.WithOverride); err != nil {
+			return nil, fmt.Errorf("merging %s: %w", l.source, err)
+		}
+	}
+
+	return merged, nil
+}
+
+// copyValues deep-copies the values so that merging them into others never modifies nested hashes of the layer.
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		copied[k] = copyValue(v)
+	}
+	return copied
+}
+
+func copyValue(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		return copyValues(typed)
+	case map[interface{}]interface{}:
+		copied := make(map[interface{}]interface{}, len(typed))
+		for k, v := range typed {
+			copied[k] = copyValue(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, v := range typed {
+			copied[i] = copyValue(v)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// releaseValuesSources returns the values files and the inline values that the release's generated values files are
+// generated from, in the order of generateValuesFiles.
+func (st *HelmState) releaseValuesSources(release *ReleaseSpec) []valuesLayer {
+	var sources []valuesLayer
+
+	collect := func(tpe string, entries []interface{}) {
+		for i, v := range entries {
+			switch typed := v.(type) {
+			case string:
+				// One values file is generated per file matching the path, that can be a glob pattern
+				paths, err := st.storage().ExpandPaths(release.ValuesPathPrefix + typed)
+				if err != nil {
+					continue
+				}
+				for _, p := range paths {
+					source := typed
+					if strings.ContainsAny(typed, "*?[{") {
+						source = st.valuesSourcePath(p)
+					}
+					sources = append(sources, valuesLayer{source: source})
+				}
+			default:
+				source := fmt.Sprintf("%s[%d] of release \"%s\" in %s", tpe, i, release.Name, st.FilePath)
+				// ExecuteTemplates prepends the rendered valuesTemplate entries to values
+				if tpe == "values" && i < len(release.ValuesTemplate) {
+					source = fmt.Sprintf("valuesTemplate[%d] of release \"%s\" in %s", i, release.Name, st.FilePath)
+				}
+				sources = append(sources, valuesLayer{source: source, inline: true})
+			}
+		}
+	}
+
+	collect("values", release.Values)
+	collect("secrets", release.Secrets)
+
+	return sources
+}
+
+// valuesSourcePath returns the path to the values file relative to the helmfile, or the path as it is when it's outside
+func (st *HelmState) valuesSourcePath(path string) string {
+	rel, err := filepath.Rel(st.basePath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+
+	return rel
+}
+
+func (st *HelmState) loadValuesSchema(release *ReleaseSpec) (gojsonschema.JSONLoader, error) {
+	var schema interface{}
+
+	switch typed := release.ValuesSchema.(type) {
+	case string:
+		var path string
+		if remote.IsRemote(typed) {
+			fetched, err := st.newRemote().Locate(typed)
+			if err != nil {
+				return nil, err
+			}
+			path = fetched
+		} else {
+			path = st.storage().normalizePath(release.ValuesPathPrefix + typed)
+		}
+
+		bs, err := st.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// YAML is a superset of JSON so that the schema file can be written in either of them
+		if err := yaml.Unmarshal(bs, &schema); err != nil {
+			return nil, fmt.Errorf("unmarshalling %s: %v", typed, err)
+		}
+	case map[interface{}]interface{}, map[string]interface{}:
+		schema = typed
+	default:
+		return nil, fmt.Errorf("unexpected type of valuesSchema: it must be either a path to a schema file or an inline schema, but got %T", typed)
+	}
+
+	casted, err := maputil.CastKeysToStrings(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return gojsonschema.NewGoLoader(casted), nil
+}
+
+func (st *HelmState) newRemote() *remote.Remote {
+	return remote.NewRemote(st.logger, "", st.readFile, st.directoryExistsAt, func(f string) bool {
+		exists, _ := st.fileExists(f)
+		return exists
+	})
+}
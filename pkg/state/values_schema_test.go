@@ -0,0 +1,158 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/huolunl/helmfile/pkg/testhelper"
+)
+
+func TestHelmState_validateReleaseValues(t *testing.T) {
+	schema := `
+type: object
+properties:
+  replicas:
+    type: integer
+  image:
+    type: object
+    properties:
+      tag:
+        type: string
+    required: [tag]
+required: [image]
+`
+
+	tests := []struct {
+		name         string
+		valuesSchema interface{}
+		values       []interface{}
+		files        map[string]string
+		generated    []string
+		wantErrs     []string
+	}{
+		{
+			name:         "no schema",
+			valuesSchema: nil,
+			files: map[string]string{
+				"/path/to/values.yaml": `replicas: foo`,
+			},
+			values:    []interface{}{"values.yaml"},
+			generated: []string{"/path/to/values.yaml"},
+		},
+		{
+			name:         "valid",
+			valuesSchema: "schema.yaml",
+			files: map[string]string{
+				"/path/to/schema.yaml": schema,
+				"/path/to/values.yaml": "replicas: 1\nimage:\n  tag: v1\n",
+			},
+			values:    []interface{}{"values.yaml"},
+			generated: []string{"/path/to/values.yaml"},
+		},
+		{
+			name:         "overridden by the later values file",
+			valuesSchema: "schema.yaml",
+			files: map[string]string{
+				"/path/to/schema.yaml":   schema,
+				"/path/to/values.yaml":   "replicas: 1\nimage:\n  tag: v1\n",
+				"/path/to/override.yaml": "image:\n  tag: 1\n",
+			},
+			values:    []interface{}{"values.yaml", "override.yaml"},
+			generated: []string{"/path/to/values.yaml", "/path/to/override.yaml"},
+			wantErrs: []string{
+				"override.yaml:2: image.tag: Invalid type. Expected: string, given: integer",
+			},
+		},
+		{
+			name: "inline schema and inline values",
+			valuesSchema: map[interface{}]interface{}{
+				"type": "object",
+				"properties": map[interface{}]interface{}{
+					"replicas": map[interface{}]interface{}{"type": "integer"},
+				},
+			},
+			files: map[string]string{
+				"/path/to/values.yaml":    "replicas: 1\n",
+				"/path/to/generated.yaml": "replicas: many\n",
+			},
+			values: []interface{}{
+				"values.yaml",
+				map[interface{}]interface{}{"replicas": "many"},
+			},
+			generated: []string{"/path/to/values.yaml", "/path/to/generated.yaml"},
+			wantErrs: []string{
+				`values[1] of release "foo" in /path/to/helmfile.yaml: replicas: Invalid type. Expected: integer, given: string`,
+			},
+		},
+		{
+			name:         "missing required value",
+			valuesSchema: "schema.yaml",
+			files: map[string]string{
+				"/path/to/schema.yaml": schema,
+				"/path/to/values.yaml": "replicas: 1\n",
+			},
+			values:    []interface{}{"values.yaml"},
+			generated: []string{"/path/to/values.yaml"},
+			wantErrs: []string{
+				"values.yaml:1: (root): image is required",
+			},
+		},
+		{
+			name:         "values glob",
+			valuesSchema: "schema.yaml",
+			files: map[string]string{
+				"/path/to/schema.yaml":          schema,
+				"/path/to/values/default.yaml":  "replicas: 1\nimage:\n  tag: v1\n",
+				"/path/to/override.yaml":        "replicas: 2\n",
+				"/path/to/values/override.yaml": "image:\n  tag: 1\n",
+				"/tmp/values-1.yaml":            "replicas: 1\nimage:\n  tag: v1\n",
+				"/tmp/values-2.yaml":            "image:\n  tag: 1\n",
+				"/tmp/values-3.yaml":            "replicas: 2\n",
+			},
+			values:    []interface{}{"values/*.yaml", "override.yaml"},
+			generated: []string{"/tmp/values-1.yaml", "/tmp/values-2.yaml", "/tmp/values-3.yaml"},
+			wantErrs: []string{
+				"values/override.yaml:2: image.tag: Invalid type. Expected: string, given: integer",
+			},
+		},
+	}
+
+	for i := range tests {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			st := &HelmState{
+				basePath: "/path/to",
+				FilePath: "/path/to/helmfile.yaml",
+				logger:   logger,
+			}
+			st = injectFs(st, testhelper.NewTestFs(tt.files))
+
+			release := &ReleaseSpec{
+				Name:         "foo",
+				Chart:        "stable/foo",
+				Values:       tt.values,
+				ValuesSchema: tt.valuesSchema,
+			}
+
+			err := st.validateReleaseValues(release, tt.generated)
+
+			var gotErrs []string
+			if err != nil {
+				schemaErr, ok := err.(*ValuesSchemaError)
+				if !ok {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				gotErrs = schemaErr.Errors
+			}
+
+			if d := cmp.Diff(tt.wantErrs, gotErrs); d != "" {
+				t.Errorf("unexpected errors:\n%s", d)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), `values of release "foo" do not conform to the values schema`) {
+				t.Errorf("unexpected error message: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package timing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Categories of the steps of a helmfile run
+const (
+	// CategoryLoad is loading a helmfile.yaml, including the rendering but not the sub-helmfiles
+	CategoryLoad = "load"
+	// CategoryRender is the first-pass and the second-pass rendering of a helmfile.yaml, and the release templates
+	CategoryRender = "render"
+	// CategoryVals is the evaluation of vals expressions like `ref+vault://...`
+	CategoryVals = "vals"
+	// CategoryRepos is `helm repo add`, `helm repo update` and `helm registry login`
+	CategoryRepos = "repos"
+	// CategoryPrepare is the preparation of the chart of a release, like the chartify and the OCI pull, and `helm dep build`
+	CategoryPrepare = "prepare"
+	// CategoryDiff is `helm diff` of a release
+	CategoryDiff = "diff"
+	// CategoryUpgrade is `helm upgrade --install` of a release
+	CategoryUpgrade = "upgrade"
+	// CategoryDelete is `helm delete` of a release
+	CategoryDelete = "delete"
+	// CategoryTemplate is `helm template` of a release
+	CategoryTemplate = "template"
+	// CategoryHook is a hook triggered by an event
+	CategoryHook = "hook"
+)
+
+// Span is the wall time of a step of a helmfile run
+type Span struct {
+	Category string
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Recorder records the wall time of the steps of a helmfile run, for `--timings`.
+//
+// All the methods are safe for concurrent use, and are no-op on the nil Recorder
+// so that the instrumented code doesn't need to check if timings are enabled.
+type Recorder struct {
+	start time.Time
+	now   func() time.Time
+
+	mu    sync.Mutex
+	spans []Span
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now(), now: time.Now}
+}
+
+// Start starts timing the step and returns the function to stop it
+func (r *Recorder) Start(category, name string) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	start := r.now()
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.spans = append(r.spans, Span{Category: category, Name: name, Start: start, Duration: r.now().Sub(start)})
+	}
+}
+
+// Spans returns the recorded steps in the order they started
+func (r *Recorder) Spans() []Span {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := append([]Span{}, r.spans...)
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+
+	return spans
+}
+
+type categorySummary struct {
+	Category string  `json:"category"`
+	Count    int     `json:"count"`
+	Seconds  float64 `json:"seconds"`
+
+	total time.Duration
+}
+
+func (r *Recorder) summarize(spans []Span) []categorySummary {
+	var summaries []categorySummary
+
+	index := map[string]int{}
+
+	for _, s := range spans {
+		i, ok := index[s.Category]
+		if !ok {
+			i = len(summaries)
+			index[s.Category] = i
+			summaries = append(summaries, categorySummary{Category: s.Category})
+		}
+
+		summaries[i].Count++
+		summaries[i].total += s.Duration
+		summaries[i].Seconds = summaries[i].total.Seconds()
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].total > summaries[j].total
+	})
+
+	return summaries
+}
+
+// Print writes the total time per category, and the steps from the slowest one to the fastest one.
+// The total per category can exceed the wall time of the run, as steps like diffs run concurrently.
+func (r *Recorder) Print(w io.Writer) {
+	if r == nil {
+		return
+	}
+
+	spans := r.Spans()
+
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 0, 1, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "\nTIMINGS (total %s)\n\n", r.now().Sub(r.start).Round(time.Millisecond))
+
+	fmt.Fprintln(tw, "CATEGORY\tCOUNT\tTOTAL")
+	for _, s := range r.summarize(spans) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", s.Category, s.Count, s.total.Round(time.Millisecond))
+	}
+
+	fmt.Fprintln(tw)
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Duration > spans[j].Duration
+	})
+
+	fmt.Fprintln(tw, "CATEGORY\tSTEP\tSTART\tDURATION")
+	for _, s := range spans {
+		fmt.Fprintf(tw, "%s\t%s\t+%s\t%s\n", s.Category, s.Name, s.Start.Sub(r.start).Round(time.Millisecond), s.Duration.Round(time.Millisecond))
+	}
+
+	tw.Flush()
+}
+
+type jsonSpan struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	// StartSeconds is the time since the start of the run
+	StartSeconds    float64 `json:"startSeconds"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+type jsonReport struct {
+	Start        time.Time         `json:"start"`
+	TotalSeconds float64           `json:"totalSeconds"`
+	Categories   []categorySummary `json:"categories"`
+	Spans        []jsonSpan        `json:"spans"`
+}
+
+// WriteJSON writes the report in JSON, with the steps in the order they started
+func (r *Recorder) WriteJSON(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+
+	spans := r.Spans()
+
+	report := jsonReport{
+		Start:        r.start,
+		TotalSeconds: r.now().Sub(r.start).Seconds(),
+		Categories:   r.summarize(spans),
+		Spans:        []jsonSpan{},
+	}
+
+	for _, s := range spans {
+		report.Spans = append(report.Spans, jsonSpan{
+			Category:        s.Category,
+			Name:            s.Name,
+			StartSeconds:    s.Start.Sub(r.start).Seconds(),
+			DurationSeconds: s.Duration.Seconds(),
+		})
+	}
+
+	bs, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(bs, '\n'))
+
+	return err
+}
+
+// WriteFile writes the report in JSON to the file at the path
+func (r *Recorder) WriteFile(path string) error {
+	if r == nil {
+		return nil
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := r.WriteJSON(buf); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
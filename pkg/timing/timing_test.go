@@ -0,0 +1,138 @@
+package timing
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestRecorder() (*Recorder, func(time.Duration)) {
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	r := &Recorder{start: now, now: func() time.Time { return now }}
+
+	return r, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRecorder(t *testing.T) {
+	r, advance := newTestRecorder()
+
+	stopLoad := r.Start(CategoryLoad, "helmfile.yaml")
+	advance(500 * time.Millisecond)
+	stopLoad()
+
+	stopFoo := r.Start(CategoryDiff, "default/apps/foo")
+	advance(time.Second)
+	stopBar := r.Start(CategoryDiff, "default/apps/bar")
+	advance(2 * time.Second)
+	stopFoo()
+	advance(time.Second)
+	stopBar()
+
+	stopHook := r.Start(CategoryHook, "presync: migrate")
+	advance(250 * time.Millisecond)
+	stopHook()
+
+	buf := &bytes.Buffer{}
+	r.Print(buf)
+
+	want := `
+TIMINGS (total 4.75s)
+
+CATEGORY  COUNT  TOTAL
+diff      2      6s
+load      1      500ms
+hook      1      250ms
+
+CATEGORY  STEP              START   DURATION
+diff      default/apps/foo  +500ms  3s
+diff      default/apps/bar  +1.5s   3s
+load      helmfile.yaml     +0s     500ms
+hook      presync: migrate  +4.5s   250ms
+`
+
+	if d := cmp.Diff(want, buf.String()); d != "" {
+		t.Errorf("unexpected report: want (-), got (+):\n%s", d)
+	}
+
+	buf = &bytes.Buffer{}
+	if err := r.WriteJSON(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantJSON := `{
+  "start": "2021-05-01T10:00:00Z",
+  "totalSeconds": 4.75,
+  "categories": [
+    {
+      "category": "diff",
+      "count": 2,
+      "seconds": 6
+    },
+    {
+      "category": "load",
+      "count": 1,
+      "seconds": 0.5
+    },
+    {
+      "category": "hook",
+      "count": 1,
+      "seconds": 0.25
+    }
+  ],
+  "spans": [
+    {
+      "category": "load",
+      "name": "helmfile.yaml",
+      "startSeconds": 0,
+      "durationSeconds": 0.5
+    },
+    {
+      "category": "diff",
+      "name": "default/apps/foo",
+      "startSeconds": 0.5,
+      "durationSeconds": 3
+    },
+    {
+      "category": "diff",
+      "name": "default/apps/bar",
+      "startSeconds": 1.5,
+      "durationSeconds": 3
+    },
+    {
+      "category": "hook",
+      "name": "presync: migrate",
+      "startSeconds": 4.5,
+      "durationSeconds": 0.25
+    }
+  ]
+}
+`
+
+	if d := cmp.Diff(wantJSON, buf.String()); d != "" {
+		t.Errorf("unexpected JSON: want (-), got (+):\n%s", d)
+	}
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var r *Recorder
+
+	r.Start(CategoryLoad, "helmfile.yaml")()
+
+	if spans := r.Spans(); spans != nil {
+		t.Errorf("unexpected spans: %v", spans)
+	}
+
+	buf := &bytes.Buffer{}
+	r.Print(buf)
+
+	if err := r.WriteJSON(buf); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if buf.Len() > 0 {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+}